@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"github.com/taskcluster/taskcluster-client-go/queue"
+)
+
+// ArchivedArtifact describes an artifact copied to the artifact archive, see
+// config setting artifactArchiveDir
+type ArchivedArtifact struct {
+	Name    string        `json:"name"`
+	SHA256  string        `json:"sha256,omitempty"`
+	SHA512  string        `json:"sha512,omitempty"`
+	Size    int64         `json:"size"`
+	Expires tcclient.Time `json:"expires"`
+}
+
+// ArchivedRun is the metadata written alongside the archived artifacts of a
+// task run, to <artifactArchiveDir>/<taskId>/<runId>.json
+type ArchivedRun struct {
+	TaskID        string                       `json:"taskId"`
+	RunID         uint                         `json:"runId"`
+	ProvisionerID string                       `json:"provisionerId"`
+	WorkerType    string                       `json:"workerType"`
+	WorkerGroup   string                       `json:"workerGroup"`
+	WorkerID      string                       `json:"workerId"`
+	Task          queue.TaskDefinitionResponse `json:"task"`
+	Artifacts     []ArchivedArtifact           `json:"artifacts"`
+}
+
+// archiveable returns whether the artifact with the given name should be
+// copied to the artifact archive
+func archiveable(name string) bool {
+	if config.ArtifactArchiveDir == "" {
+		return false
+	}
+	if len(config.ArtifactArchivePatterns) == 0 {
+		return true
+	}
+	for _, pattern := range config.ArtifactArchivePatterns {
+		if match, _ := path.Match(pattern, name); match {
+			return true
+		}
+	}
+	return false
+}
+
+// archiveArtifact copies the content of the given (uploaded) artifact to
+// <artifactArchiveDir>/<taskId>/<runId>/<name>, and adds it to the metadata
+// of the run in the archive
+func (task *TaskRun) archiveArtifact(artifact S3Artifact) error {
+	name := artifact.ArtifactName()
+	runDir := filepath.Join(config.ArtifactArchiveDir, task.TaskID, strconv.Itoa(int(task.RunID)))
+	dest := filepath.Join(runDir, filepath.FromSlash(name))
+	// artifact names come from the task, so must not be trusted to stay
+	// inside the archive directory of the task run
+	if !withinDirectory(runDir, dest) {
+		return fmt.Errorf("Artifact name %q would be archived outside of artifact archive directory %v", name, runDir)
+	}
+	err := os.MkdirAll(filepath.Dir(dest), 0777)
+	if err != nil {
+		return err
+	}
+	err = copyFileContents(longPath(filepath.Join(TaskDir, artifact.CanonicalPath)), longPath(dest))
+	if err != nil {
+		return err
+	}
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	hash := task.artifactHashes[name]
+	task.archivedArtifacts = append(task.archivedArtifacts, ArchivedArtifact{
+		Name:    name,
+		SHA256:  hash.SHA256,
+		SHA512:  hash.SHA512,
+		Size:    hash.Size,
+		Expires: artifact.Expires,
+	})
+	// rewritten each time, since artifacts are archived as they are uploaded,
+	// up to and including the task logs
+	return writeToFileAsJSON(
+		ArchivedRun{
+			TaskID:        task.TaskID,
+			RunID:         task.RunID,
+			ProvisionerID: config.ProvisionerID,
+			WorkerType:    config.WorkerType,
+			WorkerGroup:   config.WorkerGroup,
+			WorkerID:      config.WorkerID,
+			Task:          task.Definition,
+			Artifacts:     task.archivedArtifacts,
+		},
+		runDir+".json",
+	)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// artifactPrefix returns the prefix for the names of the payload artifacts of
+// the task, taken from payload property artifactPrefix, or if not set, from
+// config setting artifactPrefix, with template variables such as ${platform}
+// substituted. An unknown template variable is an error, as is a prefix that
+// is not a relative path without ".." segments, since artifact names are also
+// used as paths on the worker (e.g. by config setting artifactStorage). A
+// non-empty prefix is returned ending in exactly one "/", so that it is always
+// a directory of the artifact names, whether or not it was given with one.
+func (task *TaskRun) artifactPrefix() (string, error) {
+	prefix := task.Payload.ArtifactPrefix
+	if prefix == "" {
+		prefix = config.ArtifactPrefix
+	}
+	unknown := []string{}
+	expanded := os.Expand(prefix, func(name string) string {
+		switch name {
+		case "platform":
+			return runtime.GOOS
+		case "arch":
+			return runtime.GOARCH
+		case "provisionerId":
+			return config.ProvisionerID
+		case "workerType":
+			return config.WorkerType
+		case "workerGroup":
+			return config.WorkerGroup
+		}
+		unknown = append(unknown, name)
+		return ""
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("Artifact prefix %q contains unknown template variable(s) %v - supported variables are ${platform}, ${arch}, ${provisionerId}, ${workerType} and ${workerGroup}", prefix, strings.Join(unknown, ", "))
+	}
+	if strings.HasPrefix(expanded, "/") || strings.Contains(expanded, `\`) {
+		return "", fmt.Errorf("Artifact prefix %q must be a relative path using forward slashes, but is %q", prefix, expanded)
+	}
+	for _, segment := range strings.Split(expanded, "/") {
+		if segment == ".." {
+			return "", fmt.Errorf("Artifact prefix %q may not contain \"..\" path segments, but is %q", prefix, expanded)
+		}
+	}
+	expanded = strings.TrimRight(expanded, "/")
+	if expanded == "" {
+		return "", nil
+	}
+	return expanded + "/", nil
+}
+
+// prefixArtifactName sets the name that artifact is published under to its
+// canonical path with the given prefix
+func prefixArtifactName(artifact Artifact, prefix string) Artifact {
+	if prefix == "" {
+		return artifact
+	}
+	name := prefix + artifact.Base().CanonicalPath
+	switch a := artifact.(type) {
+	case S3Artifact:
+		a.Name = name
+		return a
+	case RedirectArtifact:
+		a.Name = name
+		return a
+	case ErrorArtifact:
+		a.Name = name
+		return a
+	}
+	return artifact
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"compress/gzip"
+	"crypto/md5"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +16,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/taskcluster/httpbackoff"
 	tcclient "github.com/taskcluster/taskcluster-client-go"
@@ -29,8 +32,16 @@ type (
 	}
 
 	BaseArtifact struct {
+		// path of the artifact content, relative to the task directory,
+		// using forward slashes
 		CanonicalPath string
-		Expires       tcclient.Time
+		// name the artifact is published under, if different to
+		// CanonicalPath (see payload property artifactPrefix)
+		Name    string
+		Expires tcclient.Time
+		// only uploaded within the artifact upload time budget of the task
+		// (see payload property artifactUploadBudgetSecs)
+		BestEffort bool
 	}
 
 	S3Artifact struct {
@@ -61,6 +72,14 @@ func (base BaseArtifact) Base() BaseArtifact {
 	return base
 }
 
+// ArtifactName returns the name the artifact is published under
+func (base BaseArtifact) ArtifactName() string {
+	if base.Name != "" {
+		return base.Name
+	}
+	return base.CanonicalPath
+}
+
 func (artifact RedirectArtifact) ProcessResponse(response interface{}) error {
 	// nothing to do
 	return nil
@@ -109,7 +128,7 @@ func gzipCompressFile(rawContentFile string) (string, error) {
 	defer tmpFile.Close()
 	gzipLogWriter := gzip.NewWriter(tmpFile)
 	gzipLogWriter.Name = baseName
-	rawContent, err := os.Open(rawContentFile)
+	rawContent, err := os.Open(longPath(rawContentFile))
 	if err != nil {
 		return "", err
 	}
@@ -119,13 +138,33 @@ func gzipCompressFile(rawContentFile string) (string, error) {
 	return tmpFile.Name(), nil
 }
 
+// ProcessResponse uploads the content of the artifact to the put url of the
+// response, without verifying the stored object (see uploadArtifact)
 func (artifact S3Artifact) ProcessResponse(resp interface{}) (err error) {
 	response := resp.(*queue.S3ArtifactResponse)
-	rawContentFile := filepath.Join(TaskUser.HomeDir, artifact.Base().CanonicalPath)
+	return artifact.put(response.PutURL, "")
+}
+
+// put uploads the content of the artifact to the given url with an HTTP PUT
+// request, gzip compressing it first if its content encoding is gzip. If
+// getURL is not empty, the stored object is requested from it to verify the
+// upload (see verifyUpload).
+func (artifact S3Artifact) put(putURL, getURL string) (err error) {
+	rawContentFile := filepath.Join(TaskDir, artifact.Base().CanonicalPath)
 
 	// if Content-Encoding is gzip then we will need to gzip content...
 	transferContentFile := rawContentFile
 	if artifact.ContentEncoding == "gzip" {
+		// the compressed copy is staged in the temp directory, which must not
+		// eat into the disk space reserved for the worker
+		info, err := os.Stat(longPath(rawContentFile))
+		if err != nil {
+			return err
+		}
+		err = headroomProblem(os.TempDir(), uint64(info.Size()))
+		if err != nil {
+			return fmt.Errorf("Not enough disk space to compress artifact %v: %s", artifact.ArtifactName(), err)
+		}
 		transferContentFile, err = gzipCompressFile(rawContentFile)
 		if err != nil {
 			return err
@@ -133,23 +172,36 @@ func (artifact S3Artifact) ProcessResponse(resp interface{}) (err error) {
 		defer os.Remove(transferContentFile)
 	}
 
+	// md5 is not FIPS 140 approved, so in FIPS mode uploads are only verified
+	// by size
+	contentMD5 := ""
+	if !config.FIPSMode {
+		contentMD5, err = fileMD5(transferContentFile)
+		if err != nil {
+			return err
+		}
+	}
+
 	// perform http PUT to upload to S3...
-	httpClient := &http.Client{}
-	httpCall := func() (*http.Response, error, error) {
-		transferContent, err := os.Open(transferContentFile)
+	httpCall := func(client *http.Client) (*http.Response, error) {
+		transferContent, err := os.Open(longPath(transferContentFile))
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		defer transferContent.Close()
 		transferContentFileInfo, err := transferContent.Stat()
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		transferContentLength := transferContentFileInfo.Size()
 
-		httpRequest, err := http.NewRequest("PUT", response.PutURL, transferContent)
+		var body io.Reader = transferContent
+		if config.ArtifactUploadBytesPerSec > 0 {
+			body = newThrottledReader(transferContent, int64(config.ArtifactUploadBytesPerSec))
+		}
+		httpRequest, err := http.NewRequest("PUT", putURL, body)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
 		httpRequest.Header.Set("Content-Type", artifact.MimeType)
 		httpRequest.ContentLength = transferContentLength
@@ -163,12 +215,23 @@ func (artifact S3Artifact) ProcessResponse(resp interface{}) (err error) {
 			log.Println("Request")
 			log.Println(string(requestHeaders))
 		}
-		putResp, err := httpClient.Do(httpRequest)
-		return putResp, err, nil
+		putResp, err := client.Do(httpRequest)
+		if err != nil || putResp.StatusCode/100 != 2 {
+			return putResp, err
+		}
+		err = verifyUpload(client, artifact.ArtifactName(), getURL, putResp, transferContentLength, contentMD5)
+		if err != nil {
+			putResp.Body.Close()
+			return nil, err
+		}
+		return putResp, nil
+	}
+	putResp, putAttempts, err := retryPolicy("artifactUpload").retryHTTP("Uploading artifact "+artifact.ArtifactName(), httpCall)
+	log.Printf("%v put requests issued to %v", putAttempts, putURL)
+	if err != nil {
+		return err
 	}
-	putResp, putAttempts, err := httpbackoff.Retry(httpCall)
 	defer putResp.Body.Close()
-	log.Printf("%v put requests issued to %v", putAttempts, response.PutURL)
 	respBody, dumpError := httputil.DumpResponse(putResp, true)
 	if dumpError != nil {
 		log.Println("Could not dump response output, never mind...")
@@ -179,6 +242,101 @@ func (artifact S3Artifact) ProcessResponse(resp interface{}) (err error) {
 	return err
 }
 
+// fileMD5 returns the hex encoded md5 hash of the content of file
+func fileMD5(file string) (string, error) {
+	f, err := os.Open(longPath(file))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := md5.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// isMD5 returns whether etag is an md5 hash, as S3 returns for objects
+// uploaded in a single part
+func isMD5(etag string) bool {
+	b, err := hex.DecodeString(etag)
+	return err == nil && len(b) == md5.Size
+}
+
+// verifyUpload checks the object stored by a successful PUT against the
+// uploaded content, so that uploads truncated or corrupted in transit are
+// retried, rather than surfacing when a consumer of the artifact fails. If
+// the ETag of the PUT response is an md5 hash, it is compared with the md5
+// hash of the content. Otherwise the first byte of the object is requested
+// from getURL (the put url is only signed for PUT), and the size of the
+// object (and ETag, if an md5 hash) compared. If contentMD5 is empty (FIPS
+// mode), only the size is compared. Objects that cannot be requested are not
+// verified.
+func verifyUpload(client *http.Client, name, getURL string, putResp *http.Response, size int64, contentMD5 string) error {
+	etag := strings.Trim(putResp.Header.Get("ETag"), `"`)
+	if contentMD5 != "" && isMD5(etag) {
+		if etag != contentMD5 {
+			return fmt.Errorf("Upload of artifact %v is corrupt: ETag %v does not match md5 hash %v of content", name, etag, contentMD5)
+		}
+		log.Printf("Verified upload of artifact %v: ETag matches md5 hash %v of content", name, contentMD5)
+		return nil
+	}
+	if getURL == "" {
+		log.Printf("WARN: could not verify upload of artifact %v: no url to request it from", name)
+		return nil
+	}
+	stored, etag, err := storedObject(client, getURL)
+	if err != nil {
+		log.Printf("WARN: could not verify upload of artifact %v: %s", name, err)
+		return nil
+	}
+	if stored != size {
+		return fmt.Errorf("Upload of artifact %v is truncated: stored object has %v bytes, but content has %v bytes", name, stored, size)
+	}
+	if contentMD5 != "" && isMD5(etag) && etag != contentMD5 {
+		return fmt.Errorf("Upload of artifact %v is corrupt: ETag %v does not match md5 hash %v of content", name, etag, contentMD5)
+	}
+	log.Printf("Verified upload of artifact %v: stored object has %v bytes", name, stored)
+	return nil
+}
+
+// storedObject returns the size and ETag of the object at getURL, requesting
+// only its first byte
+func storedObject(client *http.Client, getURL string) (size int64, etag string, err error) {
+	req, err := http.NewRequest("GET", getURL, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	// the Content-Length header may be missing (e.g. for a chunked response),
+	// so the bytes read are counted instead
+	read, err := io.Copy(ioutil.Discard, resp.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	etag = strings.Trim(resp.Header.Get("ETag"), `"`)
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// range not supported, so the whole object was returned
+		return read, etag, nil
+	case http.StatusPartialContent, http.StatusRequestedRangeNotSatisfiable:
+		// e.g. "bytes 0-0/1234", or "bytes */0" for an empty object
+		contentRange := resp.Header.Get("Content-Range")
+		size, err = strconv.ParseInt(contentRange[strings.LastIndex(contentRange, "/")+1:], 10, 64)
+		if err != nil {
+			return 0, "", fmt.Errorf("Could not interpret Content-Range %q: %s", contentRange, err)
+		}
+		return size, etag, nil
+	}
+	return 0, "", fmt.Errorf("GET request returned HTTP response code %v", resp.StatusCode)
+}
+
 func (s3Artifact S3Artifact) RequestObject() interface{} {
 	return &queue.S3ArtifactRequest{
 		ContentType: s3Artifact.MimeType,
@@ -191,8 +349,39 @@ func (s3Artifact S3Artifact) ResponseObject() interface{} {
 	return new(queue.S3ArtifactResponse)
 }
 
+// uploadedArtifactHashes returns the hashes and size of each S3 artifact
+// uploaded so far, keyed by artifact name
+func (task *TaskRun) uploadedArtifactHashes() map[string]ArtifactHash {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	hashes := make(map[string]ArtifactHash, len(task.artifactHashes))
+	for name, hash := range task.artifactHashes {
+		hashes[name] = hash
+	}
+	return hashes
+}
+
+// uploadArtifactChecksums publishes the hashes and size of each S3
+// artifact uploaded so far as task artifact public/logs/artifact_checksums.json
+func (task *TaskRun) uploadArtifactChecksums() error {
+	const checksumsFile = "public/logs/artifact_checksums.json"
+	err := writeToFileAsJSON(task.uploadedArtifactHashes(), filepath.Join(TaskDir, checksumsFile))
+	if err != nil {
+		return err
+	}
+	return task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: checksumsFile,
+				Expires:       task.Definition.Expires,
+			},
+			MimeType: "application/json",
+		},
+	)
+}
+
 // Returns the artifacts as listed in the payload of the task (note this does
-// not include log files)
+// not include log files), named with the artifact prefix of the task, if any
 func (task *TaskRun) PayloadArtifacts() []Artifact {
 	artifacts := make([]Artifact, 0)
 	log.Println("Artifacts:")
@@ -200,20 +389,23 @@ func (task *TaskRun) PayloadArtifacts() []Artifact {
 		base := BaseArtifact{
 			CanonicalPath: canonicalPath(artifact.Path),
 			Expires:       artifact.Expires,
+			BestEffort:    artifact.BestEffort,
+		}
+		if artifact.Optional && !artifactExists(artifact.Path) {
+			log.Printf("Optional artifact %v not found, so not publishing it", artifact.Path)
+			continue
 		}
+		contentType := artifact.ContentType
 		switch artifact.Type {
 		case "file":
-			artifacts = append(artifacts, resolve(base, "file"))
+			artifacts = append(artifacts, resolve(base, "file", contentType))
 		case "directory":
-			if errArtifact := resolve(base, "directory"); errArtifact != nil {
+			if errArtifact := resolve(base, "directory", contentType); errArtifact != nil {
 				artifacts = append(artifacts, errArtifact)
 				continue
 			}
 			walkFn := func(path string, info os.FileInfo, incomingErr error) error {
-				// I think we don't need to handle incomingErr != nil since
-				// resolve(...) gets called which should catch the same issues
-				// raised in incomingErr - *** I GUESS *** !!
-				relativePath, err := filepath.Rel(TaskUser.HomeDir, path)
+				relativePath, err := filepath.Rel(longPath(TaskDir), path)
 				if err != nil {
 					log.Printf("WIERD ERROR - skipping file: %s", err)
 					return nil
@@ -221,23 +413,155 @@ func (task *TaskRun) PayloadArtifacts() []Artifact {
 				b := BaseArtifact{
 					CanonicalPath: canonicalPath(relativePath),
 					Expires:       artifact.Expires,
+					BestEffort:    artifact.BestEffort,
 				}
 				switch {
+				case incomingErr != nil:
+					// e.g. a subdirectory could not be read - publish an error
+					// artifact rather than silently skipping its contents
+					artifacts = append(artifacts, ErrorArtifact{
+						BaseArtifact: b,
+						Message:      fmt.Sprintf("Could not read '%s': %s", filepath.Join(TaskDir, b.CanonicalPath), incomingErr),
+						Reason:       "file-missing-on-worker",
+					})
 				case info.IsDir():
-					if errArtifact := resolve(b, "directory"); errArtifact != nil {
+					if errArtifact := resolve(b, "directory", contentType); errArtifact != nil {
 						artifacts = append(artifacts, errArtifact)
+						// otherwise walk reports the same problem again, when
+						// it fails to read the directory
+						return filepath.SkipDir
 					}
 				default:
-					artifacts = append(artifacts, resolve(b, "file"))
+					artifacts = append(artifacts, resolve(b, "file", contentType))
 				}
 				return nil
 			}
-			filepath.Walk(filepath.Join(TaskUser.HomeDir, base.CanonicalPath), walkFn)
+			filepath.Walk(longPath(filepath.Join(TaskDir, base.CanonicalPath)), walkFn)
 		}
 	}
+	for i := range artifacts {
+		artifacts[i] = prefixArtifactName(artifacts[i], task.artifactNamePrefix)
+	}
 	return artifacts
 }
 
+// uploadBestEffortArtifacts uploads the best effort artifacts among
+// artifacts, for as long as the artifact upload time budget of the task,
+// counted from uploadsStarted, has not been used up. Best effort artifacts
+// that are skipped, or fail to upload, are reported in the task log, but do
+// not affect the resolution of the task.
+func (task *TaskRun) uploadBestEffortArtifacts(artifacts []Artifact, uploadsStarted time.Time) {
+	budget := time.Duration(task.Payload.ArtifactUploadBudgetSecs) * time.Second
+	for _, artifact := range artifacts {
+		if !artifact.Base().BestEffort || task.publishedWhileWatching(artifact) {
+			continue
+		}
+		name := artifact.Base().ArtifactName()
+		if spent := time.Since(uploadsStarted); budget > 0 && spent >= budget {
+			task.Log(fmt.Sprintf("Not uploading best effort artifact %v, since %v of the artifact upload time budget of %v has been used", name, spent-spent%time.Second, budget))
+			continue
+		}
+		err := task.uploadArtifact(artifact)
+		if err != nil {
+			task.Log(fmt.Sprintf("Could not upload best effort artifact %v: %s", name, err))
+		}
+	}
+}
+
+// uploadFailure classifies the failure to upload artifact with err, and
+// explains it in the task log
+func (task *TaskRun) uploadFailure(artifact Artifact, err error) *CommandExecutionError {
+	switch t := err.(type) {
+	case *os.PathError:
+		// artifact does not exist or is not readable...
+		return &CommandExecutionError{
+			Cause:      err,
+			TaskStatus: Failed,
+		}
+	case httpbackoff.BadHttpResponseCode:
+		// if not a 5xx error, then not worth retrying...
+		if t.HttpResponseCode/100 != 5 {
+			task.Log(fmt.Sprintf("TASK FAIL due to response code %v from Queue when uploading artifact %v", t.HttpResponseCode, artifact))
+			return &CommandExecutionError{
+				Cause:      err,
+				TaskStatus: Failed,
+			}
+		}
+		task.Log(fmt.Sprintf("TASK EXCEPTION due to response code %v from Queue when uploading artifact %v", t.HttpResponseCode, artifact))
+		return ResourceUnavailable(err) // upload failure, Queue unavailable
+	}
+	task.Log(fmt.Sprintf("TASK EXCEPTION due to error %#v", err))
+	// could not upload for another reason
+	return InternalError(err)
+}
+
+// artifactExists returns whether the file or directory at the given path,
+// relative to the task directory, exists
+func artifactExists(path string) bool {
+	_, err := os.Stat(longPath(filepath.Join(TaskDir, path)))
+	return !os.IsNotExist(err)
+}
+
+// reportMissingArtifacts writes the payload artifacts that do not exist, and
+// are not optional, to the task log, since otherwise they would only be
+// reported via the error artifacts published in their place
+func (task *TaskRun) reportMissingArtifacts() {
+	for _, artifact := range task.Payload.Artifacts {
+		if !artifact.Optional && !artifactExists(artifact.Path) {
+			task.Log(fmt.Sprintf("Artifact %v (%v) does not exist, so an error artifact will be published instead", artifact.Path, artifact.Type))
+		}
+	}
+}
+
+// checkRequiredArtifacts fails the task if any of the payload artifacts that
+// are required is missing or empty, since commands that exit successfully
+// without producing their output are usually broken
+func (task *TaskRun) checkRequiredArtifacts() *CommandExecutionError {
+	for _, artifact := range task.Payload.Artifacts {
+		if !artifact.Required {
+			continue
+		}
+		problem := ""
+		path := filepath.Join(TaskDir, artifact.Path)
+		info, err := os.Stat(longPath(path))
+		switch {
+		case err != nil:
+			problem = "does not exist"
+		case artifact.Type == "file" && !info.Mode().IsRegular():
+			problem = "is not a file"
+		case artifact.Type == "file" && info.Size() == 0:
+			problem = "is empty"
+		case artifact.Type == "directory" && !info.IsDir():
+			problem = "is not a directory"
+		case artifact.Type == "directory" && !containsFiles(path):
+			problem = "contains no files"
+		}
+		if problem != "" {
+			err := fmt.Errorf("Missing expected artifact: %v %v", artifact.Path, problem)
+			task.Log(err.Error())
+			return &CommandExecutionError{
+				Cause:      err,
+				TaskStatus: Failed,
+			}
+		}
+	}
+	return nil
+}
+
+// containsFiles returns whether the directory dir contains any files,
+// including in subdirectories
+func containsFiles(dir string) bool {
+	found := false
+	filepath.Walk(longPath(dir), func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			found = true
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return found
+}
+
 // File should be resolved as an S3Artifact if file exists as file and is
 // readable, otherwise i) if it does not exist or ii) cannot be read, as a
 // "file-missing-on-worker" ErrorArtifact, otherwise if it exists as a
@@ -245,11 +569,12 @@ func (task *TaskRun) PayloadArtifacts() []Artifact {
 // resolve as `nil` if directory exists as directory and is readable, otherwise
 // i) if it does not exist or ii) cannot be read, as a "file-missing-on-worker"
 // ErrorArtifact, otherwise if it exists as a file, as
-// "invalid-resource-on-worker" ErrorArtifact
+// "invalid-resource-on-worker" ErrorArtifact. If contentType is empty, the
+// mime type of a file artifact is determined by detectMimeType.
 // TODO: need to also handle "too-large-file-on-worker"
-func resolve(base BaseArtifact, artifactType string) Artifact {
-	fullPath := filepath.Join(TaskUser.HomeDir, base.CanonicalPath)
-	fileReader, err := os.Open(fullPath)
+func resolve(base BaseArtifact, artifactType string, contentType string) Artifact {
+	fullPath := filepath.Join(TaskDir, base.CanonicalPath)
+	fileReader, err := os.Open(longPath(fullPath))
 	if err != nil {
 		// cannot read file/dir, create an error artifact
 		return ErrorArtifact{
@@ -285,11 +610,9 @@ func resolve(base BaseArtifact, artifactType string) Artifact {
 	if artifactType == "directory" {
 		return nil
 	}
-	mimeType := mime.TypeByExtension(filepath.Ext(base.CanonicalPath))
-	// check we have a mime type!
+	mimeType := contentType
 	if mimeType == "" {
-		// application/octet-stream is the mime type for "unknown"
-		mimeType = "application/octet-stream"
+		mimeType = detectMimeType(fileReader, base.CanonicalPath)
 	}
 	return S3Artifact{
 		BaseArtifact: base,
@@ -297,6 +620,24 @@ func resolve(base BaseArtifact, artifactType string) Artifact {
 	}
 }
 
+// detectMimeType returns the mime type for the file with the given canonical
+// path, based on its file extension. If the extension is not recognised, the
+// first 512 bytes of content are sniffed instead. Text types include a
+// charset. If neither approach yields a result (e.g. the file is empty),
+// "application/octet-stream" is returned.
+func detectMimeType(content io.Reader, path string) string {
+	if mimeType := mime.TypeByExtension(filepath.Ext(path)); mimeType != "" {
+		return mimeType
+	}
+	buffer := make([]byte, 512)
+	n, err := io.ReadFull(content, buffer)
+	if n > 0 && (err == nil || err == io.ErrUnexpectedEOF) {
+		return http.DetectContentType(buffer[:n])
+	}
+	// application/octet-stream is the mime type for "unknown"
+	return "application/octet-stream"
+}
+
 // The Queue expects paths to use a forward slash, so let's make sure we have a
 // way to generate a path in this format
 func canonicalPath(path string) string {
@@ -321,19 +662,60 @@ func (task *TaskRun) uploadLog(logFile string) error {
 }
 
 func (task *TaskRun) uploadArtifact(artifact Artifact) error {
-	log.Println("Uploading artifact: " + artifact.Base().CanonicalPath)
+	log.Println("Uploading artifact: " + artifact.Base().ArtifactName())
+	defer task.trace.span("artifacts", artifact.Base().ArtifactName(), time.Now(), nil)
+	task.lock.Lock()
 	task.Artifacts = append(task.Artifacts, artifact)
+	task.lock.Unlock()
+	// record checksum and size of content, so that consumers can verify it
+	// (see uploadArtifactChecksums and chain of trust feature)
+	if s3Artifact, ok := artifact.(S3Artifact); ok {
+		hash, err := calculateHash(s3Artifact)
+		if err != nil {
+			return err
+		}
+		task.lock.Lock()
+		if task.artifactHashes == nil {
+			task.artifactHashes = map[string]ArtifactHash{}
+		}
+		task.artifactHashes[s3Artifact.ArtifactName()] = hash
+		task.lock.Unlock()
+	}
+	if task.localArtifactsDir != "" {
+		return task.saveArtifactLocally(artifact)
+	}
+	err := injectFault("artifactUpload")
+	if err != nil {
+		return err
+	}
+	s3Artifact, isS3Artifact := artifact.(S3Artifact)
+	if storage := config.artifactStorage(); storage != nil && isS3Artifact {
+		url, err := storage.Store(task, s3Artifact)
+		if err != nil {
+			return fmt.Errorf("Could not store artifact %v: %s", s3Artifact.ArtifactName(), err)
+		}
+		artifact = RedirectArtifact{
+			BaseArtifact: s3Artifact.BaseArtifact,
+			MimeType:     s3Artifact.MimeType,
+			URL:          url,
+		}
+	}
 	payload, err := json.Marshal(artifact.RequestObject())
 	if err != nil {
 		return err
 	}
 	par := queue.PostArtifactRequest(json.RawMessage(payload))
-	parsp, err := task.Queue.CreateArtifact(
-		task.TaskID,
-		strconv.Itoa(int(task.RunID)),
-		artifact.Base().CanonicalPath,
-		&par,
-	)
+	var parsp *queue.PostArtifactResponse
+	err = task.retryOnTaskAuthError("createArtifact", func() (err error) {
+		limitQueueCall("createArtifact")
+		parsp, err = task.Queue.CreateArtifact(
+			task.TaskID,
+			strconv.Itoa(int(task.RunID)),
+			artifact.Base().ArtifactName(),
+			&par,
+		)
+		return
+	})
 	if err != nil {
 		log.Printf("Could not upload artifact: %v", artifact)
 		log.Printf("%v", parsp)
@@ -345,5 +727,25 @@ func (task *TaskRun) uploadArtifact(artifact Artifact) error {
 	if err != nil {
 		return err
 	}
-	return artifact.ProcessResponse(resp)
+	if s3Response, ok := resp.(*queue.S3ArtifactResponse); ok {
+		// the put url is only signed for PUT, so the upload is verified with
+		// a url of the queue that redirects to the stored object
+		getURL, err := task.Queue.GetArtifact_SignedURL(task.TaskID, strconv.Itoa(int(task.RunID)), s3Artifact.ArtifactName(), time.Hour)
+		if err != nil {
+			return err
+		}
+		err = s3Artifact.put(s3Response.PutURL, getURL.String())
+	} else {
+		err = artifact.ProcessResponse(resp)
+	}
+	if err != nil {
+		return err
+	}
+	if isS3Artifact && archiveable(s3Artifact.ArtifactName()) {
+		err = task.archiveArtifact(s3Artifact)
+		if err != nil {
+			return fmt.Errorf("Could not copy artifact %v to artifact archive: %s", s3Artifact.ArtifactName(), err)
+		}
+	}
+	return nil
 }
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -40,7 +41,7 @@ func setup(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Test failed during setup phase!")
 	}
-	TaskUser.HomeDir = filepath.Join(cwd, "testdata")
+	TaskDir = filepath.Join(cwd, "testdata")
 
 	expiry = tcclient.Time(time.Now().Add(time.Hour * 1))
 }
@@ -48,9 +49,15 @@ func setup(t *testing.T) {
 func validateArtifacts(
 	t *testing.T,
 	payloadArtifacts []struct {
-		Expires tcclient.Time `json:"expires"`
-		Path    string        `json:"path"`
-		Type    string        `json:"type"`
+		BestEffort  bool          `json:"bestEffort,omitempty"`
+		ContentType string        `json:"contentType,omitempty"`
+		Expires     tcclient.Time `json:"expires"`
+		HostPath    string        `json:"hostPath,omitempty"`
+		Optional    bool          `json:"optional,omitempty"`
+		Path        string        `json:"path"`
+		Required    bool          `json:"required,omitempty"`
+		Type        string        `json:"type"`
+		Watch       bool          `json:"watch,omitempty"`
 	},
 	expected []Artifact) {
 
@@ -81,9 +88,15 @@ func TestDirectoryArtifacts(t *testing.T) {
 
 		// what appears in task payload
 		[]struct {
-			Expires tcclient.Time `json:"expires"`
-			Path    string        `json:"path"`
-			Type    string        `json:"type"`
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
 		}{{
 			Expires: expiry,
 			Path:    "SampleArtifacts",
@@ -124,9 +137,15 @@ func TestMissingFileArtifact(t *testing.T) {
 
 		// what appears in task payload
 		[]struct {
-			Expires tcclient.Time `json:"expires"`
-			Path    string        `json:"path"`
-			Type    string        `json:"type"`
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
 		}{{
 			Expires: expiry,
 			Path:    "TestMissingFileArtifact/no_such_file",
@@ -140,12 +159,460 @@ func TestMissingFileArtifact(t *testing.T) {
 					CanonicalPath: "TestMissingFileArtifact/no_such_file",
 					Expires:       expiry,
 				},
-				Message: "Could not read file '" + filepath.Join(TaskUser.HomeDir, "TestMissingFileArtifact", "no_such_file") + "'",
+				Message: "Could not read file '" + filepath.Join(TaskDir, "TestMissingFileArtifact", "no_such_file") + "'",
 				Reason:  "file-missing-on-worker",
 			},
 		})
 }
 
+// Task payload specifies an optional file artifact which doesn't exist on
+// worker, so no artifact should be published
+func TestMissingOptionalFileArtifact(t *testing.T) {
+
+	setup(t)
+	validateArtifacts(t,
+
+		// what appears in task payload
+		[]struct {
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
+		}{{
+			Expires:  expiry,
+			Optional: true,
+			Path:     "TestMissingOptionalFileArtifact/no_such_file",
+			Type:     "file",
+		}},
+
+		// what we expect to discover on file system
+		[]Artifact{})
+}
+
+// Payload artifacts should be published under the artifact prefix, with
+// template variables substituted, but still be read from their path on the
+// worker.
+func TestArtifactPrefix(t *testing.T) {
+	setup(t)
+	defer func(c *Config) {
+		config = c
+	}(config)
+	config = &Config{WorkerType: "win2012r2", ArtifactPrefix: "public/default/"}
+	tr := &TaskRun{}
+	prefix, err := tr.artifactPrefix()
+	if err != nil || prefix != "public/default/" {
+		t.Fatalf("Expected config artifact prefix to be used, got %q (%v)", prefix, err)
+	}
+	tr.Payload.ArtifactPrefix = "public/build/${platform}/${workerType}/"
+	prefix, err = tr.artifactPrefix()
+	if expected := "public/build/" + runtime.GOOS + "/win2012r2/"; err != nil || prefix != expected {
+		t.Fatalf("Expected artifact prefix %q, got %q (%v)", expected, prefix, err)
+	}
+	// a prefix without a trailing slash is still a directory, and one with
+	// several is normalised to have exactly one
+	for given, expected := range map[string]string{"public/build": "public/build/", "public/build//": "public/build/"} {
+		tr.Payload.ArtifactPrefix = given
+		prefix, err = tr.artifactPrefix()
+		if err != nil || prefix != expected {
+			t.Fatalf("Expected artifact prefix %q to be normalised to %q, got %q (%v)", given, expected, prefix, err)
+		}
+	}
+	tr.Payload.ArtifactPrefix = "public/${taskId}/"
+	if _, err = tr.artifactPrefix(); err == nil {
+		t.Fatal("Expected unknown template variable to be rejected")
+	}
+	for _, invalid := range []string{"../", "public/../../", "/public/", `public\build\`} {
+		tr.Payload.ArtifactPrefix = invalid
+		if _, err = tr.artifactPrefix(); err == nil {
+			t.Fatalf("Expected artifact prefix %q to be rejected", invalid)
+		}
+	}
+	tr.Payload.Artifacts = []struct {
+		BestEffort  bool          `json:"bestEffort,omitempty"`
+		ContentType string        `json:"contentType,omitempty"`
+		Expires     tcclient.Time `json:"expires"`
+		HostPath    string        `json:"hostPath,omitempty"`
+		Optional    bool          `json:"optional,omitempty"`
+		Path        string        `json:"path"`
+		Required    bool          `json:"required,omitempty"`
+		Type        string        `json:"type"`
+		Watch       bool          `json:"watch,omitempty"`
+	}{{
+		Expires: expiry,
+		Path:    "SampleArtifacts/_/X.txt",
+		Type:    "file",
+	}}
+	tr.artifactNamePrefix = "public/build/linux/"
+	artifacts := tr.PayloadArtifacts()
+	if len(artifacts) != 1 {
+		t.Fatalf("Expected one artifact, got %q", artifacts)
+	}
+	base := artifacts[0].Base()
+	if base.ArtifactName() != "public/build/linux/SampleArtifacts/_/X.txt" || base.CanonicalPath != "SampleArtifacts/_/X.txt" {
+		t.Fatalf("Expected prefixed artifact name, but unchanged path, got %#v", base)
+	}
+}
+
+// Artifacts matching the archive patterns should be copied to the artifact
+// archive, and recorded in the archived run metadata.
+func TestArchiveArtifact(t *testing.T) {
+	setup(t)
+	defer func(c *Config) {
+		config = c
+	}(config)
+	archiveDir, err := ioutil.TempDir("", "artifact-archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archiveDir)
+	config = &Config{ArtifactArchiveDir: archiveDir, ArtifactArchivePatterns: []string{"SampleArtifacts/_/*.txt"}}
+	if archiveable("SampleArtifacts/b/c/d.jpg") || !archiveable("SampleArtifacts/_/X.txt") {
+		t.Fatal("Expected only artifacts matching artifactArchivePatterns to be archiveable")
+	}
+	tr := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", RunID: 1}
+	err = tr.archiveArtifact(S3Artifact{
+		BaseArtifact: BaseArtifact{
+			CanonicalPath: "SampleArtifacts/_/X.txt",
+			Expires:       expiry,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Could not archive artifact: %v", err)
+	}
+	original, err := ioutil.ReadFile(filepath.Join(TaskDir, "SampleArtifacts", "_", "X.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived, err := ioutil.ReadFile(filepath.Join(archiveDir, "KTBKfEgxR5GdfIIREQIvFQ", "1", "SampleArtifacts", "_", "X.txt"))
+	if err != nil || !bytes.Equal(original, archived) {
+		t.Fatalf("Expected archived artifact to match original (error: %v)", err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(archiveDir, "KTBKfEgxR5GdfIIREQIvFQ", "1.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var run ArchivedRun
+	err = json.Unmarshal(data, &run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.TaskID != "KTBKfEgxR5GdfIIREQIvFQ" || len(run.Artifacts) != 1 || run.Artifacts[0].Name != "SampleArtifacts/_/X.txt" {
+		t.Fatalf("Unexpected archived run metadata: %s", data)
+	}
+	err = tr.archiveArtifact(S3Artifact{
+		BaseArtifact: BaseArtifact{
+			Name:          "../../escaped.txt",
+			CanonicalPath: "SampleArtifacts/_/X.txt",
+			Expires:       expiry,
+		},
+	})
+	if err == nil {
+		t.Fatal("Expected artifact name outside of archive directory to be rejected")
+	}
+	if _, err = os.Stat(filepath.Join(archiveDir, "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Artifact should not have been archived outside of run directory (error: %v)", err)
+	}
+}
+
+func TestArtifactStorage(t *testing.T) {
+	setup(t)
+	defer func(c *Config) {
+		config = c
+	}(config)
+	original, err := ioutil.ReadFile(filepath.Join(TaskDir, "SampleArtifacts", "_", "X.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact := S3Artifact{
+		BaseArtifact: BaseArtifact{
+			CanonicalPath: "SampleArtifacts/_/X.txt",
+			Expires:       expiry,
+		},
+		MimeType: "text/plain",
+	}
+	tr := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", RunID: 1}
+
+	storageDir, err := ioutil.TempDir("", "artifact-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(storageDir)
+	config = &Config{ArtifactStorage: "directory", ArtifactStorageDir: storageDir, ArtifactStorageURL: "https://artifacts.example.com/"}
+	url, err := config.artifactStorage().Store(tr, artifact)
+	if err != nil {
+		t.Fatalf("Could not store artifact in directory: %v", err)
+	}
+	if url != "https://artifacts.example.com/KTBKfEgxR5GdfIIREQIvFQ/1/SampleArtifacts/_/X.txt" {
+		t.Fatalf("Unexpected url for artifact stored in directory: %v", url)
+	}
+	stored, err := ioutil.ReadFile(filepath.Join(storageDir, "KTBKfEgxR5GdfIIREQIvFQ", "1", "SampleArtifacts", "_", "X.txt"))
+	if err != nil || !bytes.Equal(original, stored) {
+		t.Fatalf("Expected stored artifact to match original (error: %v)", err)
+	}
+	escaping := artifact
+	escaping.Name = "../../../escaped.txt"
+	if _, err = config.artifactStorage().Store(tr, escaping); err == nil {
+		t.Fatal("Expected artifact name outside of storage directory to be rejected")
+	}
+	if _, err = os.Stat(filepath.Join(storageDir, "..", "escaped.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Artifact should not have been stored outside of storage directory (error: %v)", err)
+	}
+
+	var putPath string
+	var put []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// uploads are verified with a GET request afterwards
+		if r.Method == "GET" {
+			http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(put))
+			return
+		}
+		putPath = r.Method + " " + r.URL.Path
+		put, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	config = &Config{ArtifactStorage: "http", ArtifactStorageURL: server.URL + "/artifacts"}
+	artifact.ContentEncoding = "gzip"
+	url, err = config.artifactStorage().Store(tr, artifact)
+	if err != nil {
+		t.Fatalf("Could not store artifact over http: %v", err)
+	}
+	if url != server.URL+"/artifacts/KTBKfEgxR5GdfIIREQIvFQ/1/SampleArtifacts/_/X.txt" || putPath != "PUT /artifacts/KTBKfEgxR5GdfIIREQIvFQ/1/SampleArtifacts/_/X.txt" {
+		t.Fatalf("Unexpected url %v for artifact stored with %v", url, putPath)
+	}
+	if !bytes.Equal(original, put) {
+		t.Fatal("Expected artifact to be uploaded uncompressed")
+	}
+}
+
+// Uploads whose stored object does not match the content should be retried,
+// whether the mismatch is reported by the ETag of the PUT response or found
+// by requesting the stored object from its get url.
+func TestVerifyUpload(t *testing.T) {
+	setup(t)
+	defer func(c *Config) {
+		config = c
+	}(config)
+	config = &Config{}
+	httpClient = &http.Client{}
+	artifact := S3Artifact{
+		BaseArtifact: BaseArtifact{
+			CanonicalPath: "SampleArtifacts/_/X.txt",
+			Expires:       expiry,
+		},
+		MimeType: "text/plain",
+	}
+	contentMD5, err := fileMD5(filepath.Join(TaskDir, "SampleArtifacts", "_", "X.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, useGet := range []bool{false, true} {
+		puts := 0
+		var stored int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case "PUT":
+				puts++
+				body, _ := ioutil.ReadAll(r.Body)
+				stored = int64(len(body))
+				if puts == 1 {
+					// simulate truncation of the first upload
+					stored--
+				}
+				if !useGet {
+					etag := contentMD5
+					if puts == 1 {
+						etag = strings.Repeat("0", 32)
+					}
+					w.Header().Set("ETag", `"`+etag+`"`)
+				}
+			case "GET":
+				if r.Header.Get("Range") != "bytes=0-0" {
+					t.Errorf("Expected upload to be verified by requesting the first byte, but Range is %q", r.Header.Get("Range"))
+				}
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-0/%v", stored))
+				w.WriteHeader(http.StatusPartialContent)
+				w.Write([]byte("x"))
+			default:
+				// e.g. HEAD, which a put url is not signed for
+				w.WriteHeader(http.StatusForbidden)
+			}
+		}))
+		err := artifact.put(server.URL+"/put/X.txt", server.URL+"/get/X.txt")
+		server.Close()
+		if err != nil || puts != 2 {
+			t.Fatalf("Expected corrupt upload to be retried once (verified with GET: %v), got %v uploads: %v", useGet, puts, err)
+		}
+	}
+
+	// servers that do not support ranges return the whole object, possibly
+	// without a Content-Length header
+	puts := 0
+	var put []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case "PUT":
+			puts++
+			put, _ = ioutil.ReadAll(r.Body)
+		case "GET":
+			// flushing before writing the body forces a chunked response
+			w.(http.Flusher).Flush()
+			w.Write(put)
+		}
+	}))
+	defer server.Close()
+	err = artifact.put(server.URL+"/put/X.txt", server.URL+"/get/X.txt")
+	if err != nil || puts != 1 {
+		t.Fatalf("Expected upload verified from a chunked response not to be retried, got %v uploads: %v", puts, err)
+	}
+
+	// in FIPS mode there is no md5 hash, so uploads that are always truncated
+	// are caught by their size
+	config = &Config{
+		FIPSMode:      true,
+		RetryPolicies: map[string]RetryPolicy{"artifactUpload": {MaxAttempts: 2}},
+	}
+	truncating := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w.Header().Set("Content-Range", "bytes 0-0/1")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write([]byte("x"))
+		}
+	}))
+	defer truncating.Close()
+	err = artifact.put(truncating.URL+"/put/X.txt", truncating.URL+"/get/X.txt")
+	if err == nil || !strings.Contains(err.Error(), "is truncated") {
+		t.Fatalf("Expected upload that is always truncated to fail verification, but got: %v", err)
+	}
+}
+
+// Best effort artifacts should only be uploaded while the artifact upload
+// time budget of the task has not been used up.
+func TestBestEffortArtifacts(t *testing.T) {
+	setup(t)
+	localDir, err := ioutil.TempDir("", "best-effort-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(localDir)
+	artifacts := []Artifact{
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: "SampleArtifacts/_/X.txt",
+				Expires:       expiry,
+				BestEffort:    true,
+			},
+			MimeType: "text/plain",
+		},
+	}
+	task := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", localArtifactsDir: localDir}
+	task.Payload.ArtifactUploadBudgetSecs = 60
+	task.uploadBestEffortArtifacts(artifacts, time.Now().Add(-2*time.Minute))
+	uploaded := filepath.Join(localDir, "SampleArtifacts", "_", "X.txt")
+	if _, err := os.Stat(uploaded); !os.IsNotExist(err) {
+		t.Fatalf("Expected best effort artifact not to be uploaded once budget was used up (%v)", err)
+	}
+	task.uploadBestEffortArtifacts(artifacts, time.Now())
+	if _, err := os.Stat(uploaded); err != nil {
+		t.Fatalf("Expected best effort artifact to be uploaded within budget: %v", err)
+	}
+}
+
+// Required artifacts that are missing or empty should fail the task.
+func TestRequiredArtifacts(t *testing.T) {
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	var err error
+	TaskDir, err = ioutil.TempDir("", "required-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(TaskDir)
+	err = os.MkdirAll(filepath.Join(TaskDir, "build", "empty"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(TaskDir, "build", "app"), []byte("binary"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(TaskDir, "build", "empty.log"), []byte{}, 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		artifactType string
+		path         string
+		problem      string
+	}{
+		{"file", "build/app", ""},
+		{"directory", "build", ""},
+		{"file", "build/missing", "does not exist"},
+		{"file", "build/empty.log", "is empty"},
+		{"directory", "build/empty", "contains no files"},
+		{"file", "build/empty", "is not a file"},
+	} {
+		task := &TaskRun{}
+		task.Payload.Artifacts = make([]struct {
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
+		}, 1)
+		task.Payload.Artifacts[0].Type = test.artifactType
+		task.Payload.Artifacts[0].Path = test.path
+		task.Payload.Artifacts[0].Required = true
+		errArtifacts := task.checkRequiredArtifacts()
+		switch {
+		case test.problem == "" && errArtifacts != nil:
+			t.Fatalf("Expected %v %v to satisfy requirement, got %v", test.artifactType, test.path, errArtifacts)
+		case test.problem != "" && (errArtifacts == nil || errArtifacts.TaskStatus != Failed || !strings.HasSuffix(errArtifacts.Cause.Error(), test.problem)):
+			t.Fatalf("Expected %v %v to fail task since it %v, got %v", test.artifactType, test.path, test.problem, errArtifacts)
+		}
+	}
+}
+
+// Payload paths should be cleaned, env var references expanded, and paths
+// outside the task directory rejected. Backslashes are only path separators
+// on Windows.
+func TestNormalisePayloadPath(t *testing.T) {
+	env := map[string]string{"BUILD_DIR": "obj-x86_64"}
+	normalised := map[string]string{
+		"${BUILD_DIR}/dist/./target.zip": "obj-x86_64/dist/target.zip",
+		"public/logs/../build/":          "public/build",
+		// the whole task directory
+		".": ".",
+	}
+	rejected := []string{"/etc/passwd", "../outside", "public/../..", "${UNDEFINED}/x"}
+	if runtime.GOOS == "windows" {
+		normalised[`public\build\target.zip`] = "public/build/target.zip"
+		rejected = append(rejected, `C:\Windows`, `\\server\share\file`, `..\outside`)
+	} else {
+		normalised[`public\build\target.zip`] = `public\build\target.zip`
+		normalised[`C:\Windows`] = `C:\Windows`
+	}
+	for p, expected := range normalised {
+		actual, err := normalisePayloadPath("artifacts[0]", p, env)
+		if err != nil || actual != expected {
+			t.Errorf("Expected path %q to be normalised to %q, but got %q (error: %v)", p, expected, actual, err)
+		}
+	}
+	for _, p := range rejected {
+		_, err := normalisePayloadPath("artifacts[0]", p, env)
+		if err == nil || !strings.HasPrefix(err.Error(), "artifacts[0]: ") {
+			t.Errorf("Expected path %q to be rejected with an error naming the payload entry, but got %v", p, err)
+		}
+	}
+}
+
 // Task payload specifies a directory artifact which doesn't exist on worker
 func TestMissingDirectoryArtifact(t *testing.T) {
 
@@ -154,9 +621,15 @@ func TestMissingDirectoryArtifact(t *testing.T) {
 
 		// what appears in task payload
 		[]struct {
-			Expires tcclient.Time `json:"expires"`
-			Path    string        `json:"path"`
-			Type    string        `json:"type"`
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
 		}{{
 			Expires: expiry,
 			Path:    "TestMissingDirectoryArtifact/no_such_dir",
@@ -170,7 +643,7 @@ func TestMissingDirectoryArtifact(t *testing.T) {
 					CanonicalPath: "TestMissingDirectoryArtifact/no_such_dir",
 					Expires:       expiry,
 				},
-				Message: "Could not read directory '" + filepath.Join(TaskUser.HomeDir, "TestMissingDirectoryArtifact", "no_such_dir") + "'",
+				Message: "Could not read directory '" + filepath.Join(TaskDir, "TestMissingDirectoryArtifact", "no_such_dir") + "'",
 				Reason:  "file-missing-on-worker",
 			},
 		})
@@ -184,9 +657,15 @@ func TestFileArtifactIsDirectory(t *testing.T) {
 
 		// what appears in task payload
 		[]struct {
-			Expires tcclient.Time `json:"expires"`
-			Path    string        `json:"path"`
-			Type    string        `json:"type"`
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
 		}{{
 			Expires: expiry,
 			Path:    "SampleArtifacts/b/c",
@@ -200,7 +679,7 @@ func TestFileArtifactIsDirectory(t *testing.T) {
 					CanonicalPath: "SampleArtifacts/b/c",
 					Expires:       expiry,
 				},
-				Message: "File artifact '" + filepath.Join(TaskUser.HomeDir, "SampleArtifacts", "b", "c") + "' exists as a directory, not a file, on the worker",
+				Message: "File artifact '" + filepath.Join(TaskDir, "SampleArtifacts", "b", "c") + "' exists as a directory, not a file, on the worker",
 				Reason:  "invalid-resource-on-worker",
 			},
 		})
@@ -214,9 +693,15 @@ func TestDirectoryArtifactIsFile(t *testing.T) {
 
 		// what appears in task payload
 		[]struct {
-			Expires tcclient.Time `json:"expires"`
-			Path    string        `json:"path"`
-			Type    string        `json:"type"`
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
 		}{{
 			Expires: expiry,
 			Path:    "SampleArtifacts/b/c/d.jpg",
@@ -230,12 +715,206 @@ func TestDirectoryArtifactIsFile(t *testing.T) {
 					CanonicalPath: "SampleArtifacts/b/c/d.jpg",
 					Expires:       expiry,
 				},
-				Message: "Directory artifact '" + filepath.Join(TaskUser.HomeDir, "SampleArtifacts", "b", "c", "d.jpg") + "' exists as a file, not a directory, on the worker",
+				Message: "Directory artifact '" + filepath.Join(TaskDir, "SampleArtifacts", "b", "c", "d.jpg") + "' exists as a file, not a directory, on the worker",
 				Reason:  "invalid-resource-on-worker",
 			},
 		})
 }
 
+// Task payload specifies a file artifact without a file extension, so the
+// mime type should be determined by sniffing the content
+func TestSniffedMimeType(t *testing.T) {
+
+	setup(t)
+	validateArtifacts(t,
+
+		// what appears in task payload
+		[]struct {
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
+		}{{
+			Expires: expiry,
+			Path:    "MimeTypes/report",
+			Type:    "file",
+		}},
+
+		// what we expect to discover on file system
+		[]Artifact{
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: "MimeTypes/report",
+					Expires:       expiry,
+				},
+				MimeType: "text/html; charset=utf-8",
+			},
+		})
+}
+
+// Task payload specifies an explicit content type for a file artifact, which
+// should take precedence over the detected mime type
+func TestExplicitContentType(t *testing.T) {
+
+	setup(t)
+	validateArtifacts(t,
+
+		// what appears in task payload
+		[]struct {
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
+		}{{
+			ContentType: "text/plain; charset=latin1",
+			Expires:     expiry,
+			Path:        "MimeTypes/report",
+			Type:        "file",
+		}},
+
+		// what we expect to discover on file system
+		[]Artifact{
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: "MimeTypes/report",
+					Expires:       expiry,
+				},
+				MimeType: "text/plain; charset=latin1",
+			},
+		})
+}
+
+// Deeply nested directory trees (e.g. node_modules) can exceed MAX_PATH (260
+// characters) on Windows. This checks that files under such a tree are still
+// discovered when collecting a directory artifact.
+func TestLongPathDirectoryArtifact(t *testing.T) {
+
+	setup(t)
+	segments := []string{"LongPaths"}
+	for i := 0; i < 30; i++ {
+		segments = append(segments, "node_modules")
+	}
+	relDir := strings.Join(segments, "/")
+	absDir := filepath.Join(TaskDir, filepath.FromSlash(relDir))
+	if len(filepath.Join(absDir, "X.txt")) <= 260 {
+		t.Fatalf("Test directory path is not long enough: %v", absDir)
+	}
+	err := os.MkdirAll(longPath(absDir), 0755)
+	if err != nil {
+		t.Fatalf("Could not create directory %v: %v", absDir, err)
+	}
+	defer os.RemoveAll(longPath(filepath.Join(TaskDir, "LongPaths")))
+	err = ioutil.WriteFile(longPath(filepath.Join(absDir, "X.txt")), []byte("Hello World!\n"), 0644)
+	if err != nil {
+		t.Fatalf("Could not write file under %v: %v", absDir, err)
+	}
+
+	validateArtifacts(t,
+
+		// what appears in task payload
+		[]struct {
+			BestEffort  bool          `json:"bestEffort,omitempty"`
+			ContentType string        `json:"contentType,omitempty"`
+			Expires     tcclient.Time `json:"expires"`
+			HostPath    string        `json:"hostPath,omitempty"`
+			Optional    bool          `json:"optional,omitempty"`
+			Path        string        `json:"path"`
+			Required    bool          `json:"required,omitempty"`
+			Type        string        `json:"type"`
+			Watch       bool          `json:"watch,omitempty"`
+		}{{
+			Expires: expiry,
+			Path:    "LongPaths",
+			Type:    "directory",
+		}},
+
+		// what we expect to discover on file system
+		[]Artifact{
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: relDir + "/X.txt",
+					Expires:       expiry,
+				},
+				MimeType: "text/plain; charset=utf-8",
+			},
+		})
+}
+
+// An unreadable subdirectory of a directory artifact should be published as
+// a single error artifact, alongside the files that could be read.
+func TestUnreadableSubdirectoryArtifact(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Directory permissions are not enforced with chmod on Windows")
+	}
+	if os.Geteuid() == 0 {
+		t.Skip("Directory permissions are not enforced for root")
+	}
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	var err error
+	TaskDir, err = ioutil.TempDir("", "unreadable-subdirectory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(TaskDir)
+	err = os.MkdirAll(filepath.Join(TaskDir, "build", "secret"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(TaskDir, "build", "app.txt"), []byte("binary"), 0644)
+	}
+	if err == nil {
+		err = os.Chmod(filepath.Join(TaskDir, "build", "secret"), 0)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(filepath.Join(TaskDir, "build", "secret"), 0755)
+	task := &TaskRun{}
+	err = json.Unmarshal([]byte(`{"artifacts": [{"path": "build", "type": "directory"}]}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string][]Artifact{}
+	for _, artifact := range task.PayloadArtifacts() {
+		names[artifact.Base().CanonicalPath] = append(names[artifact.Base().CanonicalPath], artifact)
+	}
+	if len(names) != 2 || len(names["build/app.txt"]) != 1 {
+		t.Fatalf("Expected an artifact for build/app.txt and build/secret, but got %q", names)
+	}
+	if secret := names["build/secret"]; len(secret) != 1 {
+		t.Fatalf("Expected one error artifact for unreadable directory build/secret, but got %q", secret)
+	} else if _, isError := secret[0].(ErrorArtifact); !isError {
+		t.Fatalf("Expected an error artifact for unreadable directory build/secret, but got %q", secret[0])
+	}
+}
+
+func TestThrottledReader(t *testing.T) {
+	content := bytes.Repeat([]byte("x"), 3000)
+	start := time.Now()
+	data, err := ioutil.ReadAll(newThrottledReader(bytes.NewReader(content), 10000))
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("Could not read from throttled reader: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Fatal("Throttled reader returned different content to underlying reader")
+	}
+	// 3000 bytes at 10000 bytes per second should take at least 0.3s
+	if elapsed < 300*time.Millisecond {
+		t.Fatalf("Expected reading 3000 bytes at 10000 bytes per second to take at least 300ms, but took %v", elapsed)
+	}
+}
+
 func TestUpload(t *testing.T) {
 
 	setup(t)
@@ -640,3 +1319,89 @@ func TestUpload(t *testing.T) {
 		t.Fatalf("Expected region to be \"outer-space\" but was %v", cotCert.Environment.Region)
 	}
 }
+
+func TestHostArtifacts(t *testing.T) {
+	defer func(c *Config) {
+		config = c
+	}(config)
+	hostDir, err := ioutil.TempDir("", "host-artifacts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hostDir)
+	allowed := filepath.Join(hostDir, "dumps")
+	err = os.MkdirAll(filepath.Join(allowed, "app"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(allowed, "app", "crash.dmp"), []byte("dump"), 0644)
+	}
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(hostDir, "secret.txt"), []byte("secret"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = &Config{WorkerType: "win2012r2", HostArtifactDirs: []string{allowed}}
+
+	if err := validateHostArtifact(filepath.Join(allowed, "app"), false); err != nil {
+		t.Fatalf("Expected directory inside hostArtifactDirs to be allowed, got %v", err)
+	}
+	for _, test := range []struct {
+		hostPath string
+		watch    bool
+	}{
+		{filepath.Join(hostDir, "secret.txt"), false},
+		{filepath.Join(allowed, "..", "secret.txt"), false},
+		{allowed + "-other", false},
+		{filepath.Join("dumps", "app"), false},
+		{filepath.Join(allowed, "app"), true},
+	} {
+		if err := validateHostArtifact(test.hostPath, test.watch); err == nil {
+			t.Fatalf("Expected hostPath %v (watch %v) to be rejected", test.hostPath, test.watch)
+		}
+	}
+
+	taskDir := filepath.Join(hostDir, "task")
+	dest := filepath.Join(taskDir, "public", "crashes")
+	err = copyHostArtifact(filepath.Join(allowed, "app"), taskDir, "public/crashes")
+	if err != nil {
+		t.Fatalf("Could not copy host artifact: %v", err)
+	}
+	dump, err := ioutil.ReadFile(filepath.Join(dest, "crash.dmp"))
+	if err != nil || string(dump) != "dump" {
+		t.Fatalf("Expected crash.dmp to be copied, got %q, %v", dump, err)
+	}
+
+	if runtime.GOOS == "windows" {
+		return
+	}
+	// symbolic links must not escape the allowed directories
+	err = os.Symlink(filepath.Join(hostDir, "secret.txt"), filepath.Join(allowed, "link.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyHostArtifact(filepath.Join(allowed, "link.txt"), taskDir, "public/crashes/link.txt"); err == nil {
+		t.Fatal("Expected symbolic link to file outside hostArtifactDirs to be rejected")
+	}
+	err = copyHostArtifact(allowed, taskDir, "all")
+	if err != nil {
+		t.Fatalf("Could not copy host artifact: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(hostDir, "task", "all", "link.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected symbolic link inside directory not to be copied, got %v", err)
+	}
+	// nor may symbolic links planted in the task directory redirect the copy
+	outside := filepath.Join(hostDir, "outside")
+	err = os.Mkdir(outside, 0755)
+	if err == nil {
+		err = os.Symlink(outside, filepath.Join(taskDir, "planted"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := copyHostArtifact(filepath.Join(allowed, "app"), taskDir, "planted/crashes"); err == nil {
+		t.Fatal("Expected copy through symbolic link in task directory to be rejected")
+	}
+	if _, err := os.Lstat(filepath.Join(outside, "crashes")); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing to be written through symbolic link in task directory, got %v", err)
+	}
+}
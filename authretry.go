@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+
+	"github.com/taskcluster/httpbackoff"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"github.com/taskcluster/taskcluster-client-go/queue"
+)
+
+var (
+	// number of calls that still failed with http 401/403 after refreshing
+	// credentials, published in worker metadata; accessed atomically, since
+	// calls are made concurrently. The worker is quarantined once there is
+	// one, see recordTaskOutcome.
+	permanentAuthFailures int64
+)
+
+// isAuthError returns whether err is an authentication (401) or authorization
+// (403) failure from a taskcluster service
+func isAuthError(err error) bool {
+	e, ok := err.(httpbackoff.BadHttpResponseCode)
+	return ok && (e.HttpResponseCode == 401 || e.HttpResponseCode == 403)
+}
+
+// retryOnAuthError calls f, and if it fails with an authentication or
+// authorization error, refreshes credentials with refresh and calls f once
+// more. This rides out intermittent credential problems, such as rotated
+// credentials or expired temporary credentials. If refresh fails, or f still
+// fails with an authentication or authorization error, the failure is
+// permanent, and logged as such, since it is most likely due to a
+// misconfigured worker.
+func retryOnAuthError(description string, refresh func() error, f func() error) error {
+	err := f()
+	if !isAuthError(err) {
+		return err
+	}
+	log.Printf("WARN: %v failed due to %v, so refreshing credentials and retrying", description, err)
+	errRefresh := refresh()
+	if errRefresh != nil {
+		log.Printf("WARN: could not refresh credentials: %s", errRefresh)
+	} else {
+		err = f()
+	}
+	if isAuthError(err) {
+		atomic.AddInt64(&permanentAuthFailures, 1)
+		log.Printf("PERMANENT AUTH FAILURE: %v still failing after refreshing credentials due to %v - check the credentials (clientId %v) and scopes in the worker config", description, err, config.ClientID)
+	}
+	return err
+}
+
+// reloadWorkerCredentials re-reads the worker credentials from the config
+// file, in case they have been rotated, and recreates Queue with them
+func reloadWorkerCredentials() error {
+	c, err := loadConfig(configFile, false)
+	if err != nil {
+		return err
+	}
+	// note we don't need to worry about a mutex here since either old value
+	// or new value can be used for some crossover time
+	config.ClientID = c.ClientID
+	config.AccessToken = c.AccessToken
+	config.Certificate = c.Certificate
+	Queue = queue.New(
+		&tcclient.Credentials{
+			ClientID:    config.ClientID,
+			AccessToken: config.AccessToken,
+			Certificate: config.Certificate,
+		},
+	)
+	return nil
+}
+
+// refreshTaskCredentials reclaims the task, which provides fresh temporary
+// credentials for task.Queue. Once a call has failed permanently with the
+// refreshed credentials (see taskAuthFailed), the task is not reclaimed
+// again, so that later calls fail straight away.
+func (task *TaskRun) refreshTaskCredentials() error {
+	task.lock.Lock()
+	failed := task.taskAuthFailed
+	task.lock.Unlock()
+	if failed {
+		return fmt.Errorf("not reclaiming task %v to refresh its credentials, since refreshed credentials already failed", task.TaskID)
+	}
+	return updateTaskStatus(TaskStatusUpdate{
+		Task:   task,
+		Status: Reclaimed,
+	})
+}
+
+// retryOnTaskAuthError is retryOnAuthError for calls made with the temporary
+// credentials of the task
+func (task *TaskRun) retryOnTaskAuthError(description string, f func() error) error {
+	err := retryOnAuthError(description, task.refreshTaskCredentials, f)
+	if isAuthError(err) {
+		task.lock.Lock()
+		task.taskAuthFailed = true
+		task.lock.Unlock()
+	}
+	return err
+}
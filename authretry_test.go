@@ -0,0 +1,58 @@
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+
+	"github.com/taskcluster/httpbackoff"
+)
+
+// Calls failing with 401/403 should be retried once after refreshing
+// credentials, and other failures should not be retried. Task credentials
+// should not be refreshed again once they have failed permanently.
+func TestRetryOnAuthError(t *testing.T) {
+	defer atomic.StoreInt64(&permanentAuthFailures, 0)
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ClientID: "test-client"}
+	calls, refreshes := 0, 0
+	refresh := func() error {
+		refreshes++
+		return nil
+	}
+	err := retryOnAuthError("test", refresh, func() error {
+		calls++
+		if calls < 2 {
+			return httpbackoff.BadHttpResponseCode{HttpResponseCode: 401}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 || refreshes != 1 {
+		t.Fatalf("Expected success after 1 refresh, got err %v after %v calls and %v refreshes", err, calls, refreshes)
+	}
+	calls, refreshes = 0, 0
+	err = retryOnAuthError("test", refresh, func() error {
+		calls++
+		return httpbackoff.BadHttpResponseCode{HttpResponseCode: 500}
+	})
+	if err == nil || calls != 1 || refreshes != 0 {
+		t.Fatalf("Expected non-auth failure not to be retried, got %v calls and %v refreshes", calls, refreshes)
+	}
+	calls, refreshes = 0, 0
+	err = retryOnAuthError("test", refresh, func() error {
+		calls++
+		return httpbackoff.BadHttpResponseCode{HttpResponseCode: 403}
+	})
+	if !isAuthError(err) || calls != 2 || refreshes != 1 || atomic.LoadInt64(&permanentAuthFailures) != 1 {
+		t.Fatalf("Expected permanent auth failure to be recorded after 1 refresh, got %v after %v calls and %v refreshes", err, calls, refreshes)
+	}
+
+	task := &TaskRun{TaskID: "test-task", taskAuthFailed: true}
+	calls = 0
+	err = task.retryOnTaskAuthError("test", func() error {
+		calls++
+		return httpbackoff.BadHttpResponseCode{HttpResponseCode: 403}
+	})
+	if !isAuthError(err) || calls != 1 {
+		t.Fatalf("Expected task credentials not to be refreshed again after failing permanently, got %v after %v calls", err, calls)
+	}
+}
@@ -9,9 +9,11 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"net"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -111,7 +113,10 @@ func (f File) ExtractZip() error {
 
 // This is a modified version of
 // http://stackoverflow.com/questions/20357223/easy-way-to-unzip-file-with-golang
-// to work with in memory zip, rather than a file
+// to work with in memory zip, rather than a file. Entries with absolute paths,
+// entries that would be written outside of dest (e.g. via ".." or via
+// previously extracted symlinks), and symlinks pointing outside of dest, are
+// rejected.
 func Unzip(b []byte, dest string) error {
 	br := bytes.NewReader(b)
 	r, err := zip.NewReader(br, int64(len(b)))
@@ -119,10 +124,30 @@ func Unzip(b []byte, dest string) error {
 		return err
 	}
 
+	dest = longPath(dest)
 	os.MkdirAll(dest, 0755)
+	realDest, err := filepath.EvalSymlinks(dest)
+	if err != nil {
+		return err
+	}
 
 	// Closure to address file descriptors issue with all the deferred .Close() methods
 	extractAndWriteFile := func(f *zip.File) error {
+		if filepath.IsAbs(f.Name) || strings.HasPrefix(f.Name, "/") || strings.HasPrefix(f.Name, `\`) || filepath.VolumeName(f.Name) != "" {
+			return fmt.Errorf("Zip entry %q has an absolute path", f.Name)
+		}
+		path := filepath.Join(dest, f.Name)
+		if !withinDirectory(dest, path) {
+			return fmt.Errorf("Zip entry %q would be extracted outside of %v", f.Name, dest)
+		}
+		inside, err := resolvesWithinDirectory(realDest, path)
+		if err != nil {
+			return err
+		}
+		if !inside {
+			return fmt.Errorf("Zip entry %q would be extracted via a symlink to outside of %v", f.Name, dest)
+		}
+
 		rc, err := f.Open()
 		if err != nil {
 			return err
@@ -133,12 +158,38 @@ func Unzip(b []byte, dest string) error {
 			}
 		}()
 
-		path := filepath.Join(dest, f.Name)
-
-		if f.FileInfo().IsDir() {
+		switch {
+		case f.FileInfo().IsDir():
 			os.MkdirAll(path, f.Mode())
-		} else {
-			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		case f.Mode()&os.ModeSymlink != 0:
+			target, err := ioutil.ReadAll(rc)
+			if err != nil {
+				return err
+			}
+			link := string(target)
+			if filepath.IsAbs(link) || !withinDirectory(dest, filepath.Join(filepath.Dir(path), link)) {
+				return fmt.Errorf("Zip entry %q is a symlink to %q which is outside of %v", f.Name, link, dest)
+			}
+			os.MkdirAll(filepath.Dir(path), 0755)
+			err = os.Symlink(link, path)
+			if err != nil {
+				if runtime.GOOS == "windows" {
+					// creating symlinks requires SeCreateSymbolicLinkPrivilege
+					log.Printf("WARN: skipping symlink %q -> %q in zip file: %s", f.Name, link, err)
+					return nil
+				}
+				return err
+			}
+			// the target may still resolve outside of dest via other symlinks,
+			// e.g. "a/.." where "a" is a symlink to "."
+			if resolved, err := filepath.EvalSymlinks(path); err == nil && !withinDirectory(realDest, resolved) {
+				os.Remove(path)
+				return fmt.Errorf("Zip entry %q is a symlink to %q which resolves to %v, outside of %v", f.Name, link, resolved, dest)
+			}
+		default:
+			os.MkdirAll(filepath.Dir(path), 0755)
+			mode := f.Mode()
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 			if err != nil {
 				return err
 			}
@@ -152,6 +203,9 @@ func Unzip(b []byte, dest string) error {
 			if err != nil {
 				return err
 			}
+			// OpenFile applies the umask, and does not alter the mode of an
+			// existing file, so explicitly set the mode from the zip entry
+			return os.Chmod(path, mode.Perm())
 		}
 		return nil
 	}
@@ -166,6 +220,38 @@ func Unzip(b []byte, dest string) error {
 	return nil
 }
 
+// withinDirectory returns true if path is dir, or is lexically located
+// somewhere beneath dir.
+func withinDirectory(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolvesWithinDirectory returns true if path, after evaluating any symlinks
+// in those of its ancestors that already exist, is located beneath realDir.
+// realDir itself should not contain any symlinks.
+func resolvesWithinDirectory(realDir, path string) (bool, error) {
+	existing := filepath.Dir(path)
+	for {
+		_, err := os.Lstat(existing)
+		if err == nil {
+			break
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
+		existing = filepath.Dir(existing)
+	}
+	resolved, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return false, err
+	}
+	return withinDirectory(realDir, resolved), nil
+}
+
 func (c *Config) updateConfigWithAmazonSettings() error {
 	userData, err := queryUserData()
 	if err != nil {
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+type zipEntry struct {
+	Name    string
+	Content string
+	Mode    os.FileMode
+}
+
+// createZip builds an in-memory zip file containing the given entries, in
+// order. Entries with a zero Mode are created as regular files.
+func createZip(t *testing.T, entries []zipEntry) []byte {
+	buf := new(bytes.Buffer)
+	w := zip.NewWriter(buf)
+	for _, entry := range entries {
+		header := &zip.FileHeader{Name: entry.Name}
+		if entry.Mode == 0 {
+			header.SetMode(0644)
+		} else {
+			header.SetMode(entry.Mode)
+		}
+		f, err := w.CreateHeader(header)
+		if err != nil {
+			t.Fatalf("Could not create zip entry %q: %v", entry.Name, err)
+		}
+		_, err = f.Write([]byte(entry.Content))
+		if err != nil {
+			t.Fatalf("Could not write zip entry %q: %v", entry.Name, err)
+		}
+	}
+	err := w.Close()
+	if err != nil {
+		t.Fatalf("Could not close zip writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// unzipToTempDir extracts the zip into a subdirectory "dest" of a new
+// temporary directory, and returns the temporary directory, so tests can check
+// nothing was written alongside "dest".
+func unzipToTempDir(t *testing.T, entries []zipEntry) (string, error) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-unzip")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	return tempDir, Unzip(createZip(t, entries), filepath.Join(tempDir, "dest"))
+}
+
+func TestUnzip(t *testing.T) {
+	tempDir, err := unzipToTempDir(t, []zipEntry{
+		{Name: "a/", Mode: os.ModeDir | 0755},
+		{Name: "a/b.txt", Content: "hello"},
+		{Name: "c/d/e.txt", Content: "world"},
+	})
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Could not unzip valid zip file: %v", err)
+	}
+	for file, content := range map[string]string{
+		"a/b.txt":   "hello",
+		"c/d/e.txt": "world",
+	} {
+		data, err := ioutil.ReadFile(filepath.Join(tempDir, "dest", filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatalf("Could not read extracted file %v: %v", file, err)
+		}
+		if string(data) != content {
+			t.Fatalf("Expected %v to contain %q but it contains %q", file, content, string(data))
+		}
+	}
+}
+
+func TestUnzipHostileArchives(t *testing.T) {
+	hostile := map[string][]zipEntry{
+		"parent directory traversal": {
+			{Name: "../escaped.txt", Content: "pwned"},
+		},
+		"nested parent directory traversal": {
+			{Name: "a/../../escaped.txt", Content: "pwned"},
+		},
+		"absolute path": {
+			{Name: "/escaped.txt", Content: "pwned"},
+		},
+		"symlink to parent directory": {
+			{Name: "link", Content: "..", Mode: os.ModeSymlink | 0777},
+		},
+		"symlink to absolute path": {
+			{Name: "link", Content: os.TempDir(), Mode: os.ModeSymlink | 0777},
+		},
+		"symlink to parent directory via another symlink": {
+			{Name: "self", Content: ".", Mode: os.ModeSymlink | 0777},
+			{Name: "up", Content: "self/..", Mode: os.ModeSymlink | 0777},
+			{Name: "up/escaped.txt", Content: "pwned"},
+		},
+	}
+	for name, entries := range hostile {
+		if runtime.GOOS == "windows" && entries[0].Mode&os.ModeSymlink != 0 {
+			// creating symlinks requires elevated privileges on Windows
+			continue
+		}
+		tempDir, err := unzipToTempDir(t, entries)
+		if err == nil {
+			t.Errorf("Expected error extracting zip with %v, but got none", name)
+		}
+		if _, statErr := os.Lstat(filepath.Join(tempDir, "escaped.txt")); statErr == nil {
+			t.Errorf("Zip with %v wrote a file outside of the target directory", name)
+		}
+		os.RemoveAll(tempDir)
+	}
+}
+
+func TestUnzipPreservesSymlinksAndModes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Symlinks and unix file modes are not supported on Windows")
+	}
+	tempDir, err := unzipToTempDir(t, []zipEntry{
+		{Name: "lib/libclang.so.13", Content: "library", Mode: 0644},
+		{Name: "lib/libclang.so", Content: "libclang.so.13", Mode: os.ModeSymlink | 0777},
+		{Name: "bin/clang", Content: "#!/bin/sh\n", Mode: 0755},
+	})
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Could not unzip valid zip file: %v", err)
+	}
+	dest := filepath.Join(tempDir, "dest")
+	target, err := os.Readlink(filepath.Join(dest, "lib", "libclang.so"))
+	if err != nil {
+		t.Fatalf("Expected lib/libclang.so to be a symlink: %v", err)
+	}
+	if target != "libclang.so.13" {
+		t.Fatalf("Expected lib/libclang.so to point to libclang.so.13 but it points to %v", target)
+	}
+	for file, mode := range map[string]os.FileMode{
+		"lib/libclang.so.13": 0644,
+		"bin/clang":          0755,
+	} {
+		info, err := os.Stat(filepath.Join(dest, filepath.FromSlash(file)))
+		if err != nil {
+			t.Fatalf("Could not stat extracted file %v: %v", file, err)
+		}
+		if info.Mode().Perm() != mode {
+			t.Fatalf("Expected %v to have mode %v but it has mode %v", file, mode, info.Mode().Perm())
+		}
+	}
+}
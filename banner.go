@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// how often the notices at config.TaskLogNoticesURL are fetched
+	taskLogNoticesRefreshInterval = 5 * time.Minute
+	// how long fetching the notices may take, so that an unresponsive server
+	// does not hold up tasks
+	taskLogNoticesTimeout = 10 * time.Second
+	// notices longer than this are truncated
+	taskLogNoticesMaxBytes = 4096
+)
+
+var (
+	// the notices last fetched from config.TaskLogNoticesURL, which are kept
+	// if fetching them fails
+	taskLogNotices        string
+	taskLogNoticesFetched time.Time
+	taskLogNoticesLock    sync.Mutex
+)
+
+// expandBanner substitutes the template variables (such as ${workerType}) in
+// banner. Variables ${image.<key>} are replaced with top level properties of
+// the image manifest (see config setting imageManifestFile). Unknown
+// variables are returned, and replaced with an empty string.
+func expandBanner(banner string) (string, []string) {
+	var image map[string]interface{}
+	if imageManifest != nil {
+		// manifest content is a json object, checked by readImageManifest
+		json.Unmarshal(imageManifest.Content, &image)
+	}
+	unknown := []string{}
+	expanded := os.Expand(banner, func(name string) string {
+		switch name {
+		case "platform":
+			return runtime.GOOS
+		case "arch":
+			return runtime.GOARCH
+		case "provisionerId":
+			return config.ProvisionerID
+		case "workerType":
+			return config.WorkerType
+		case "workerGroup":
+			return config.WorkerGroup
+		case "workerId":
+			return config.WorkerID
+		case "deploymentId":
+			return config.DeploymentID
+		case "version":
+			return version
+		}
+		if strings.HasPrefix(name, "image.") {
+			if value, exists := image[strings.TrimPrefix(name, "image.")]; exists {
+				return fmt.Sprintf("%v", value)
+			}
+			return ""
+		}
+		unknown = append(unknown, name)
+		return ""
+	})
+	return expanded, unknown
+}
+
+// validateTaskLogBanner checks that config setting taskLogBanner only
+// contains known template variables
+func (c *Config) validateTaskLogBanner() error {
+	_, unknown := expandBanner(c.TaskLogBanner)
+	if len(unknown) > 0 {
+		return fmt.Errorf("Config setting taskLogBanner contains unknown template variable(s) %v - supported variables are ${platform}, ${arch}, ${provisionerId}, ${workerType}, ${workerGroup}, ${workerId}, ${deploymentId}, ${version} and ${image.<key>}", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// fetchTaskLogNotices returns the notices at config.TaskLogNoticesURL,
+// fetching them at most every taskLogNoticesRefreshInterval. If they cannot
+// be fetched, the notices fetched last time are returned.
+func fetchTaskLogNotices() string {
+	if config.TaskLogNoticesURL == "" {
+		return ""
+	}
+	taskLogNoticesLock.Lock()
+	defer taskLogNoticesLock.Unlock()
+	if time.Since(taskLogNoticesFetched) < taskLogNoticesRefreshInterval {
+		return taskLogNotices
+	}
+	client := *httpClient
+	client.Timeout = taskLogNoticesTimeout
+	notices, err := getTaskLogNotices(&client, config.TaskLogNoticesURL)
+	if err != nil {
+		// don't keep every task waiting on a server that is down
+		taskLogNoticesFetched = time.Now()
+		log.Printf("WARN: could not fetch task log notices from %v: %s", config.TaskLogNoticesURL, err)
+		return taskLogNotices
+	}
+	taskLogNotices = notices
+	taskLogNoticesFetched = time.Now()
+	return taskLogNotices
+}
+
+func getTaskLogNotices(client *http.Client, url string) (string, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	// no notices, e.g. if the file has been deleted
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return "", nil
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("HTTP response code %v", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, taskLogNoticesMaxBytes))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// logBanner writes config setting taskLogBanner, and any notices at
+// config.TaskLogNoticesURL, to the top of the task log
+func (task *TaskRun) logBanner() {
+	if config.TaskLogBanner != "" {
+		banner, _ := expandBanner(config.TaskLogBanner)
+		task.Log(banner)
+	}
+	for _, line := range strings.Split(fetchTaskLogNotices(), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			task.Log("NOTICE: " + line)
+		}
+	}
+}
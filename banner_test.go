@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskLogBanner(t *testing.T) {
+	defer func(c *Config, client *http.Client, manifest *ImageManifest) {
+		config = c
+		httpClient = client
+		imageManifest = manifest
+		taskLogNotices = ""
+		taskLogNoticesFetched = time.Time{}
+	}(config, httpClient, imageManifest)
+	notices := "This pool is being deprecated\n\nPlease move to pool new-pool\n"
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(notices))
+	}))
+	defer server.Close()
+	httpClient = &http.Client{}
+	imageManifest = &ImageManifest{Content: json.RawMessage(`{"version": "2026.10.1"}`)}
+	config = &Config{
+		WorkerType:        "win2012r2",
+		TaskLogBanner:     "Pool ${workerType} running image ${image.version}",
+		TaskLogNoticesURL: server.URL,
+	}
+	if err := config.validateTaskLogBanner(); err != nil {
+		t.Fatalf("Banner should be valid, but got: %v", err)
+	}
+	if err := (&Config{TaskLogBanner: "${colour}"}).validateTaskLogBanner(); err == nil {
+		t.Fatal("Expected banner with unknown template variable to be rejected")
+	}
+
+	logged := func() string {
+		var buf bytes.Buffer
+		task := &TaskRun{logWriter: &buf}
+		task.logBanner()
+		return buf.String()
+	}
+	taskLog := logged()
+	for _, expected := range []string{
+		"] Pool win2012r2 running image 2026.10.1\n",
+		"] NOTICE: This pool is being deprecated\n",
+		"] NOTICE: Please move to pool new-pool\n",
+	} {
+		if !strings.Contains(taskLog, expected) {
+			t.Fatalf("Expected task log to contain %q but it is:\n%v", expected, taskLog)
+		}
+	}
+	if strings.Count(taskLog, "\n") != 3 {
+		t.Fatalf("Expected 3 lines in task log but it is:\n%v", taskLog)
+	}
+
+	// notices fetched last time are kept if the server fails
+	status = http.StatusInternalServerError
+	taskLogNoticesFetched = time.Time{}
+	if taskLog := logged(); !strings.Contains(taskLog, "NOTICE: This pool is being deprecated") {
+		t.Fatalf("Expected notices to be kept when server fails, but task log is:\n%v", taskLog)
+	}
+}
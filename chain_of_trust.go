@@ -2,9 +2,8 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -19,8 +18,13 @@ import (
 type ChainOfTrustFeature struct {
 }
 
+// ArtifactHash holds the hashes of config setting checksumAlgorithms of the
+// content of an artifact (which include sha256 whenever chain of trust
+// certificates can be signed, see validateChecksums)
 type ArtifactHash struct {
-	SHA256 string `json:"sha256"`
+	SHA256 string `json:"sha256,omitempty"`
+	SHA512 string `json:"sha512,omitempty"`
+	Size   int64  `json:"size"`
 }
 
 type CoTEnvironment struct {
@@ -29,6 +33,8 @@ type CoTEnvironment struct {
 	InstanceID       string `json:"instanceId"`
 	InstanceType     string `json:"instanceType"`
 	Region           string `json:"region"`
+	// see config setting imageManifestFile
+	ImageManifest *ImageManifest `json:"imageManifest,omitempty"`
 }
 
 type ChainOfTrustData struct {
@@ -46,12 +52,32 @@ type ChainOfTrustTaskFeature struct {
 	task *TaskRun
 }
 
+func (feature *ChainOfTrustFeature) Name() string {
+	return "Chain of Trust"
+}
+
+// livelog is started before, and stopped after, chain of trust, so it is still
+// running when the certified log is copied from the backing log in Stop; the
+// certified log therefore has all task output, but not anything logged by
+// features that are stopped after chain of trust
+func (feature *ChainOfTrustFeature) Dependencies() []string {
+	return []string{"LiveLog"}
+}
+
 func (feature *ChainOfTrustFeature) Initialise() error {
 	return nil
 }
 
-func (feature *ChainOfTrustFeature) IsEnabled(fl EnabledFeatures) bool {
-	return fl.ChainOfTrust
+func (feature *ChainOfTrustFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.ChainOfTrust
+}
+
+// the chain of trust certificate can only be signed if the worker has a key
+func (feature *ChainOfTrustFeature) CheckSupported() error {
+	if config.SigningKeyLocation == "" {
+		return fmt.Errorf("Feature %q is not supported by worker type %v, since signingKeyLocation is not set in its config", feature.Name(), config.WorkerType)
+	}
+	return nil
 }
 
 func (feature *ChainOfTrustFeature) NewTaskFeature(task *TaskRun) TaskFeature {
@@ -70,9 +96,9 @@ func (cot *ChainOfTrustTaskFeature) Start() error {
 }
 
 func (cot *ChainOfTrustTaskFeature) Stop() error {
-	logFile := filepath.Join(TaskUser.HomeDir, "public", "logs", "live_backing.log")
-	certifiedLogFile := filepath.Join(TaskUser.HomeDir, "public", "logs", "certified.log")
-	signedCert := filepath.Join(TaskUser.HomeDir, "public", "logs", "chainOfTrust.json.asc")
+	logFile := filepath.Join(TaskDir, "public", "logs", "live_backing.log")
+	certifiedLogFile := filepath.Join(TaskDir, "public", "logs", "certified.log")
+	signedCert := filepath.Join(TaskDir, "public", "logs", "chainOfTrust.json.asc")
 	err := copyFileContents(logFile, certifiedLogFile)
 	if err != nil {
 		return err
@@ -81,24 +107,9 @@ func (cot *ChainOfTrustTaskFeature) Stop() error {
 	if err != nil {
 		return err
 	}
-	artifactHashes := map[string]ArtifactHash{}
-	for _, artifact := range cot.task.Artifacts {
-		switch a := artifact.(type) {
-		case S3Artifact:
-			// make sure SHA256 is calculated
-			hash, err := calculateHash(a)
-			if err != nil {
-				return err
-			}
-			artifactHashes[a.CanonicalPath] = ArtifactHash{
-				SHA256: hash,
-			}
-		}
-	}
-
 	cotCert := &ChainOfTrustData{
 		Version:     1,
-		Artifacts:   artifactHashes,
+		Artifacts:   cot.task.uploadedArtifactHashes(),
 		Task:        cot.task.Definition,
 		TaskID:      cot.task.TaskID,
 		RunID:       cot.task.RunID,
@@ -110,6 +121,7 @@ func (cot *ChainOfTrustTaskFeature) Stop() error {
 			InstanceID:       config.InstanceID,
 			InstanceType:     config.InstanceType,
 			Region:           config.Region,
+			ImageManifest:    imageManifest,
 		},
 	}
 
@@ -178,18 +190,14 @@ func copyFileContents(src, dst string) (err error) {
 	return
 }
 
-func calculateHash(artifact S3Artifact) (hash string, err error) {
-	rawContentFile := filepath.Join(TaskUser.HomeDir, artifact.Base().CanonicalPath)
-	rawContent, err := os.Open(rawContentFile)
+// calculateHash returns the hashes (see config setting checksumAlgorithms) and
+// size of the (uncompressed) content of the given artifact
+func calculateHash(artifact S3Artifact) (hash ArtifactHash, err error) {
+	rawContentFile := filepath.Join(TaskDir, artifact.Base().CanonicalPath)
+	rawContent, err := os.Open(longPath(rawContentFile))
 	if err != nil {
 		return
 	}
 	defer rawContent.Close()
-	hasher := sha256.New()
-	_, err = io.Copy(hasher, rawContent)
-	if err != nil {
-		panic(err)
-	}
-	hash = hex.EncodeToString(hasher.Sum(nil))
-	return
+	return hashContent(rawContent, config.checksumAlgorithms())
 }
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"sort"
+	"strings"
+)
+
+// checksumHashes are the hash algorithms that config setting
+// checksumAlgorithms can select for artifact checksums (and so the chain of
+// trust certificate). All are FIPS 140 approved.
+var checksumHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+// fipsMode reports whether the operating system runs in FIPS mode; a var so
+// that tests can replace it
+var fipsMode = hostFIPSMode // platform specific
+
+// checksumAlgorithms returns the configured checksum algorithms, sha256 if
+// none are configured (or there is no config)
+func (c *Config) checksumAlgorithms() []string {
+	if c == nil || len(c.ChecksumAlgorithms) == 0 {
+		return []string{"sha256"}
+	}
+	return c.ChecksumAlgorithms
+}
+
+// checksumAlgorithm returns whether the given algorithm is one of the
+// configured checksum algorithms
+func (c *Config) checksumAlgorithm(algorithm string) bool {
+	for _, a := range c.checksumAlgorithms() {
+		if a == algorithm {
+			return true
+		}
+	}
+	return false
+}
+
+// validateChecksums checks config settings checksumAlgorithms and fipsMode
+func (c *Config) validateChecksums() error {
+	if len(c.ChecksumAlgorithms) == 0 {
+		return fmt.Errorf("Config setting checksumAlgorithms must contain at least one algorithm")
+	}
+	for _, algorithm := range c.ChecksumAlgorithms {
+		if _, supported := checksumHashes[algorithm]; !supported {
+			supported := []string{}
+			for a := range checksumHashes {
+				supported = append(supported, a)
+			}
+			sort.Strings(supported)
+			return fmt.Errorf("Config setting checksumAlgorithms contains unsupported algorithm %q (supported: %v)", algorithm, strings.Join(supported, ", "))
+		}
+	}
+	// chain of trust verifiers require sha256 hashes of artifacts
+	if c.SigningKeyLocation != "" && !c.checksumAlgorithm("sha256") {
+		return fmt.Errorf("Config setting checksumAlgorithms must include \"sha256\" when config setting signingKeyLocation is set, since chain of trust verifiers require it")
+	}
+	if c.FIPSMode {
+		enabled, err := fipsMode()
+		if err != nil {
+			return fmt.Errorf("Could not determine whether the operating system runs in FIPS mode, which config setting fipsMode requires: %s", err)
+		}
+		if !enabled {
+			return fmt.Errorf("Config setting fipsMode requires the operating system to run in FIPS mode")
+		}
+	}
+	return nil
+}
+
+// hashContent returns the hashes with the given algorithms, and the size, of
+// the content read from r
+func hashContent(r io.Reader, algorithms []string) (ArtifactHash, error) {
+	hashers := make([]hash.Hash, len(algorithms))
+	writers := make([]io.Writer, len(algorithms))
+	for i, algorithm := range algorithms {
+		hashers[i] = checksumHashes[algorithm]()
+		writers[i] = hashers[i]
+	}
+	var h ArtifactHash
+	size, err := io.Copy(io.MultiWriter(writers...), r)
+	if err != nil {
+		return h, err
+	}
+	h.Size = size
+	for i, algorithm := range algorithms {
+		sum := hex.EncodeToString(hashers[i].Sum(nil))
+		switch algorithm {
+		case "sha256":
+			h.SHA256 = sum
+		case "sha512":
+			h.SHA512 = sum
+		}
+	}
+	return h, nil
+}
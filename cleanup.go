@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// CleanupFeature removes scheduled tasks created by the task user while a task
+// runs, and scheduled tasks and registry keys declared in the task payload, so
+// that they cannot affect later tasks on long-lived Windows workers. Registry
+// keys in the hive of the task user are removed with the task user, unless
+// tasks run as the current user.
+type CleanupFeature struct {
+}
+
+type CleanupTask struct {
+	task *TaskRun
+	// scheduled tasks that existed before the task started
+	scheduledTasks map[string]bool
+}
+
+// scheduledTask is a Windows scheduled task, and the user it runs as
+type scheduledTask struct {
+	Name  string
+	RunAs string
+}
+
+// platform specific, vars so that tests can replace them
+var (
+	scheduledTasks      = listScheduledTasks
+	deleteScheduledTask = removeScheduledTask
+)
+
+func (feature *CleanupFeature) Name() string {
+	return "Cleanup"
+}
+
+func (feature *CleanupFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *CleanupFeature) Initialise() error {
+	return nil
+}
+
+// Always enabled on Windows, since scheduled tasks are cleaned up even if the
+// payload declares nothing
+func (feature *CleanupFeature) IsEnabled(task *TaskRun) bool {
+	return runtime.GOOS == "windows" || len(task.Payload.Cleanup.RegistryKeys) > 0 || len(task.Payload.Cleanup.ScheduledTasks) > 0
+}
+
+func (feature *CleanupFeature) CheckSupported() error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("Feature %q is only supported on Windows", feature.Name())
+	}
+	return nil
+}
+
+func (feature *CleanupFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &CleanupTask{
+		task: task,
+	}
+}
+
+// Deleting registry keys outside the hive of the task user, or scheduled tasks
+// that the task did not create, requires the privileges of the worker, so is
+// controlled by scopes
+func (c *CleanupTask) RequiredScopes() scopes.Required {
+	required := []string{}
+	if len(c.task.Payload.Cleanup.RegistryKeys) > 0 {
+		required = append(required, "generic-worker:cleanup-registry:"+config.ProvisionerID+"/"+config.WorkerType)
+	}
+	if len(c.task.Payload.Cleanup.ScheduledTasks) > 0 {
+		required = append(required, "generic-worker:cleanup-scheduled-tasks:"+config.ProvisionerID+"/"+config.WorkerType)
+	}
+	if len(required) == 0 {
+		return scopes.Required{}
+	}
+	return scopes.Required{required}
+}
+
+// RequiredScopeSources names the cleanup payload properties that require each
+// scope
+func (c *CleanupTask) RequiredScopeSources() map[string][]string {
+	sources := map[string][]string{}
+	if len(c.task.Payload.Cleanup.RegistryKeys) > 0 {
+		sources["generic-worker:cleanup-registry:"+config.ProvisionerID+"/"+config.WorkerType] = []string{"cleanup.registryKeys"}
+	}
+	if len(c.task.Payload.Cleanup.ScheduledTasks) > 0 {
+		sources["generic-worker:cleanup-scheduled-tasks:"+config.ProvisionerID+"/"+config.WorkerType] = []string{"cleanup.scheduledTasks"}
+	}
+	return sources
+}
+
+// Start records the scheduled tasks that already exist, so that those created
+// by the task can be identified in Stop.
+func (c *CleanupTask) Start() error {
+	existing, err := scheduledTasks()
+	if err != nil {
+		return err
+	}
+	c.scheduledTasks = map[string]bool{}
+	for _, t := range existing {
+		c.scheduledTasks[t.Name] = true
+	}
+	return nil
+}
+
+// Stop deletes the scheduled tasks that the task user created since Start,
+// and the scheduled tasks and registry keys declared in the payload. Scheduled
+// tasks created since Start by other users (e.g. by the worker's own
+// maintenance) are left alone, as are all of them when tasks run as the
+// current user, since there is then no task user. Failures are reported in the
+// task log, but do not affect the task resolution.
+func (c *CleanupTask) Stop() error {
+	remove := append([]string{}, c.task.Payload.Cleanup.ScheduledTasks...)
+	current, err := scheduledTasks()
+	if err != nil {
+		c.task.Log(fmt.Sprintf("WARNING: could not list scheduled tasks to clean up: %s", err))
+	}
+	for _, t := range current {
+		if !c.scheduledTasks[t.Name] && isTaskUser(t.RunAs) {
+			remove = append(remove, t.Name)
+		}
+	}
+	for _, name := range remove {
+		c.task.Log("Deleting scheduled task " + name)
+		err := deleteScheduledTask(name)
+		if err != nil {
+			c.task.Log(fmt.Sprintf("WARNING: could not delete scheduled task %v: %s", name, err))
+		}
+	}
+	for _, key := range c.task.Payload.Cleanup.RegistryKeys {
+		c.task.Log("Deleting registry key " + key)
+		err := deleteRegistryKey(key)
+		if err != nil {
+			c.task.Log(fmt.Sprintf("WARNING: could not delete registry key %v: %s", key, err))
+		}
+	}
+	log.Printf("Cleaned up %v scheduled task(s) and %v registry key(s)", len(remove), len(c.task.Payload.Cleanup.RegistryKeys))
+	return nil
+}
+
+// isTaskUser returns whether the given user, optionally qualified with a
+// domain (DOMAIN\user), is the task user. Windows user names are case
+// insensitive.
+func isTaskUser(user string) bool {
+	if i := strings.LastIndex(user, "\\"); i >= 0 {
+		user = user[i+1:]
+	}
+	return TaskUser.Name != "" && strings.EqualFold(user, TaskUser.Name)
+}
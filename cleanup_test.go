@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Only scheduled tasks that the task user created while the task ran, and
+// those declared in the payload, should be deleted.
+func TestCleanupScheduledTasks(t *testing.T) {
+	defer func(u OSUser) { TaskUser = u }(TaskUser)
+	defer func(list func() ([]scheduledTask, error), remove func(string) error) {
+		scheduledTasks, deleteScheduledTask = list, remove
+	}(scheduledTasks, deleteScheduledTask)
+	TaskUser = OSUser{Name: "task_123"}
+	existing := []scheduledTask{
+		{Name: `\Preexisting`, RunAs: `WORKER\task_123`},
+		{Name: `\Maintenance`, RunAs: "SYSTEM"},
+	}
+	scheduledTasks = func() ([]scheduledTask, error) {
+		return existing, nil
+	}
+	deleted := []string{}
+	deleteScheduledTask = func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	task := &TaskRun{logWriter: ioutil.Discard}
+	err := json.Unmarshal([]byte(`{"cleanup": {"scheduledTasks": ["\\Declared"]}}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := (&CleanupFeature{}).NewTaskFeature(task)
+	err = c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing = append(existing,
+		scheduledTask{Name: `\CreatedByTask`, RunAs: `WORKER\TASK_123`},
+		scheduledTask{Name: `\CreatedByWorker`, RunAs: "SYSTEM"},
+		scheduledTask{Name: `\CreatedByOtherUser`, RunAs: `WORKER\task_1234`},
+	)
+	err = c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	expected := []string{`\CreatedByTask`, `\Declared`}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("Expected scheduled tasks %v to be deleted, but got %v", expected, deleted)
+	}
+}
+
+// When tasks run as the current user there is no task user, so only the
+// scheduled tasks declared in the payload should be deleted.
+func TestCleanupScheduledTasksNoTaskUser(t *testing.T) {
+	defer func(u OSUser) { TaskUser = u }(TaskUser)
+	defer func(list func() ([]scheduledTask, error), remove func(string) error) {
+		scheduledTasks, deleteScheduledTask = list, remove
+	}(scheduledTasks, deleteScheduledTask)
+	TaskUser = OSUser{}
+	existing := []scheduledTask{}
+	scheduledTasks = func() ([]scheduledTask, error) {
+		return existing, nil
+	}
+	deleted := []string{}
+	deleteScheduledTask = func(name string) error {
+		deleted = append(deleted, name)
+		return nil
+	}
+	task := &TaskRun{logWriter: ioutil.Discard}
+	c := (&CleanupFeature{}).NewTaskFeature(task)
+	err := c.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	existing = append(existing, scheduledTask{Name: `\CreatedByTask`, RunAs: `WORKER\`})
+	err = c.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("Expected no scheduled tasks to be deleted, but got %v", deleted)
+	}
+}
+
+// Deleting declared registry keys and scheduled tasks should each require a
+// per worker type scope.
+func TestCleanupRequiredScopes(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	for _, test := range []struct {
+		payload string
+		scopes  [][]string
+	}{
+		{
+			payload: `{}`,
+			scopes:  [][]string{},
+		},
+		{
+			payload: `{"cleanup": {"registryKeys": ["HKLM\\SOFTWARE\\Test"]}}`,
+			scopes:  [][]string{{"generic-worker:cleanup-registry:test-provisioner/test-worker-type"}},
+		},
+		{
+			payload: `{"cleanup": {"scheduledTasks": ["\\Test"]}}`,
+			scopes:  [][]string{{"generic-worker:cleanup-scheduled-tasks:test-provisioner/test-worker-type"}},
+		},
+		{
+			payload: `{"cleanup": {"registryKeys": ["HKLM\\SOFTWARE\\Test"], "scheduledTasks": ["\\Test"]}}`,
+			scopes: [][]string{{
+				"generic-worker:cleanup-registry:test-provisioner/test-worker-type",
+				"generic-worker:cleanup-scheduled-tasks:test-provisioner/test-worker-type",
+			}},
+		},
+	} {
+		task := &TaskRun{}
+		err := json.Unmarshal([]byte(test.payload), &task.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scopes := (&CleanupFeature{}).NewTaskFeature(task).RequiredScopes()
+		if !reflect.DeepEqual([][]string(scopes), test.scopes) {
+			t.Errorf("With payload %v expected required scopes %v but got %v", test.payload, test.scopes, scopes)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+// how often the worker clock is compared against the queue's clock, when
+// config.MaxClockSkewSecs is set
+const clockSkewCheckInterval = 10 * time.Minute
+
+var (
+	// how far the worker clock was ahead of the queue's clock (negative if
+	// behind) when last checked - only accessed from the worker loop in
+	// runWorker, and from task runs started by it
+	clockSkew time.Duration
+	// when clockSkew was last measured
+	clockSkewChecked time.Time
+)
+
+// measureClockSkew compares the worker clock against the Date header of a
+// response from the queue. Since the Date header only has a resolution of one
+// second, so does the result.
+func measureClockSkew() (time.Duration, error) {
+	before := time.Now()
+	resp, err := httpClient.Get(Queue.BaseURL + "/ping")
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	after := time.Now()
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, fmt.Errorf("Could not parse Date header %q of queue response: %s", resp.Header.Get("Date"), err)
+	}
+	local := before.Add(after.Sub(before) / 2)
+	skew := local.Sub(serverTime)
+	return skew - skew%time.Second, nil
+}
+
+// clockInSync returns false if the worker clock differs from the queue's
+// clock by more than config.MaxClockSkewSecs, in which case the worker should
+// not claim tasks, since signed urls and chain of trust timestamps would be
+// unreliable. The clock is checked at most every clockSkewCheckInterval.
+func clockInSync() bool {
+	if config.MaxClockSkewSecs <= 0 {
+		return true
+	}
+	if time.Since(clockSkewChecked) >= clockSkewCheckInterval {
+		skew, err := measureClockSkew()
+		if err != nil {
+			// don't stop claiming tasks just because the check failed
+			log.Printf("WARN: could not check clock skew: %s", err)
+			return true
+		}
+		clockSkew = skew
+		clockSkewChecked = time.Now()
+		if clockSkewExceeded() {
+			log.Printf("Worker clock is %v out from queue clock, which exceeds maxClockSkewSecs (%v) - not claiming tasks", clockSkew, config.MaxClockSkewSecs)
+		}
+	}
+	return !clockSkewExceeded()
+}
+
+func clockSkewExceeded() bool {
+	skew := clockSkew
+	if skew < 0 {
+		skew = -skew
+	}
+	return skew > time.Duration(config.MaxClockSkewSecs)*time.Second
+}
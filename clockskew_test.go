@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/taskcluster/taskcluster-client-go/queue"
+)
+
+// Worker should not claim tasks while its clock differs too much from the
+// queue's clock.
+func TestClockSkew(t *testing.T) {
+	offset := time.Hour
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+	}))
+	defer ts.Close()
+	defer func(q *queue.Queue, client *http.Client, c *Config) {
+		Queue = q
+		httpClient = client
+		config = c
+	}(Queue, httpClient, config)
+	Queue = queue.New(nil)
+	Queue.BaseURL = ts.URL
+	httpClient = &http.Client{}
+	config = &Config{MaxClockSkewSecs: 60}
+	defer func() {
+		clockSkewChecked = time.Time{}
+	}()
+
+	clockSkewChecked = time.Time{}
+	if clockInSync() {
+		t.Fatal("Worker clock should be considered out of sync when an hour behind the queue")
+	}
+	if clockSkew > -59*time.Minute || clockSkew < -61*time.Minute {
+		t.Fatalf("Expected clock skew of about -1h, but measured %v", clockSkew)
+	}
+
+	offset = 0
+	clockSkewChecked = time.Time{}
+	if !clockInSync() {
+		t.Fatalf("Worker clock should be considered in sync, but measured skew %v", clockSkew)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// commandCheck is run periodically while a command is running, see
+// monitorCommand
+type commandCheck struct {
+	interval time.Duration
+	// check returns the error to resolve the task with if the command should
+	// be killed, or nil if it may keep running
+	check func() *CommandExecutionError
+	// if set, a snapshot of the processes of the command is published before
+	// it is killed, with this reason, see snapshotProcesses
+	snapshot string
+}
+
+// commandChecks returns the checks to run while command index of the task is
+// running, depending on the payload and worker config
+func (task *TaskRun) commandChecks(index int) []commandCheck {
+	checks := []commandCheck{task.deadlineCheck()}
+	// teardown commands run even if the task was aborted
+	if !task.isTeardown(index) {
+		checks = append(checks, task.abortCheck())
+	}
+	if task.activity != nil {
+		checks = append(checks, task.idleTimeCheck(index))
+	}
+	if task.Payload.MaxTaskDiskUsage > 0 {
+		checks = append(checks, task.diskUsageCheck(index))
+	}
+	if config.ReservedDiskSpaceMB > 0 || config.ReservedMemoryMB > 0 {
+		checks = append(checks, headroomCheck(index))
+	}
+	return checks
+}
+
+// monitorCommand runs the given checks, each at its own interval, while the
+// given command is running. The command is killed (see Command.kill) as soon
+// as any check fails. Close the returned exited channel once the command has
+// exited, and then read from the returned killed channel to find out why the
+// command was killed (nil if it wasn't).
+func (task *TaskRun) monitorCommand(command *Command, checks []commandCheck) (exited chan<- bool, killed <-chan *CommandExecutionError) {
+	e := make(chan bool)
+	k := make(chan *CommandExecutionError, 1)
+	// only the first failing check kills the command
+	var kill sync.Once
+	var running sync.WaitGroup
+	for _, c := range checks {
+		running.Add(1)
+		go func(c commandCheck) {
+			defer running.Done()
+			ticker := time.NewTicker(c.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-e:
+					return
+				case <-ticker.C:
+					problem := c.check()
+					if problem == nil {
+						continue
+					}
+					kill.Do(func() {
+						if c.snapshot != "" {
+							task.snapshotProcesses(command, c.snapshot)
+						}
+						err := command.kill()
+						if err != nil {
+							log.Printf("WARN: could not kill command: %s", err)
+						}
+						task.Log("Command killed: " + problem.Cause.Error())
+						k <- problem
+					})
+					return
+				}
+			}
+		}(c)
+	}
+	go func() {
+		running.Wait()
+		kill.Do(func() {
+			k <- nil
+		})
+	}()
+	return e, k
+}
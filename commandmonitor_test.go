@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A command killed for being idle should be sent SIGTERM first, giving it a
+// chance to write out results before it exits.
+func TestKillGracePeriod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test command uses a bash trap, which is not available on Windows")
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [["/bin/bash", "-c", "trap 'echo flushed > results.txt; exit 1' TERM; sleep 30 & wait"]],
+		"maxRunTime": 60,
+		"maxIdleTime": 1,
+		"artifacts": [{"type": "file", "path": "results.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, _ := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Failed {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Failed, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "results.txt"))
+	if err != nil {
+		t.Fatalf("Command did not write results.txt before exiting: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "flushed" {
+		t.Fatalf("Expected results.txt to contain %q but it contains %q", "flushed", string(out))
+	}
+	checkProcessSnapshot(t, tempDir)
+}
+
+// checkProcessSnapshot checks that the processes of the killed command
+// "sleep 30" were captured, and published by the task run in tempDir
+func checkProcessSnapshot(t *testing.T, tempDir string) {
+	processes, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "timeout", "processes.txt"))
+	if err != nil {
+		t.Fatalf("Process snapshot was not published: %v", err)
+	}
+	if !strings.Contains(string(processes), "sleep 30") {
+		t.Fatalf("Expected process snapshot to include sleep command, but got:\n%s", processes)
+	}
+	// it is public, so should only list processes of the command's process
+	// group (the first column), and not e.g. the worker
+	lines := strings.Split(strings.TrimSpace(string(processes)), "\n")
+	for _, line := range lines[1:] {
+		if pgid := strings.Fields(line)[0]; pgid != strings.Fields(lines[1])[0] || pgid == strconv.Itoa(os.Getpid()) {
+			t.Fatalf("Expected process snapshot to only include processes of the command, but got:\n%s", processes)
+		}
+	}
+}
+
+// A command still running when the task exceeds maxRunTime should be sent
+// SIGTERM, so that it can write out results, before it is killed and the task
+// resolved as failed.
+func TestMaxRunTimeKillGracePeriod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test command uses a bash trap, which is not available on Windows")
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [["/bin/bash", "-c", "trap 'echo flushed > results.txt; exit 1' TERM; sleep 30 & wait"]],
+		"maxRunTime": 1,
+		"artifacts": [{"type": "file", "path": "results.txt", "expires": "` + expires + `"}]
+	}`
+	started := time.Now()
+	tempDir, status, _ := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Failed {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Failed, status)
+	}
+	// well within the kill grace period of runTaskLocally
+	if elapsed := time.Since(started); elapsed > 8*time.Second {
+		t.Fatalf("Expected command to exit soon after being sent SIGTERM, but task took %v", elapsed)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "results.txt"))
+	if err != nil {
+		t.Fatalf("Command did not write results.txt before exiting: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "flushed" {
+		t.Fatalf("Expected results.txt to contain %q but it contains %q", "flushed", string(out))
+	}
+	checkProcessSnapshot(t, tempDir)
+}
+
+// A command still running when the worker shuts down should be sent SIGTERM,
+// in the same way as when the task exceeds maxRunTime, and the task resolved
+// as worker-shutdown, after its teardown commands have run.
+func TestWorkerShutdownKillGracePeriod(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test command uses a bash trap, which is not available on Windows")
+	}
+	defer func() {
+		currentStatus.Lock()
+		currentStatus.shutdownErr = nil
+		currentStatus.Unlock()
+	}()
+	go func() {
+		for {
+			if status := workerStatus(); status.Task != nil && status.Task.Phase == "running" {
+				// give the command time to set its trap
+				time.Sleep(time.Second)
+				abortTasks(WorkerShutdown(errors.New("Worker received terminated")))
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [["/bin/bash", "-c", "trap 'echo flushed > results.txt; exit 1' TERM; sleep 30 & wait"], ["/bin/bash", "-c", "echo second > second.txt"]],
+		"teardown": [["/bin/bash", "-c", "echo done > teardown.txt"]],
+		"maxRunTime": 60,
+		"artifacts": [{"type": "file", "path": "results.txt", "expires": "` + expires + `"}, {"type": "file", "path": "teardown.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, _ := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Errored {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Errored, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "results.txt"))
+	if err != nil {
+		t.Fatalf("Command did not write results.txt before exiting: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "flushed" {
+		t.Fatalf("Expected results.txt to contain %q but it contains %q", "flushed", string(out))
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "second.txt")); err == nil {
+		t.Fatalf("Expected no further commands to run after the worker started shutting down")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "artifacts", "teardown.txt")); err != nil {
+		t.Fatalf("Expected teardown command to run after the worker started shutting down: %v", err)
+	}
+}
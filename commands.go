@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+)
+
+// commandSpec is a single entry of the task payload command (or teardown)
+// list. Entries may be given either as just the command (a commandLine, which
+// is platform specific), or as an object which additionally specifies a
+// working directory and env vars for the command.
+type commandSpec struct {
+	Command commandLine       `json:"command"`
+	Cwd     string            `json:"cwd"`
+	Env     map[string]string `json:"env"`
+}
+
+// parseCommandSpecs converts the (already schema validated) payload command
+// entries into commandSpecs.
+func parseCommandSpecs(entries []json.RawMessage) ([]commandSpec, error) {
+	specs := make([]commandSpec, len(entries))
+	for i, entry := range entries {
+		err := json.Unmarshal(entry, &specs[i].Command)
+		if err == nil {
+			continue
+		}
+		err = json.Unmarshal(entry, &specs[i])
+		if err != nil {
+			return nil, fmt.Errorf("Could not interpret command %v (%s): %s", i, entry, err)
+		}
+	}
+	return specs, nil
+}
+
+// dir returns the absolute directory the command should run in
+func (c commandSpec) dir() string {
+	if c.Cwd == "" {
+		return TaskDir
+	}
+	if filepath.IsAbs(c.Cwd) {
+		return c.Cwd
+	}
+	return filepath.Join(TaskDir, c.Cwd)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Commands given as objects should run in the given directory, with the
+// given env vars in addition to the env inherited from the worker.
+func TestCommandCwdAndEnv(t *testing.T) {
+	os.Setenv("GENERIC_WORKER_TEST_INHERITED", "world")
+	defer os.Unsetenv("GENERIC_WORKER_TEST_INHERITED")
+	command := `[{"command": ["/bin/bash", "-c", "echo ${GREETING} ${GENERIC_WORKER_TEST_INHERITED} > out.txt"], "cwd": "subdir", "env": {"GREETING": "hello"}}]`
+	if runtime.GOOS == "windows" {
+		command = `[{"command": "echo %GREETING% %GENERIC_WORKER_TEST_INHERITED%> out.txt", "cwd": "subdir", "env": {"GREETING": "hello"}}]`
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"artifacts": [{"type": "file", "path": "subdir/out.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, func() {
+		err := os.Mkdir("subdir", 0755)
+		if err != nil {
+			t.Fatalf("Could not create subdirectory: %v", err)
+		}
+	})
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Task should have run successfully, but got error: %v", err)
+	}
+	if status != Succeeded {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Succeeded, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "subdir", "out.txt"))
+	if err != nil {
+		t.Fatalf("Artifact subdir/out.txt was not saved locally: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "hello world" {
+		t.Fatalf("Expected artifact subdir/out.txt to contain %q but it contains %q", "hello world", string(out))
+	}
+}
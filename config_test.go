@@ -1,11 +1,24 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMissingIPConfig(t *testing.T) {
@@ -105,3 +118,335 @@ func TestWorkerTypeMetadata(t *testing.T) {
 		t.Fatalf("Was expecting machine-setup to be set properly\n%#v", config)
 	}
 }
+
+func TestCACertificatesFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("The system root certificates cannot be loaded on Windows")
+	}
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// without the server's certificate, the request should fail
+	client, err := (&Config{}).newHTTPClient()
+	if err != nil {
+		t.Fatalf("Could not create http client: %v", err)
+	}
+	_, err = client.Get(server.URL)
+	if err == nil {
+		t.Fatal("Was expecting request to server with untrusted certificate to fail, but it succeeded")
+	}
+
+	caFile, err := ioutil.TempFile("", "generic-worker-ca")
+	if err != nil {
+		t.Fatalf("Could not create temp file: %v", err)
+	}
+	defer os.Remove(caFile.Name())
+	err = pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: server.TLS.Certificates[0].Certificate[0]})
+	caFile.Close()
+	if err != nil {
+		t.Fatalf("Could not write CA certificate to %v: %v", caFile.Name(), err)
+	}
+	client, err = (&Config{CACertificatesFile: caFile.Name()}).newHTTPClient()
+	if err != nil {
+		t.Fatalf("Could not create http client: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Was expecting request to server with trusted certificate to succeed, but got: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestInvalidCACertificatesFile(t *testing.T) {
+	file := filepath.Join("testdata", "config", "valid.json")
+	_, err := (&Config{CACertificatesFile: file}).newHTTPClient()
+	if err == nil {
+		t.Fatal("Was expecting to get an error back due to a CA file without certificates, but didn't get one!")
+	}
+}
+
+// A client certificate without its key, or a key without its certificate, is
+// a config mistake that should not be silently ignored.
+func TestClientCertificateWithoutKey(t *testing.T) {
+	valid, err := ioutil.ReadFile(filepath.Join("testdata", "config", "valid.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "generic-worker-client-certificate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, setting := range []string{"clientCertificateFile", "clientKeyFile"} {
+		settings := map[string]interface{}{}
+		err := json.Unmarshal(valid, &settings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		settings[setting] = filepath.Join(dir, "client.pem")
+		file := filepath.Join(dir, "generic-worker.config")
+		data, err := json.Marshal(settings)
+		if err == nil {
+			err = ioutil.WriteFile(file, data, 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = loadConfig(file, false)
+		if err == nil || !strings.Contains(err.Error(), "clientKeyFile") {
+			t.Fatalf("Expected config with only %v set to be rejected, but got %v", setting, err)
+		}
+	}
+}
+
+func TestMaintenanceWindows(t *testing.T) {
+	at := func(hhmm string) time.Time {
+		tm, err := time.Parse("15:04", hhmm)
+		if err != nil {
+			t.Fatalf("Bad time %q in test: %v", hhmm, err)
+		}
+		return tm
+	}
+	for _, tc := range []struct {
+		window   string
+		time     string
+		expected bool
+	}{
+		{"02:00-04:30", "01:59", false},
+		{"02:00-04:30", "02:00", true},
+		{"02:00-04:30", "04:29", true},
+		{"02:00-04:30", "04:30", false},
+		{"23:00-01:00", "23:30", true},
+		{"23:00-01:00", "00:30", true},
+		{"23:00-01:00", "12:00", false},
+	} {
+		w, err := parseMaintenanceWindow(tc.window)
+		if err != nil {
+			t.Fatalf("Could not parse maintenance window %q: %v", tc.window, err)
+		}
+		if actual := w.contains(at(tc.time)); actual != tc.expected {
+			t.Errorf("Expected maintenance window %q to contain %v: %v, but got %v", tc.window, tc.time, tc.expected, actual)
+		}
+	}
+	for _, window := range []string{"", "2am-4am", "02:00-24:00", "02:60-03:00"} {
+		_, err := parseMaintenanceWindow(window)
+		if err == nil {
+			t.Errorf("Expected invalid maintenance window %q to be rejected", window)
+		}
+	}
+}
+
+// A random worker id should be reused on the same machine, but regenerated if
+// the worker id file was copied from another machine.
+func TestRandomWorkerID(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-worker-id")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	oldFingerprint := machineFingerprint
+	defer func() {
+		machineFingerprint = oldFingerprint
+	}()
+	machine := "machine-a"
+	machineFingerprint = func() (string, error) {
+		return machine, nil
+	}
+	c := &Config{WorkerIDStrategy: "random"}
+	file := workerIDFile(filepath.Join(tempDir, "generic-worker.config"))
+
+	err = c.applyWorkerIDStrategy(file)
+	if err != nil {
+		t.Fatalf("Could not generate worker id: %v", err)
+	}
+	first := c.WorkerID
+	if first == "" {
+		t.Fatal("No worker id generated")
+	}
+	err = c.applyWorkerIDStrategy(file)
+	if err != nil || c.WorkerID != first {
+		t.Fatalf("Expected persisted worker id %q on same machine, but got %q (error: %v)", first, c.WorkerID, err)
+	}
+	machine = "machine-b"
+	err = c.applyWorkerIDStrategy(file)
+	if err != nil || c.WorkerID == first {
+		t.Fatalf("Expected new worker id on different machine, but got %q (error: %v)", c.WorkerID, err)
+	}
+}
+
+func TestSanitiseWorkerID(t *testing.T) {
+	if id := sanitiseWorkerID("build.example.com"); id != "build-example-com" {
+		t.Fatalf("Expected worker id %q but got %q", "build-example-com", id)
+	}
+	if id := sanitiseWorkerID("a-very-long-hostname-that-is-too-long"); len(id) != 22 {
+		t.Fatalf("Expected worker id to be truncated to 22 characters, but got %q", id)
+	}
+}
+
+func TestParseUmask(t *testing.T) {
+	if mask, err := parseUmask("0027"); err != nil || mask != 027 {
+		t.Fatalf("Expected umask 0027 but got %04o (error: %v)", mask, err)
+	}
+	for _, umask := range []string{"022x", "0999", "1777"} {
+		if _, err := parseUmask(umask); err == nil {
+			t.Fatalf("Expected umask %q to be rejected", umask)
+		}
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "new-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	oldCurrentScopes := currentScopes
+	defer func() { currentScopes = oldCurrentScopes }()
+	var checked *Config
+	currentScopes = func(c *Config) ([]string, error) {
+		checked = c
+		return []string{"queue:*"}, nil
+	}
+	file := filepath.Join(dir, "generic-worker.config")
+	tasksDir := filepath.Join(dir, "tasks")
+	// accept the default provisionerId, first skip (then give) workerType,
+	// and skip the optional certificate
+	answers := strings.Join([]string{"", "", "some-worker-type", "wg", "wid", "client", "token", "", "2.1.2.1", "secret", tasksDir}, "\n") + "\n"
+	var out bytes.Buffer
+	err = newConfig(file, strings.NewReader(answers), &out)
+	if err != nil {
+		t.Fatalf("Could not generate config: %s\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "Config setting workerType is required") {
+		t.Errorf("Expected to be asked for workerType again, but got:\n%s", out.String())
+	}
+	if checked == nil || checked.ProvisionerID != "aws-provisioner-v1" || checked.WorkerType != "some-worker-type" || checked.Certificate != "" {
+		t.Fatalf("Scopes were not checked for the generated config: %#v", checked)
+	}
+	if _, err := os.Stat(tasksDir); err != nil {
+		t.Fatalf("Tasks directory was not created: %s", err)
+	}
+	config, err := loadConfig(file, false)
+	if err != nil {
+		t.Fatalf("Generated config should pass validation, but got:\n%s", err)
+	}
+	if config.PublicIP.String() != "2.1.2.1" || config.LiveLogSecret != "secret" {
+		t.Fatalf("Generated config has wrong settings: %#v", config)
+	}
+
+	// running out of input before all required settings are given fails
+	err = newConfig(file, strings.NewReader("\nsome-worker-type\n"), ioutil.Discard)
+	if _, ok := err.(MissingConfigError); !ok {
+		t.Fatalf("Expected MissingConfigError, but got %#v", err)
+	}
+}
+
+func TestReadImageManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "image-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "image-manifest.json")
+	manifest, err := readImageManifest(file)
+	if err != nil || manifest != nil {
+		t.Fatalf("Expected no image manifest if file does not exist, but got %#v (error: %v)", manifest, err)
+	}
+	content := []byte(`{"amiId": "ami-0a1b2c3d", "toolchains": {"go": "1.10"}}`)
+	err = ioutil.WriteFile(file, content, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err = readImageManifest(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(content)
+	if manifest.SHA256 != hex.EncodeToString(hash[:]) || string(manifest.Content) != string(content) {
+		t.Fatalf("Unexpected image manifest %#v", manifest)
+	}
+	err = ioutil.WriteFile(file, []byte("ami-0a1b2c3d"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = readImageManifest(file)
+	if err == nil {
+		t.Fatal("Expected an error for an image manifest that is not json")
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	defer func(c *Config) {
+		config = c
+		faults.random = nil
+	}(config)
+	if err := (FaultInjection{Faults: map[string]float64{"download": 0.5}}).validate(); err == nil {
+		t.Fatal("Expected unknown fault point to be rejected")
+	}
+	if err := (FaultInjection{Faults: map[string]float64{"reclaim": 1.5}}).validate(); err == nil {
+		t.Fatal("Expected probability greater than 1 to be rejected")
+	}
+	config = &Config{FaultInjection: FaultInjection{Seed: 42, Faults: map[string]float64{"reclaim": 0.5}}}
+	outcomes := func() (failed []bool) {
+		faults.random = nil
+		for i := 0; i < 20; i++ {
+			failed = append(failed, injectFault("reclaim") != nil)
+			if injectFault("artifactUpload") != nil {
+				t.Fatal("Fault point without configured probability should never fail")
+			}
+		}
+		return
+	}
+	first := outcomes()
+	if !reflect.DeepEqual(first, outcomes()) {
+		t.Fatal("Expected the same faults to be injected for the same seed")
+	}
+	if !containsBool(first, true) || !containsBool(first, false) {
+		t.Fatalf("Expected some, but not all, reclaims to fail: %v", first)
+	}
+}
+
+func containsBool(values []bool, value bool) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestChecksumAlgorithms(t *testing.T) {
+	if err := (&Config{ChecksumAlgorithms: []string{"sha256", "sha512"}}).validateChecksums(); err != nil {
+		t.Fatalf("Expected sha256 and sha512 to be supported, but got: %v", err)
+	}
+	if err := (&Config{ChecksumAlgorithms: []string{"blake3"}}).validateChecksums(); err == nil {
+		t.Fatal("Expected unsupported algorithm to be rejected")
+	}
+	if err := (&Config{}).validateChecksums(); err == nil {
+		t.Fatal("Expected empty list of algorithms to be rejected")
+	}
+	if err := (&Config{ChecksumAlgorithms: []string{"sha512"}, SigningKeyLocation: "signing.key"}).validateChecksums(); err == nil {
+		t.Fatal("Expected algorithms without sha256 to be rejected when chain of trust certificates are signed")
+	}
+	defer func(f func() (bool, error)) { fipsMode = f }(fipsMode)
+	for _, enabled := range []bool{false, true} {
+		fipsMode = func() (bool, error) { return enabled, nil }
+		err := (&Config{ChecksumAlgorithms: []string{"sha256"}, FIPSMode: true}).validateChecksums()
+		if enabled != (err == nil) {
+			t.Fatalf("Expected fipsMode to be accepted if and only if the operating system runs in FIPS mode (%v), but got: %v", enabled, err)
+		}
+	}
+
+	content := []byte("hello world")
+	h, err := hashContent(bytes.NewReader(content), []string{"sha512"})
+	if err != nil {
+		t.Fatalf("Could not hash content: %v", err)
+	}
+	expected := ArtifactHash{
+		SHA512: fmt.Sprintf("%x", sha512.Sum512(content)),
+		Size:   int64(len(content)),
+	}
+	if h != expected {
+		t.Fatalf("Expected hash %v but got %v", expected, h)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+type CrashDumpsFeature struct {
+}
+
+type CrashDumpsTask struct {
+	task *TaskRun
+	// restores the crash dump settings in place before the task started
+	restore func() error
+}
+
+// crash dumps are written to this directory, relative to the task directory,
+// which is also the artifact path prefix they are published under
+const crashDumpsDir = "public/crashes"
+
+func (feature *CrashDumpsFeature) Name() string {
+	return "Crash Dumps"
+}
+
+// features are stopped in reverse order, so depending on chain of trust
+// means crash dumps are published before the chain of trust certificate is
+// generated, and are therefore included in it
+func (feature *CrashDumpsFeature) Dependencies() []string {
+	return []string{"Chain of Trust"}
+}
+
+func (feature *CrashDumpsFeature) Initialise() error {
+	return nil
+}
+
+func (feature *CrashDumpsFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.CrashDumps
+}
+
+// where crash dumps are written is a host wide setting (e.g.
+// /proc/sys/kernel/core_pattern on Linux), which the worker can only change
+// with administrative privileges
+func (feature *CrashDumpsFeature) CheckSupported() error {
+	err := checkCrashDumpPrivileges()
+	if err != nil {
+		return fmt.Errorf("Feature %q is not supported by worker type %v, since the worker does not have the privileges to configure where crash dumps are written: %s", feature.Name(), config.WorkerType, err)
+	}
+	return nil
+}
+
+func (feature *CrashDumpsFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &CrashDumpsTask{
+		task: task,
+	}
+}
+
+func (c *CrashDumpsTask) RequiredScopes() scopes.Required {
+	// let's not require any scopes, as I see no reason to control access to this feature
+	return scopes.Required{}
+}
+
+// Start configures the OS to write crash dumps to the crashes directory of
+// the task (platform specific).
+func (c *CrashDumpsTask) Start() error {
+	dir := filepath.Join(TaskDir, filepath.FromSlash(crashDumpsDir))
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	c.restore, err = enableCrashDumps(dir)
+	return err
+}
+
+// Stop restores the previous crash dump settings, and publishes any crash
+// dumps produced by the task.
+func (c *CrashDumpsTask) Stop() error {
+	if c.restore != nil {
+		err := c.restore()
+		if err != nil {
+			log.Printf("WARN: could not restore crash dump settings: %s", err)
+		}
+	}
+	files, err := ioutil.ReadDir(filepath.Join(TaskDir, filepath.FromSlash(crashDumpsDir)))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.Mode().IsRegular() {
+			continue
+		}
+		c.task.Log("Crash dump found: " + file.Name())
+		err := c.task.uploadArtifact(
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: crashDumpsDir + "/" + file.Name(),
+					Expires:       c.task.Definition.Expires,
+				},
+				MimeType: "application/octet-stream",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
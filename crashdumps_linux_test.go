@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// enableCrashDumps should lift the core file size limit and set the core
+// pattern, and the returned function should put both back.
+func TestEnableCrashDumps(t *testing.T) {
+	dir, err := ioutil.TempDir("", "enable-crash-dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) {
+		corePatternFile = file
+	}(corePatternFile)
+	corePatternFile = filepath.Join(dir, "core_pattern")
+	err = ioutil.WriteFile(corePatternFile, []byte("core"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var before syscall.Rlimit
+	err = syscall.Getrlimit(syscall.RLIMIT_CORE, &before)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restore, err := enableCrashDumps(dir)
+	if err != nil {
+		t.Fatalf("Could not enable crash dumps: %v", err)
+	}
+	pattern, _ := ioutil.ReadFile(corePatternFile)
+	if string(pattern) != filepath.Join(dir, "core.%e.%p") {
+		t.Fatalf("Core pattern not set to crash dumps directory: %q", pattern)
+	}
+	var limit syscall.Rlimit
+	syscall.Getrlimit(syscall.RLIMIT_CORE, &limit)
+	if limit.Cur != before.Max {
+		t.Fatalf("Core file size limit should be raised to %v, but is %v", before.Max, limit.Cur)
+	}
+
+	err = restore()
+	if err != nil {
+		t.Fatalf("Could not restore crash dump settings: %v", err)
+	}
+	pattern, _ = ioutil.ReadFile(corePatternFile)
+	if string(pattern) != "core" {
+		t.Fatalf("Core pattern not restored: %q", pattern)
+	}
+	syscall.Getrlimit(syscall.RLIMIT_CORE, &limit)
+	if limit != before {
+		t.Fatalf("Core file size limit not restored to %v: %v", before, limit)
+	}
+}
+
+// Crash dumps are only supported if the worker may write the core pattern.
+func TestCheckCrashDumpPrivileges(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("Skipping test since root may write any file")
+	}
+	dir, err := ioutil.TempDir("", "crash-dump-privileges")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func(file string) {
+		corePatternFile = file
+	}(corePatternFile)
+	corePatternFile = filepath.Join(dir, "core_pattern")
+	err = ioutil.WriteFile(corePatternFile, []byte("core"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCrashDumpPrivileges(); err != nil {
+		t.Fatalf("Expected writable core pattern to be supported, got %v", err)
+	}
+	err = os.Chmod(corePatternFile, 0444)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := checkCrashDumpPrivileges(); err == nil {
+		t.Fatal("Expected read only core pattern not to be supported")
+	}
+}
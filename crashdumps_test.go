@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/openpgp/clearsign"
+)
+
+// Stop should restore the crash dump settings, and publish the crash dumps
+// written by the task.
+func TestCrashDumpsPublished(t *testing.T) {
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	var err error
+	TaskDir, err = ioutil.TempDir("", "crash-dumps")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(TaskDir)
+	dumps := filepath.Join(TaskDir, filepath.FromSlash(crashDumpsDir))
+	err = os.MkdirAll(filepath.Join(dumps, "not-a-dump"), 0755)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(dumps, "core.app.123"), []byte("dump"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := &bytes.Buffer{}
+	task := &TaskRun{
+		logWriter:         log,
+		localArtifactsDir: filepath.Join(TaskDir, "artifacts"),
+	}
+	restored := false
+	c := &CrashDumpsTask{
+		task: task,
+		restore: func() error {
+			restored = true
+			return nil
+		},
+	}
+	err = c.Stop()
+	if err != nil {
+		t.Fatalf("Could not publish crash dumps: %v", err)
+	}
+	if !restored {
+		t.Fatal("Crash dump settings were not restored")
+	}
+	if len(task.Artifacts) != 1 || task.Artifacts[0].Base().CanonicalPath != "public/crashes/core.app.123" {
+		t.Fatalf("Expected only crash dump public/crashes/core.app.123 to be published, but got %v", task.Artifacts)
+	}
+	if _, err := os.Stat(filepath.Join(task.localArtifactsDir, "public", "crashes", "core.app.123")); err != nil {
+		t.Fatalf("Crash dump was not published: %v", err)
+	}
+	if !strings.Contains(log.String(), "Crash dump found: core.app.123") {
+		t.Fatalf("Crash dump was not reported in task log:\n%s", log)
+	}
+}
+
+// Crash dumps should be published before the chain of trust certificate is
+// generated, so that they are included in it.
+func TestCrashDumpsInChainOfTrust(t *testing.T) {
+	defer func(dir string, c *Config) {
+		TaskDir = dir
+		config = c
+	}(TaskDir, config)
+	var err error
+	TaskDir, err = ioutil.TempDir("", "crash-dumps-cot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(TaskDir)
+	config = &Config{
+		SigningKeyLocation: filepath.Join("testdata", "private-opengpg-key"),
+	}
+	dumps := filepath.Join(TaskDir, filepath.FromSlash(crashDumpsDir))
+	logs := filepath.Join(TaskDir, "public", "logs")
+	for _, dir := range []string{dumps, logs} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = ioutil.WriteFile(filepath.Join(dumps, "core.app.123"), []byte("dump"), 0644)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(logs, "live_backing.log"), []byte("task log"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &TaskRun{
+		logWriter:         &bytes.Buffer{},
+		localArtifactsDir: filepath.Join(TaskDir, "artifacts"),
+	}
+	features, err := orderFeatures([]Feature{&CrashDumpsFeature{}, &ChainOfTrustFeature{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskFeatures := make([]TaskFeature, len(features))
+	for i, feature := range features {
+		taskFeatures[i] = feature.NewTaskFeature(task)
+	}
+	err = stopTaskFeatures(taskFeatures)
+	if err != nil {
+		t.Fatalf("Could not stop task features: %v", err)
+	}
+
+	signed, err := ioutil.ReadFile(filepath.Join(logs, "chainOfTrust.json.asc"))
+	if err != nil {
+		t.Fatalf("Chain of trust certificate was not written: %v", err)
+	}
+	block, _ := clearsign.Decode(signed)
+	if block == nil {
+		t.Fatalf("Chain of trust certificate is not clearsigned:\n%s", signed)
+	}
+	cotCert := &ChainOfTrustData{}
+	err = json.Unmarshal(block.Plaintext, cotCert)
+	if err != nil {
+		t.Fatalf("Could not parse chain of trust certificate: %v", err)
+	}
+	if _, included := cotCert.Artifacts["public/crashes/core.app.123"]; !included {
+		t.Fatalf("Crash dump public/crashes/core.app.123 not in chain of trust artifacts: %v", cotCert.Artifacts)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// commandDeadline limits how long the commands of a task may run. A command
+// still running when it expires is killed, and no further commands are
+// started, see ExecuteCommand.
+type commandDeadline struct {
+	at time.Time
+	// e.g. "maxRunTime of 60 seconds", for the task log
+	description string
+	// how the task is resolved if the deadline expires
+	status TaskStatus
+	reason string
+	// whether to publish a snapshot of the processes of a command killed due
+	// to the deadline, see snapshotProcesses
+	snapshot bool
+}
+
+func (d *commandDeadline) hasExpired() bool {
+	return !time.Now().Before(d.at)
+}
+
+// error returns the error that a command killed, or not started, due to the
+// deadline resolves the task with
+func (d *commandDeadline) error() *CommandExecutionError {
+	return &CommandExecutionError{
+		Cause:      fmt.Errorf("Task exceeded %v", d.description),
+		Reason:     d.reason,
+		TaskStatus: d.status,
+	}
+}
+
+// deadlineCheck kills a command still running when task.deadline expires,
+// see monitorCommand
+func (task *TaskRun) deadlineCheck() commandCheck {
+	deadline := task.deadline
+	c := commandCheck{
+		interval: time.Second,
+		check: func() *CommandExecutionError {
+			if deadline.hasExpired() {
+				return deadline.error()
+			}
+			return nil
+		},
+	}
+	if deadline.snapshot {
+		c.snapshot = deadline.description + " being exceeded"
+	}
+	return c
+}
+
+// abortCheck kills a command still running when the task is aborted, see
+// monitorCommand
+func (task *TaskRun) abortCheck() commandCheck {
+	return commandCheck{
+		interval: time.Second,
+		check:    task.abortError,
+	}
+}
+
+// abort stops the task running any more task commands, and kills the command
+// it is running (in the same way as when the deadline expires, see
+// abortCheck), e.g. because the task was cancelled, or the worker is shutting
+// down. Teardown commands still run. The task is resolved with err, unless it
+// was already aborted.
+func (task *TaskRun) abort(err *CommandExecutionError) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.abortErr == nil {
+		task.abortErr = err
+	}
+}
+
+// abortError returns the error the task was aborted with, or nil if it has not
+// been aborted
+func (task *TaskRun) abortError() *CommandExecutionError {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	return task.abortErr
+}
+
+// isTeardown returns true if command index of the task is a teardown command,
+// see validatePayload
+func (task *TaskRun) isTeardown(index int) bool {
+	return index >= len(task.Payload.Command)
+}
+
+// teardownMaxRunTime returns how many seconds the teardown commands of the
+// task may run for in total, or 0 if the task has no teardown commands
+func (task *TaskRun) teardownMaxRunTime() int {
+	if len(task.Payload.Teardown) == 0 {
+		return 0
+	}
+	if task.Payload.TeardownMaxRunTime > 0 {
+		return task.Payload.TeardownMaxRunTime
+	}
+	return config.TeardownMaxRunTimeSecs
+}
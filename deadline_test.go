@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A command still running when the task deadline expires should be killed,
+// and no further commands should be started.
+func TestCommandDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands use true and sleep")
+	}
+	defer func(c *Config) { config = c }(config)
+	config = &Config{KillGracePeriodSecs: 1}
+	task := newCommandTask(t, `{"command": [["true"], ["sleep", "30"]], "maxRunTime": 1}`, &commandDeadline{
+		at:          time.Now().Add(time.Second),
+		description: "maxRunTime of 1 seconds",
+		status:      Failed,
+		reason:      "max-run-time-exceeded",
+	})
+	for i, expectKilled := range []bool{false, true} {
+		err := task.generateCommand(i)
+		if err != nil {
+			t.Fatalf("Could not generate command %v: %v", i, err)
+		}
+		command := &task.Commands[i]
+		started := time.Now()
+		err = command.osCommand.Start()
+		if err != nil {
+			t.Fatalf("Could not start command %v: %v", task.describeCommand(i), err)
+		}
+		exited, killed := task.monitorCommand(command, []commandCheck{task.deadlineCheck()})
+		_ = command.osCommand.Wait()
+		close(exited)
+		if k := <-killed != nil; k != expectKilled {
+			t.Fatalf("Expected command %v to be killed: %v, but it was killed: %v", task.describeCommand(i), expectKilled, k)
+		}
+		if elapsed := time.Since(started); elapsed > 10*time.Second {
+			t.Fatalf("Command %v should have been killed shortly after the deadline, but took %v", task.describeCommand(i), elapsed)
+		}
+	}
+	execErr := task.ExecuteCommand(0)
+	if execErr == nil || execErr.TaskStatus != Failed || execErr.Reason != "max-run-time-exceeded" {
+		t.Fatalf("Command should not be started after the deadline has expired, but got: %#v", execErr)
+	}
+}
+
+// Teardown commands should be limited by payload teardownMaxRunTime, or
+// otherwise config setting teardownMaxRunTimeSecs.
+func TestTeardownMaxRunTime(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{TeardownMaxRunTimeSecs: 300}
+	teardownCommand := `["true"]`
+	if runtime.GOOS == "windows" {
+		teardownCommand = `"echo done"`
+	}
+	for _, test := range []struct {
+		payload  string
+		expected int
+	}{
+		{payload: `{"command": [], "maxRunTime": 60}`, expected: 0},
+		{payload: `{"command": [], "teardown": [], "maxRunTime": 60, "teardownMaxRunTime": 30}`, expected: 0},
+		{payload: `{"command": [], "teardown": [` + teardownCommand + `], "maxRunTime": 60}`, expected: 300},
+		{payload: `{"command": [], "teardown": [` + teardownCommand + `], "maxRunTime": 60, "teardownMaxRunTime": 30}`, expected: 30},
+	} {
+		task := TaskRun{}
+		err := json.Unmarshal([]byte(test.payload), &task.Payload)
+		if err != nil {
+			t.Fatalf("Could not unmarshal payload %v: %v", test.payload, err)
+		}
+		if actual := task.teardownMaxRunTime(); actual != test.expected {
+			t.Fatalf("Expected teardownMaxRunTime %v for payload %v but got %v", test.expected, test.payload, actual)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how often the task directory is scanned while commands are running, when
+// payload specifies maxTaskDiskUsage
+var diskUsageCheckInterval = 10 * time.Second
+
+// directorySize returns the total size in bytes of all regular files under
+// dir. Symlinks are not followed.
+func directorySize(dir string) (size int64, err error) {
+	err = filepath.Walk(longPath(dir), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			// files may be deleted by the running command while we scan
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.Mode().IsRegular() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
+// diskUsageCheck kills command index of the task if the files in the task
+// directory exceed task.Payload.MaxTaskDiskUsage megabytes, see
+// monitorCommand
+func (task *TaskRun) diskUsageCheck(index int) commandCheck {
+	maxBytes := int64(task.Payload.MaxTaskDiskUsage) * 1024 * 1024
+	return commandCheck{
+		interval: diskUsageCheckInterval,
+		check: func() *CommandExecutionError {
+			size, err := directorySize(TaskDir)
+			if err != nil {
+				log.Printf("WARN: could not calculate disk usage of %v: %s", TaskDir, err)
+				return nil
+			}
+			if size <= maxBytes {
+				return nil
+			}
+			return &CommandExecutionError{
+				Cause:      fmt.Errorf("Command %v caused task directory %v to exceed %v megabytes (maxTaskDiskUsage)", index, TaskDir, task.Payload.MaxTaskDiskUsage),
+				Reason:     "disk-usage-exceeded",
+				TaskStatus: Failed,
+			}
+		},
+	}
+}
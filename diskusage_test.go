@@ -0,0 +1,48 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A command writing more than maxTaskDiskUsage megabytes to the task directory
+// should be killed, failing the task.
+func TestMaxTaskDiskUsage(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands use bash")
+	}
+	defer func(c *Config) { config = c }(config)
+	config = &Config{KillGracePeriodSecs: 1}
+	oldInterval := diskUsageCheckInterval
+	diskUsageCheckInterval = 100 * time.Millisecond
+	defer func() {
+		diskUsageCheckInterval = oldInterval
+	}()
+	tempDir, err := ioutil.TempDir("", "generic-worker-disk-usage")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	TaskDir = tempDir
+
+	command := `["/bin/bash", "-c", "head -c 3145728 /dev/zero > '` + filepath.Join(tempDir, "big.bin") + `'; sleep 30"]`
+	task := newCommandTask(t, `{"command": [`+command+`], "maxRunTime": 60, "maxTaskDiskUsage": 2}`, &commandDeadline{
+		at:          time.Now().Add(time.Minute),
+		description: "maxRunTime of 60 seconds",
+	})
+	started := time.Now()
+	execErr := task.ExecuteCommand(0)
+	if execErr == nil || execErr.TaskStatus != Failed || execErr.Reason != "disk-usage-exceeded" {
+		t.Fatalf("Expected command to fail for exceeding maxTaskDiskUsage, but got: %#v", execErr)
+	}
+	if elapsed := time.Since(started); elapsed > 20*time.Second {
+		t.Fatalf("Expected command to be killed soon after exceeding maxTaskDiskUsage, but it took %v", elapsed)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// durationEstimateWeight is the weight given to the most recent task run
+// when updating the exponentially weighted moving average of task durations
+var durationEstimateWeight = 0.2
+
+// DurationEstimate is the estimated run duration of tasks from a task queue
+// (<provisionerId>/<workerType>), based on the tasks this worker has run
+// from it, for autoscalers to make capacity decisions with.
+type DurationEstimate struct {
+	// exponentially weighted moving average of the durations, in seconds
+	Seconds float64 `json:"seconds"`
+	// number of task runs the estimate is based on
+	Samples int `json:"samples"`
+	// when the last task run the estimate is based on finished
+	Updated tcclient.Time `json:"updated"`
+}
+
+var durationEstimates struct {
+	sync.Mutex
+	// file the estimates are persisted to, so that they survive worker
+	// restarts; not persisted if empty
+	file      string
+	estimates map[string]DurationEstimate
+}
+
+// durationEstimatesFile is stored alongside the worker config
+func durationEstimatesFile(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), "task-durations.json")
+}
+
+// loadDurationEstimates reads the persisted estimates from file, if it
+// exists, and persists future estimates to it
+func loadDurationEstimates(file string) error {
+	durationEstimates.Lock()
+	defer durationEstimates.Unlock()
+	durationEstimates.file = file
+	durationEstimates.estimates = map[string]DurationEstimate{}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &durationEstimates.estimates)
+}
+
+// recordTaskDuration updates the estimate for the task queue of the task
+// with how long it took to run. Only tasks that completed or failed are
+// counted, since tasks resolved as exception may have been cut short.
+func (task *TaskRun) recordTaskDuration(duration time.Duration, err error) {
+	if e, ok := err.(*CommandExecutionError); err != nil && (!ok || e.TaskStatus != Failed) {
+		return
+	}
+	taskQueue := task.Definition.ProvisionerID + "/" + task.Definition.WorkerType
+	durationEstimates.Lock()
+	defer durationEstimates.Unlock()
+	if durationEstimates.estimates == nil {
+		durationEstimates.estimates = map[string]DurationEstimate{}
+	}
+	estimate := durationEstimates.estimates[taskQueue]
+	if estimate.Samples == 0 {
+		estimate.Seconds = duration.Seconds()
+	} else {
+		estimate.Seconds = durationEstimateWeight*duration.Seconds() + (1-durationEstimateWeight)*estimate.Seconds
+	}
+	estimate.Samples++
+	estimate.Updated = tcclient.Time(time.Now())
+	durationEstimates.estimates[taskQueue] = estimate
+	if durationEstimates.file != "" {
+		err := writeToFileAsJSON(durationEstimates.estimates, durationEstimates.file)
+		if err != nil {
+			log.Printf("WARN: could not persist task duration estimates: %s", err)
+		}
+	}
+}
+
+// currentDurationEstimates returns a copy of the estimates of all task
+// queues this worker has run tasks from
+func currentDurationEstimates() map[string]DurationEstimate {
+	durationEstimates.Lock()
+	defer durationEstimates.Unlock()
+	estimates := make(map[string]DurationEstimate, len(durationEstimates.estimates))
+	for taskQueue, estimate := range durationEstimates.estimates {
+		estimates[taskQueue] = estimate
+	}
+	return estimates
+}
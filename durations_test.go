@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecordTaskDuration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "durations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "task-durations.json")
+	err = loadDurationEstimates(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loadDurationEstimates("")
+	task := &TaskRun{}
+	task.Definition.ProvisionerID = "test-provisioner"
+	task.Definition.WorkerType = "test-worker-type"
+	task.recordTaskDuration(100*time.Second, nil)
+	task.recordTaskDuration(200*time.Second, &CommandExecutionError{Cause: errors.New("exit code 1"), TaskStatus: Failed})
+	// exceptions are not counted
+	task.recordTaskDuration(time.Second, InternalError(errors.New("could not start task feature")))
+	expected := durationEstimateWeight*200 + (1-durationEstimateWeight)*100
+	estimate := currentDurationEstimates()["test-provisioner/test-worker-type"]
+	if estimate.Samples != 2 || estimate.Seconds != expected {
+		t.Fatalf("Expected estimate of %v seconds from 2 samples, but got %#v", expected, estimate)
+	}
+	// estimates survive a worker restart
+	err = loadDurationEstimates(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if persisted := currentDurationEstimates()["test-provisioner/test-worker-type"]; persisted.Samples != 2 || persisted.Seconds != expected {
+		t.Fatalf("Expected persisted estimate %#v, but got %#v", estimate, persisted)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// secretEnvVarName matches the names of env vars whose values should not be
+// published
+var secretEnvVarName = regexp.MustCompile(`(?i)(SECRET|TOKEN|PASSWORD|PASSWD|CREDENTIAL|PRIVATE|_KEY$|^KEY$|AUTH)`)
+
+type EnvironmentCaptureFeature struct {
+}
+
+type EnvironmentCaptureTask struct {
+	task *TaskRun
+}
+
+func (feature *EnvironmentCaptureFeature) Name() string {
+	return "Environment Capture"
+}
+
+func (feature *EnvironmentCaptureFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *EnvironmentCaptureFeature) Initialise() error {
+	return nil
+}
+
+func (feature *EnvironmentCaptureFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.EnvironmentCapture
+}
+
+func (feature *EnvironmentCaptureFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *EnvironmentCaptureFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &EnvironmentCaptureTask{
+		task: task,
+	}
+}
+
+func (e *EnvironmentCaptureTask) RequiredScopes() scopes.Required {
+	// secrets are redacted, and the rest is no more than the task could
+	// discover for itself
+	return scopes.Required{}
+}
+
+// Start writes a description of the environment to public/env/ and uploads
+// it. Failing to describe part of the environment does not fail the task,
+// but is recorded in the artifact.
+func (e *EnvironmentCaptureTask) Start() error {
+	env, err := e.task.capturedEnv()
+	if err != nil {
+		return err
+	}
+	for _, capture := range []struct {
+		name     string
+		content  string
+		commands [][]string
+	}{
+		{name: "env.txt", content: env},
+		{name: "packages.txt", commands: [][]string{packageListCommand()}},
+		{name: "os.txt", commands: osVersionCommands()},
+		{name: "tools.txt", commands: config.EnvironmentProbes},
+	} {
+		content := capture.content
+		for _, command := range capture.commands {
+			content += probeOutput(command)
+		}
+		artifact := "public/env/" + capture.name
+		file := filepath.Join(TaskDir, filepath.FromSlash(artifact))
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(file, []byte(content), 0644)
+		if err != nil {
+			return err
+		}
+		err = e.task.uploadArtifact(
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: artifact,
+					Expires:       e.task.Definition.Expires,
+				},
+				MimeType:        "text/plain; charset=utf-8",
+				ContentEncoding: "gzip",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *EnvironmentCaptureTask) Stop() error {
+	return nil
+}
+
+// capturedEnv returns the env vars that task commands inherit from the
+// worker, overridden by those in the task payload, one per line and sorted,
+// with secret values redacted
+func (task *TaskRun) capturedEnv() (string, error) {
+	env := map[string]string{}
+	for _, v := range os.Environ() {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	if task.Payload.Env != nil {
+		payloadEnv := map[string]string{}
+		err := json.Unmarshal(task.Payload.Env, &payloadEnv)
+		if err != nil {
+			return "", err
+		}
+		for name, value := range payloadEnv {
+			env[name] = value
+		}
+	}
+	lines := []string{}
+	for name, value := range env {
+		if secretEnvVarName.MatchString(name) {
+			value = "<redacted>"
+		}
+		lines = append(lines, name+"="+value)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// probeOutput runs the given command, and returns a description of it
+// together with its combined output
+func probeOutput(command []string) string {
+	output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+	result := "$ " + strings.Join(command, " ") + "\n" + string(output)
+	if err != nil {
+		result += fmt.Sprintf("(%s)\n", err)
+	}
+	return result + "\n"
+}
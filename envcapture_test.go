@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Env vars published by feature environmentCapture should have secret
+// values redacted, and payload env vars should override worker env vars.
+func TestCapturedEnv(t *testing.T) {
+	os.Setenv("GW_TEST_CAPTURED", "worker")
+	defer os.Unsetenv("GW_TEST_CAPTURED")
+	task := &TaskRun{}
+	task.Payload.Env = json.RawMessage(`{"GW_TEST_CAPTURED": "payload", "GW_TEST_API_TOKEN": "hunter2", "GW_TEST_DEPLOY_KEY": "hunter2"}`)
+	env, err := task.capturedEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"GW_TEST_CAPTURED=payload\n", "GW_TEST_API_TOKEN=<redacted>\n", "GW_TEST_DEPLOY_KEY=<redacted>\n"} {
+		if !strings.Contains(env, line) {
+			t.Errorf("Expected captured env to contain %q", line)
+		}
+	}
+	if strings.Contains(env, "hunter2") {
+		t.Fatalf("Captured env contains secret:\n%v", env)
+	}
+}
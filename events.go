@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// eventProtocolVersion is incremented whenever a change to workerEvent could
+// break existing event handlers. Adding properties or events does not.
+const eventProtocolVersion = 1
+
+// workerEvent is written as a single line of json to the standard in of each
+// event handler (see config setting eventHandlers), so that site integrators
+// can extend the worker, e.g. with audit logging, without patching it.
+type workerEvent struct {
+	Version int           `json:"version"`
+	Event   string        `json:"event"`
+	Time    tcclient.Time `json:"time"`
+	TaskID  string        `json:"taskId"`
+	RunID   uint          `json:"runId"`
+	// for "command-exit" events
+	CommandIndex *int `json:"commandIndex,omitempty"`
+	ExitCode     *int `json:"exitCode,omitempty"`
+	// for "task-end" events
+	Status string `json:"status,omitempty"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// eventHandler is a long running process that events are written to. If it
+// exits, it is restarted for the next event.
+type eventHandler struct {
+	command []string
+	events  chan []byte
+}
+
+var eventHandlers []*eventHandler
+
+// startEventHandlers starts a process for each of the given commands, to
+// write events to
+func startEventHandlers(commands [][]string) {
+	for _, command := range commands {
+		h := &eventHandler{
+			command: command,
+			// events are dropped rather than holding up tasks if a handler
+			// falls this far behind
+			events: make(chan []byte, 100),
+		}
+		eventHandlers = append(eventHandlers, h)
+		go h.run()
+	}
+}
+
+func (h *eventHandler) run() {
+	var cmd *exec.Cmd
+	var stdin io.WriteCloser
+	for event := range h.events {
+		// if the handler has exited, it is restarted, and the event written
+		// to the new process
+		for attempt := 1; attempt <= 2; attempt++ {
+			if cmd == nil {
+				cmd = exec.Command(h.command[0], h.command[1:]...)
+				cmd.Stdout = os.Stdout
+				cmd.Stderr = os.Stderr
+				pipe, err := cmd.StdinPipe()
+				if err == nil {
+					err = cmd.Start()
+				}
+				if err != nil {
+					log.Printf("WARN: could not start event handler %q: %s", h.command, err)
+					cmd = nil
+					break
+				}
+				stdin = pipe
+			}
+			_, err := stdin.Write(event)
+			if err == nil {
+				break
+			}
+			log.Printf("WARN: could not write event to event handler %q, which will be restarted: %s", h.command, err)
+			stdin.Close()
+			cmd.Wait()
+			cmd = nil
+		}
+	}
+}
+
+// publishTaskEnd publishes a "task-end" event with the resolution of the
+// task, given the error returned by TaskRun.run()
+func (task *TaskRun) publishTaskEnd(err error) {
+	e := workerEvent{
+		Event:  "task-end",
+		Status: string(task.Status),
+	}
+	if cee, ok := err.(*CommandExecutionError); ok {
+		e.Reason = cee.Reason
+	}
+	task.publishEvent(e)
+}
+
+// publishEvent writes the given event of the task to all event handlers
+func (task *TaskRun) publishEvent(e workerEvent) {
+	if len(eventHandlers) == 0 {
+		return
+	}
+	e.Version = eventProtocolVersion
+	e.Time = tcclient.Time(time.Now())
+	e.TaskID = task.TaskID
+	e.RunID = task.RunID
+	line, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("WARN: could not create %v event: %s", e.Event, err)
+		return
+	}
+	line = append(line, '\n')
+	for _, h := range eventHandlers {
+		select {
+		case h.events <- line:
+		default:
+			log.Printf("WARN: dropping %v event, since event handler %q is not keeping up", e.Event, h.command)
+		}
+	}
+}
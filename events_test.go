@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Event handlers should receive one json event per line, and be restarted if
+// they exit.
+func TestEventHandlers(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test event handlers use a unix shell")
+	}
+	tempDir, err := ioutil.TempDir("", "generic-worker-events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	out := filepath.Join(tempDir, "events.txt")
+	defer func() { eventHandlers = nil }()
+	// the handler exits after each event, so must be restarted
+	startEventHandlers([][]string{{"/bin/sh", "-c", "head -n 1 >> " + out}})
+	task := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", RunID: 1, Status: Failed}
+	task.publishEvent(workerEvent{Event: "task-start"})
+	waitForLines := func(n int) []string {
+		deadline := time.Now().Add(10 * time.Second)
+		for {
+			data, _ := ioutil.ReadFile(out)
+			lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+			if len(data) > 0 && len(lines) >= n || time.Now().After(deadline) {
+				return lines
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitForLines(1)
+	// give the handler time to exit
+	time.Sleep(500 * time.Millisecond)
+	task.publishTaskEnd(&CommandExecutionError{TaskStatus: Failed, Reason: "idle-timeout"})
+	lines := waitForLines(2)
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 events, but got %q", lines)
+	}
+	var start, end workerEvent
+	if err := json.Unmarshal([]byte(lines[0]), &start); err != nil || start.Event != "task-start" || start.Version != eventProtocolVersion || start.TaskID != task.TaskID {
+		t.Fatalf("Unexpected first event %q (error: %v)", lines[0], err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &end); err != nil || end.Event != "task-end" || end.Status != "Failed" || end.Reason != "idle-timeout" {
+		t.Fatalf("Unexpected second event %q (error: %v)", lines[1], err)
+	}
+}
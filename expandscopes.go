@@ -0,0 +1,56 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"github.com/taskcluster/taskcluster-client-go/auth"
+)
+
+// expandScopes calls auth.expandScopes to expand all roles in the given
+// scopes. It is a var so that tests can replace it.
+var expandScopes = func(taskScopes []string) ([]string, error) {
+	a := auth.New(
+		&tcclient.Credentials{
+			ClientID:    config.ClientID,
+			AccessToken: config.AccessToken,
+			Certificate: config.Certificate,
+		},
+	)
+	expanded, err := a.ExpandScopes(&auth.SetOfScopes{Scopes: taskScopes})
+	if err != nil {
+		return nil, err
+	}
+	return expanded.Scopes, nil
+}
+
+// hasScopes returns whether the task has the given required scopes. Roles
+// are only expanded when the task scopes alone are insufficient.
+func (task *TaskRun) hasScopes(requiredScopes scopes.Required) (bool, error) {
+	if scopes.Given(task.Definition.Scopes).Satisfies(requiredScopes) {
+		return true, nil
+	}
+	expanded, err := task.expandedScopes()
+	if err != nil {
+		return false, err
+	}
+	return scopes.Given(expanded).Satisfies(requiredScopes), nil
+}
+
+// expandedScopes returns the task scopes with all roles expanded, so that
+// scopes the task only has via a role can satisfy the scopes required by task
+// features. The expansion is only requested once per task, and is written to
+// the task log to help with debugging rejected scopes.
+func (task *TaskRun) expandedScopes() ([]string, error) {
+	if task.scopeExpansion != nil {
+		return task.scopeExpansion, nil
+	}
+	expanded, err := expandScopes(task.Definition.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	task.scopeExpansion = expanded
+	task.Log("Task scopes expand to:\n  " + strings.Join(task.scopeExpansion, "\n  "))
+	return task.scopeExpansion, nil
+}
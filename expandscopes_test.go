@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// A feature scope that the task only has via a role should satisfy the
+// feature, with roles expanded only once, and only when needed.
+func TestFeatureScopeGrantedThroughRole(t *testing.T) {
+	defer func(c *Config, expand func([]string) ([]string, error)) {
+		config = c
+		expandScopes = expand
+	}(config, expandScopes)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	expansions := 0
+	expandScopes = func(taskScopes []string) ([]string, error) {
+		expansions++
+		if !reflect.DeepEqual(taskScopes, []string{"assume:project:test"}) {
+			t.Fatalf("Expected task scopes to be expanded, but got %v", taskScopes)
+		}
+		return []string{
+			"assume:project:test",
+			"generic-worker:device:kvm:test-provisioner/test-worker-type",
+		}, nil
+	}
+
+	task := &TaskRun{logWriter: ioutil.Discard}
+	task.Definition.Scopes = []string{"assume:project:test"}
+	kvm := (&KVMFeature{}).NewTaskFeature(task)
+	satisfied, err := task.hasScopes(kvm.RequiredScopes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !satisfied {
+		t.Fatal("Expected scope granted through role to satisfy kvm feature")
+	}
+	hostOverrides := (&HostOverridesFeature{}).NewTaskFeature(task)
+	satisfied, err = task.hasScopes(hostOverrides.RequiredScopes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if satisfied {
+		t.Fatal("Expected host overrides feature scope not to be satisfied, as role does not grant it")
+	}
+	if expansions != 1 {
+		t.Fatalf("Expected task scopes to be expanded once, but were expanded %v times", expansions)
+	}
+
+	// no expansion should be requested if the task scopes are sufficient
+	task = &TaskRun{logWriter: ioutil.Discard}
+	task.Definition.Scopes = []string{"generic-worker:device:kvm:test-provisioner/*"}
+	satisfied, err = task.hasScopes((&KVMFeature{}).NewTaskFeature(task).RequiredScopes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !satisfied || expansions != 1 {
+		t.Fatalf("Expected task scopes to satisfy kvm feature without expansion, but satisfied=%v and expansions=%v", satisfied, expansions)
+	}
+}
+
+// If roles cannot be expanded, the failure should be reported rather than the
+// feature scope being rejected.
+func TestExpandScopesFailure(t *testing.T) {
+	defer func(c *Config, expand func([]string) ([]string, error)) {
+		config = c
+		expandScopes = expand
+	}(config, expandScopes)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	expandScopes = func(taskScopes []string) ([]string, error) {
+		return nil, errors.New("auth service unavailable")
+	}
+
+	task := &TaskRun{logWriter: ioutil.Discard}
+	task.Definition.Scopes = []string{"assume:project:test"}
+	_, err := task.hasScopes((&KVMFeature{}).NewTaskFeature(task).RequiredScopes())
+	if err == nil {
+		t.Fatal("Expected error when task scopes cannot be expanded")
+	}
+}
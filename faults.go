@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+)
+
+// FaultInjection is the (deliberately undocumented) config section that
+// makes the worker fail on purpose, so that its error handling can be
+// exercised in integration tests and staging pools. It must never be set on
+// production workers.
+type FaultInjection struct {
+	// seed of the random number generator deciding which faults occur, so
+	// that runs are reproducible
+	Seed int64 `json:"seed"`
+	// probability (between 0 and 1) of each fault point failing, keyed by
+	// fault point
+	Faults map[string]float64 `json:"faults"`
+}
+
+// faultPoints are the places in the worker that faults can be injected
+var faultPoints = map[string]string{
+	"reclaim":        "reclaiming the task from the queue",
+	"artifactUpload": "uploading an artifact",
+	"featureStart":   "starting task features",
+}
+
+var faults struct {
+	sync.Mutex
+	random *rand.Rand
+}
+
+func (f FaultInjection) validate() error {
+	for point, probability := range f.Faults {
+		if _, exists := faultPoints[point]; !exists {
+			return fmt.Errorf("Config setting faultInjection contains unknown fault point %q", point)
+		}
+		if probability < 0 || probability > 1 {
+			return fmt.Errorf("Config setting faultInjection has probability %v for fault point %q, which is not between 0 and 1", probability, point)
+		}
+	}
+	return nil
+}
+
+// injectFault returns an error with the probability configured for the
+// given fault point, or nil
+func injectFault(point string) error {
+	probability := config.FaultInjection.Faults[point]
+	if probability == 0 {
+		return nil
+	}
+	faults.Lock()
+	defer faults.Unlock()
+	if faults.random == nil {
+		faults.random = rand.New(rand.NewSource(config.FaultInjection.Seed))
+	}
+	if faults.random.Float64() >= probability {
+		return nil
+	}
+	log.Printf("FAULT INJECTION: failing %v", faultPoints[point])
+	return fmt.Errorf("Injected fault: failed %v", faultPoints[point])
+}
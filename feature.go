@@ -1,11 +1,30 @@
 package main
 
-import "github.com/taskcluster/taskcluster-base-go/scopes"
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
 
 type (
 	Feature interface {
+		Name() string
+		// Names of features which, if enabled, must be started before this
+		// feature, and stopped after it
+		Dependencies() []string
 		Initialise() error
-		IsEnabled(fl EnabledFeatures) bool
+		// Whether the feature is enabled for the given task, typically
+		// determined from the task payload
+		IsEnabled(task *TaskRun) bool
+		// Returns an error explaining why the worker cannot provide this
+		// feature (e.g. due to platform or worker config), or nil if it can.
+		// Tasks requiring an unsupported feature are rejected before any
+		// task features are started.
+		CheckSupported() error
 		NewTaskFeature(task *TaskRun) TaskFeature
 	}
 
@@ -15,8 +34,178 @@ type (
 		Stop() error
 	}
 
-	EnabledFeatures struct {
-		// A certificate should be generated which will include information for downstream tasks to build a level of trust for the artifacts produced by the task and the environment it ran in.
-		ChainOfTrust bool `json:"chainOfTrust,omitempty"`
+	// ScopeSources is optionally implemented by task features whose required
+	// scopes depend on individual payload entries, so that if the task lacks
+	// a scope, the task log can name the payload entries that require it
+	ScopeSources interface {
+		// Maps each required scope to the payload entries (e.g.
+		// "artifacts[2].hostPath") that require it
+		RequiredScopeSources() map[string][]string
 	}
+
+	// MultiError combines the errors from several operations which were all
+	// attempted, even though some of them failed
+	MultiError []error
 )
+
+func (m MultiError) Error() string {
+	messages := make([]string, len(m))
+	for i, err := range m {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "; ")
+}
+
+// orderFeatures returns the given features, sorted such that each feature
+// comes after all of its dependencies. Otherwise the order of features is
+// preserved. An error is returned if a dependency is unknown or if there is a
+// dependency cycle.
+func orderFeatures(features []Feature) ([]Feature, error) {
+	byName := map[string]Feature{}
+	for _, feature := range features {
+		byName[feature.Name()] = feature
+	}
+	ordered := []Feature{}
+	// a feature is "visiting" while its dependencies are being added, and
+	// "added" once it has been appended to ordered
+	visiting := map[string]bool{}
+	added := map[string]bool{}
+	var add func(feature Feature) error
+	add = func(feature Feature) error {
+		name := feature.Name()
+		if added[name] {
+			return nil
+		}
+		if visiting[name] {
+			return fmt.Errorf("Feature %q has a cyclic dependency on itself", name)
+		}
+		visiting[name] = true
+		for _, dependency := range feature.Dependencies() {
+			f, exists := byName[dependency]
+			if !exists {
+				return fmt.Errorf("Feature %q depends on unknown feature %q", name, dependency)
+			}
+			err := add(f)
+			if err != nil {
+				return err
+			}
+		}
+		added[name] = true
+		ordered = append(ordered, feature)
+		return nil
+	}
+	for _, feature := range features {
+		err := add(feature)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// missingScopeSources describes, one line per scope, the payload entries that
+// require those scopes in sources that given does not satisfy
+func missingScopeSources(sources map[string][]string, given scopes.Given) string {
+	lines := []string{}
+	for scope, entries := range sources {
+		if !given.Satisfies(scopes.Required{{scope}}) {
+			lines = append(lines, fmt.Sprintf("  %v (required by %v)", scope, strings.Join(entries, ", ")))
+		}
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// startTaskFeatures starts the given task features (which must be ordered by
+// orderFeatures) concurrently, except that each task feature is only started
+// once those of its dependencies that are also enabled have started. If any
+// task feature fails to start, those that did start are stopped again, and a
+// MultiError of the start failures is returned. The returned duration is the
+// time that was saved by starting the task features concurrently rather than
+// one after another.
+func startTaskFeatures(features []Feature, taskFeatures []TaskFeature, trace *taskTrace) (time.Duration, error) {
+	type result struct {
+		started  bool
+		duration time.Duration
+		err      error
+	}
+	results := make([]result, len(taskFeatures))
+	done := map[string]chan struct{}{}
+	for _, feature := range features {
+		done[feature.Name()] = make(chan struct{})
+	}
+	index := map[string]int{}
+	for i, feature := range features {
+		index[feature.Name()] = i
+	}
+	begin := time.Now()
+	var wg sync.WaitGroup
+	for i := range taskFeatures {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer close(done[features[i].Name()])
+			for _, dependency := range features[i].Dependencies() {
+				if d, enabled := done[dependency]; enabled {
+					<-d
+					if !results[index[dependency]].started {
+						// dependency failed to start, so don't start this
+						return
+					}
+				}
+			}
+			start := time.Now()
+			err := startRecoveringPanics(features[i].Name(), taskFeatures[i])
+			// features start concurrently, so each gets its own track
+			trace.span("feature: "+features[i].Name(), "start", start, nil)
+			results[i] = result{
+				started:  err == nil,
+				duration: time.Since(start),
+				err:      err,
+			}
+		}(i)
+	}
+	wg.Wait()
+	elapsed := time.Since(begin)
+
+	started := []TaskFeature{}
+	startErrors := MultiError{}
+	var sequential time.Duration
+	for i, r := range results {
+		sequential += r.duration
+		if r.started {
+			started = append(started, taskFeatures[i])
+		}
+		if r.err != nil {
+			startErrors = append(startErrors, fmt.Errorf("Feature %q could not be started: %s", features[i].Name(), r.err))
+		}
+	}
+	if len(startErrors) > 0 {
+		errStop := stopTaskFeatures(started)
+		if errStop != nil {
+			startErrors = append(startErrors, errStop)
+		}
+		return 0, startErrors
+	}
+	if sequential < elapsed {
+		return 0, nil
+	}
+	return sequential - elapsed, nil
+}
+
+// stopTaskFeatures stops the given task features in reverse order to how they
+// were started. All of the task features are stopped, even if some of them
+// fail to stop, in which case a MultiError is returned.
+func stopTaskFeatures(taskFeatures []TaskFeature) error {
+	stopErrors := MultiError{}
+	for i := len(taskFeatures) - 1; i >= 0; i-- {
+		err := taskFeatures[i].Stop()
+		if err != nil {
+			stopErrors = append(stopErrors, err)
+		}
+	}
+	if len(stopErrors) > 0 {
+		return stopErrors
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"golang.org/x/crypto/ssh"
+)
+
+// dummyFeature is a feature with configurable dependencies, whose task feature
+// records the order in which it is started and stopped
+type dummyFeature struct {
+	name         string
+	dependencies []string
+	startErr     error
+	startPanic   interface{}
+	stopErr      error
+	events       *[]string
+}
+
+// guards dummyFeature events, since task features are started concurrently
+var dummyEventsLock sync.Mutex
+
+func (f *dummyFeature) Name() string                             { return f.name }
+func (f *dummyFeature) Dependencies() []string                   { return f.dependencies }
+func (f *dummyFeature) Initialise() error                        { return nil }
+func (f *dummyFeature) IsEnabled(task *TaskRun) bool             { return true }
+func (f *dummyFeature) CheckSupported() error                    { return nil }
+func (f *dummyFeature) NewTaskFeature(task *TaskRun) TaskFeature { return f }
+func (f *dummyFeature) RequiredScopes() scopes.Required          { return scopes.Required{} }
+
+func (f *dummyFeature) Start() error {
+	dummyEventsLock.Lock()
+	defer dummyEventsLock.Unlock()
+	*f.events = append(*f.events, "start "+f.name)
+	if f.startPanic != nil {
+		panic(f.startPanic)
+	}
+	return f.startErr
+}
+
+func (f *dummyFeature) Stop() error {
+	dummyEventsLock.Lock()
+	defer dummyEventsLock.Unlock()
+	*f.events = append(*f.events, "stop "+f.name)
+	return f.stopErr
+}
+
+func featureNames(features []Feature) []string {
+	names := make([]string, len(features))
+	for i, feature := range features {
+		names[i] = feature.Name()
+	}
+	return names
+}
+
+func TestOrderFeatures(t *testing.T) {
+	features := []Feature{
+		&dummyFeature{name: "a", dependencies: []string{"c"}},
+		&dummyFeature{name: "b"},
+		&dummyFeature{name: "c", dependencies: []string{"b"}},
+		&dummyFeature{name: "d"},
+	}
+	ordered, err := orderFeatures(features)
+	if err != nil {
+		t.Fatalf("Could not order features: %v", err)
+	}
+	expected := []string{"b", "c", "a", "d"}
+	if actual := featureNames(ordered); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("Expected features in order %v but got %v", expected, actual)
+	}
+}
+
+func TestOrderFeaturesCycle(t *testing.T) {
+	features := []Feature{
+		&dummyFeature{name: "a", dependencies: []string{"b"}},
+		&dummyFeature{name: "b", dependencies: []string{"a"}},
+	}
+	_, err := orderFeatures(features)
+	if err == nil {
+		t.Fatal("Was expecting an error due to a dependency cycle, but didn't get one!")
+	}
+}
+
+func TestOrderFeaturesUnknownDependency(t *testing.T) {
+	features := []Feature{
+		&dummyFeature{name: "a", dependencies: []string{"z"}},
+	}
+	_, err := orderFeatures(features)
+	if err == nil {
+		t.Fatal("Was expecting an error due to an unknown dependency, but didn't get one!")
+	}
+}
+
+// All task features should be stopped in reverse order, even if some fail to
+// stop, and all errors should be reported
+func TestStopTaskFeatures(t *testing.T) {
+	events := []string{}
+	taskFeatures := []TaskFeature{
+		&dummyFeature{name: "a", events: &events, stopErr: errors.New("a failed")},
+		&dummyFeature{name: "b", events: &events},
+		&dummyFeature{name: "c", events: &events, stopErr: errors.New("c failed")},
+	}
+	err := stopTaskFeatures(taskFeatures)
+	expected := []string{"stop c", "stop b", "stop a"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected task features to be stopped in order %v but got %v", expected, events)
+	}
+	multiErr, ok := err.(MultiError)
+	if !ok {
+		t.Fatalf("Was expecting an error of type MultiError but received error of type %T", err)
+	}
+	if len(multiErr) != 2 || multiErr.Error() != "c failed; a failed" {
+		t.Fatalf("Was expecting both stop errors to be reported, but got: %v", multiErr)
+	}
+}
+
+// Task features should only be started once their dependencies have started,
+// and if one fails to start, its dependents should not be started, and those
+// that did start should be stopped again.
+func TestStartTaskFeatures(t *testing.T) {
+	events := []string{}
+	features := []Feature{
+		&dummyFeature{name: "a", events: &events},
+		&dummyFeature{name: "b", dependencies: []string{"a"}, events: &events, startErr: errors.New("b failed")},
+		&dummyFeature{name: "c", dependencies: []string{"b"}, events: &events},
+	}
+	taskFeatures := make([]TaskFeature, len(features))
+	for i, feature := range features {
+		taskFeatures[i] = feature.NewTaskFeature(nil)
+	}
+	_, err := startTaskFeatures(features, taskFeatures, nil)
+	if err == nil {
+		t.Fatal("Was expecting an error starting task features, but didn't get one!")
+	}
+	expected := []string{"start a", "start b", "stop a"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected task feature events %v but got %v", expected, events)
+	}
+}
+
+// A task feature that panics while starting should fail to start, like one
+// that returns an error, rather than crashing the worker.
+func TestStartTaskFeaturesPanic(t *testing.T) {
+	events := []string{}
+	features := []Feature{
+		&dummyFeature{name: "a", events: &events},
+		&dummyFeature{name: "b", dependencies: []string{"a"}, events: &events, startPanic: "b panicked"},
+	}
+	taskFeatures := make([]TaskFeature, len(features))
+	for i, feature := range features {
+		taskFeatures[i] = feature.NewTaskFeature(nil)
+	}
+	_, err := startTaskFeatures(features, taskFeatures, nil)
+	if err == nil || !strings.Contains(err.Error(), "b panicked") {
+		t.Fatalf("Was expecting the panic to be reported as an error starting task features, but got: %v", err)
+	}
+	expected := []string{"start a", "start b", "stop a"}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("Expected task feature events %v but got %v", expected, events)
+	}
+}
+
+// When a task lacks a scope, the payload entries requiring it should be named,
+// but not those requiring scopes the task has.
+func TestMissingScopeSources(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	task := &TaskRun{}
+	task.Payload.SSHCertificate.Principals = []string{"deploy", "admin"}
+	sources := (&SSHCertificateTask{task: task}).RequiredScopeSources()
+	missing := missingScopeSources(sources, scopes.Given{"generic-worker:ssh-certificate:test-provisioner/test-worker-type/deploy"})
+	if expected := "  generic-worker:ssh-certificate:test-provisioner/test-worker-type/admin (required by sshCertificate.principals[1])"; missing != expected {
+		t.Fatalf("Expected missing scope sources %q but got %q", expected, missing)
+	}
+	if missing := missingScopeSources(sources, scopes.Given{"generic-worker:ssh-certificate:*"}); missing != "" {
+		t.Fatalf("Expected no missing scope sources, but got %q", missing)
+	}
+}
+
+// Each log chunk should contain only what was written to the task log since
+// the previous chunk.
+func TestLogChunks(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-log-chunks")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+	oldTaskDir := TaskDir
+	TaskDir = tempDir
+	defer func() {
+		TaskDir = oldTaskDir
+	}()
+	err = os.MkdirAll(filepath.Join(tempDir, "public", "logs", "chunks"), 0755)
+	if err != nil {
+		t.Fatalf("Could not create log directory: %v", err)
+	}
+	logFile := filepath.Join(tempDir, "public", "logs", "live_backing.log")
+	l := &LogChunksTask{
+		task: &TaskRun{localArtifactsDir: filepath.Join(tempDir, "artifacts")},
+	}
+	for _, text := range []string{"first\n", "", "second\n"} {
+		f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatalf("Could not open task log: %v", err)
+		}
+		_, err = f.WriteString(text)
+		f.Close()
+		if err != nil {
+			t.Fatalf("Could not write to task log: %v", err)
+		}
+		err = l.uploadChunk()
+		if err != nil {
+			t.Fatalf("Could not upload log chunk: %v", err)
+		}
+	}
+	for i, expected := range []string{"first\n", "second\n"} {
+		chunk, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "chunks", fmt.Sprintf("%06d.log", i)))
+		if err != nil {
+			t.Fatalf("Log chunk %v was not published: %v", i, err)
+		}
+		if string(chunk) != expected {
+			t.Errorf("Expected log chunk %v to contain %q but it contains %q", i, expected, chunk)
+		}
+	}
+	if l.chunks != 2 {
+		t.Errorf("Expected 2 log chunks (none for an empty interval), but got %v", l.chunks)
+	}
+}
+
+// The SSH certificate of a task should only be valid for the requested
+// principals until the run deadline of the task, require a scope for each
+// principal, and be deleted together with its key when the task completes.
+func TestSSHCertificate(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-ssh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	TaskDir = tempDir
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caKeyFile := filepath.Join(tempDir, "ca")
+	err = ioutil.WriteFile(caKeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type", SSHCertificateAuthorityKey: caKeyFile}
+
+	deadline := time.Now().Add(time.Hour).Truncate(time.Second)
+	task := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", RunID: 1, maxRunTimeDeadline: deadline}
+	task.Definition.Deadline = tcclient.Time(deadline.Add(time.Hour))
+	task.Payload.SSHCertificate.Principals = []string{"deploy", "admin"}
+	feature := &SSHCertificateFeature{}
+	if !feature.IsEnabled(task) {
+		t.Fatal("Feature should be enabled when principals are requested")
+	}
+	if err := feature.CheckSupported(); runtime.GOOS != "windows" && err == nil {
+		t.Fatal("Feature should not be supported on Linux and macOS without the sandbox, since tasks could read the key")
+	}
+	config.Sandbox = runtime.GOOS == "linux"
+	if err := feature.CheckSupported(); runtime.GOOS != "darwin" && err != nil {
+		t.Fatalf("Feature should be supported, but got %v", err)
+	}
+	taskFeature := feature.NewTaskFeature(task)
+
+	required := taskFeature.RequiredScopes()
+	if scopes.Given([]string{"generic-worker:ssh-certificate:test-provisioner/test-worker-type/deploy"}).Satisfies(required) {
+		t.Fatalf("Scope for one principal should not satisfy required scopes %v", required)
+	}
+	if !scopes.Given([]string{"generic-worker:ssh-certificate:test-provisioner/test-worker-type/*"}).Satisfies(required) {
+		t.Fatalf("Scope for all principals should satisfy required scopes %v", required)
+	}
+
+	started := time.Now()
+	err = taskFeature.Start()
+	if err != nil {
+		t.Fatalf("Could not start feature: %v", err)
+	}
+	keyFile, certFile := task.featureEnv["TASKCLUSTER_SSH_KEY"], task.featureEnv["TASKCLUSTER_SSH_CERTIFICATE"]
+	certBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		t.Fatalf("Could not read certificate: %v", err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+	if err != nil {
+		t.Fatalf("Could not parse certificate: %v", err)
+	}
+	cert, ok := publicKey.(*ssh.Certificate)
+	if !ok {
+		t.Fatalf("Expected a certificate, but got %T", publicKey)
+	}
+	if !reflect.DeepEqual(cert.ValidPrincipals, []string{"deploy", "admin"}) {
+		t.Fatalf("Expected certificate to be valid for the requested principals, but it is valid for %v", cert.ValidPrincipals)
+	}
+	if validAfter := time.Unix(int64(cert.ValidAfter), 0); validAfter.After(started) || validAfter.Before(started.Add(-10*time.Minute)) {
+		t.Fatalf("Expected certificate to be valid from shortly before the task started, but it is valid after %v", validAfter)
+	}
+	if validBefore := time.Unix(int64(cert.ValidBefore), 0); !validBefore.Equal(deadline) {
+		t.Fatalf("Expected certificate to be valid until maxRunTime deadline %v, but it is valid until %v", deadline, validBefore)
+	}
+	caPublicKey, err := ssh.NewPublicKey(&caKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checker := &ssh.CertChecker{
+		IsUserAuthority: func(auth ssh.PublicKey) bool {
+			return bytes.Equal(auth.Marshal(), caPublicKey.Marshal())
+		},
+	}
+	_, err = checker.Authenticate(sshConnMetadata("admin"), cert)
+	if err != nil {
+		t.Fatalf("Expected certificate to authenticate requested principal: %v", err)
+	}
+	_, err = checker.Authenticate(sshConnMetadata("root"), cert)
+	if err == nil {
+		t.Fatal("Expected certificate not to authenticate principal that was not requested")
+	}
+
+	err = taskFeature.Stop()
+	if err != nil {
+		t.Fatalf("Could not stop feature: %v", err)
+	}
+	for _, file := range []string{keyFile, certFile} {
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			t.Fatalf("Expected %v to be deleted when the task completes, but got %v", file, err)
+		}
+	}
+}
+
+// sshConnMetadata is the ssh.ConnMetadata of a connection as the given user
+type sshConnMetadata string
+
+func (user sshConnMetadata) User() string     { return string(user) }
+func (sshConnMetadata) SessionID() []byte     { return nil }
+func (sshConnMetadata) ClientVersion() []byte { return nil }
+func (sshConnMetadata) ServerVersion() []byte { return nil }
+func (sshConnMetadata) RemoteAddr() net.Addr  { return nil }
+func (sshConnMetadata) LocalAddr() net.Addr   { return nil }
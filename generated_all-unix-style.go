@@ -14,16 +14,80 @@ type (
 	// TaskCluster Task definition.
 	GenericWorkerPayload struct {
 
+		// Prefix for the names that the artifacts listed in `artifacts` are published
+		// under, for example `public/build/${platform}/`, so that the same task
+		// definition can publish distinct artifacts on different platforms. May
+		// contain `${platform}`, `${arch}`, `${provisionerId}`, `${workerType}` and
+		// `${workerGroup}`. Artifact paths on the worker are unaffected. If not set,
+		// the worker config setting `artifactPrefix` (if any) is used. Must be a
+		// relative path, without `..` segments or backslashes. The prefix is a
+		// directory of the artifact names: `public/build` and `public/build/` are
+		// equivalent.
+		//
+		// Syntax:     ^(((\.?[^/\\.]|\.\.[^/\\])[^/\\]*|\.)(/((\.?[^/\\.]|\.\.[^/\\])[^/\\]*|\.)?)*)?$
+		ArtifactPrefix string `json:"artifactPrefix,omitempty"`
+
+		// Best effort artifacts (see property `bestEffort` of `artifacts`) are only
+		// uploaded while less than this many seconds have been spent uploading the
+		// artifacts of the task, so that uploading nice-to-have artifacts (such as
+		// huge debug symbols) over a slow link does not delay the resolution of the
+		// task. Best effort artifacts are uploaded after all other artifacts, and an
+		// upload that has started is completed. If not set, best effort artifacts
+		// are uploaded regardless of how long uploads take.
+		//
+		// Mininum:    0
+		ArtifactUploadBudgetSecs int `json:"artifactUploadBudgetSecs,omitempty"`
+
 		// Artifacts to be published. For example:
 		// `{ "type": "file", "path": "builds\\firefox.exe", "expires": "2015-08-19T17:30:00.000Z" }`
 		Artifacts []struct {
 
+			// If true, the artifact is only uploaded if the artifact upload time budget
+			// of the task (see property `artifactUploadBudgetSecs`) has not been used
+			// up, and failing to upload it does not affect the resolution of the task.
+			BestEffort bool `json:"bestEffort,omitempty"`
+
+			// Explicitly set the value of the HTTP `Content-Type` response header when
+			// the artifact(s) is/are served over HTTP(S). If not provided, the content
+			// type is determined from the file extension, or failing that, by sniffing
+			// the first bytes of the file content. For a `directory` artifact, the
+			// content type applies to all files in the directory.
+			ContentType string `json:"contentType,omitempty"`
+
 			// Date when artifact should expire must be in the future
 			Expires tcclient.Time `json:"expires"`
 
-			// Filesystem path of artifact
+			// If set, the file or directory at this absolute path on the worker
+			// (e.g. crash dumps or event logs written outside the task directory) is
+			// copied to `path` after the task commands have run, and published from
+			// there. It must be inside one of the directories of worker config
+			// setting `hostArtifactDirs`, and requires scope
+			// `generic-worker:host-artifacts:<provisionerId>/<workerType>`. May not be
+			// combined with `watch`.
+			HostPath string `json:"hostPath,omitempty"`
+
+			// If true, no artifact is published if the file or directory does not
+			// exist, for example for screenshots or crash dumps that are only
+			// sometimes produced. Otherwise a missing artifact is published as an
+			// error artifact, and reported in the task log.
+			Optional bool `json:"optional,omitempty"`
+
+			// Filesystem path of artifact, relative to the task directory, or `.` for
+			// the task directory itself. The path separator is `/` (`\` is a legal
+			// character in file names).
+			// `${NAME}` is replaced with the value of env var `NAME` from the payload
+			// `env`, and referencing an env var not in `env` is an error. Note that
+			// paths containing `${` were used literally by earlier worker versions.
+			// Absolute paths, and paths outside the task directory, are rejected (the
+			// task resolves as `exception/malformed-payload`).
 			Path string `json:"path"`
 
+			// If true, the task resolves as failed if the file does not exist or is
+			// empty (or the directory does not exist or contains no files), even if all
+			// commands succeeded, to catch builds that silently failed to produce their
+			// output. May not be combined with `optional`.
+			Required bool `json:"required,omitempty"`
+
 			// Artifacts can be either an individual `file` or a `directory` containing
 			// potentially multiple files with recursively included subdirectories.
 			//
@@ -31,13 +95,60 @@ type (
 			//   * "file"
 			//   * "directory"
 			Type string `json:"type"`
+
+			// If true (directory artifacts only), files in the directory are also
+			// published while the task commands run, once they have not changed for
+			// several seconds, so that partial results are available before the task
+			// completes, and even if it times out or crashes.
+			Watch bool `json:"watch,omitempty"`
 		} `json:"artifacts,omitempty"`
 
+		// Scheduled tasks that the task user creates while the task runs are always
+		// deleted when it completes (Windows only). In addition, the scheduled tasks
+		// and registry keys listed here are deleted, for state that the task creates
+		// outside the profile of the task user. Deleting listed registry keys requires
+		// scope `generic-worker:cleanup-registry:<provisionerId>/<workerType>`, and
+		// deleting listed scheduled tasks requires scope
+		// `generic-worker:cleanup-scheduled-tasks:<provisionerId>/<workerType>`.
+		Cleanup struct {
+
+			// Registry keys (under `HKLM\`) to delete, with all their subkeys and
+			// values, e.g. `HKLM\SOFTWARE\Mozilla\TestHarness`.
+			RegistryKeys []string `json:"registryKeys,omitempty"`
+
+			// Names of scheduled tasks to delete, e.g. `\TestHarnessWatchdog`.
+			ScheduledTasks []string `json:"scheduledTasks,omitempty"`
+		} `json:"cleanup,omitempty"`
+
 		// One array per command (each command is an array of arguments). Several arrays
-		// for several commands.
-		Command [][]string `json:"command"`
+		// for several commands. Alternatively, a command may be given as an object
+		// with the array of arguments in property `command`, and optionally a
+		// working directory `cwd` and env vars `env` for that command.
+		//
+		// Commands may output lines `::group::<name>` and `::endgroup::` to open and
+		// close a named (optionally nested) section of the task log. The sections are
+		// listed with their line numbers and durations at the end of the task log.
+		Command []json.RawMessage `json:"command"`
+
+		// Devices on the worker that the task requires access to.
+		Devices struct {
+
+			// The task requires read/write access to `/dev/kvm`, for example to run
+			// hardware accelerated virtual machines or emulators. Only supported on
+			// Linux workers whose config enables `kvm`, and requires scope
+			// `generic-worker:device:kvm:<provisionerId>/<workerType>`.
+			Kvm bool `json:"kvm,omitempty"`
+		} `json:"devices,omitempty"`
 
 		// Example: ```{ "PATH": "C:\\Windows\\system32;C:\\Windows", "GOOS": "darwin" }```
+		//
+		// In addition, env var `TASK_WORKDIR` is set to the absolute path of the
+		// task directory, which commands start in, and which artifact paths are
+		// relative to.
+		// Env vars `TASK_DEADLINE` and `TASK_MAX_RUN_TIME_DEADLINE` are set to the
+		// deadline of the task, and the time at which the task is aborted for
+		// exceeding `maxRunTime`, in RFC3339 format, so that commands can stop in
+		// time to publish partial results.
 		Env json.RawMessage `json:"env,omitempty"`
 
 		// Feature flags enable additional functionality.
@@ -48,13 +159,288 @@ type (
 			// a level of trust for the artifacts produced by the task and
 			// the environment it ran in.
 			ChainOfTrust bool `json:"chainOfTrust,omitempty"`
+
+			// The worker is configured to write core dumps (Linux, macOS) or
+			// minidumps (Windows) of crashing processes to directory
+			// `public/crashes`, and any dumps produced are published as artifacts
+			// under `public/crashes/` when the task completes.
+			CrashDumps bool `json:"crashDumps,omitempty"`
+
+			// When the task starts, a description of the environment it runs in is
+			// published under `public/env/`, to help reproduce failures elsewhere:
+			// the environment variables (`env.txt`, with the values of variables
+			// whose names suggest secrets redacted), the installed packages
+			// (`packages.txt`), the operating system version and patch level
+			// (`os.txt`), and the versions of tools that the worker type probes for
+			// (`tools.txt`, see worker config setting `environmentProbes`).
+			EnvironmentCapture bool `json:"environmentCapture,omitempty"`
+
+			// The task displays a user interface, e.g. for GUI tests (Windows only).
+			// Task commands must run in an interactive desktop session for their
+			// windows to be rendered. If the worker runs in Session 0 (as a Windows
+			// service), task commands therefore run in the session of the user logged on
+			// to the console, as that user, which requires scope
+			// `generic-worker:interactive-ui:<provisionerId>/<workerType>`. The task is
+			// resolved as `exception/malformed-payload` if no user is logged on to the
+			// console.
+			InteractiveUI bool `json:"interactiveUI,omitempty"`
+
+			// Run task commands in their own network namespace, in which only the
+			// loopback interface is available, to check that the task does not need
+			// network access (Linux only). Note that endpoints the worker serves on its
+			// loopback interface (e.g. for `progressReporting`) are not reachable from
+			// the namespace. The worker must run as root, with `unshare` and `ip`
+			// installed.
+			NetworkIsolation bool `json:"networkIsolation,omitempty"`
+
+			// An HTTP endpoint is served on the loopback interface for the duration
+			// of the task, at the url given in env var `TASKCLUSTER_PROGRESS_URL`.
+			// The task may POST json objects of the form
+			// `{"percent": 40, "step": "running unit tests"}` to this url, which
+			// will be written to the task log, with a timestamp. Progress is not
+			// relayed to the queue, which has no api for task run metadata.
+			ProgressReporting bool `json:"progressReporting,omitempty"`
+
+			// An HTTP endpoint is served on the loopback interface for the duration
+			// of the task, at the url given in env var `TASKCLUSTER_TIME_BUDGET_URL`.
+			// A GET request returns a json object with the task deadline
+			// (`taskDeadline`), when the task is aborted for exceeding `maxRunTime`
+			// (`maxRunTimeDeadline`), when the current claim of the task expires
+			// (`takenUntil`), and the number of seconds until the earlier of the two
+			// deadlines (`remainingSeconds`). Cannot be combined with
+			// `networkIsolation`, since the endpoint is not reachable from an
+			// isolated network.
+			TimeBudget bool `json:"timeBudget,omitempty"`
+
+			// Run task commands without the AppArmor profile that the worker
+			// config (`appArmorProfile`) applies to them (Linux only). Requires scope
+			// `generic-worker:unconfined:<provisionerId>/<workerType>`.
+			Unconfined bool `json:"unconfined,omitempty"`
 		} `json:"features,omitempty"`
 
-		// Maximum time the task container can run in seconds
+		// Hostname to IP address mappings to add to the hosts file of the worker
+		// for the duration of the task, e.g. for tests that require fake domains. On
+		// Linux workers with config setting `sandbox`, the overrides only apply to
+		// the task commands. Otherwise they apply to the whole worker, so hostnames
+		// of services the worker depends on (the queue, and domains `taskcluster.net`,
+		// `amazonaws.com` and `windows.net`) may not be overridden. The overrides
+		// are removed when the task completes. Requires scope
+		// `generic-worker:host-overrides:<provisionerId>/<workerType>`.
+		HostOverrides []struct {
+
+			// Syntax:     ^[A-Za-z0-9.-]+$
+			Hostname string `json:"hostname"`
+
+			// Syntax:     ^[0-9A-Fa-f.:]+$
+			IP string `json:"ip"`
+		} `json:"hostOverrides,omitempty"`
+
+		// Time zone and language to run the task commands with, e.g. for
+		// localisation tests. They are set via env vars `TZ`, `LANG` and `LC_ALL`.
+		// Requires scope `generic-worker:locale:<provisionerId>/<workerType>`.
+		Locale struct {
+
+			// Locale name, e.g. `de_DE.UTF-8`. The locale must be installed on the
+			// worker.
+			Language string `json:"language,omitempty"`
+
+			// Time zone name from the tz database, e.g. `Europe/Berlin`.
+			TimeZone string `json:"timeZone,omitempty"`
+		} `json:"locale,omitempty"`
+
+		// If specified, a command that produces no output (on either standard out or
+		// standard error) for this many seconds is killed, and the task resolved as
+		// failed. Useful for catching deadlocked test runners long before
+		// `maxRunTime` is reached.
+		//
+		// A snapshot of the running processes (and on Linux/macOS, their open files
+		// and sockets) is published under `public/timeout/` before the command is
+		// killed.
+		//
+		// Mininum:    1
+		// Maximum:    86400
+		MaxIdleTime int `json:"maxIdleTime,omitempty"`
+
+		// Network usage of the task is measured while commands run, from the byte
+		// counters of the worker's network interfaces (excluding loopback), and is
+		// written to the task log and published as artifact
+		// `public/logs/network_usage.json`. The counters are not specific to the
+		// task, so other traffic of the worker while commands run, such as live log
+		// streaming and artifact uploads, is included. If specified, and more than
+		// this many megabytes are received and sent in total, a warning is written
+		// to the task log; the task is not failed, since the traffic may not be the
+		// task's.
+		//
+		// Mininum:    1
+		MaxNetworkUsage int `json:"maxNetworkUsage,omitempty"`
+
+		// Maximum time the task container can run in seconds. If it is exceeded, a
+		// snapshot of the processes of the running command is published under
+		// `public/timeout/`, the command is killed, and the task is resolved as failed after any
+		// teardown commands have run (see `teardownMaxRunTime`).
 		//
 		// Mininum:    1
 		// Maximum:    86400
 		MaxRunTime int `json:"maxRunTime"`
+
+		// If specified, the task directory is periodically scanned while commands
+		// are running, and if the files in it exceed this many megabytes, the
+		// running command is killed and the task resolved as failed.
+		//
+		// Mininum:    1
+		MaxTaskDiskUsage int `json:"maxTaskDiskUsage,omitempty"`
+
+		// If specified, task commands (but not teardown commands) are run under
+		// `perf record`, and the profile of each command is published as artifact
+		// `public/perf/command_<n>.data` (Linux only). Requires worker config
+		// setting `perf`, and scope `generic-worker:perf:<provisionerId>/<workerType>`.
+		Perf struct {
+
+			// Events to record, as passed to `perf record -e`, e.g. `["cycles"]`.
+			Events []string `json:"events"`
+
+			// Sampling frequency in Hz, as passed to `perf record -F`. If not
+			// specified, the perf default is used.
+			//
+			// Mininum:    1
+			// Maximum:    100000
+			Frequency int `json:"frequency,omitempty"`
+		} `json:"perf,omitempty"`
+
+		// Windows performance counters to sample every second while the task runs,
+		// e.g. `\Processor(_Total)\% Processor Time`. The samples are published as
+		// artifact `public/perf/counters.csv` when the task completes (Windows only).
+		// Counter paths must start with `\`.
+		PerformanceCounters []string `json:"performanceCounters,omitempty"`
+
+		// Conditions on the worker's file system that must hold for the task
+		// commands to be able to run, checked after task features have started and
+		// before any commands run. If a `state` condition is not met, the task
+		// resolves as `exception/malformed-payload`; if there is too little free
+		// disk space, it resolves as `exception/resource-unavailable`, so that it can be
+		// retried on another worker. Teardown commands still run.
+		Preconditions []struct {
+
+			// Minimum free disk space, in megabytes, on the volume containing
+			// `path` (which must exist).
+			//
+			// Mininum:    1
+			MinFreeSpace int `json:"minFreeSpace,omitempty"`
+
+			// Path to check, relative to the task directory (or absolute).
+			Path string `json:"path"`
+
+			// Whether `path` must be a file, a directory, or must not exist.
+			//
+			// Possible values:
+			//   * "file"
+			//   * "directory"
+			//   * "absent"
+			State string `json:"state,omitempty"`
+		} `json:"preconditions,omitempty"`
+
+		// Hardware that the worker must have for the task to be able to run. If the
+		// worker does not have it, the task resolves as `exception/malformed-payload`,
+		// with an explanation in the task log, since it was routed to the wrong worker
+		// type. The hardware of the worker is published in
+		// `public/logs/worker_type_metadata.json`, under `host`.
+		Requires struct {
+
+			// Architecture of the worker, as named by Go, e.g. `amd64` or `arm64`.
+			Arch string `json:"arch,omitempty"`
+
+			// Capabilities that the worker type must declare in worker config setting
+			// `capabilities`, e.g. `["gpu", "loopback-av"]`. The capabilities of the
+			// worker are published in `public/logs/worker_type_metadata.json`, under
+			// `capabilities`.
+			Capabilities []string `json:"capabilities,omitempty"`
+
+			// CPU features the worker must have. `virtualization` is hardware
+			// virtualization support (Intel VT-x or AMD-V).
+			CPUFeatures []string `json:"cpuFeatures,omitempty"`
+
+			// Text that the GPU model of the worker must contain (case insensitively),
+			// e.g. `NVIDIA` or `Tesla T4`.
+			GPU string `json:"gpu,omitempty"`
+
+			// Minimum total physical memory of the worker, in megabytes.
+			//
+			// Mininum:    1
+			MinMemoryMB int `json:"minMemoryMB,omitempty"`
+		} `json:"requires,omitempty"`
+
+		// Path, relative to the task directory, of a json file that task commands
+		// may write to describe the result of the task, e.g. `result.json`. After all
+		// commands have run, the worker validates the file, and writes a summary of it
+		// to the task log. If its `status` is `failed`, the task resolves as failed,
+		// even if all commands succeeded. If the file is missing or invalid, the task
+		// also resolves as failed. The file should be of the form
+		// `{"status": "passed", "failureReasons": [], "suites": [{"name": "unit",
+		// "status": "passed", "passed": 10, "failed": 0, "skipped": 1}]}`, where only
+		// `status` is required.
+		// Optional `annotations` (e.g. `[{"path": "src/lib.go", "line": 12, "endLine":
+		// 12, "level": "failure", "title": "TestLib", "message": "..."}]`, with `level`
+		// one of `notice`, `warning` or `failure`) mark lines of source files. A
+		// markdown summary and the annotations (in the format of GitHub check runs) are
+		// published as artifacts `public/github/summary.md` and
+		// `public/github/annotations.json`, for GitHub integrations to render.
+		ResultFile string `json:"resultFile,omitempty"`
+
+		// Provision an SSH key pair for the task, whose public key is signed by the
+		// SSH certificate authority of the worker type for the given principals. The
+		// paths of the private key and the certificate are exported in env vars
+		// `TASKCLUSTER_SSH_KEY` and `TASKCLUSTER_SSH_CERTIFICATE`. The certificate
+		// expires when the task reaches its `maxRunTime` (or deadline, if earlier),
+		// and the key pair is deleted when the task completes. Requires scope
+		// `generic-worker:ssh-certificate:<provisionerId>/<workerType>/<principal>`
+		// for each principal.
+		SSHCertificate struct {
+
+			// Users (or other principals) that the certificate is valid for on
+			// the systems that trust the certificate authority.
+			Principals []string `json:"principals"`
+		} `json:"sshCertificate,omitempty"`
+
+		// Commands to run after the task commands have completed, regardless of
+		// whether they succeeded, failed, were not run due to an earlier failure,
+		// were killed for exceeding `maxRunTime`, or the task was cancelled or the
+		// worker is shutting down.
+		// Useful for releasing external resources (device pools, licenses, etc).
+		// Same format as `command`. A failing teardown command resolves an otherwise
+		// successful task as failed.
+		Teardown []json.RawMessage `json:"teardown,omitempty"`
+
+		// Maximum time the teardown commands may run in total. A teardown command
+		// still running when it is exceeded is killed, later teardown commands are
+		// not run, and an otherwise successful task is resolved as failed. Defaults
+		// to the worker config setting `teardownMaxRunTimeSecs`.
+		//
+		// Mininum:    1
+		// Maximum:    86400
+		TeardownMaxRunTime int `json:"teardownMaxRunTime,omitempty"`
+
+		// If set, the task commands are run once for each variant, in order, e.g.
+		// to build and test for several architectures without a task per
+		// architecture. Each variant runs in its own log section, with the env vars
+		// of the variant and env var `TASK_VARIANT` set to its name. After each
+		// variant, the task artifacts are published with the name of the variant
+		// inserted after the artifact prefix (e.g. `public/arm64/build.zip`),
+		// before the next variant overwrites them. A variant that fails (including
+		// due to a missing `required` artifact) does not stop later variants from
+		// running. The task resolves as exception if any variant did, otherwise as
+		// failed if any variant failed. Teardown commands run once, after all
+		// variants. May not be combined with `watch` artifacts.
+		Variants []struct {
+
+			// Env vars to set for the commands of this variant, in addition to
+			// those in the payload `env` (which they override).
+			Env json.RawMessage `json:"env,omitempty"`
+
+			// Name of the variant, used in its artifact names, so must be unique.
+			//
+			// Syntax:     ^[A-Za-z0-9_-][A-Za-z0-9_.-]*$
+			Name string `json:"name"`
+		} `json:"variants,omitempty"`
 	}
 )
 
@@ -77,22 +463,59 @@ func taskPayloadSchema() string {
   "description": "This schema defines the structure of the ` + "`" + `payload` + "`" + ` property referred to in a\nTaskCluster Task definition.",
   "id": "http://schemas.taskcluster.net/generic-worker/v1/payload.json#",
   "properties": {
+    "artifactPrefix": {
+      "description": "Prefix for the names that the artifacts listed in ` + "`" + `artifacts` + "`" + ` are published\nunder, for example ` + "`" + `public/build/${platform}/` + "`" + `, so that the same task\ndefinition can publish distinct artifacts on different platforms. May\ncontain ` + "`" + `${platform}` + "`" + `, ` + "`" + `${arch}` + "`" + `, ` + "`" + `${provisionerId}` + "`" + `, ` + "`" + `${workerType}` + "`" + ` and\n` + "`" + `${workerGroup}` + "`" + `. Artifact paths on the worker are unaffected. If not set,\nthe worker config setting ` + "`" + `artifactPrefix` + "`" + ` (if any) is used. Must be a\nrelative path, without ` + "`" + `..` + "`" + ` segments or backslashes. The prefix is a\ndirectory of the artifact names: ` + "`" + `public/build` + "`" + ` and ` + "`" + `public/build/` + "`" + ` are\nequivalent.",
+      "pattern": "^(((\\.?[^/\\\\.]|\\.\\.[^/\\\\])[^/\\\\]*|\\.)(/((\\.?[^/\\\\.]|\\.\\.[^/\\\\])[^/\\\\]*|\\.)?)*)?$",
+      "title": "Prefix for artifact names",
+      "type": "string"
+    },
+    "artifactUploadBudgetSecs": {
+      "description": "Best effort artifacts (see property ` + "`" + `bestEffort` + "`" + ` of ` + "`" + `artifacts` + "`" + `) are only\nuploaded while less than this many seconds have been spent uploading the\nartifacts of the task, so that uploading nice-to-have artifacts (such as\nhuge debug symbols) over a slow link does not delay the resolution of the\ntask. Best effort artifacts are uploaded after all other artifacts, and an\nupload that has started is completed. If not set, best effort artifacts\nare uploaded regardless of how long uploads take.",
+      "minimum": 0,
+      "title": "Time budget for best effort artifacts",
+      "type": "integer"
+    },
     "artifacts": {
       "description": "Artifacts to be published. For example:\n` + "`" + `{ \"type\": \"file\", \"path\": \"builds\\\\firefox.exe\", \"expires\": \"2015-08-19T17:30:00.000Z\" }` + "`" + `",
       "items": {
         "additionalProperties": false,
         "properties": {
+          "bestEffort": {
+            "description": "If true, the artifact is only uploaded if the artifact upload time budget\nof the task (see property ` + "`" + `artifactUploadBudgetSecs` + "`" + `) has not been used\nup, and failing to upload it does not affect the resolution of the task.",
+            "title": "Whether the artifact is nice-to-have",
+            "type": "boolean"
+          },
+          "contentType": {
+            "description": "Explicitly set the value of the HTTP ` + "`" + `Content-Type` + "`" + ` response header when\nthe artifact(s) is/are served over HTTP(S). If not provided, the content\ntype is determined from the file extension, or failing that, by sniffing\nthe first bytes of the file content. For a ` + "`" + `directory` + "`" + ` artifact, the\ncontent type applies to all files in the directory.",
+            "title": "Content-Type of artifact",
+            "type": "string"
+          },
           "expires": {
             "description": "Date when artifact should expire must be in the future",
             "format": "date-time",
             "title": "Expiry date and time",
             "type": "string"
           },
+          "hostPath": {
+            "description": "If set, the file or directory at this absolute path on the worker\n(e.g. crash dumps or event logs written outside the task directory) is\ncopied to ` + "`" + `path` + "`" + ` after the task commands have run, and published from\nthere. It must be inside one of the directories of worker config\nsetting ` + "`" + `hostArtifactDirs` + "`" + `, and requires scope\n` + "`" + `generic-worker:host-artifacts:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `. May not be\ncombined with ` + "`" + `watch` + "`" + `.",
+            "title": "Absolute path on the worker to publish the artifact from",
+            "type": "string"
+          },
+          "optional": {
+            "description": "If true, no artifact is published if the file or directory does not\nexist, for example for screenshots or crash dumps that are only\nsometimes produced. Otherwise a missing artifact is published as an\nerror artifact, and reported in the task log.",
+            "title": "Whether the artifact may be missing",
+            "type": "boolean"
+          },
           "path": {
-            "description": "Filesystem path of artifact",
+            "description": "Filesystem path of artifact, relative to the task directory, or ` + "`" + `.` + "`" + ` for\nthe task directory itself. The path separator is ` + "`" + `/` + "`" + ` (` + "`" + `\\` + "`" + ` is a legal\ncharacter in file names).\n` + "`" + `${NAME}` + "`" + ` is replaced with the value of env var ` + "`" + `NAME` + "`" + ` from the payload\n` + "`" + `env` + "`" + `, and referencing an env var not in ` + "`" + `env` + "`" + ` is an error. Note that\npaths containing ` + "`" + `${` + "`" + ` were used literally by earlier worker versions.\nAbsolute paths, and paths outside the task directory, are rejected (the\ntask resolves as ` + "`" + `exception/malformed-payload` + "`" + `).",
             "title": "Artifact location",
             "type": "string"
           },
+          "required": {
+            "description": "If true, the task resolves as failed if the file does not exist or is\nempty (or the directory does not exist or contains no files), even if all\ncommands succeeded, to catch builds that silently failed to produce their\noutput. May not be combined with ` + "`" + `optional` + "`" + `.",
+            "title": "Whether the task fails without the artifact",
+            "type": "boolean"
+          },
           "type": {
             "description": "Artifacts can be either an individual ` + "`" + `file` + "`" + ` or a ` + "`" + `directory` + "`" + ` containing\npotentially multiple files with recursively included subdirectories.",
             "enum": [
@@ -101,6 +524,11 @@ func taskPayloadSchema() string {
             ],
             "title": "Artifact upload type.",
             "type": "string"
+          },
+          "watch": {
+            "description": "If true (directory artifacts only), files in the directory are also\npublished while the task commands run, once they have not changed for\nseveral seconds, so that partial results are available before the task\ncompletes, and even if it times out or crashes.",
+            "title": "Publish files while the task runs",
+            "type": "boolean"
           }
         },
         "required": [
@@ -113,21 +541,90 @@ func taskPayloadSchema() string {
       "title": "Artifacts to be published",
       "type": "array"
     },
+    "cleanup": {
+      "additionalProperties": false,
+      "description": "Scheduled tasks that the task user creates while the task runs are always\ndeleted when it completes (Windows only). In addition, the scheduled tasks\nand registry keys listed here are deleted, for state that the task creates\noutside the profile of the task user. Deleting listed registry keys requires\nscope ` + "`" + `generic-worker:cleanup-registry:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `, and\ndeleting listed scheduled tasks requires scope\n` + "`" + `generic-worker:cleanup-scheduled-tasks:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+      "properties": {
+        "registryKeys": {
+          "description": "Registry keys (under ` + "`" + `HKLM\\` + "`" + `) to delete, with all their subkeys and\nvalues, e.g. ` + "`" + `HKLM\\SOFTWARE\\Mozilla\\TestHarness` + "`" + `.",
+          "items": {
+            "pattern": "^HKLM\\\\",
+            "type": "string"
+          },
+          "title": "Registry keys to delete",
+          "type": "array"
+        },
+        "scheduledTasks": {
+          "description": "Names of scheduled tasks to delete, e.g. ` + "`" + `\\TestHarnessWatchdog` + "`" + `.",
+          "items": {
+            "type": "string"
+          },
+          "title": "Scheduled tasks to delete",
+          "type": "array"
+        }
+      },
+      "title": "Windows state to clean up after the task",
+      "type": "object"
+    },
     "command": {
-      "description": "One array per command (each command is an array of arguments). Several arrays\nfor several commands.",
+      "description": "One array per command (each command is an array of arguments). Several arrays\nfor several commands. Alternatively, a command may be given as an object\nwith the array of arguments in property ` + "`" + `command` + "`" + `, and optionally a\nworking directory ` + "`" + `cwd` + "`" + ` and env vars ` + "`" + `env` + "`" + ` for that command.\n\nCommands may output lines ` + "`" + `::group::\u003cname\u003e` + "`" + ` and ` + "`" + `::endgroup::` + "`" + ` to open and\nclose a named (optionally nested) section of the task log. The sections are\nlisted with their line numbers and durations at the end of the task log.",
       "items": {
-        "items": {
-          "type": "string"
-        },
-        "minItems": 1,
-        "type": "array"
+        "oneOf": [
+          {
+            "items": {
+              "type": "string"
+            },
+            "minItems": 1,
+            "type": "array"
+          },
+          {
+            "additionalProperties": false,
+            "properties": {
+              "command": {
+                "items": {
+                  "type": "string"
+                },
+                "minItems": 1,
+                "type": "array"
+              },
+              "cwd": {
+                "description": "Directory to run the command in, relative to the task directory\n(or absolute).",
+                "type": "string"
+              },
+              "env": {
+                "additionalProperties": {
+                  "type": "string"
+                },
+                "description": "Env vars to set for this command only, in addition to those in\nthe payload ` + "`" + `env` + "`" + `.",
+                "type": "object"
+              }
+            },
+            "required": [
+              "command"
+            ],
+            "type": "object"
+          }
+        ]
       },
       "minItems": 1,
       "title": "Commands to run",
       "type": "array"
     },
+    "devices": {
+      "additionalProperties": false,
+      "description": "Devices on the worker that the task requires access to.",
+      "properties": {
+        "kvm": {
+          "description": "The task requires read/write access to ` + "`" + `/dev/kvm` + "`" + `, for example to run\nhardware accelerated virtual machines or emulators. Only supported on\nLinux workers whose config enables ` + "`" + `kvm` + "`" + `, and requires scope\n` + "`" + `generic-worker:device:kvm:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+          "title": "Require access to /dev/kvm",
+          "type": "boolean"
+        }
+      },
+      "title": "Devices required by the task",
+      "type": "object"
+    },
     "env": {
-      "description": "Example: ` + "`" + `` + "`" + `` + "`" + `{ \"PATH\": \"C:\\\\Windows\\\\system32;C:\\\\Windows\", \"GOOS\": \"darwin\" }` + "`" + `` + "`" + `` + "`" + `",
+      "description": "Example: ` + "`" + `` + "`" + `` + "`" + `{ \"PATH\": \"C:\\\\Windows\\\\system32;C:\\\\Windows\", \"GOOS\": \"darwin\" }` + "`" + `` + "`" + `` + "`" + `\n\nIn addition, env var ` + "`" + `TASK_WORKDIR` + "`" + ` is set to the absolute path of the\ntask directory, which commands start in, and which artifact paths are\nrelative to.\nEnv vars ` + "`" + `TASK_DEADLINE` + "`" + ` and ` + "`" + `TASK_MAX_RUN_TIME_DEADLINE` + "`" + ` are set to the\ndeadline of the task, and the time at which the task is aborted for\nexceeding ` + "`" + `maxRunTime` + "`" + `, in RFC3339 format, so that commands can stop in\ntime to publish partial results.",
       "title": "Environment variable mappings.",
       "type": "object"
     },
@@ -139,18 +636,339 @@ func taskPayloadSchema() string {
           "description": "An artifact named chainOfTrust.json.asc should be generated\nwhich will include information for downstream tasks to build\na level of trust for the artifacts produced by the task and\nthe environment it ran in.",
           "title": "Enable generation of a openpgp signed Chain of Trust artifact",
           "type": "boolean"
+        },
+        "crashDumps": {
+          "description": "The worker is configured to write core dumps (Linux, macOS) or\nminidumps (Windows) of crashing processes to directory\n` + "`" + `public/crashes` + "`" + `, and any dumps produced are published as artifacts\nunder ` + "`" + `public/crashes/` + "`" + ` when the task completes.",
+          "title": "Enable collection of crash dumps",
+          "type": "boolean"
+        },
+        "environmentCapture": {
+          "description": "When the task starts, a description of the environment it runs in is\npublished under ` + "`" + `public/env/` + "`" + `, to help reproduce failures elsewhere:\nthe environment variables (` + "`" + `env.txt` + "`" + `, with the values of variables\nwhose names suggest secrets redacted), the installed packages\n(` + "`" + `packages.txt` + "`" + `), the operating system version and patch level\n(` + "`" + `os.txt` + "`" + `), and the versions of tools that the worker type probes for\n(` + "`" + `tools.txt` + "`" + `, see worker config setting ` + "`" + `environmentProbes` + "`" + `).",
+          "title": "Publish a description of the worker environment",
+          "type": "boolean"
+        },
+        "interactiveUI": {
+          "description": "The task displays a user interface, e.g. for GUI tests (Windows only).\nTask commands must run in an interactive desktop session for their\nwindows to be rendered. If the worker runs in Session 0 (as a Windows\nservice), task commands therefore run in the session of the user logged on\nto the console, as that user, which requires scope\n` + "`" + `generic-worker:interactive-ui:<provisionerId>/<workerType>` + "`" + `. The task is\nresolved as ` + "`" + `exception/malformed-payload` + "`" + ` if no user is logged on to the\nconsole.",
+          "title": "Task requires an interactive desktop",
+          "type": "boolean"
+        },
+        "networkIsolation": {
+          "description": "Run task commands in their own network namespace, in which only the\nloopback interface is available, to check that the task does not need\nnetwork access (Linux only). Note that endpoints the worker serves on its\nloopback interface (e.g. for ` + "`" + `progressReporting` + "`" + `) are not reachable from\nthe namespace. The worker must run as root, with ` + "`" + `unshare` + "`" + ` and ` + "`" + `ip` + "`" + `\ninstalled.",
+          "title": "Run task commands without network access",
+          "type": "boolean"
+        },
+        "progressReporting": {
+          "description": "An HTTP endpoint is served on the loopback interface for the duration\nof the task, at the url given in env var ` + "`" + `TASKCLUSTER_PROGRESS_URL` + "`" + `.\nThe task may POST json objects of the form\n` + "`" + `{\"percent\": 40, \"step\": \"running unit tests\"}` + "`" + ` to this url, which\nwill be written to the task log, with a timestamp. Progress is not\nrelayed to the queue, which has no api for task run metadata.",
+          "title": "Enable reporting of task progress to the task log",
+          "type": "boolean"
+        },
+        "timeBudget": {
+          "description": "An HTTP endpoint is served on the loopback interface for the duration\nof the task, at the url given in env var ` + "`" + `TASKCLUSTER_TIME_BUDGET_URL` + "`" + `.\nA GET request returns a json object with the task deadline\n(` + "`" + `taskDeadline` + "`" + `), when the task is aborted for exceeding ` + "`" + `maxRunTime` + "`" + `\n(` + "`" + `maxRunTimeDeadline` + "`" + `), when the current claim of the task expires\n(` + "`" + `takenUntil` + "`" + `), and the number of seconds until the earlier of the two\ndeadlines (` + "`" + `remainingSeconds` + "`" + `). Cannot be combined with\n` + "`" + `networkIsolation` + "`" + `, since the endpoint is not reachable from an\nisolated network.",
+          "title": "Enable querying of the remaining time of the task",
+          "type": "boolean"
+        },
+        "unconfined": {
+          "description": "Run task commands without the AppArmor profile that the worker\nconfig (` + "`" + `appArmorProfile` + "`" + `) applies to them (Linux only). Requires scope\n` + "`" + `generic-worker:unconfined:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+          "title": "Run task commands without an AppArmor profile",
+          "type": "boolean"
         }
       },
       "title": "Feature flags",
       "type": "object"
     },
+    "hostOverrides": {
+      "description": "Hostname to IP address mappings to add to the hosts file of the worker\nfor the duration of the task, e.g. for tests that require fake domains. On\nLinux workers with config setting ` + "`" + `sandbox` + "`" + `, the overrides only apply to\nthe task commands. Otherwise they apply to the whole worker, so hostnames\nof services the worker depends on (the queue, and domains ` + "`" + `taskcluster.net` + "`" + `,\n` + "`" + `amazonaws.com` + "`" + ` and ` + "`" + `windows.net` + "`" + `) may not be overridden. The overrides\nare removed when the task completes. Requires scope\n` + "`" + `generic-worker:host-overrides:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+      "items": {
+        "additionalProperties": false,
+        "properties": {
+          "hostname": {
+            "pattern": "^[A-Za-z0-9.-]+$",
+            "type": "string"
+          },
+          "ip": {
+            "pattern": "^[0-9A-Fa-f.:]+$",
+            "type": "string"
+          }
+        },
+        "required": [
+          "hostname",
+          "ip"
+        ],
+        "type": "object"
+      },
+      "title": "Hostname overrides",
+      "type": "array"
+    },
+    "locale": {
+      "additionalProperties": false,
+      "description": "Time zone and language to run the task commands with, e.g. for\nlocalisation tests. They are set via env vars ` + "`" + `TZ` + "`" + `, ` + "`" + `LANG` + "`" + ` and ` + "`" + `LC_ALL` + "`" + `.\nRequires scope ` + "`" + `generic-worker:locale:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+      "properties": {
+        "language": {
+          "description": "Locale name, e.g. ` + "`" + `de_DE.UTF-8` + "`" + `. The locale must be installed on the\nworker.",
+          "type": "string"
+        },
+        "timeZone": {
+          "description": "Time zone name from the tz database, e.g. ` + "`" + `Europe/Berlin` + "`" + `.",
+          "type": "string"
+        }
+      },
+      "title": "Time zone and language of task commands",
+      "type": "object"
+    },
+    "maxIdleTime": {
+      "description": "If specified, a command that produces no output (on either standard out or\nstandard error) for this many seconds is killed, and the task resolved as\nfailed. Useful for catching deadlocked test runners long before\n` + "`" + `maxRunTime` + "`" + ` is reached.\n\nA snapshot of the running processes (and on Linux/macOS, their open files\nand sockets) is published under ` + "`" + `public/timeout/` + "`" + ` before the command is\nkilled.",
+      "maximum": 86400,
+      "minimum": 1,
+      "multipleOf": 1,
+      "title": "Maximum time in seconds without log output",
+      "type": "integer"
+    },
+    "maxNetworkUsage": {
+      "description": "Network usage of the task is measured while commands run, from the byte\ncounters of the worker's network interfaces (excluding loopback), and is\nwritten to the task log and published as artifact\n` + "`" + `public/logs/network_usage.json` + "`" + `. The counters are not specific to the\ntask, so other traffic of the worker while commands run, such as live log\nstreaming and artifact uploads, is included. If specified, and more than\nthis many megabytes are received and sent in total, a warning is written\nto the task log; the task is not failed, since the traffic may not be the\ntask's.",
+      "minimum": 1,
+      "multipleOf": 1,
+      "title": "Maximum network usage in megabytes",
+      "type": "integer"
+    },
     "maxRunTime": {
-      "description": "Maximum time the task container can run in seconds",
+      "description": "Maximum time the task container can run in seconds. If it is exceeded, a\nsnapshot of the processes of the running command is published under\n` + "`" + `public/timeout/` + "`" + `, the command is killed, and the task is resolved as failed after any\nteardown commands have run (see ` + "`" + `teardownMaxRunTime` + "`" + `).",
       "maximum": 86400,
       "minimum": 1,
       "multipleOf": 1,
       "title": "Maximum run time in seconds",
       "type": "integer"
+    },
+    "maxTaskDiskUsage": {
+      "description": "If specified, the task directory is periodically scanned while commands\nare running, and if the files in it exceed this many megabytes, the\nrunning command is killed and the task resolved as failed.",
+      "minimum": 1,
+      "multipleOf": 1,
+      "title": "Maximum disk usage in megabytes",
+      "type": "integer"
+    },
+    "perf": {
+      "additionalProperties": false,
+      "description": "If specified, task commands (but not teardown commands) are run under\n` + "`" + `perf record` + "`" + `, and the profile of each command is published as artifact\n` + "`" + `public/perf/command_\u003cn\u003e.data` + "`" + ` (Linux only). Requires worker config\nsetting ` + "`" + `perf` + "`" + `, and scope ` + "`" + `generic-worker:perf:\u003cprovisionerId\u003e/\u003cworkerType\u003e` + "`" + `.",
+      "properties": {
+        "events": {
+          "description": "Events to record, as passed to ` + "`" + `perf record -e` + "`" + `, e.g. ` + "`" + `[\"cycles\"]` + "`" + `.",
+          "items": {
+            "type": "string"
+          },
+          "minItems": 1,
+          "type": "array"
+        },
+        "frequency": {
+          "description": "Sampling frequency in Hz, as passed to ` + "`" + `perf record -F` + "`" + `. If not\nspecified, the perf default is used.",
+          "maximum": 100000,
+          "minimum": 1,
+          "multipleOf": 1,
+          "type": "integer"
+        }
+      },
+      "required": [
+        "events"
+      ],
+      "title": "Profile task commands with perf",
+      "type": "object"
+    },
+    "performanceCounters": {
+      "description": "Windows performance counters to sample every second while the task runs,\ne.g. ` + "`" + `\\Processor(_Total)\\% Processor Time` + "`" + `. The samples are published as\nartifact ` + "`" + `public/perf/counters.csv` + "`" + ` when the task completes (Windows only).\nCounter paths must start with ` + "`" + `\\` + "`" + `.",
+      "items": {
+        "pattern": "^\\\\",
+        "type": "string"
+      },
+      "minItems": 1,
+      "title": "Performance counters to record",
+      "type": "array"
+    },
+    "preconditions": {
+      "description": "Conditions on the worker's file system that must hold for the task\ncommands to be able to run, checked after task features have started and\nbefore any commands run. If a ` + "`" + `state` + "`" + ` condition is not met, the task\nresolves as ` + "`" + `exception/malformed-payload` + "`" + `; if there is too little free\ndisk space, it resolves as ` + "`" + `exception/resource-unavailable` + "`" + `, so that it can be\nretried on another worker. Teardown commands still run.",
+      "items": {
+        "additionalProperties": false,
+        "properties": {
+          "minFreeSpace": {
+            "description": "Minimum free disk space, in megabytes, on the volume containing\n` + "`" + `path` + "`" + ` (which must exist).",
+            "minimum": 1,
+            "multipleOf": 1,
+            "type": "integer"
+          },
+          "path": {
+            "description": "Path to check, relative to the task directory (or absolute).",
+            "type": "string"
+          },
+          "state": {
+            "description": "Whether ` + "`" + `path` + "`" + ` must be a file, a directory, or must not exist.",
+            "enum": [
+              "file",
+              "directory",
+              "absent"
+            ],
+            "type": "string"
+          }
+        },
+        "required": [
+          "path"
+        ],
+        "type": "object"
+      },
+      "title": "Conditions to check before running commands",
+      "type": "array"
+    },
+    "requires": {
+      "additionalProperties": false,
+      "description": "Hardware that the worker must have for the task to be able to run. If the\nworker does not have it, the task resolves as ` + "`" + `exception/malformed-payload` + "`" + `,\nwith an explanation in the task log, since it was routed to the wrong worker\ntype. The hardware of the worker is published in\n` + "`" + `public/logs/worker_type_metadata.json` + "`" + `, under ` + "`" + `host` + "`" + `.",
+      "properties": {
+        "arch": {
+          "description": "Architecture of the worker, as named by Go, e.g. ` + "`" + `amd64` + "`" + ` or ` + "`" + `arm64` + "`" + `.",
+          "title": "CPU architecture",
+          "type": "string"
+        },
+        "capabilities": {
+          "description": "Capabilities that the worker type must declare in worker config setting\n` + "`" + `capabilities` + "`" + `, e.g. ` + "`" + `[\"gpu\", \"loopback-av\"]` + "`" + `. The capabilities of the\nworker are published in ` + "`" + `public/logs/worker_type_metadata.json` + "`" + `, under\n` + "`" + `capabilities` + "`" + `.",
+          "items": {
+            "pattern": "^[A-Za-z0-9_-]+$",
+            "type": "string"
+          },
+          "title": "Required worker capabilities",
+          "type": "array",
+          "uniqueItems": true
+        },
+        "cpuFeatures": {
+          "description": "CPU features the worker must have. ` + "`" + `virtualization` + "`" + ` is hardware\nvirtualization support (Intel VT-x or AMD-V).",
+          "items": {
+            "enum": [
+              "aes",
+              "avx",
+              "avx2",
+              "avx512f",
+              "sse4_2",
+              "virtualization"
+            ],
+            "type": "string"
+          },
+          "title": "Required CPU features",
+          "type": "array"
+        },
+        "gpu": {
+          "description": "Text that the GPU model of the worker must contain (case insensitively),\ne.g. ` + "`" + `NVIDIA` + "`" + ` or ` + "`" + `Tesla T4` + "`" + `.",
+          "title": "GPU model",
+          "type": "string"
+        },
+        "minMemoryMB": {
+          "description": "Minimum total physical memory of the worker, in megabytes.",
+          "minimum": 1,
+          "title": "Minimum total memory",
+          "type": "integer"
+        }
+      },
+      "title": "Hardware required by the task",
+      "type": "object"
+    },
+    "resultFile": {
+      "description": "Path, relative to the task directory, of a json file that task commands\nmay write to describe the result of the task, e.g. ` + "`" + `result.json` + "`" + `. After all\ncommands have run, the worker validates the file, and writes a summary of it\nto the task log. If its ` + "`" + `status` + "`" + ` is ` + "`" + `failed` + "`" + `, the task resolves as failed,\neven if all commands succeeded. If the file is missing or invalid, the task\nalso resolves as failed. The file should be of the form\n` + "`" + `{\"status\": \"passed\", \"failureReasons\": [], \"suites\": [{\"name\": \"unit\",\n\"status\": \"passed\", \"passed\": 10, \"failed\": 0, \"skipped\": 1}]}` + "`" + `, where only\n` + "`" + `status` + "`" + ` is required.\nOptional ` + "`" + `annotations` + "`" + ` (e.g. ` + "`" + `[{\"path\": \"src/lib.go\", \"line\": 12, \"endLine\":\n12, \"level\": \"failure\", \"title\": \"TestLib\", \"message\": \"...\"}]` + "`" + `, with ` + "`" + `level` + "`" + `\none of ` + "`" + `notice` + "`" + `, ` + "`" + `warning` + "`" + ` or ` + "`" + `failure` + "`" + `) mark lines of source files. A\nmarkdown summary and the annotations (in the format of GitHub check runs) are\npublished as artifacts ` + "`" + `public/github/summary.md` + "`" + ` and\n` + "`" + `public/github/annotations.json` + "`" + `, for GitHub integrations to render.",
+      "title": "Structured result file",
+      "type": "string"
+    },
+    "sshCertificate": {
+      "additionalProperties": false,
+      "description": "Provision an SSH key pair for the task, whose public key is signed by the\nSSH certificate authority of the worker type for the given principals. The\npaths of the private key and the certificate are exported in env vars\n` + "`" + `TASKCLUSTER_SSH_KEY` + "`" + ` and ` + "`" + `TASKCLUSTER_SSH_CERTIFICATE` + "`" + `. The certificate\nexpires when the task reaches its ` + "`" + `maxRunTime` + "`" + ` (or deadline, if earlier),\nand the key pair is deleted when the task completes. Requires scope\n` + "`" + `generic-worker:ssh-certificate:\u003cprovisionerId\u003e/\u003cworkerType\u003e/\u003cprincipal\u003e` + "`" + `\nfor each principal.",
+      "properties": {
+        "principals": {
+          "description": "Users (or other principals) that the certificate is valid for on\nthe systems that trust the certificate authority.",
+          "items": {
+            "pattern": "^[A-Za-z0-9._@-]+$",
+            "type": "string"
+          },
+          "minItems": 1,
+          "title": "Principals",
+          "type": "array",
+          "uniqueItems": true
+        }
+      },
+      "required": [
+        "principals"
+      ],
+      "title": "Short-lived SSH credentials",
+      "type": "object"
+    },
+    "teardown": {
+      "description": "Commands to run after the task commands have completed, regardless of\nwhether they succeeded, failed, were not run due to an earlier failure,\nwere killed for exceeding ` + "`" + `maxRunTime` + "`" + `, or the task was cancelled or the\nworker is shutting down.\nUseful for releasing external resources (device pools, licenses, etc).\nSame format as ` + "`" + `command` + "`" + `. A failing teardown command resolves an otherwise\nsuccessful task as failed.",
+      "items": {
+        "oneOf": [
+          {
+            "items": {
+              "type": "string"
+            },
+            "minItems": 1,
+            "type": "array"
+          },
+          {
+            "additionalProperties": false,
+            "properties": {
+              "command": {
+                "items": {
+                  "type": "string"
+                },
+                "minItems": 1,
+                "type": "array"
+              },
+              "cwd": {
+                "description": "Directory to run the command in, relative to the task directory\n(or absolute).",
+                "type": "string"
+              },
+              "env": {
+                "additionalProperties": {
+                  "type": "string"
+                },
+                "description": "Env vars to set for this command only, in addition to those in\nthe payload ` + "`" + `env` + "`" + `.",
+                "type": "object"
+              }
+            },
+            "required": [
+              "command"
+            ],
+            "type": "object"
+          }
+        ]
+      },
+      "title": "Commands to run after the task commands",
+      "type": "array"
+    },
+    "teardownMaxRunTime": {
+      "description": "Maximum time the teardown commands may run in total. A teardown command\nstill running when it is exceeded is killed, later teardown commands are\nnot run, and an otherwise successful task is resolved as failed. Defaults\nto the worker config setting ` + "`" + `teardownMaxRunTimeSecs` + "`" + `.",
+      "maximum": 86400,
+      "minimum": 1,
+      "multipleOf": 1,
+      "title": "Maximum run time of teardown commands in seconds",
+      "type": "integer"
+    },
+    "variants": {
+      "description": "If set, the task commands are run once for each variant, in order, e.g.\nto build and test for several architectures without a task per\narchitecture. Each variant runs in its own log section, with the env vars\nof the variant and env var ` + "`" + `TASK_VARIANT` + "`" + ` set to its name. After each\nvariant, the task artifacts are published with the name of the variant\ninserted after the artifact prefix (e.g. ` + "`" + `public/arm64/build.zip` + "`" + `),\nbefore the next variant overwrites them. A variant that fails (including\ndue to a missing ` + "`" + `required` + "`" + ` artifact) does not stop later variants from\nrunning. The task resolves as exception if any variant did, otherwise as\nfailed if any variant failed. Teardown commands run once, after all\nvariants. May not be combined with ` + "`" + `watch` + "`" + ` artifacts.",
+      "items": {
+        "additionalProperties": false,
+        "properties": {
+          "env": {
+            "additionalProperties": {
+              "type": "string"
+            },
+            "description": "Env vars to set for the commands of this variant, in addition to\nthose in the payload ` + "`" + `env` + "`" + ` (which they override).",
+            "type": "object"
+          },
+          "name": {
+            "description": "Name of the variant, used in its artifact names, so must be unique.",
+            "pattern": "^[A-Za-z0-9_-][A-Za-z0-9_.-]*$",
+            "type": "string"
+          }
+        },
+        "required": [
+          "name"
+        ],
+        "type": "object"
+      },
+      "minItems": 1,
+      "title": "Variants of the task commands",
+      "type": "array",
+      "uniqueItems": true
     }
   },
   "required": [
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// headroomProblem returns an error if the free disk space of the volume
+// containing dir, less the given number of bytes that are about to be
+// written to it, or the available memory, is below what the worker config
+// reserves for the worker itself (see reservedDiskSpaceMB and
+// reservedMemoryMB), so that the worker is not the one to run out.
+func headroomProblem(dir string, needed uint64) error {
+	if reserve := uint64(config.ReservedDiskSpaceMB) * 1024 * 1024; reserve > 0 {
+		free, err := freeDiskSpace(dir)
+		if err != nil {
+			log.Printf("WARN: could not determine free disk space of %v: %s", dir, err)
+		} else if free < reserve+needed {
+			return fmt.Errorf("Only %v megabytes free for %v, but %v megabytes are reserved for the worker (reservedDiskSpaceMB)", free/1024/1024, dir, config.ReservedDiskSpaceMB)
+		}
+	}
+	if reserve := uint64(config.ReservedMemoryMB) * 1024 * 1024; reserve > 0 {
+		available, err := availableMemory() // platform specific
+		if err != nil {
+			log.Printf("WARN: could not determine available memory: %s", err)
+		} else if available < reserve {
+			return fmt.Errorf("Only %v megabytes of memory available, but %v megabytes are reserved for the worker (reservedMemoryMB)", available/1024/1024, config.ReservedMemoryMB)
+		}
+	}
+	return nil
+}
+
+// headroomCheck kills command index of a task if it eats into the disk space
+// or memory reserved for the worker, see monitorCommand
+func headroomCheck(index int) commandCheck {
+	return commandCheck{
+		interval: 10 * time.Second,
+		check: func() *CommandExecutionError {
+			problem := headroomProblem(TaskDir, 0)
+			if problem == nil {
+				return nil
+			}
+			return ResourceUnavailable(fmt.Errorf("Command %v used resources reserved for the worker: %s", index, problem))
+		},
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestHeadroomProblem(t *testing.T) {
+	defer func(c *Config) {
+		config = c
+	}(config)
+	dir := os.TempDir()
+	config = &Config{}
+	if err := headroomProblem(dir, 1<<60); err != nil {
+		t.Fatalf("Expected no problem without reserved resources, but got %v", err)
+	}
+	config = &Config{ReservedDiskSpaceMB: 1}
+	if err := headroomProblem(dir, 0); err != nil {
+		t.Fatalf("Expected 1 megabyte to be free in %v, but got %v", dir, err)
+	}
+	if err := headroomProblem(dir, 1<<60); err == nil {
+		t.Fatal("Expected a problem when writing more than the free disk space")
+	}
+	config = &Config{ReservedMemoryMB: 1 << 30}
+	if err := headroomProblem(dir, 0); err == nil {
+		t.Fatal("Expected a problem when reserving more memory than is available")
+	}
+}
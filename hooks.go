@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// runHooks runs the given commands (from config settings startupHooks,
+// preTaskHooks, postTaskHooks or shutdownHooks), for site specific needs
+// such as license server check-ins. Each command is killed if it runs for
+// longer than config.HookTimeoutSecs. Env var GENERIC_WORKER_HOOK is set to
+// the stage, in addition to the given env vars. Failures are logged, but
+// otherwise ignored.
+func runHooks(stage string, commands [][]string, env ...string) {
+	for _, command := range commands {
+		log.Printf("Running %v hook %q", stage, command)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.HookTimeoutSecs)*time.Second)
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Env = append(append(os.Environ(), "GENERIC_WORKER_HOOK="+stage), env...)
+		output, err := cmd.CombinedOutput()
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ctx.Err()
+		}
+		cancel()
+		if out := strings.TrimSpace(string(output)); out != "" {
+			log.Printf("Output of %v hook %q:\n%v", stage, command, out)
+		}
+		if err != nil {
+			log.Printf("WARN: %v hook %q failed: %s", stage, command, err)
+		}
+	}
+}
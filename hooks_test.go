@@ -0,0 +1,42 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Hooks should run with the hook stage and given env vars set, and be killed
+// if they exceed the hook timeout.
+func TestHooks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test hooks use a unix shell")
+	}
+	tempDir, err := ioutil.TempDir("", "generic-worker-hooks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	out := filepath.Join(tempDir, "hook.txt")
+	defer func(c *Config) { config = c }(config)
+	config = &Config{HookTimeoutSecs: 1}
+	start := time.Now()
+	runHooks("pre-task", [][]string{
+		{"/bin/sh", "-c", "echo $GENERIC_WORKER_HOOK $TASK_ID > " + out},
+		{"sleep", "10"},
+	}, "TASK_ID=abc")
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected hook to be killed after 1 second, but hooks took %v", elapsed)
+	}
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "pre-task abc" {
+		t.Fatalf("Expected hook to see env vars %q, but got %q", "pre-task abc", data)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+type HostArtifactsFeature struct {
+}
+
+type HostArtifactsTask struct {
+	task *TaskRun
+}
+
+func (feature *HostArtifactsFeature) Name() string {
+	return "Host Artifacts"
+}
+
+func (feature *HostArtifactsFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *HostArtifactsFeature) Initialise() error {
+	return nil
+}
+
+func (feature *HostArtifactsFeature) IsEnabled(task *TaskRun) bool {
+	for _, artifact := range task.Payload.Artifacts {
+		if artifact.HostPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func (feature *HostArtifactsFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *HostArtifactsFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &HostArtifactsTask{
+		task: task,
+	}
+}
+
+// Files outside the task directory may contain data of other tasks, or of
+// the worker itself, so publishing them is restricted per worker type (as
+// well as by config setting hostArtifactDirs)
+func (h *HostArtifactsTask) RequiredScopes() scopes.Required {
+	return scopes.Required{
+		{"generic-worker:host-artifacts:" + config.ProvisionerID + "/" + config.WorkerType},
+	}
+}
+
+// RequiredScopeSources names the artifacts that are published from outside the
+// task directory
+func (h *HostArtifactsTask) RequiredScopeSources() map[string][]string {
+	entries := []string{}
+	for i, artifact := range h.task.Payload.Artifacts {
+		if artifact.HostPath != "" {
+			entries = append(entries, fmt.Sprintf("artifacts[%v].hostPath", i))
+		}
+	}
+	return map[string][]string{
+		"generic-worker:host-artifacts:" + config.ProvisionerID + "/" + config.WorkerType: entries,
+	}
+}
+
+func (h *HostArtifactsTask) Start() error {
+	return nil
+}
+
+func (h *HostArtifactsTask) Stop() error {
+	return nil
+}
+
+// hostArtifactAllowed returns whether path is (inside) one of the
+// directories of config setting hostArtifactDirs
+func hostArtifactAllowed(path string) bool {
+	for _, dir := range config.HostArtifactDirs {
+		rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(path))
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHostArtifact checks payload property hostPath of the given artifact
+func validateHostArtifact(hostPath string, watch bool) error {
+	if !filepath.IsAbs(hostPath) {
+		return fmt.Errorf("hostPath %q of artifact must be absolute", hostPath)
+	}
+	if watch {
+		return fmt.Errorf("artifact with hostPath %q cannot be watched", hostPath)
+	}
+	if !hostArtifactAllowed(hostPath) {
+		return fmt.Errorf("hostPath %q of artifact is not inside any of the directories %v that worker type %v allows artifacts from (config setting hostArtifactDirs)", hostPath, config.HostArtifactDirs, config.WorkerType)
+	}
+	return nil
+}
+
+// collectHostArtifacts copies the payload artifacts with property hostPath
+// into the task directory, at their path, so that they are published like
+// any other artifact. Artifacts that do not exist are left for the usual
+// handling of missing artifacts. Symbolic links are resolved first, so that
+// they cannot point outside the allowed directories, and are not followed
+// inside directories. Symbolic links that the task has created in the task
+// directory are not followed either (see copyHostArtifact).
+func (task *TaskRun) collectHostArtifacts() {
+	for _, artifact := range task.Payload.Artifacts {
+		if artifact.HostPath == "" {
+			continue
+		}
+		err := copyHostArtifact(artifact.HostPath, TaskDir, artifact.Path)
+		if err != nil {
+			task.Log(fmt.Sprintf("Could not collect artifact %v from %v: %s", artifact.Path, artifact.HostPath, err))
+			continue
+		}
+		task.Log(fmt.Sprintf("Collected artifact %v from %v", artifact.Path, artifact.HostPath))
+	}
+}
+
+// copyHostArtifact copies hostPath to artifactPath (using forward slashes) in
+// taskDir. The task controls the content of taskDir, so nothing is written
+// through a symbolic link there, as it could point anywhere that the worker
+// can write to.
+func copyHostArtifact(hostPath, taskDir, artifactPath string) error {
+	resolved, err := filepath.EvalSymlinks(hostPath)
+	if err != nil {
+		return err
+	}
+	if !hostArtifactAllowed(resolved) {
+		return fmt.Errorf("%v resolves to %v, which is not inside any of the directories allowed by config setting hostArtifactDirs", hostPath, resolved)
+	}
+	dest := filepath.Join(taskDir, filepath.FromSlash(artifactPath))
+	return filepath.Walk(resolved, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(resolved, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+		err = noSymlinksBelow(taskDir, target)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(longPath(target), 0777)
+		}
+		err = os.MkdirAll(longPath(filepath.Dir(target)), 0777)
+		if err != nil {
+			return err
+		}
+		return copyFileContents(longPath(path), longPath(target))
+	})
+}
+
+// noSymlinksBelow returns an error if path, or any of its ancestors below
+// dir, is a symbolic link. Those that do not exist yet are fine, since they
+// will be created by the worker.
+func noSymlinksBelow(dir, path string) error {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return err
+	}
+	current := dir
+	for _, component := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, component)
+		info, err := os.Lstat(longPath(current))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%v is a symbolic link, so will not be written through", current)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// HostCapabilities describes the hardware of the worker, published in worker
+// metadata, and matched against payload property requires
+type HostCapabilities struct {
+	// architecture, as named by Go, e.g. amd64 or arm64
+	Arch string `json:"arch"`
+	// CPU features of interest to tasks (see cpuFeatureFlags)
+	CPUFeatures []string `json:"cpuFeatures"`
+	// total physical memory, in megabytes
+	MemoryMB uint64 `json:"memoryMB"`
+	// GPU model(s), if any could be found
+	GPU string `json:"gpu,omitempty"`
+}
+
+// cpuFeatureFlags are the CPU features that are reported, with the flags
+// that platforms use for them (lower case)
+var cpuFeatureFlags = map[string][]string{
+	"aes":            {"aes"},
+	"avx":            {"avx", "avx1.0"},
+	"avx2":           {"avx2"},
+	"avx512f":        {"avx512f"},
+	"sse4_2":         {"sse4_2", "sse4.2"},
+	"virtualization": {"vmx", "svm"},
+}
+
+var hostCapabilities *HostCapabilities
+
+// detectHostCapabilities detects the hardware of the worker. Anything that
+// cannot be detected is logged and left empty.
+func detectHostCapabilities() *HostCapabilities {
+	h := &HostCapabilities{
+		Arch:        runtime.GOARCH,
+		CPUFeatures: []string{},
+	}
+	flags, err := cpuFlags()
+	if err != nil {
+		log.Printf("WARN: could not detect CPU features: %s", err)
+	}
+	h.CPUFeatures = cpuFeatures(flags)
+	memory, err := totalMemory()
+	if err != nil {
+		log.Printf("WARN: could not detect total memory: %s", err)
+	}
+	h.MemoryMB = memory / 1024 / 1024
+	h.GPU, err = gpuModel()
+	if err != nil {
+		log.Printf("WARN: could not detect GPU: %s", err)
+	}
+	return h
+}
+
+// cpuFeatures returns the (sorted) CPU features that the given CPU flags
+// provide
+func cpuFeatures(flags map[string]bool) []string {
+	features := []string{}
+	for feature, aliases := range cpuFeatureFlags {
+		for _, flag := range aliases {
+			if flags[flag] {
+				features = append(features, feature)
+				break
+			}
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// checkRequirements checks the hardware and capabilities required by the task
+// payload against those of the worker, and resolves the task as
+// malformed-payload if they are not met, since the task was routed to a
+// worker type that cannot run it.
+func (task *TaskRun) checkRequirements() *CommandExecutionError {
+	requires := task.Payload.Requires
+	h := hostCapabilities
+	if h == nil {
+		h = detectHostCapabilities()
+	}
+	problems := []string{}
+	if requires.Arch != "" && requires.Arch != h.Arch {
+		problems = append(problems, fmt.Sprintf("architecture %v, but worker has %v", requires.Arch, h.Arch))
+	}
+	for _, feature := range requires.CPUFeatures {
+		found := false
+		for _, f := range h.CPUFeatures {
+			found = found || f == feature
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("CPU feature %v, but worker only has [%v]", feature, strings.Join(h.CPUFeatures, ", ")))
+		}
+	}
+	if requires.MinMemoryMB > 0 && uint64(requires.MinMemoryMB) > h.MemoryMB {
+		problems = append(problems, fmt.Sprintf("%v MB of memory, but worker has %v MB", requires.MinMemoryMB, h.MemoryMB))
+	}
+	if requires.GPU != "" && !strings.Contains(strings.ToLower(h.GPU), strings.ToLower(requires.GPU)) {
+		gpu := h.GPU
+		if gpu == "" {
+			gpu = "none"
+		}
+		problems = append(problems, fmt.Sprintf("GPU %q, but worker has %v", requires.GPU, gpu))
+	}
+	for _, capability := range task.requiredCapabilities() {
+		found := false
+		for _, c := range config.Capabilities {
+			found = found || c == capability
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("capability %v, but worker type only declares [%v] (config setting capabilities)", capability, strings.Join(config.Capabilities, ", ")))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	for _, problem := range problems {
+		task.Log("Task requires " + problem)
+	}
+	return MalformedPayloadError(fmt.Errorf("Worker type %v does not meet the requirements of the task: task requires %v", config.WorkerType, strings.Join(problems, "; ")))
+}
+
+// capabilityFeatures maps the capabilities that task features need to
+// whether the task uses the feature
+var capabilityFeatures = map[string]func(task *TaskRun) bool{
+	"kvm": func(task *TaskRun) bool {
+		return task.Payload.Devices.Kvm
+	},
+	"interactive": func(task *TaskRun) bool {
+		return task.Payload.Features.InteractiveUI
+	},
+}
+
+// requiredCapabilities returns the capabilities that the task requires in
+// payload property requires.capabilities. If the worker config declares
+// capabilities, those needed by task features the task uses are included
+// too, so that the worker type controls which features its tasks may use.
+func (task *TaskRun) requiredCapabilities() []string {
+	capabilities := append([]string{}, task.Payload.Requires.Capabilities...)
+	if config.Capabilities == nil {
+		return capabilities
+	}
+	for capability, used := range capabilityFeatures {
+		if used(task) {
+			capabilities = append(capabilities, capability)
+		}
+	}
+	sort.Strings(capabilities)
+	return capabilities
+}
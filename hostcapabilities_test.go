@@ -0,0 +1,69 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tasks requiring hardware the worker does not have should be resolved as
+// malformed-payload.
+func TestCheckRequirements(t *testing.T) {
+	defer func(h *HostCapabilities) {
+		hostCapabilities = h
+	}(hostCapabilities)
+	defer func(c *Config) { config = c }(config)
+	config = &Config{WorkerType: "test-worker-type"}
+	hostCapabilities = &HostCapabilities{
+		Arch:        "amd64",
+		CPUFeatures: cpuFeatures(map[string]bool{"avx2": true, "svm": true, "fpu": true}),
+		MemoryMB:    16384,
+		GPU:         "NVIDIA Corporation TU104GL [Tesla T4]",
+	}
+	if f := strings.Join(hostCapabilities.CPUFeatures, ","); f != "avx2,virtualization" {
+		t.Fatalf("Unexpected CPU features: %v", f)
+	}
+	task := &TaskRun{}
+	task.Payload.Requires.Arch = "amd64"
+	task.Payload.Requires.CPUFeatures = []string{"virtualization"}
+	task.Payload.Requires.MinMemoryMB = 8192
+	task.Payload.Requires.GPU = "tesla t4"
+	if err := task.checkRequirements(); err != nil {
+		t.Fatalf("Expected requirements to be met, got %v", err)
+	}
+	task.Payload.Requires.CPUFeatures = []string{"avx512f"}
+	task.Payload.Requires.MinMemoryMB = 32768
+	err := task.checkRequirements()
+	if err == nil || err.Reason != "malformed-payload" || !strings.Contains(err.Cause.Error(), "avx512f") || !strings.Contains(err.Cause.Error(), "32768 MB") {
+		t.Fatalf("Expected malformed-payload explaining missing CPU feature and memory, got %v", err)
+	}
+}
+
+// Tasks should be rejected if they require capabilities, explicitly or by
+// using features that need them, that the worker type does not declare.
+func TestCheckCapabilities(t *testing.T) {
+	defer func(h *HostCapabilities) {
+		hostCapabilities = h
+	}(hostCapabilities)
+	hostCapabilities = &HostCapabilities{}
+	defer func(c *Config) { config = c }(config)
+	config = &Config{WorkerType: "test-worker-type"}
+	task := &TaskRun{}
+	task.Payload.Features.InteractiveUI = true
+	if err := task.checkRequirements(); err != nil {
+		t.Fatalf("Features should not need capabilities if the worker type declares none, got %v", err)
+	}
+	task.Payload.Requires.Capabilities = []string{"gpu"}
+	err := task.checkRequirements()
+	if err == nil || err.Reason != "malformed-payload" || !strings.Contains(err.Cause.Error(), "capability gpu") {
+		t.Fatalf("Expected malformed-payload explaining missing capability, got %v", err)
+	}
+	config.Capabilities = []string{"gpu", "loopback-av"}
+	err = task.checkRequirements()
+	if err == nil || !strings.Contains(err.Cause.Error(), "capability interactive") || strings.Contains(err.Cause.Error(), "capability gpu") {
+		t.Fatalf("Expected malformed-payload explaining that feature interactiveUI needs capability interactive, got %v", err)
+	}
+	config.Capabilities = append(config.Capabilities, "interactive")
+	if err := task.checkRequirements(); err != nil {
+		t.Fatalf("Expected declared capabilities to meet requirements, got %v", err)
+	}
+}
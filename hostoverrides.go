@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// the overrides of a task are written to the hosts file between these lines,
+// so that they can be found again if the worker did not get to remove them
+const (
+	hostOverridesStart = "# added by generic-worker for task "
+	hostOverridesEnd   = "# end of generic-worker host overrides"
+)
+
+// workerServiceDomains are the domains of services the worker itself depends
+// on (taskcluster services, and the S3 and Azure storage used for artifacts
+// and task queues), which tasks may not override in the hosts file of the
+// worker, in addition to the host of the queue
+var workerServiceDomains = []string{"taskcluster.net", "amazonaws.com", "windows.net"}
+
+type HostOverridesFeature struct {
+}
+
+type HostOverridesTask struct {
+	task *TaskRun
+}
+
+func (feature *HostOverridesFeature) Name() string {
+	return "Host Overrides"
+}
+
+func (feature *HostOverridesFeature) Dependencies() []string {
+	return nil
+}
+
+// Initialise removes any host overrides left in the hosts file by a task that
+// the worker did not get to stop the feature for, e.g. because it crashed
+func (feature *HostOverridesFeature) Initialise() error {
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	repaired := removeHostOverrides(string(content), "")
+	if repaired == string(content) {
+		return nil
+	}
+	log.Printf("Removing host overrides left in %v by a previous task", hostsFile)
+	return ioutil.WriteFile(hostsFile, []byte(repaired), 0644)
+}
+
+// removeHostOverrides returns the given hosts file content without the host
+// overrides added by HostOverridesTask.Start for the given task, or for any
+// task if taskID is empty
+func removeHostOverrides(content string, taskID string) string {
+	kept := []string{}
+	overrides := false
+	for _, line := range strings.SplitAfter(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case !overrides && (trimmed == hostOverridesStart+taskID || taskID == "" && strings.HasPrefix(trimmed, hostOverridesStart)):
+			overrides = true
+		case overrides && trimmed == hostOverridesEnd:
+			overrides = false
+		case !overrides:
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "")
+}
+
+// addHostOverrides returns the given hosts file content with the host
+// overrides of the task appended
+func (task *TaskRun) addHostOverrides(content string) string {
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		content += "\n"
+	}
+	content += hostOverridesStart + task.TaskID + "\n"
+	for _, override := range task.Payload.HostOverrides {
+		content += override.IP + " " + override.Hostname + "\n"
+	}
+	return content + hostOverridesEnd + "\n"
+}
+
+// isolatedHostOverrides returns true if host overrides only apply to the task
+// commands, rather than the whole worker: on Linux, with config setting
+// sandbox, a hosts file with the overrides is bound over the hosts file in the
+// sandbox (see TaskRun.sandbox)
+func isolatedHostOverrides() bool {
+	return runtime.GOOS == "linux" && config.Sandbox
+}
+
+// validateHostOverrides checks that, if host overrides apply to the whole
+// worker, they do not redirect services the worker itself depends on
+func (task *TaskRun) validateHostOverrides() error {
+	if isolatedHostOverrides() {
+		return nil
+	}
+	protected := workerServiceDomains
+	if Queue != nil {
+		if u, err := url.Parse(Queue.BaseURL); err == nil {
+			protected = append([]string{strings.Split(u.Host, ":")[0]}, protected...)
+		}
+	}
+	for _, override := range task.Payload.HostOverrides {
+		hostname := strings.ToLower(strings.TrimSuffix(override.Hostname, "."))
+		for _, domain := range protected {
+			if hostname == domain || strings.HasSuffix(hostname, "."+domain) {
+				return fmt.Errorf("host override for %v is not allowed, since the worker depends on %v", override.Hostname, domain)
+			}
+		}
+	}
+	return nil
+}
+
+func (feature *HostOverridesFeature) IsEnabled(task *TaskRun) bool {
+	return len(task.Payload.HostOverrides) > 0
+}
+
+// the hosts file can typically only be written with administrative privileges
+func (feature *HostOverridesFeature) CheckSupported() error {
+	if isolatedHostOverrides() {
+		return nil
+	}
+	file, err := os.OpenFile(hostsFile, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("Feature %q is not supported by worker type %v, since the worker cannot write to hosts file %v: %s", feature.Name(), config.WorkerType, hostsFile, err)
+	}
+	return file.Close()
+}
+
+func (feature *HostOverridesFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &HostOverridesTask{
+		task: task,
+	}
+}
+
+// Overriding hostnames may affect all processes on the worker, including the
+// worker itself, so is restricted per worker type
+func (h *HostOverridesTask) RequiredScopes() scopes.Required {
+	return scopes.Required{
+		{"generic-worker:host-overrides:" + config.ProvisionerID + "/" + config.WorkerType},
+	}
+}
+
+// Start appends the overrides to the hosts file of the worker, or, if they
+// only apply to the task commands, to a copy of it for the sandbox
+func (h *HostOverridesTask) Start() error {
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		return err
+	}
+	for _, override := range h.task.Payload.HostOverrides {
+		h.task.Log("Resolving " + override.Hostname + " to " + override.IP + " for the duration of the task")
+	}
+	if !isolatedHostOverrides() {
+		return ioutil.WriteFile(hostsFile, []byte(h.task.addHostOverrides(string(content))), 0644)
+	}
+	file, err := ioutil.TempFile("", "generic-worker-hosts")
+	if err != nil {
+		return err
+	}
+	h.task.hostsFile = file.Name()
+	_, err = file.WriteString(h.task.addHostOverrides(string(content)))
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// Stop removes the overrides of the task from the hosts file, leaving any
+// other changes made to it while the task ran
+func (h *HostOverridesTask) Stop() error {
+	if h.task.hostsFile != "" {
+		return os.Remove(h.task.hostsFile)
+	}
+	content, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(hostsFile, []byte(removeHostOverrides(string(content), h.task.TaskID)), 0644)
+}
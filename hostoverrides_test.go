@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Host overrides should be added to the hosts file while the task runs, and
+// only they should be removed afterwards, leaving any other changes made to
+// the hosts file while the task ran.
+func TestHostOverrides(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-hosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(f string, c *Config) {
+		hostsFile = f
+		config = c
+	}(hostsFile, config)
+	hostsFile = filepath.Join(tempDir, "hosts")
+	config = &Config{WorkerType: "test-worker-type"}
+	original := "127.0.0.1 localhost"
+	err = ioutil.WriteFile(hostsFile, []byte(original), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", logWriter: ioutil.Discard}
+	err = json.Unmarshal([]byte(`{"hostOverrides": [{"hostname": "example.com", "ip": "10.0.0.1"}, {"hostname": "example.org", "ip": "::1"}]}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	feature := &HostOverridesFeature{}
+	if !feature.IsEnabled(task) {
+		t.Fatal("Host overrides feature should be enabled when payload specifies hostOverrides")
+	}
+	if err := feature.CheckSupported(); err != nil {
+		t.Fatalf("Expected host overrides to be supported with a writable hosts file, got %v", err)
+	}
+	taskFeature := feature.NewTaskFeature(task)
+	err = taskFeature.Start()
+	if err != nil {
+		t.Fatalf("Could not start host overrides feature: %v", err)
+	}
+	hosts, err := ioutil.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := original + "\n# added by generic-worker for task KTBKfEgxR5GdfIIREQIvFQ\n10.0.0.1 example.com\n::1 example.org\n# end of generic-worker host overrides\n"
+	if string(hosts) != expected {
+		t.Fatalf("Expected hosts file while task runs to be %q but it is %q", expected, hosts)
+	}
+	// e.g. by the OS, or another tool, while the task runs
+	err = ioutil.WriteFile(hostsFile, []byte(expected+"10.0.0.3 added.concurrently\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = stopTaskFeatures([]TaskFeature{taskFeature})
+	if err != nil {
+		t.Fatalf("Could not stop host overrides feature: %v", err)
+	}
+	hosts, err = ioutil.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := original + "\n10.0.0.3 added.concurrently\n"; string(hosts) != expected {
+		t.Fatalf("Expected only the host overrides of the task to be removed, leaving %q, but hosts file is %q", expected, hosts)
+	}
+
+	// overrides left behind, e.g. by a worker that crashed, are removed when
+	// the worker starts
+	err = ioutil.WriteFile(hostsFile, []byte(expected+"10.0.0.2 added.later\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = feature.Initialise()
+	if err != nil {
+		t.Fatalf("Could not initialise host overrides feature: %v", err)
+	}
+	hosts, err = ioutil.ReadFile(hostsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := original + "\n10.0.0.2 added.later\n"; string(hosts) != expected {
+		t.Fatalf("Expected leftover host overrides to be removed, leaving %q, but hosts file is %q", expected, hosts)
+	}
+}
+
+// Host overrides that apply to the whole worker should not redirect services
+// the worker depends on.
+func TestValidateHostOverrides(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{}
+	for hostname, allowed := range map[string]bool{
+		"example.com":                    true,
+		"nottaskcluster.net":             true,
+		"queue.taskcluster.net":          false,
+		"Auth.Taskcluster.Net.":          false,
+		"bucket.s3.amazonaws.com":        false,
+		"account.queue.core.windows.net": false,
+	} {
+		task := &TaskRun{}
+		err := json.Unmarshal([]byte(`{"hostOverrides": [{"hostname": "`+hostname+`", "ip": "10.0.0.1"}]}`), &task.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = task.validateHostOverrides()
+		if allowed && err != nil {
+			t.Fatalf("Expected host override for %v to be allowed, but got %v", hostname, err)
+		}
+		if !allowed && (err == nil || !strings.Contains(err.Error(), hostname)) {
+			t.Fatalf("Expected host override for %v to be rejected, but got %v", hostname, err)
+		}
+	}
+}
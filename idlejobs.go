@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IdleJob is a maintenance command (such as garbage collecting a cache, or
+// trimming the disk) that the worker runs while it is idle, rather than
+// between tasks, see config setting idleJobs
+type IdleJob struct {
+	// name of the job, used in logs
+	Name string `json:"name"`
+	// the command, as an array of arguments
+	Command []string `json:"command"`
+	// minimum time between the job completing and it running again
+	IntervalSecs int `json:"intervalSecs"`
+	// when several jobs are due, the one with the highest priority runs first
+	Priority int `json:"priority"`
+}
+
+// idleJob is a job registered with an idleJobScheduler
+type idleJob struct {
+	name     string
+	priority int
+	interval time.Duration
+	// run performs the job, and should return promptly once ctx is cancelled
+	run func(ctx context.Context) error
+	// when the job last completed (successfully or not); cancelled runs do
+	// not count, so the job is due again straight away
+	lastRun time.Time
+}
+
+func (job *idleJob) due(now time.Time) bool {
+	return job.lastRun.IsZero() || now.Sub(job.lastRun) >= job.interval
+}
+
+// idleJobScheduler runs registered jobs one at a time, in the background,
+// while the worker is idle. The running job is cancelled as soon as the
+// worker claims a task, so housekeeping never delays a task.
+type idleJobScheduler struct {
+	sync.Mutex
+	jobs    []*idleJob
+	running *idleJob
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// idleJobs are the jobs of config setting idleJobs, registered at startup
+var idleJobs = &idleJobScheduler{}
+
+func (s *idleJobScheduler) register(job *idleJob) {
+	s.Lock()
+	defer s.Unlock()
+	s.jobs = append(s.jobs, job)
+}
+
+// next returns the due job with the highest priority, or nil if no job is
+// due. Jobs of equal priority run in the order they were registered.
+func (s *idleJobScheduler) next(now time.Time) *idleJob {
+	var next *idleJob
+	for _, job := range s.jobs {
+		if job.due(now) && (next == nil || job.priority > next.priority) {
+			next = job
+		}
+	}
+	return next
+}
+
+// startNext starts the next due job in the background, unless a job is
+// already running
+func (s *idleJobScheduler) startNext(now time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	if s.running != nil {
+		return
+	}
+	job := s.next(now)
+	if job == nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.running, s.cancel, s.done = job, cancel, done
+	go func() {
+		defer close(done)
+		log.Printf("Running idle job %v", job.name)
+		started := time.Now()
+		err := job.run(ctx)
+		s.Lock()
+		defer s.Unlock()
+		s.running, s.cancel, s.done = nil, nil, nil
+		cancel()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			log.Printf("Idle job %v cancelled after %v", job.name, time.Since(started))
+			return
+		case err != nil:
+			log.Printf("WARN: idle job %v failed after %v: %s", job.name, time.Since(started), err)
+		default:
+			log.Printf("Idle job %v completed in %v", job.name, time.Since(started))
+		}
+		job.lastRun = time.Now()
+	}()
+}
+
+// stop cancels the running job, if any, and waits for it to finish
+func (s *idleJobScheduler) stop() {
+	s.Lock()
+	cancel, done := s.cancel, s.done
+	if s.running != nil {
+		log.Printf("Cancelling idle job %v", s.running.name)
+	}
+	s.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// validateIdleJobs checks config settings idleJobs and idleJobsAfterSecs
+func (c *Config) validateIdleJobs() error {
+	if c.IdleJobsAfterSecs < 0 {
+		return fmt.Errorf("Config setting idleJobsAfterSecs must not be negative")
+	}
+	names := map[string]bool{}
+	for _, job := range c.IdleJobs {
+		switch {
+		case job.Name == "":
+			return fmt.Errorf("Config setting idleJobs must not contain jobs without a name")
+		case names[job.Name]:
+			return fmt.Errorf("Config setting idleJobs contains more than one job named %q", job.Name)
+		case len(job.Command) == 0:
+			return fmt.Errorf("Config setting idleJobs contains job %q without a command", job.Name)
+		case job.IntervalSecs <= 0:
+			return fmt.Errorf("Config setting idleJobs contains job %q without a positive intervalSecs", job.Name)
+		}
+		names[job.Name] = true
+	}
+	return nil
+}
+
+// registerIdleJobs registers the jobs of config setting idleJobs
+func registerIdleJobs() {
+	for _, job := range config.IdleJobs {
+		idleJobs.register(&idleJob{
+			name:     job.Name,
+			priority: job.Priority,
+			interval: time.Duration(job.IntervalSecs) * time.Second,
+			run:      idleJobCommand(job.Name, job.Command),
+		})
+	}
+}
+
+// idleJobCommand returns a job that runs command, killing it when cancelled.
+// Env var GENERIC_WORKER_IDLE_JOB is set to the name of the job.
+func idleJobCommand(name string, command []string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+		cmd.Env = append(os.Environ(), "GENERIC_WORKER_IDLE_JOB="+name)
+		output, err := cmd.CombinedOutput()
+		if out := strings.TrimSpace(string(output)); out != "" {
+			log.Printf("Output of idle job %v:\n%v", name, out)
+		}
+		return err
+	}
+}
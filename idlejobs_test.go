@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestIdleJobs(t *testing.T) {
+	s := &idleJobScheduler{}
+	ran := make(chan string, 10)
+	blocked := make(chan struct{})
+	s.register(&idleJob{
+		name:     "low",
+		priority: 1,
+		interval: time.Hour,
+		run: func(ctx context.Context) error {
+			ran <- "low"
+			return nil
+		},
+	})
+	s.register(&idleJob{
+		name:     "high",
+		priority: 2,
+		interval: time.Hour,
+		run: func(ctx context.Context) error {
+			ran <- "high"
+			close(blocked)
+			<-ctx.Done()
+			return ctx.Err()
+		},
+	})
+
+	s.startNext(time.Now())
+	<-blocked
+	// a job is already running, so nothing else starts
+	s.startNext(time.Now())
+	s.stop()
+	if job := <-ran; job != "high" {
+		t.Fatalf("Expected job with highest priority to run first, but %v ran", job)
+	}
+	// the cancelled job is still due
+	if job := s.next(time.Now()); job == nil || job.name != "high" {
+		t.Fatalf("Expected cancelled job to still be due, but next job is %v", job)
+	}
+	s.jobs[1].lastRun = time.Now()
+	s.startNext(time.Now())
+	if job := <-ran; job != "low" {
+		t.Fatalf("Expected job low to run, but %v ran", job)
+	}
+	s.stop()
+	if job := s.next(time.Now()); job != nil {
+		t.Fatalf("Expected no job to be due after both ran, but %v is", job.name)
+	}
+	if err := (&Config{IdleJobs: []IdleJob{{Name: "gc", Command: []string{"gc"}}}}).validateIdleJobs(); err == nil {
+		t.Fatal("Expected idle job without intervalSecs to be rejected")
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// activityWriter is an io.Writer that records the time of the most recent
+// write, so that commands which stop producing output can be detected.
+type activityWriter struct {
+	w io.Writer
+	// time of last write, in nanoseconds since the unix epoch - must be
+	// accessed atomically
+	lastWrite int64
+}
+
+func (a *activityWriter) Write(p []byte) (int, error) {
+	a.touch()
+	return a.w.Write(p)
+}
+
+// touch resets the idle time to zero
+func (a *activityWriter) touch() {
+	atomic.StoreInt64(&a.lastWrite, time.Now().UnixNano())
+}
+
+func (a *activityWriter) idleTime() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.lastWrite)))
+}
+
+// commandOutput returns the writer that task commands should write their
+// standard out and standard error to: the task log, via task.activity if the
+// payload specifies maxIdleTime.
+func (task *TaskRun) commandOutput() io.Writer {
+	if task.activity != nil {
+		return task.activity
+	}
+	return task.logWriter
+}
+
+// idleTimeCheck kills command index of the task if it writes no output for
+// task.Payload.MaxIdleTime seconds, see monitorCommand
+func (task *TaskRun) idleTimeCheck(index int) commandCheck {
+	task.activity.touch()
+	maxIdleTime := time.Duration(task.Payload.MaxIdleTime) * time.Second
+	return commandCheck{
+		interval: time.Second,
+		check: func() *CommandExecutionError {
+			if task.activity.idleTime() <= maxIdleTime {
+				return nil
+			}
+			return &CommandExecutionError{
+				Cause:      fmt.Errorf("Command %v produced no output for %v seconds (maxIdleTime)", index, task.Payload.MaxIdleTime),
+				Reason:     "idle-timeout",
+				TaskStatus: Failed,
+			}
+		},
+		snapshot: "maxIdleTime being exceeded",
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// A command that produces no output for maxIdleTime seconds should be killed,
+// even if the worker writes to the task log meanwhile, whereas a command that
+// keeps producing output should run to completion.
+func TestMaxIdleTime(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands use bash")
+	}
+	defer func(c *Config, dir string) {
+		config = c
+		TaskDir = dir
+	}(config, TaskDir)
+	config = &Config{KillGracePeriodSecs: 1}
+	for command, idle := range map[string]bool{
+		`["/bin/bash", "-c", "sleep 5"]`:                                           true,
+		`["/bin/bash", "-c", "for i in 1 2 3 4 5 6; do echo $i; sleep 0.5; done"]`: false,
+	} {
+		tempDir, err := ioutil.TempDir("", "generic-worker-max-idle-time")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDir)
+		TaskDir = tempDir
+		task := newCommandTask(t, `{"command": [`+command+`], "maxRunTime": 60, "maxIdleTime": 1}`, &commandDeadline{
+			at:          time.Now().Add(time.Minute),
+			description: "maxRunTime of 60 seconds",
+		})
+		// for the process snapshot of the idle command
+		task.localArtifactsDir = filepath.Join(tempDir, "artifacts")
+		task.activity = &activityWriter{w: task.logWriter}
+		// lines the worker writes to the task log are not command output
+		done := make(chan bool)
+		go func() {
+			ticker := time.NewTicker(200 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					task.Log("Waiting for command")
+				}
+			}
+		}()
+		execErr := task.ExecuteCommand(0)
+		close(done)
+		killed := execErr != nil && execErr.Reason == "idle-timeout"
+		if killed != idle {
+			t.Fatalf("Expected command %v to be killed for exceeding maxIdleTime: %v, but got: %#v", command, idle, execErr)
+		}
+		_, err = os.Stat(filepath.Join(tempDir, "artifacts", "public", "timeout", "processes.txt"))
+		if snapshot := err == nil; snapshot != idle {
+			t.Fatalf("Expected process snapshot of command %v to be published: %v, but got: %v", command, idle, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// ImageManifest describes the machine image the worker runs on, as written
+// by the image build to config setting imageManifestFile, so that reviewers
+// can verify which image produced release artifacts. Its content is not
+// interpreted by the worker, but would typically include the machine image
+// id, a hash of the image build artifacts, and installed toolchain versions.
+type ImageManifest struct {
+	File    string          `json:"file"`
+	SHA256  string          `json:"sha256"`
+	Content json.RawMessage `json:"content"`
+}
+
+var imageManifest *ImageManifest
+
+// readImageManifest reads the image manifest from the given file, returning
+// nil if the file does not exist
+func readImageManifest(file string) (*ImageManifest, error) {
+	if file == "" {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var content map[string]interface{}
+	err = json.Unmarshal(data, &content)
+	if err != nil {
+		return nil, fmt.Errorf("Image manifest %v is not a json object: %s", file, err)
+	}
+	hash := sha256.Sum256(data)
+	return &ImageManifest{
+		File:    file,
+		SHA256:  hex.EncodeToString(hash[:]),
+		Content: json.RawMessage(data),
+	}, nil
+}
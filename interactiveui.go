@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+var (
+	// workerSession returns the id of the Windows session that the worker
+	// runs in (platform specific)
+	workerSession = workerSessionID
+	// consoleSession returns the id of the session of the user logged on to
+	// the console, and their user name (platform specific)
+	consoleSession = consoleSessionID
+)
+
+type InteractiveUIFeature struct {
+}
+
+type InteractiveUITask struct {
+	task *TaskRun
+}
+
+func (feature *InteractiveUIFeature) Name() string {
+	return "Interactive UI"
+}
+
+func (feature *InteractiveUIFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *InteractiveUIFeature) Initialise() error {
+	return nil
+}
+
+func (feature *InteractiveUIFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.InteractiveUI
+}
+
+// Processes in Session 0 (e.g. Windows services) have no visible desktop, so
+// GUI tests would silently render nowhere. A worker running in Session 0
+// therefore starts task commands in the session of the user logged on to the
+// console instead, so one must be logged on. There are no sessions on other
+// platforms, so the feature is only supported on Windows.
+func (feature *InteractiveUIFeature) CheckSupported() error {
+	session, err := workerSession()
+	if err != nil {
+		return fmt.Errorf("Feature %q is not supported, since the session of the worker could not be determined: %s", feature.Name(), err)
+	}
+	if session == 0 {
+		if _, _, err := consoleSession(); err != nil {
+			return fmt.Errorf("Feature %q is not supported by worker type %v, since the worker runs in Session 0 (as a Windows service), which has no interactive desktop, and task commands cannot be run in the console session instead: %s", feature.Name(), config.WorkerType, err)
+		}
+	}
+	return nil
+}
+
+func (feature *InteractiveUIFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &InteractiveUITask{
+		task: task,
+	}
+}
+
+// RequiredScopes only requires a scope if the worker runs in Session 0, since
+// task commands then run as the user logged on to the console, rather than
+// the task user.
+func (i *InteractiveUITask) RequiredScopes() scopes.Required {
+	if session, err := workerSession(); err == nil && session == 0 {
+		return scopes.Required{
+			{"generic-worker:interactive-ui:" + config.ProvisionerID + "/" + config.WorkerType},
+		}
+	}
+	return scopes.Required{}
+}
+
+// Start arranges for task commands to run in the console session, if the
+// worker runs in Session 0 (see generateCommand)
+func (i *InteractiveUITask) Start() error {
+	session, err := workerSession()
+	if err != nil {
+		return err
+	}
+	if session != 0 {
+		i.task.Log(fmt.Sprintf("Task commands will run in interactive session %v", session))
+		return nil
+	}
+	console, user, err := consoleSession()
+	if err != nil {
+		return err
+	}
+	i.task.interactiveSession = console
+	i.task.Log(fmt.Sprintf("Worker runs in Session 0, so task commands will run in interactive session %v, as user %v logged on to the console", console, user))
+	return nil
+}
+
+func (i *InteractiveUITask) Stop() error {
+	return nil
+}
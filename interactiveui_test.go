@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Tasks requiring an interactive UI should run in the session of the worker
+// if it is interactive, i.e. not Session 0, and otherwise in the console
+// session, and be rejected if there is none.
+func TestInteractiveUI(t *testing.T) {
+	defer func(session func() (uint32, error)) {
+		workerSession = session
+	}(workerSession)
+	defer func(session func() (uint32, string, error)) {
+		consoleSession = session
+	}(consoleSession)
+	consoleSession = func() (uint32, string, error) {
+		return 0, "", errors.New("No session is attached to the console")
+	}
+	defer func(c *Config) { config = c }(config)
+	config = &Config{WorkerType: "test-worker-type"}
+	feature := &InteractiveUIFeature{}
+
+	task := &TaskRun{}
+	if feature.IsEnabled(task) {
+		t.Fatal("Interactive UI should only be enabled when payload specifies features.interactiveUI")
+	}
+	task.Payload.Features.InteractiveUI = true
+	if !feature.IsEnabled(task) {
+		t.Fatal("Interactive UI should be enabled when payload specifies features.interactiveUI")
+	}
+
+	workerSession = func() (uint32, error) {
+		return 0, nil
+	}
+	if err := feature.CheckSupported(); err == nil || !strings.Contains(err.Error(), "Session 0") {
+		t.Fatalf("Interactive UI should not be supported in Session 0 without a console session, but got: %v", err)
+	}
+	workerSession = func() (uint32, error) {
+		return 0, errors.New("Windows sessions are not available")
+	}
+	if err := feature.CheckSupported(); err == nil {
+		t.Fatal("Interactive UI should not be supported when the session of the worker cannot be determined")
+	}
+	workerSession = func() (uint32, error) {
+		return 2, nil
+	}
+	if err := feature.CheckSupported(); err != nil {
+		t.Fatalf("Interactive UI should be supported in an interactive session, but got: %v", err)
+	}
+
+	log := &bytes.Buffer{}
+	task.logWriter = log
+	taskFeature := feature.NewTaskFeature(task)
+	err := taskFeature.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(log.String(), "interactive session 2") {
+		t.Fatalf("Expected task log to mention the session that task commands run in, but got:\n%v", log)
+	}
+	err = taskFeature.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A worker running in Session 0 should run task commands in the session of
+// the user logged on to the console, which requires a scope.
+func TestInteractiveUIConsoleSession(t *testing.T) {
+	defer func(session func() (uint32, error)) {
+		workerSession = session
+	}(workerSession)
+	defer func(session func() (uint32, string, error)) {
+		consoleSession = session
+	}(consoleSession)
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	workerSession = func() (uint32, error) {
+		return 0, nil
+	}
+	consoleSession = func() (uint32, string, error) {
+		return 1, "task_1234", nil
+	}
+	feature := &InteractiveUIFeature{}
+	if err := feature.CheckSupported(); err != nil {
+		t.Fatalf("Interactive UI should be supported in Session 0 when a user is logged on to the console, but got: %v", err)
+	}
+
+	log := &bytes.Buffer{}
+	task := &TaskRun{logWriter: log}
+	taskFeature := feature.NewTaskFeature(task)
+	requiredScopes := taskFeature.RequiredScopes()
+	if len(requiredScopes) != 1 || len(requiredScopes[0]) != 1 || requiredScopes[0][0] != "generic-worker:interactive-ui:test-provisioner/test-worker-type" {
+		t.Fatalf("Unexpected required scopes: %v", requiredScopes)
+	}
+	err := taskFeature.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.interactiveSession != 1 {
+		t.Fatalf("Expected task commands to run in console session 1, but got session %v", task.interactiveSession)
+	}
+	if !strings.Contains(log.String(), "interactive session 1, as user task_1234") {
+		t.Fatalf("Expected task log to mention the session and user that task commands run as, but got:\n%v", log)
+	}
+}
+
+// There are no Windows sessions on other platforms, so the feature should
+// not be supported there.
+func TestInteractiveUINotSupported(t *testing.T) {
+	if _, err := workerSessionID(); err == nil {
+		t.Skip("Worker runs in a Windows session")
+	}
+	if err := (&InteractiveUIFeature{}).CheckSupported(); err == nil {
+		t.Fatal("Interactive UI should not be supported without Windows sessions")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+const kvmDevice = "/dev/kvm"
+
+type KVMFeature struct {
+}
+
+type KVMTask struct {
+	task *TaskRun
+}
+
+func (feature *KVMFeature) Name() string {
+	return "KVM"
+}
+
+func (feature *KVMFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *KVMFeature) Initialise() error {
+	return nil
+}
+
+func (feature *KVMFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Devices.Kvm
+}
+
+func (feature *KVMFeature) CheckSupported() error {
+	if runtime.GOOS != "linux" || !config.KVM {
+		return fmt.Errorf("Task requires device %v, but worker type %v does not provide it", kvmDevice, config.WorkerType)
+	}
+	return nil
+}
+
+func (feature *KVMFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &KVMTask{
+		task: task,
+	}
+}
+
+// Access to /dev/kvm allows a task to consume a lot of resources of the host,
+// so it is restricted per worker type
+func (k *KVMTask) RequiredScopes() scopes.Required {
+	return scopes.Required{
+		{"generic-worker:device:kvm:" + config.ProvisionerID + "/" + config.WorkerType},
+	}
+}
+
+// Start checks that task commands (which run as the same OS user as the
+// worker on Linux) can access the kvm device.
+func (k *KVMTask) Start() error {
+	device, err := os.OpenFile(kvmDevice, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("Worker is configured to provide %v, but it cannot be opened: %s", kvmDevice, err)
+	}
+	k.task.Log("Task has access to " + kvmDevice)
+	return device.Close()
+}
+
+func (k *KVMTask) Stop() error {
+	return nil
+}
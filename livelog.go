@@ -16,12 +16,20 @@ import (
 type LiveLogFeature struct {
 }
 
+func (feature *LiveLogFeature) Name() string {
+	return "LiveLog"
+}
+
+func (feature *LiveLogFeature) Dependencies() []string {
+	return nil
+}
+
 func (feature *LiveLogFeature) Initialise() error {
 	return nil
 }
 
 // livelog is always enabled
-func (feature *LiveLogFeature) IsEnabled(fl EnabledFeatures) bool {
+func (feature *LiveLogFeature) IsEnabled(task *TaskRun) bool {
 	return true
 }
 
@@ -33,6 +41,10 @@ type LiveLogTask struct {
 	task    *TaskRun
 }
 
+func (feature *LiveLogFeature) CheckSupported() error {
+	return nil
+}
+
 func (feature *LiveLogFeature) NewTaskFeature(task *TaskRun) TaskFeature {
 	return &LiveLogTask{
 		task: task,
@@ -52,7 +64,9 @@ func (l *LiveLogTask) Start() error {
 		return nil
 	}
 	l.liveLog = liveLog
+	l.task.lock.Lock()
 	l.task.logWriter = io.MultiWriter(liveLog.LogWriter, l.task.logWriter)
+	l.task.lock.Unlock()
 	err = l.uploadLiveLog()
 	if err != nil {
 		log.Printf("WARN: could not upload livelog: %s", err)
@@ -89,7 +103,7 @@ func (l *LiveLogTask) Stop() error {
 		},
 	)
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
 	}
 	return nil
 }
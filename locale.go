@@ -0,0 +1,75 @@
+package main
+
+import (
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+type LocaleFeature struct {
+}
+
+type LocaleTask struct {
+	task    *TaskRun
+	restore func() error
+}
+
+func (feature *LocaleFeature) Name() string {
+	return "Locale"
+}
+
+func (feature *LocaleFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *LocaleFeature) Initialise() error {
+	return nil
+}
+
+func (feature *LocaleFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Locale.TimeZone != "" || task.Payload.Locale.Language != ""
+}
+
+func (feature *LocaleFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *LocaleFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &LocaleTask{
+		task: task,
+	}
+}
+
+func (l *LocaleTask) RequiredScopes() scopes.Required {
+	// on some platforms the system time zone is changed, which affects other
+	// processes on the worker, so access is restricted
+	return scopes.Required{
+		{
+			"generic-worker:locale:" + config.ProvisionerID + "/" + config.WorkerType,
+		},
+	}
+}
+
+// RequiredScopeSources names the locale settings of the task payload
+func (l *LocaleTask) RequiredScopeSources() map[string][]string {
+	entries := []string{}
+	if l.task.Payload.Locale.TimeZone != "" {
+		entries = append(entries, "locale.timeZone")
+	}
+	if l.task.Payload.Locale.Language != "" {
+		entries = append(entries, "locale.language")
+	}
+	return map[string][]string{
+		"generic-worker:locale:" + config.ProvisionerID + "/" + config.WorkerType: entries,
+	}
+}
+
+// Start applies the time zone and language of the task payload (platform
+// specific, see applyLocale)
+func (l *LocaleTask) Start() (err error) {
+	l.restore, err = l.task.applyLocale()
+	return err
+}
+
+// Stop restores any system settings changed by Start
+func (l *LocaleTask) Stop() error {
+	return l.restore()
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// The locale feature should only be enabled when the payload specifies a
+// time zone or language, and then require a per worker type scope.
+func TestLocaleEnabled(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ProvisionerID: "test-provisioner", WorkerType: "test-worker-type"}
+	feature := &LocaleFeature{}
+	for _, test := range []struct {
+		payload string
+		enabled bool
+	}{
+		{payload: `{}`, enabled: false},
+		{payload: `{"locale": {"timeZone": "Europe/Berlin"}}`, enabled: true},
+		{payload: `{"locale": {"language": "de-DE"}}`, enabled: true},
+	} {
+		task := &TaskRun{}
+		err := json.Unmarshal([]byte(test.payload), &task.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if enabled := feature.IsEnabled(task); enabled != test.enabled {
+			t.Errorf("With payload %v expected locale feature enabled to be %v, but was %v", test.payload, test.enabled, enabled)
+		}
+	}
+	expected := [][]string{{"generic-worker:locale:test-provisioner/test-worker-type"}}
+	if scopes := feature.NewTaskFeature(&TaskRun{}).RequiredScopes(); !reflect.DeepEqual([][]string(scopes), expected) {
+		t.Fatalf("Expected required scopes %v but got %v", expected, scopes)
+	}
+}
+
+// On unix platforms the time zone and language should be set for task
+// commands via env vars.
+func TestLocaleEnvVars(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Locale changes system settings on Windows")
+	}
+	task := &TaskRun{logWriter: ioutil.Discard}
+	err := json.Unmarshal([]byte(`{"locale": {"timeZone": "Europe/Berlin", "language": "de_DE.UTF-8"}}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := (&LocaleFeature{}).NewTaskFeature(task)
+	err = l.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"TZ":     "Europe/Berlin",
+		"LANG":   "de_DE.UTF-8",
+		"LC_ALL": "de_DE.UTF-8",
+	}
+	if !reflect.DeepEqual(task.featureEnv, expected) {
+		t.Fatalf("Expected env vars %v for task commands, but got %v", expected, task.featureEnv)
+	}
+	err = l.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+}
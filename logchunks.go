@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// chunks of the task log are written to this directory, relative to the task
+// directory, which is also the artifact path prefix they are published under
+const logChunksDir = "public/logs/chunks"
+
+type LogChunksFeature struct {
+}
+
+type LogChunksTask struct {
+	task *TaskRun
+	// number of bytes of the task log already published as chunks
+	offset int64
+	chunks int
+	done   chan bool
+	exited chan bool
+}
+
+func (feature *LogChunksFeature) Name() string {
+	return "Log Chunks"
+}
+
+func (feature *LogChunksFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *LogChunksFeature) Initialise() error {
+	return nil
+}
+
+// enabled by the worker config rather than the task payload, since it
+// protects the worker owner's ability to investigate worker failures
+func (feature *LogChunksFeature) IsEnabled(task *TaskRun) bool {
+	return config.LogChunkIntervalSecs > 0
+}
+
+func (feature *LogChunksFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *LogChunksFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &LogChunksTask{
+		task: task,
+	}
+}
+
+func (l *LogChunksTask) RequiredScopes() scopes.Required {
+	return scopes.Required{}
+}
+
+// Start periodically publishes whatever has been written to the task log
+// since the last chunk, so that if the worker dies, most of the log can still
+// be retrieved.
+func (l *LogChunksTask) Start() error {
+	err := os.MkdirAll(filepath.Join(TaskDir, filepath.FromSlash(logChunksDir)), 0777)
+	if err != nil {
+		return err
+	}
+	l.done = make(chan bool)
+	l.exited = make(chan bool)
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.LogChunkIntervalSecs) * time.Second)
+		defer ticker.Stop()
+		defer close(l.exited)
+		for {
+			select {
+			case <-l.done:
+				return
+			case <-ticker.C:
+				err := l.uploadChunk()
+				if err != nil {
+					// best effort, since the full log is uploaded at the end
+					log.Printf("WARN: could not upload task log chunk: %s", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops publishing chunks. There is no need to publish a final chunk,
+// since the complete task log is published when the task completes.
+func (l *LogChunksTask) Stop() error {
+	close(l.done)
+	<-l.exited
+	return nil
+}
+
+func (l *LogChunksTask) uploadChunk() error {
+	logFile, err := os.Open(longPath(filepath.Join(TaskDir, "public", "logs", "live_backing.log")))
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	_, err = logFile.Seek(l.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	data, err := ioutil.ReadAll(logFile)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+	chunkPath := fmt.Sprintf("%v/%06d.log", logChunksDir, l.chunks)
+	err = ioutil.WriteFile(longPath(filepath.Join(TaskDir, filepath.FromSlash(chunkPath))), data, 0644)
+	if err != nil {
+		return err
+	}
+	err = l.task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: chunkPath,
+				Expires:       l.task.Definition.Expires,
+			},
+			MimeType: "text/plain; charset=utf-8",
+		},
+	)
+	if err != nil {
+		return err
+	}
+	l.offset += int64(len(data))
+	l.chunks++
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Marker lines that task commands can write to the task log, to open and
+// close a named log section, e.g.
+//
+//	::group::Run unit tests
+//	...
+//	::endgroup::
+//
+// Sections may be nested. A summary of the sections, with their line numbers
+// and durations, is written at the end of the task log.
+const (
+	sectionStartMarker = "::group::"
+	sectionEndMarker   = "::endgroup::"
+	// only this many bytes at the start of each line are checked for markers
+	maxMarkerLineLength = 256
+)
+
+// logSection is a named section of the task log
+type logSection struct {
+	name  string
+	depth int
+	// line number of the start marker in the task log, counting from 1
+	line     int
+	started  time.Time
+	finished time.Time
+}
+
+// sectionWriter is an io.Writer that passes all writes through to w, and
+// records the log sections that are opened and closed by marker lines.
+type sectionWriter struct {
+	w io.Writer
+	// guards all of the following, since stdout and stderr of commands, and
+	// task.Log, may write concurrently
+	sync.Mutex
+	// start of the current (incomplete) line
+	line []byte
+	// number of complete lines written
+	lines    int
+	open     []*logSection
+	sections []*logSection
+}
+
+func (s *sectionWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	s.Lock()
+	defer s.Unlock()
+	for data := p[:n]; len(data) > 0; {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			s.appendToLine(data)
+			break
+		}
+		s.appendToLine(data[:i])
+		s.lines++
+		s.endLine(string(s.line))
+		s.line = s.line[:0]
+		data = data[i+1:]
+	}
+	return n, err
+}
+
+func (s *sectionWriter) appendToLine(data []byte) {
+	if room := maxMarkerLineLength - len(s.line); room > 0 {
+		if len(data) > room {
+			data = data[:room]
+		}
+		s.line = append(s.line, data...)
+	}
+}
+
+// endLine opens or closes a section if line (line number s.lines) is a
+// marker line
+func (s *sectionWriter) endLine(line string) {
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, sectionStartMarker):
+		section := &logSection{
+			name:    strings.TrimSpace(strings.TrimPrefix(line, sectionStartMarker)),
+			depth:   len(s.open),
+			line:    s.lines,
+			started: time.Now(),
+		}
+		s.open = append(s.open, section)
+		s.sections = append(s.sections, section)
+	case line == sectionEndMarker && len(s.open) > 0:
+		s.open[len(s.open)-1].finished = time.Now()
+		s.open = s.open[:len(s.open)-1]
+	}
+}
+
+// summary returns a description of each log section, in the order they were
+// opened, indented according to their nesting. Sections that are still open
+// are reported as not closed, with their duration until now.
+func (s *sectionWriter) summary() []string {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	lines := make([]string, 0, len(s.sections))
+	for _, section := range s.sections {
+		finished, note := section.finished, ""
+		if finished.IsZero() {
+			finished, note = now, ", not closed"
+		}
+		duration := finished.Sub(section.started)
+		duration -= duration % time.Millisecond
+		lines = append(lines, fmt.Sprintf("%vline %v: %v (%v%v)", strings.Repeat("  ", section.depth+1), section.line, section.name, duration, note))
+	}
+	return lines
+}
+
+// logSectionSummary writes the summary of the log sections (if any) to the
+// task log
+func (task *TaskRun) logSectionSummary() {
+	if task.sections == nil {
+		return
+	}
+	summary := task.sections.summary()
+	if len(summary) == 0 {
+		return
+	}
+	task.Log("=== Log Sections ===")
+	task.Log(strings.Join(summary, "\n"))
+}
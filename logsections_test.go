@@ -0,0 +1,36 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Marker lines should open and close (nested) log sections, even if split
+// across writes, and output should pass through unchanged.
+func TestLogSections(t *testing.T) {
+	var buf bytes.Buffer
+	s := &sectionWriter{w: &buf}
+	output := "setup\n::group::Build\ncompiling\n  ::group::Tests\r\nok\n::endgroup::\n::endgroup::\n::group::Upload\n"
+	for _, chunk := range []string{output[:12], output[12:40], output[40:]} {
+		_, err := s.Write([]byte(chunk))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if buf.String() != output {
+		t.Fatalf("Expected output to be unchanged, but got %q", buf.String())
+	}
+	summary := s.summary()
+	if len(summary) != 3 {
+		t.Fatalf("Expected 3 log sections, but got %q", summary)
+	}
+	for i, expected := range []string{"  line 2: Build (", "    line 4: Tests (", "  line 8: Upload ("} {
+		if !strings.HasPrefix(summary[i], expected) {
+			t.Fatalf("Expected section %v to start %q, but got %q", i, expected, summary[i])
+		}
+	}
+	if !strings.HasSuffix(summary[2], ", not closed)") || strings.Contains(summary[0], "not closed") {
+		t.Fatalf("Expected only last section to be reported as not closed, but got %q", summary)
+	}
+}
@@ -16,11 +16,14 @@ import (
 	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"reflect"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -36,6 +39,10 @@ var (
 	// General platform independent user settings, such as home directory, username...
 	// Platform specific data should be managed in plat_<platform>.go files
 	TaskUser OSUser
+	// Directory that task commands run in, and that artifact paths are
+	// relative to. This is the home directory of TaskUser, unless config
+	// setting tasksDir is set.
+	TaskDir string
 	// Queue is the object we will use for accessing queue api. See
 	// https://docs.taskcluster.net/reference/platform/queue/api-docs
 	Queue *queue.Queue
@@ -62,7 +69,26 @@ var (
 	Features           []Feature = []Feature{
 		&LiveLogFeature{},
 		&ChainOfTrustFeature{},
+		&ProgressReportingFeature{},
+		&CrashDumpsFeature{},
+		&KVMFeature{},
+		&UnconfinedFeature{},
+		&NetworkIsolationFeature{},
+		&HostOverridesFeature{},
+		&LogChunksFeature{},
+		&PerformanceCountersFeature{},
+		&PerfFeature{},
+		&InteractiveUIFeature{},
+		&LocaleFeature{},
+		&TimeBudgetFeature{},
+		&EnvironmentCaptureFeature{},
+		&SSHCertificateFeature{},
+		&CleanupFeature{},
+		&HostArtifactsFeature{},
 	}
+	// http client used for uploading artifacts, which respects any TLS
+	// settings in the config
+	httpClient = &http.Client{}
 
 	version = "5.3.1"
 	usage   = `
@@ -81,7 +107,13 @@ and reports back results to the queue.
                                             [--username       USERNAME]
                                             [--password       PASSWORD]
     generic-worker show-payload-schema
+    generic-worker run-task                 --payload        PAYLOAD-FILE --no-queue
+                                            [--artifacts-dir ARTIFACTS-DIR]
     generic-worker new-openpgp-keypair      --file PRIVATE-KEY-FILE
+    generic-worker list-task-dirs           [--config         CONFIG-FILE]
+    generic-worker new-config               [--config         CONFIG-FILE]
+    generic-worker supervise                [--config         CONFIG-FILE]
+                                            [--configure-for-aws]
     generic-worker --help
     generic-worker --version
 
@@ -98,10 +130,36 @@ and reports back results to the queue.
                                             does not already exist on the system, the user
                                             will be created. This user will be used to run
                                             the service.
+    run-task                                Runs a single task locally, in the current
+                                            directory, without talking to the
+                                            taskcluster queue. The task payload is read
+                                            from PAYLOAD-FILE, and artifacts are copied
+                                            to ARTIFACTS-DIR instead of being uploaded.
+                                            Task features are not supported. Useful for
+                                            iterating on a task payload.
     new-openpgp-keypair                     This will generate a fresh, new OpenPGP
                                             compliant private/public key pair. The public
                                             key will be written to stdout and the private
                                             key will be written to the specified file.
+    list-task-dirs                          Lists the task directories of failed tasks that
+                                            have been kept on the worker for debugging (see
+                                            config setting keepTaskDirs).
+    new-config                              Asks for the required config settings on
+                                            standard in, and writes them to CONFIG-FILE.
+                                            The config is then validated, the credentials
+                                            are checked to have the scopes the worker
+                                            needs, and the tasks directory (if given) is
+                                            checked to be writable.
+    supervise                               Runs the generic-worker (as with target run) in
+                                            a child process, restarting it if it crashes.
+                                            The output of each crashed run is saved in
+                                            directory crash-logs next to CONFIG-FILE, and
+                                            reported to the notificationWebhooks. If the
+                                            worker crashes crashLoopRestarts times within
+                                            crashLoopWindowSecs, the host is quarantined
+                                            (the worker runs, but claims no tasks) until
+                                            file quarantine.json next to CONFIG-FILE is
+                                            deleted. Exits if the config is invalid.
 
   Options:
     --config CONFIG-FILE                    Json configuration file to use. See
@@ -130,6 +188,13 @@ and reports back results to the queue.
                                             to. The parent directory must already exist.
                                             If the file exists it will be overwritten,
                                             otherwise it will be created.
+    --payload PAYLOAD-FILE                  Json file containing the task payload to run.
+    --no-queue                              Run the task without claiming it from, or
+                                            reporting results to, the taskcluster queue.
+                                            This is currently the only mode supported by
+                                            run-task.
+    --artifacts-dir ARTIFACTS-DIR           The directory to copy task artifacts to when
+                                            running a task locally. [default: artifacts]
     --help                                  Display this help text.
     --version                               The release version of the generic-worker.
 
@@ -183,6 +248,12 @@ and reports back results to the queue.
                                             over https. If not set, http will be used.
           usersDir                          The location where user home directories should be
                                             created on the worker. [default: C:\Users]
+          tasksDir                          The location where task directories should be
+                                            created on the worker, e.g. on a separate volume
+                                            from usersDir. If not set, task directories are
+                                            the home directories of the task users (Windows)
+                                            or the current directory of the worker
+                                            (Linux/macOS).
           cleanUpTaskDirs                   Whether to delete the home directories of the task
                                             users after the task completes. Normally you would
                                             want to do this to avoid filling up disk space,
@@ -194,6 +265,11 @@ and reports back results to the queue.
                                             An integer, >= 0. A value of 0 means "do not shut
                                             the computer down" - i.e. continue running
                                             indefinitely.
+          teardownMaxRunTimeSecs            How long the teardown commands of a task may
+                                            run in total, unless the task payload sets
+                                            teardownMaxRunTime. Teardown commands run even
+                                            if the task exceeded maxRunTime, was cancelled,
+                                            or the worker is shutting down. [default: 300]
           workerTypeMetaData                This arbitrary json blob will be uploaded as an
                                             artifact called worker_type_metadata.json with each
                                             task. Providing information here, such as a URL to
@@ -201,13 +277,386 @@ and reports back results to the queue.
                                             mean that people running tasks on the worker type
                                             will have more information about how it was set up
                                             (for example what has been installed on the
-                                            machine).
+                                            machine). Details identifying the worker
+                                            (provisionerId, workerType, workerGroup, workerId
+                                            and deploymentId) are added under the key
+                                            "worker".
           signingKeyLocation                The PGP signing key for signing artifacts with.
                                             If not set, tasks will not be signed.
           runTasksAsCurrentUser             If true, users will not be created for tasks, but
                                             the current OS user will be used. Useful if not an
                                             administrator, e.g. when running tests. Should not
                                             be used in production! [default: false]
+          caCertificatesFile                A file containing PEM encoded CA certificates to
+                                            trust, in addition to the system root
+                                            certificates, when transferring artifacts. Useful
+                                            if artifacts are stored on servers using a private
+                                            PKI. Not supported on Windows, where the system
+                                            root certificates cannot be loaded.
+          clientCertificateFile             A file containing a PEM encoded client certificate
+                                            to present when transferring artifacts, for
+                                            servers requiring mutual TLS. Requires
+                                            clientKeyFile to also be set.
+          clientKeyFile                     A file containing the PEM encoded private key for
+                                            clientCertificateFile, which requires it to also
+                                            be set.
+          quarantineAfterErrors             The number of consecutive internal worker errors
+                                            (such as failing to create a task user, or a
+                                            task resolving as exception with reason
+                                            internal-error) after which the worker is
+                                            quarantined, and stops claiming tasks. A value
+                                            of 0 means the worker is never quarantined.
+                                            [default: 0]
+          deploymentId                      An arbitrary identifier for the deployment (for
+                                            example, the version of the worker type
+                                            definition) that this worker was created from.
+                                            Included in worker_type_metadata.json, so that
+                                            task failures can be correlated with changes to
+                                            the worker fleet.
+          artifactUploadBytesPerSec         The maximum rate, in bytes per second, at which
+                                            each artifact is uploaded. A value of 0 means
+                                            uploads are not throttled. [default: 0]
+          kvm                               If true, tasks may request access to /dev/kvm
+                                            (Linux only) via "devices": {"kvm": true} in
+                                            the task payload. The worker must be able to
+                                            open /dev/kvm for reading and writing. Tasks
+                                            also require scope
+                                            generic-worker:device:kvm:<provisionerId>/<workerType>.
+                                            [default: false]
+          killGracePeriodSecs               When a command is aborted (e.g. due to maxRunTime,
+                                            maxIdleTime or maxTaskDiskUsage), how many seconds its
+                                            processes are given to exit after being sent
+                                            SIGTERM (CTRL_BREAK on Windows), before they
+                                            are killed. This also applies when the task is
+                                            cancelled, or the worker is shutting down.
+                                            [default: 10]
+          maintenanceWindows                Daily periods of time, in UTC, during which the
+                                            worker finishes any task it is running, but does
+                                            not claim new tasks, e.g. ["02:00-04:30"]. A
+                                            window may span midnight, e.g. "23:00-01:00".
+                                            The worker is not considered idle (see
+                                            idleShutdownTimeoutSecs) during these windows.
+          maxPayloadBytes                   The maximum size, in bytes, of task payloads. Tasks
+                                            with larger payloads are resolved as
+                                            exception/malformed-payload. A value of 0 means
+                                            no limit. [default: 0]
+          maxTaskCommands                   The maximum number of commands (including
+                                            teardown commands) a task may have. Tasks with
+                                            more are resolved as
+                                            exception/malformed-payload. A value of 0 means
+                                            no limit. [default: 0]
+          appArmorProfile                   The name of an AppArmor profile (already loaded on
+                                            the worker) to confine task commands with, via
+                                            aa-exec (Linux only). Tasks may opt out with
+                                            "features": {"unconfined": true} in the task
+                                            payload, which requires scope
+                                            generic-worker:unconfined:<provisionerId>/<workerType>.
+          sandbox                           If true, task commands are run in a bubblewrap
+                                            (bwrap) sandbox (Linux only), in which the file
+                                            system is read only except for the task
+                                            directory, /tmp and the home directory are
+                                            private and empty, and the worker config file
+                                            and key files are hidden. [default: false]
+          umask                             The file mode creation mask (in octal, e.g.
+                                            "0027") of the worker and the task commands it
+                                            runs, which determines the permissions of files
+                                            and directories they create, e.g. to comply with
+                                            a hardened image policy (Linux/macOS only). If
+                                            not set, the umask the worker was started with
+                                            is used.
+          workerIdStrategy                  How the worker id is determined, overriding
+                                            workerId: "instance-id" (requires
+                                            --configure-for-aws), "hostname", "mac" (the
+                                            hardware address of the first network
+                                            interface), or "random" (generated once, and
+                                            persisted in worker-id.json alongside the config
+                                            file, but regenerated if that file was created
+                                            on a different machine, e.g. due to a cloned
+                                            disk image). Characters that are not valid in a
+                                            worker id are replaced with a hyphen.
+          maxClockSkewSecs                  If greater than 0, the worker compares its clock
+                                            with the clock of the queue every 10 minutes, and
+                                            does not claim tasks while they differ by more
+                                            than this many seconds, since clock skew breaks
+                                            signed urls and chain of trust timestamps. The
+                                            measured skew is written to the task log.
+                                            [default: 0]
+          logChunkIntervalSecs              If greater than 0, whatever has been written to
+                                            the task log since the previous chunk is
+                                            published every this many seconds, as artifacts
+                                            public/logs/chunks/000000.log, 000001.log, etc, so
+                                            that most of the log can be retrieved even if the
+                                            worker dies before the task completes.
+                                            [default: 0]
+          infraRetries                      The number of times a task is re-run on the
+                                            worker if it hits an infrastructure exception
+                                            (reason internal-error or resource-unavailable)
+                                            before it could be resolved, for example if a
+                                            task feature could not be started. The logs of
+                                            failed attempts are published as
+                                            public/logs/attempt_<n>.log. [default: 0]
+          keepTaskDirs                      The number of task directories of failed tasks
+                                            to keep on the worker, rather than delete, so
+                                            that failures can be investigated on the worker.
+                                            The most recent ones are kept. Only applies if
+                                            each task has its own task directory (Windows,
+                                            when not running tasks as the current user).
+                                            See also target list-task-dirs. [default: 0]
+          notificationWebhooks              URLs that a json description of each task event
+                                            (task-started or task-resolved) is posted to,
+                                            including task and worker ids, and for resolved
+                                            tasks, the status and reason. The property
+                                            "text" contains a human readable summary, so
+                                            that e.g. Slack incoming webhooks can be used
+                                            directly. Delivery is best effort.
+          artifactArchiveDir                If set, a local (or network mounted) directory
+                                            that uploaded artifacts are also copied to, as
+                                            <taskId>/<runId>/<artifact name>, for data
+                                            retention independent of artifact expiry. The
+                                            task definition, worker details and the hashes
+                                            (see checksumAlgorithms), size and expiry of the
+                                            archived artifacts are written to
+                                            <taskId>/<runId>.json. A failure to archive an
+                                            artifact is treated like a failure to upload it.
+          artifactArchivePatterns           If set, only artifacts with names matching one of
+                                            these patterns (e.g. "public/build/*.zip", with
+                                            the syntax of go's path.Match) are copied to
+                                            artifactArchiveDir.
+          artifactPrefix                    A prefix for the names of the artifacts listed in
+                                            task payloads, for tasks that do not specify
+                                            payload property artifactPrefix, e.g.
+                                            "public/build/${platform}/". May contain
+                                            ${platform}, ${arch}, ${provisionerId},
+                                            ${workerType} and ${workerGroup}. A trailing "/"
+                                            is implied if missing. Does not apply to logs or
+                                            other artifacts published by the worker.
+          perf                              If true, tasks may request that their commands are
+                                            profiled with perf (Linux only) via "perf" in the
+                                            task payload. Tasks also require scope
+                                            generic-worker:perf:<provisionerId>/<workerType>.
+                                            [default: false]
+          postTaskCheckCommands             Commands (each an array of arguments) that are run
+                                            after each task, to verify that the task has
+                                            not left the machine in a dirty state, e.g.
+                                            [["/usr/local/bin/check-no-stray-processes"]].
+                                            A command failing (exiting non-zero) is handled
+                                            according to postTaskCheckFailure.
+          postTaskCheckFailure              What to do if a post task verification command
+                                            fails: "quarantine" (stop claiming tasks) or
+                                            "reboot" (reboot the machine).
+                                            [default: quarantine]
+          startupHooks                      Commands (each an array of arguments) that are run
+                                            when the worker starts, before it claims any
+                                            tasks, for site specific needs such as license
+                                            server check-ins. Env var GENERIC_WORKER_HOOK is
+                                            set to "startup". Output and failures are written
+                                            to the worker log, but do not stop the worker.
+          preTaskHooks                      Like startupHooks, but run before each task, with
+                                            GENERIC_WORKER_HOOK set to "pre-task" and env var
+                                            TASK_ID set.
+          postTaskHooks                     Like startupHooks, but run after each task has
+                                            been resolved, with GENERIC_WORKER_HOOK set to
+                                            "post-task" and env var TASK_ID set.
+          shutdownHooks                     Like startupHooks, but run when the worker shuts
+                                            down, with GENERIC_WORKER_HOOK set to
+                                            "shutdown".
+          hookTimeoutSecs                   How many seconds each hook command may run for
+                                            before it is killed. [default: 300]
+          statusPort                        If greater than 0, the worker serves its status as
+                                            json at http://127.0.0.1:<statusPort>/status,
+                                            for tray tools and fleet dashboards: its state
+                                            (idle, maintenance, quarantined or running-task),
+                                            the reason it is quarantined (if it is), the
+                                            number of tasks run, and the id, current phase
+                                            and number of uploaded artifacts of the task it
+                                            is running. [default: 0]
+          sshCertificateAuthorityKey        Path to the private key (in PEM format) of an SSH
+                                            certificate authority. If set, tasks may request
+                                            short-lived SSH credentials via "sshCertificate"
+                                            in the task payload, which are signed with this
+                                            key. Tasks also require scope
+                                            generic-worker:ssh-certificate:<provisionerId>/<workerType>/<principal>
+                                            for each principal requested. Tasks must not be
+                                            able to read the key: on Linux config setting
+                                            sandbox must be true (the key is hidden in the
+                                            sandbox), and on Windows the key must only be
+                                            readable by the worker user. SSH credentials are
+                                            not supported on macOS.
+          artifactStorage                   Where the content of artifacts is stored: "queue"
+                                            (the S3 buckets of the queue), "directory" (a
+                                            local or network mounted directory, see
+                                            artifactStorageDir) or "http" (uploaded with
+                                            HTTP PUT requests, e.g. to a WebDAV server).
+                                            Artifacts not stored by the queue are published
+                                            as redirect artifacts to their location under
+                                            artifactStorageURL, at
+                                            <taskId>/<runId>/<name>. [default: queue]
+          artifactStorageDir                The directory artifacts are copied to, if
+                                            artifactStorage is "directory".
+          artifactStorageURL                The url that artifacts are downloaded from (and,
+                                            if artifactStorage is "http", uploaded to).
+                                            Required if artifactStorage is "directory"
+                                            or "http".
+          imageManifestFile                 A json file describing the machine image the
+                                            worker runs on, written when the image is
+                                            built, e.g. containing the AMI id, a hash of
+                                            the image build artifacts, and the versions of
+                                            installed toolchains. If the file exists, its
+                                            content and sha256 hash are included in the
+                                            worker metadata written to each task log, and
+                                            in the chain of trust certificate.
+                                            [default: /etc/generic-worker/image-manifest.json
+                                            on Linux and macOS, and
+                                            C:\generic-worker\image-manifest.json on Windows]
+          antivirusExclusions               If true, the directory that task directories
+                                            are created in (tasksDir if set, otherwise
+                                            usersDir) is added as a Windows Defender
+                                            exclusion when the worker starts, since
+                                            scanning files as they are written slows tasks
+                                            down considerably. Caches and downloads are
+                                            still scanned. The exclusion is written to the
+                                            worker log (Windows only).
+                                            [default: false]
+          environmentProbes                 Commands (each an array of arguments) whose output
+                                            is published in artifact public/env/tools.txt by
+                                            tasks that enable feature environmentCapture,
+                                            e.g. [["go", "version"], ["python", "--version"]].
+          reservedDiskSpaceMB               Megabytes of free disk space that are reserved for
+                                            the worker itself, e.g. for log files and
+                                            staging compressed artifacts. If the free space
+                                            of the task directory volume drops below this
+                                            while a command runs, the command is killed and
+                                            the task resolved as
+                                            exception/resource-unavailable, rather than the
+                                            worker running out of space. Uploading an
+                                            artifact fails if compressing it would use
+                                            reserved space.
+                                            [default: 0]
+          reservedMemoryMB                  Megabytes of memory that are reserved for the
+                                            worker itself. If less memory than this is
+                                            available while a command runs, the command is
+                                            killed and the task resolved as
+                                            exception/resource-unavailable. [default: 0]
+          eventHandlers                     Commands (each an array of arguments) that are
+                                            started with the worker, and are sent task
+                                            events on standard in, one json object per line,
+                                            so that sites can extend the worker (e.g. with
+                                            audit logging) without patching it. Each event
+                                            has properties "version" (of the protocol,
+                                            currently 1), "event", "time", "taskId" and
+                                            "runId". Events are "task-start", "command-exit"
+                                            (with "commandIndex" and "exitCode") and
+                                            "task-end" (with "status" and, if applicable,
+                                            "reason"). New events and properties may be
+                                            added without changing the version, so handlers
+                                            should ignore those they do not know. A handler
+                                            that exits is restarted for the next event.
+          retryPolicies                     How http requests that fail intermittently are
+                                            retried, keyed by operation: "artifactUpload",
+                                            "notification" and "queue" (deleting messages
+                                            from Azure queues). Calls to the queue service
+                                            are retried by the taskcluster client itself,
+                                            so are not covered. Each policy has
+                                            properties "maxAttempts", "initialIntervalSecs"
+                                            (wait before the second attempt),
+                                            "maxIntervalSecs", "multiplier" (growth of the
+                                            wait after each attempt) and "timeoutSecs"
+                                            (maximum duration of each attempt).
+                                            Properties not set, and operations
+                                            not listed, use the defaults. Failures with an
+                                            http 4xx status are not retried.
+                                            [default: artifactUpload 10 attempts, 1s to 60s;
+                                            notification 5 attempts, 1s to 30s, 30s timeout;
+                                            queue 3 attempts, 1s to 30s; all with
+                                            multiplier 2]
+          crashLoopRestarts                 The number of times the worker may crash within
+                                            crashLoopWindowSecs, when run with target
+                                            supervise, before the host is quarantined. A
+                                            value of 0 means the host is never quarantined.
+                                            [default: 5]
+          crashLoopWindowSecs               The window, in seconds, that crashLoopRestarts
+                                            counts crashes in. Once the host is quarantined,
+                                            the worker is restarted after this long, rather
+                                            than straight away. [default: 600]
+          queueCallsPerMinute               The maximum average number of calls per minute
+                                            that the worker makes to the queue. Calls over
+                                            the limit wait, with some random jitter, so
+                                            that many workers started at the same time do
+                                            not overload the queue. A value of 0 means
+                                            calls are not limited. [default: 600]
+          queueCallBurst                    The number of calls to the queue that can be
+                                            made in quick succession, before
+                                            queueCallsPerMinute applies. [default: 20]
+          queueStartupJitterSecs            The maximum random delay, in seconds, before
+                                            the first call to the queue, to spread out the
+                                            calls of workers started at the same time.
+                                            [default: 0]
+          checksumAlgorithms                The hash algorithms used for the checksums of
+                                            artifacts, published in artifact
+                                            public/logs/artifact_checksums.json and the
+                                            chain of trust certificate. Supported
+                                            algorithms are "sha256" and "sha512". Must
+                                            include "sha256" if signingKeyLocation is set,
+                                            since chain of trust verifiers require it.
+                                            [default: ["sha256"]]
+          fipsMode                          If true, the worker refuses to start unless the
+                                            operating system runs in FIPS mode (on Linux
+                                            /proc/sys/crypto/fips_enabled, on Windows the
+                                            FipsAlgorithmPolicy security policy), and
+                                            verifies artifact uploads by size rather than
+                                            md5 hash. This does not make the worker FIPS
+                                            140 compliant: its own cryptography (e.g. TLS
+                                            and chain of trust signatures) is not
+                                            provided by a FIPS validated module.
+                                            [default: false]
+          taskLogBanner                     Text written at the top of every task log, e.g.
+                                            with links to the policies of the worker pool,
+                                            and how to contact its owners. Template
+                                            variables ${platform}, ${arch},
+                                            ${provisionerId}, ${workerType},
+                                            ${workerGroup}, ${workerId}, ${deploymentId} and
+                                            ${version} (of generic-worker) are substituted,
+                                            as is ${image.<key>}, with the top level
+                                            property <key> of the image manifest (see
+                                            imageManifestFile).
+          taskLogNoticesURL                 A url serving plain text notices for task
+                                            owners (e.g. "this worker pool is being
+                                            deprecated"), which are written to the top of
+                                            every task log, one line per notice. The
+                                            notices are fetched at most every 5 minutes. If
+                                            they cannot be fetched, the notices fetched last
+                                            time are used. A response with http status 404
+                                            means there are no notices.
+          hostArtifactDirs                  Absolute directories on the worker that payload
+                                            artifacts may be published from with property
+                                            hostPath, e.g. directories of crash dumps or
+                                            event logs. This also requires scope
+                                            generic-worker:host-artifacts:<provisionerId>/<workerType>.
+                                            [default: none]
+          idleJobs                          Maintenance jobs that the worker runs, one at a
+                                            time, while it is idle, e.g. garbage collecting
+                                            a cache or trimming the disk:
+                                            [{"name": "trim", "command": ["fstrim", "-a"],
+                                            "intervalSecs": 86400, "priority": 1}]. When
+                                            several jobs are due, the one with the highest
+                                            priority runs first. A running job is killed
+                                            when the worker claims a task, and runs again
+                                            next time the worker is idle. Env var
+                                            GENERIC_WORKER_IDLE_JOB is set to the job name.
+                                            [default: none]
+          idleJobsAfterSecs                 How long the worker must have been idle before
+                                            it starts idle jobs. [default: 60]
+          capabilities                      Capabilities that the worker type provides, e.g.
+                                            ["gpu", "docker", "loopback-av"], which are
+                                            published in worker type metadata. Tasks list
+                                            the capabilities they need in payload property
+                                            requires.capabilities, and are resolved as
+                                            exception/malformed-payload if the worker does
+                                            not declare all of them. If set, tasks that
+                                            request /dev/kvm (devices.kvm) or an
+                                            interactive desktop (features.interactiveUI)
+                                            also need capability kvm or interactive
+                                            respectively. [default: not set]
 
     Here is an syntactically valid example configuration file:
 
@@ -255,9 +704,25 @@ func main() {
 			fmt.Printf("%v\n", err)
 			os.Exit(64)
 		}
-		runWorker()
-		forever := make(chan bool)
-		<-forever
+		done := runWorker()
+		// when asked to stop (e.g. by supervise, or the service manager),
+		// abort the running task, so that its commands are killed in the same
+		// way as when it exceeds maxRunTime (see Command.kill), and it is
+		// resolved as worker-shutdown before the worker exits
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		sig := <-signals
+		log.Printf("Received %v, shutting down worker", sig)
+		abortTasks(WorkerShutdown(fmt.Errorf("Worker received %v", sig)))
+		done <- true
+		<-done
+	case arguments["run-task"]:
+		status, err := runTaskLocally(arguments["--payload"].(string), arguments["--artifacts-dir"].(string))
+		fmt.Printf("Task resolved as %v\n", status)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(67)
+		}
 	case arguments["install"]:
 		// platform specific...
 		err := install(arguments)
@@ -266,6 +731,30 @@ func main() {
 			fmt.Printf("%#v\n", err)
 			os.Exit(65)
 		}
+	case arguments["list-task-dirs"]:
+		configFile = arguments["--config"].(string)
+		err := listTaskDirs(os.Stdout)
+		if err != nil {
+			fmt.Println("Error listing kept task directories:")
+			fmt.Printf("%v\n", err)
+			os.Exit(68)
+		}
+	case arguments["new-config"]:
+		configFile = arguments["--config"].(string)
+		err := newConfig(configFile, os.Stdin, os.Stdout)
+		if err != nil {
+			fmt.Println("Error generating configuration:")
+			fmt.Printf("%v\n", err)
+			os.Exit(69)
+		}
+	case arguments["supervise"]:
+		configFile = arguments["--config"].(string)
+		err := supervise(configFile, arguments["--configure-for-aws"].(bool))
+		if err != nil {
+			fmt.Println("Error supervising generic worker:")
+			fmt.Printf("%v\n", err)
+			os.Exit(70)
+		}
 	case arguments["new-openpgp-keypair"]:
 		err := generateOpenPGPKeypair(arguments["--file"].(string))
 		if err != nil {
@@ -295,12 +784,22 @@ func loadConfig(filename string, queryUserData bool) (*Config, error) {
 		ProvisionerID:              "aws-provisioner-v1",
 		LiveLogExecutable:          "livelog",
 		RefreshUrlsPrematurelySecs: 310,
+		TeardownMaxRunTimeSecs:     300,
 		UsersDir:                   "C:\\Users",
 		CachesDir:                  "C:\\generic-worker\\caches",
 		DownloadsDir:               "C:\\generic-worker\\downloads",
 		CleanUpTaskDirs:            true,
 		RunTasksAsCurrentUser:      false,
 		IdleShutdownTimeoutSecs:    0,
+		KillGracePeriodSecs:        10,
+		HookTimeoutSecs:            300,
+		ImageManifestFile:          defaultImageManifestFile,
+		CrashLoopRestarts:          5,
+		CrashLoopWindowSecs:        600,
+		QueueCallsPerMinute:        600,
+		QueueCallBurst:             20,
+		ChecksumAlgorithms:         []string{"sha256"},
+		IdleJobsAfterSecs:          60,
 		WorkerTypeMetadata: map[string]interface{}{
 			"generic-worker": map[string]string{
 				"go-arch":    runtime.GOARCH,
@@ -327,6 +826,11 @@ func loadConfig(filename string, queryUserData bool) (*Config, error) {
 		c.updateConfigWithAmazonSettings()
 	}
 
+	err = c.applyWorkerIDStrategy(workerIDFile(filename))
+	if err != nil {
+		return c, err
+	}
+
 	// now check all required values are set
 	// TODO: could probably do this with reflection to avoid explicitly listing
 	// all members
@@ -355,12 +859,106 @@ func loadConfig(filename string, queryUserData bool) (*Config, error) {
 			return c, MissingConfigError{Setting: f.name, File: filename}
 		}
 	}
+	if c.TeardownMaxRunTimeSecs < 1 {
+		return c, fmt.Errorf("Config setting teardownMaxRunTimeSecs must be at least 1, but is %v", c.TeardownMaxRunTimeSecs)
+	}
+	if (c.ClientCertificateFile == "") != (c.ClientKeyFile == "") {
+		return c, errors.New("Config settings clientCertificateFile and clientKeyFile must either both be set, or neither")
+	}
+	if c.Sandbox && runtime.GOOS != "linux" {
+		return c, fmt.Errorf("Config setting sandbox is only supported on Linux, not %v", runtime.GOOS)
+	}
+	if c.AntivirusExclusions && runtime.GOOS != "windows" {
+		return c, fmt.Errorf("Config setting antivirusExclusions is only supported on Windows, not %v", runtime.GOOS)
+	}
+	if c.Umask != "" {
+		if runtime.GOOS == "windows" {
+			return c, errors.New("Config setting umask is not supported on Windows")
+		}
+		_, err := parseUmask(c.Umask)
+		if err != nil {
+			return c, err
+		}
+	}
+	for setting, commands := range map[string][][]string{
+		"postTaskCheckCommands": c.PostTaskCheckCommands,
+		"startupHooks":          c.StartupHooks,
+		"preTaskHooks":          c.PreTaskHooks,
+		"postTaskHooks":         c.PostTaskHooks,
+		"shutdownHooks":         c.ShutdownHooks,
+		"environmentProbes":     c.EnvironmentProbes,
+		"eventHandlers":         c.EventHandlers,
+	} {
+		for _, command := range commands {
+			if len(command) == 0 {
+				return c, fmt.Errorf("Config setting %v must not contain empty commands", setting)
+			}
+		}
+	}
+	switch c.PostTaskCheckFailure {
+	case "", "quarantine", "reboot":
+	default:
+		return c, fmt.Errorf("Config setting postTaskCheckFailure must be \"quarantine\" or \"reboot\", not %q", c.PostTaskCheckFailure)
+	}
+	switch c.ArtifactStorage {
+	case "", "queue":
+	case "directory":
+		if c.ArtifactStorageDir == "" {
+			return c, MissingConfigError{Setting: "artifactStorageDir", File: filename}
+		}
+		fallthrough
+	case "http":
+		if c.ArtifactStorageURL == "" {
+			return c, MissingConfigError{Setting: "artifactStorageURL", File: filename}
+		}
+	default:
+		return c, fmt.Errorf("Config setting artifactStorage must be \"queue\", \"directory\" or \"http\", not %q", c.ArtifactStorage)
+	}
+	for _, pattern := range c.ArtifactArchivePatterns {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return c, fmt.Errorf("Config setting artifactArchivePatterns contains invalid pattern %q: %s", pattern, err)
+		}
+	}
+	err = c.FaultInjection.validate()
+	if err != nil {
+		return c, err
+	}
+	err = validateRetryPolicies(c.RetryPolicies)
+	if err != nil {
+		return c, err
+	}
+	err = c.validateQueueRateLimit()
+	if err != nil {
+		return c, err
+	}
+	err = c.validateChecksums()
+	if err != nil {
+		return c, err
+	}
+	err = c.validateTaskLogBanner()
+	if err != nil {
+		return c, err
+	}
+	err = c.validateIdleJobs()
+	if err != nil {
+		return c, err
+	}
+	for _, window := range c.MaintenanceWindows {
+		_, err := parseMaintenanceWindow(window)
+		if err != nil {
+			return c, err
+		}
+	}
 	// all required config set!
 	return c, nil
 }
 
 // returns a channel that you can send 'true' to, to shut it down
-func runWorker() chan<- bool {
+// runWorker starts claiming and running tasks. Send to the returned channel to
+// stop the worker once it has finished the task it is running; the channel is
+// closed once it has stopped.
+func runWorker() chan bool {
 	// Any custom startup per platform...
 	err := startup()
 	// any errors are fatal
@@ -369,9 +967,42 @@ func runWorker() chan<- bool {
 		panic(err)
 	}
 
+	httpClient, err = config.newHTTPClient()
+	if err != nil {
+		log.Printf("OH NO!!!\n\n%#v", err)
+		panic(err)
+	}
+
 	// initialise features
 	for _, feature := range Features {
-		feature.Initialise()
+		err := feature.Initialise()
+		if err != nil {
+			log.Printf("WARN: could not initialise feature %q: %s", feature.Name(), err)
+		}
+	}
+
+	hostCapabilities = detectHostCapabilities()
+
+	imageManifest, err = readImageManifest(config.ImageManifestFile)
+	if err != nil {
+		log.Printf("WARN: could not read image manifest: %s", err)
+	}
+
+	err = loadDurationEstimates(durationEstimatesFile(configFile))
+	if err != nil {
+		log.Printf("WARN: could not load task duration estimates: %s", err)
+	}
+
+	registerIdleJobs()
+	startEventHandlers(config.EventHandlers)
+	runHooks("startup", config.StartupHooks)
+
+	if config.StatusPort > 0 {
+		err = serveStatus(config.StatusPort)
+		if err != nil {
+			log.Printf("OH NO!!!\n\n%#v", err)
+			panic(err)
+		}
 	}
 
 	done := make(chan bool)
@@ -395,22 +1026,58 @@ func runWorker() chan<- bool {
 
 		// loop forever claiming and running tasks!
 		lastActive := time.Now()
+		// idle jobs only start once no task has run for
+		// config.IdleJobsAfterSecs, so not in short gaps between tasks
+		lastTask := time.Now()
 		for {
 			// make sure at least 1 second passes between iterations
 			waitASec := time.NewTimer(time.Second * 1)
-			taskFound := FindAndRunTask()
+			// during maintenance windows the worker does not claim tasks, but
+			// is not considered idle either, so is not shut down
+			maintenance := maintenanceMode(time.Now())
+			if maintenance {
+				lastActive = time.Now()
+			}
+			loadQuarantine(quarantineFile(configFile))
+			switch {
+			case quarantined():
+				setQuarantined(quarantineReason)
+			case maintenance:
+				setWorkerState("maintenance")
+			default:
+				setWorkerState("idle")
+			}
+			// a quarantined worker, or one with too much clock skew, does not
+			// claim tasks, so will eventually shut down if
+			// idleShutdownTimeoutSecs is set
+			taskFound := !quarantined() && !maintenance && !shuttingDown() && clockInSync() && FindAndRunTask()
 			if !taskFound {
 				log.Println("No task claimed...")
+				if time.Since(lastTask) >= time.Duration(config.IdleJobsAfterSecs)*time.Second {
+					idleJobs.startNext(time.Now())
+				}
 				if config.IdleShutdownTimeoutSecs > 0 {
 					idleTime := time.Now().Sub(lastActive)
 					if idleTime.Seconds() > float64(config.IdleShutdownTimeoutSecs) {
+						idleJobs.stop()
+						runHooks("shutdown", config.ShutdownHooks)
 						immediateShutdown()
 						break
 					}
 				}
 			} else {
-				taskCleanup()
+				taskFinished()
+				err := taskCleanup()
+				if err != nil {
+					log.Printf("Could not clean up after task: %v", err)
+					recordInternalError(err)
+				}
+				err = verifyMachineState()
+				if err != nil {
+					handleDirtyMachine(err)
+				}
 				lastActive = time.Now()
+				lastTask = time.Now()
 			}
 			// To avoid hammering queue, make sure there is at least a second
 			// between consecutive requests. Note we do this even if a task ran,
@@ -420,8 +1087,10 @@ func runWorker() chan<- bool {
 				continue
 			case <-done:
 				fmt.Println("Shutting down worker...")
+				idleJobs.stop()
+				runHooks("shutdown", config.ShutdownHooks)
 				close(done)
-				break
+				return
 			}
 		}
 		// signedDoneChan <- true
@@ -475,32 +1144,43 @@ func FindAndRunTask() bool {
 
 		// If there is one or more messages the worker must claim the tasks
 		// referenced in the messages, and delete the messages.
-		taskStatusUpdate <- TaskStatusUpdate{
+		claimStarted := time.Now()
+		err = updateTaskStatus(TaskStatusUpdate{
 			Task:   task,
 			Status: Claimed,
-		}
-		err = <-taskStatusUpdateErr
+		})
 		if err != nil {
 			log.Printf("WARN: Not able to claim task %v", task.TaskID)
 			log.Printf("%v", err)
 			break
 		}
+		task.trace.span("worker", "claim", claimStarted, nil)
+		// housekeeping must not compete with the task
+		idleJobs.stop()
 		task.setReclaimTimer()
 		task.fetchTaskDefinition()
 		err = task.validatePayload()
 		if err != nil {
 			log.Printf("TASK EXCEPTION: Not able to validate task payload for task %v", task.TaskID)
 			log.Printf("%#v", err)
-			taskStatusUpdate <- TaskStatusUpdate{
-				Task:   task,
-				Status: Errored,
-				Reason: "malformed-payload", // "invalid-payload"
-			}
-			task.reportPossibleError(<-taskStatusUpdateErr)
+			task.reportMalformedPayload(err)
 			break
 		}
-		err = task.run()
+		task.setTaskPhase("claimed")
+		runHooks("pre-task", config.PreTaskHooks, "TASK_ID="+task.TaskID)
+		task.notify("task-started", nil)
+		task.publishEvent(workerEvent{Event: "task-start"})
+		started := time.Now()
+		err = task.runWithInfraRetries()
+		task.recordTaskDuration(time.Since(started), err)
+		task.notify("task-resolved", err)
+		task.publishTaskEnd(err)
+		runHooks("post-task", config.PostTaskHooks, "TASK_ID="+task.TaskID)
 		task.reportPossibleError(err)
+		recordTaskOutcome(err)
+		if err != nil {
+			task.keepTaskDir()
+		}
 		break
 	}
 	return taskFound
@@ -670,16 +1350,15 @@ func deleteFromAzure(deleteUrl string) error {
 	// either case the worker should delete the message as we don't want
 	// another worker to receive message later.
 
-	httpCall := func() (*http.Response, error, error) {
+	httpCall := func(client *http.Client) (*http.Response, error) {
 		req, err := http.NewRequest("DELETE", deleteUrl, nil)
 		if err != nil {
-			return nil, nil, err
+			return nil, err
 		}
-		resp, err := http.DefaultClient.Do(req)
-		return resp, err, nil
+		return client.Do(req)
 	}
 
-	resp, _, err := httpbackoff.Retry(httpCall)
+	resp, _, err := retryPolicy("queue").retryHTTP("Deleting task from Azure queue", httpCall)
 
 	// Notice, that failure to delete messages from Azure queue is serious, as
 	// it wouldn't manifest itself in an immediate bug. Instead if messages
@@ -696,6 +1375,7 @@ func deleteFromAzure(deleteUrl string) error {
 		log.Printf("%v", err)
 		return err
 	}
+	resp.Body.Close()
 	log.Printf("Successfully deleted task from azure queue (delete url: %v) with http response code %v.", deleteUrl, resp.StatusCode)
 	// no errors occurred, yay!
 	return nil
@@ -711,34 +1391,36 @@ func (task *TaskRun) setReclaimTimer() {
 	// the claim denoted in `takenUntil` expires. It's recommended that this
 	// attempted a few minutes prior to expiration, to allow for clock drift.
 
-	// First time we need to check claim response, after that, need to check reclaim response
-	var takenUntil time.Time
-	if len(task.TaskReclaimResponse.Status.Runs) > 0 {
-		takenUntil = time.Time(task.TaskReclaimResponse.Status.Runs[task.RunID].TakenUntil)
-	} else {
-		takenUntil = time.Time(task.TaskClaimResponse.Status.Runs[task.RunID].TakenUntil)
-	}
-
 	// Attempt to reclaim 3 mins earlier...
-	reclaimTime := takenUntil.Add(time.Minute * -3)
+	reclaimTime := task.takenUntil().Add(time.Minute * -3)
 	waitTimeUntilReclaim := reclaimTime.Sub(time.Now())
 	task.reclaimTimer = time.AfterFunc(
 		waitTimeUntilReclaim, func() {
-			taskStatusUpdate <- TaskStatusUpdate{
+			err := updateTaskStatus(TaskStatusUpdate{
 				Task:   task,
 				Status: Reclaimed,
+			})
+			if isConflictError(err) {
+				// the queue no longer considers the run to be running, i.e.
+				// it was cancelled, so there is no point running it any
+				// further, and it needn't (and can't) be resolved
+				log.Printf("Task %v is no longer running, aborting it: %v", task.TaskID, err)
+				task.abort(&CommandExecutionError{
+					Cause:      fmt.Errorf("Task %v was cancelled", task.TaskID),
+					Reason:     "canceled",
+					TaskStatus: Cancelled,
+				})
+				return
 			}
-			err := <-taskStatusUpdateErr
 			if err != nil {
 				log.Println("TASK EXCEPTION due to reclaim failure")
 				task.Log("TASK EXCEPTION due to reclaim failure - please report this in #taskcluster as it is a serious error")
 				log.Printf("%v", err)
-				taskStatusUpdate <- TaskStatusUpdate{
+				task.reportPossibleError(updateTaskStatus(TaskStatusUpdate{
 					Task:   task,
 					Status: Errored,
-					Reason: "worker-shutdown", // internal error ("reclaim-failed")
-				}
-				task.reportPossibleError(<-taskStatusUpdateErr)
+					Reason: "internal-error", // reclaim failed
+				}))
 				return
 			}
 			// only set another reclaim timer if the previous reclaim succeeded
@@ -755,6 +1437,9 @@ func (task *TaskRun) fetchTaskDefinition() {
 func (task *TaskRun) validatePayload() error {
 	jsonPayload := task.Definition.Payload
 	log.Printf("Json Payload: %s", jsonPayload)
+	if config.MaxPayloadBytes > 0 && len(jsonPayload) > config.MaxPayloadBytes {
+		return fmt.Errorf("Malformed payload: payload is %v bytes, but worker type %v allows at most %v bytes (config setting maxPayloadBytes)", len(jsonPayload), config.WorkerType, config.MaxPayloadBytes)
+	}
 	schemaLoader := gojsonschema.NewStringLoader(taskPayloadSchema())
 	docLoader := gojsonschema.NewStringLoader(string(jsonPayload))
 	result, err := gojsonschema.Validate(schemaLoader, docLoader)
@@ -764,9 +1449,9 @@ func (task *TaskRun) validatePayload() error {
 	if result.Valid() {
 		log.Println("The task payload is valid.")
 	} else {
-		log.Println("TASK FAIL since the task payload is invalid. See errors:")
+		problems := []string{"Malformed payload: the task payload does not conform to the payload schema of the worker:"}
 		for _, desc := range result.Errors() {
-			log.Printf("- %s", desc)
+			problems = append(problems, fmt.Sprintf("- %s", desc))
 		}
 		// Dealing with Invalid Task Payloads
 		// ----------------------------------
@@ -796,32 +1481,105 @@ func (task *TaskRun) validatePayload() error {
 		// failed. The difference is whether or not the unexpected behavior
 		// happened before or after the execution of task specific Turing
 		// complete code.
-		taskStatusUpdate <- TaskStatusUpdate{
-			Task:   task,
-			Status: Errored,
-			Reason: "malformed-payload",
-		}
-		task.reportPossibleError(<-taskStatusUpdateErr)
-		return fmt.Errorf("Validation of payload failed for task %v", task.TaskID)
+		//
+		// See reportMalformedPayload.
+		return errors.New(strings.Join(problems, "\n"))
 	}
 	err = json.Unmarshal(jsonPayload, &task.Payload)
 	if err != nil {
 		return err
 	}
+	task.commandSpecs, err = parseCommandSpecs(append(task.Payload.Command, task.Payload.Teardown...))
+	if err != nil {
+		return err
+	}
+	if config.MaxTaskCommands > 0 && len(task.commandSpecs) > config.MaxTaskCommands {
+		return fmt.Errorf("Malformed payload: task has %v commands (including teardown commands), but worker type %v allows at most %v (config setting maxTaskCommands)", len(task.commandSpecs), config.WorkerType, config.MaxTaskCommands)
+	}
+	err = task.normalisePayloadPaths()
+	if err != nil {
+		return fmt.Errorf("Malformed payload: %s", err)
+	}
+	// the time budget endpoint listens on the loopback interface of the
+	// worker, not of the network namespace of an isolated task
+	if task.Payload.Features.TimeBudget && task.Payload.Features.NetworkIsolation {
+		return errors.New("Malformed payload: features timeBudget and networkIsolation cannot be combined, since the time budget endpoint is not reachable from an isolated network")
+	}
 	for _, artifact := range task.Payload.Artifacts {
 		if time.Time(artifact.Expires).Before(time.Time(task.Definition.Deadline)) {
 			return errors.New("Malformed payload: artifact expiration before task deadline")
 		}
+		if artifact.Required && artifact.Optional {
+			return fmt.Errorf("Malformed payload: artifact %v cannot be both optional and required", artifact.Path)
+		}
+		if artifact.HostPath != "" {
+			err := validateHostArtifact(artifact.HostPath, artifact.Watch)
+			if err != nil {
+				return fmt.Errorf("Malformed payload: %s", err)
+			}
+		}
+	}
+	err = task.validateVariants()
+	if err != nil {
+		return fmt.Errorf("Malformed payload: %s", err)
+	}
+	err = task.validateHostOverrides()
+	if err != nil {
+		return fmt.Errorf("Malformed payload: %s", err)
+	}
+	task.artifactNamePrefix, err = task.artifactPrefix()
+	if err != nil {
+		return fmt.Errorf("Malformed payload: %s", err)
 	}
 	return nil
 }
 
+// reportMalformedPayload resolves the task as exception with reason
+// malformed-payload, after publishing a task log explaining why, since the
+// task log is otherwise only created once the task runs, and task authors
+// can't see the worker log.
+func (task *TaskRun) reportMalformedPayload(reason error) {
+	logFile := filepath.Join(TaskDir, "public", "logs", "live_backing.log")
+	err := os.MkdirAll(longPath(filepath.Dir(logFile)), 0755)
+	var logFileHandle *os.File
+	if err == nil {
+		logFileHandle, err = os.Create(longPath(logFile))
+	}
+	if err == nil {
+		task.lock.Lock()
+		task.logWriter = logFileHandle
+		task.lock.Unlock()
+		task.Log("TASK EXCEPTION: the task was not run, since its payload is malformed")
+		task.Log(reason.Error())
+		task.lock.Lock()
+		task.logWriter = nil
+		task.lock.Unlock()
+		err = logFileHandle.Close()
+	}
+	if err == nil {
+		err = task.uploadLog("public/logs/live_backing.log")
+	}
+	if err != nil {
+		log.Printf("WARN: could not publish task log explaining why the payload of task %v is malformed: %s", task.TaskID, err)
+	}
+	task.reportPossibleError(updateTaskStatus(TaskStatusUpdate{
+		Task:   task,
+		Status: Errored,
+		Reason: "malformed-payload", // "invalid-payload"
+	}))
+}
+
 type CommandExecutionError struct {
 	TaskStatus TaskStatus
 	Cause      error
 	Reason     string
 }
 
+// The following functions classify why a task resolves as exception, so that
+// transient problems can be retried, and payload mistakes and worker bugs are
+// not confused with each other.
+
+// WorkerShutdown is for tasks interrupted by the worker shutting down
 func WorkerShutdown(err error) *CommandExecutionError {
 	return &CommandExecutionError{
 		Cause:      err,
@@ -830,7 +1588,39 @@ func WorkerShutdown(err error) *CommandExecutionError {
 	}
 }
 
+// MalformedPayloadError is for tasks that can never succeed as specified,
+// e.g. due to invalid payload, missing scopes or unsupported features
+func MalformedPayloadError(err error) *CommandExecutionError {
+	return &CommandExecutionError{
+		Cause:      err,
+		Reason:     "malformed-payload",
+		TaskStatus: Errored,
+	}
+}
+
+// ResourceUnavailable is for tasks that could not run due to a (probably
+// transient) lack of resources, such as disk space or an unavailable service
+func ResourceUnavailable(err error) *CommandExecutionError {
+	return &CommandExecutionError{
+		Cause:      err,
+		Reason:     "resource-unavailable",
+		TaskStatus: Errored,
+	}
+}
+
+// InternalError is for tasks that could not run due to a problem with the
+// worker itself
+func InternalError(err error) *CommandExecutionError {
+	return &CommandExecutionError{
+		Cause:      err,
+		Reason:     "internal-error",
+		TaskStatus: Errored,
+	}
+}
+
 func (task *TaskRun) Log(message string) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
 	if task.logWriter != nil {
 		for _, line := range strings.Split(message, "\n") {
 			task.logWriter.Write([]byte("[taskcluster " + tcclient.Time(time.Now()).String() + "] " + line + "\n"))
@@ -838,25 +1628,63 @@ func (task *TaskRun) Log(message string) {
 	}
 }
 
+// setEnvVar sets an environment variable for all task commands, in addition
+// to those specified in the task payload. Should be called by task features
+// in Start(), before commands are generated.
+func (task *TaskRun) setEnvVar(name, value string) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.featureEnv == nil {
+		task.featureEnv = map[string]string{}
+	}
+	task.featureEnv[name] = value
+}
+
 func (err CommandExecutionError) Error() string {
 	return fmt.Sprintf("TASK NOT SUCCESSFUL: status %v with reason: %q due to %s", err.TaskStatus, err.Reason, err.Cause)
 }
 
 func (task *TaskRun) ExecuteCommand(index int) *CommandExecutionError {
 
+	if errAbort := task.abortError(); errAbort != nil && !task.isTeardown(index) {
+		task.Log("Not executing command " + strconv.Itoa(index) + ", since task was aborted: " + errAbort.Cause.Error())
+		return errAbort
+	}
+	if task.deadline.hasExpired() {
+		task.Log("Not executing command " + strconv.Itoa(index) + ", since task exceeded " + task.deadline.description)
+		return task.deadline.error()
+	}
 	err := task.generateCommand(index) // platform specific
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
 	}
 
 	task.Log("Executing command " + strconv.Itoa(index) + ": " + task.describeCommand(index))
+	defer task.trace.span("commands", "command "+strconv.Itoa(index), time.Now(), map[string]string{"command": task.describeCommand(index)})
+	networkStart, networkErr := networkCounters() // platform specific
+	if networkErr != nil {
+		log.Printf("WARN: could not read network usage: %s", networkErr)
+	}
 	err = task.Commands[index].osCommand.Start()
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
 	}
 
+	exited, killed := task.monitorCommand(&task.Commands[index], task.commandChecks(index))
+
 	log.Println("Waiting for command to finish...")
 	errCommand := task.Commands[index].osCommand.Wait()
+	close(exited)
+	if networkErr == nil {
+		usage, err := networkUsageSince(networkStart)
+		if err != nil {
+			log.Printf("WARN: could not read network usage: %s", err)
+		}
+		task.networkUsage = task.networkUsage.add(usage)
+	}
+	if problem := <-killed; problem != nil {
+		return problem
+	}
 	exitStatus := 0
 	if errCommand != nil {
 		if exiterr, ok := errCommand.(*exec.ExitError); ok {
@@ -872,13 +1700,11 @@ func (task *TaskRun) ExecuteCommand(index int) *CommandExecutionError {
 		}
 	}
 	task.Log("Exit Code: " + strconv.Itoa(exitStatus))
+	task.publishEvent(workerEvent{Event: "command-exit", CommandIndex: &index, ExitCode: &exitStatus})
 
 	if errCommand != nil {
 		return exceptionOrFailure(errCommand)
 	}
-	if err != nil {
-		return WorkerShutdown(err)
-	}
 	return nil
 }
 
@@ -894,19 +1720,27 @@ func (task *TaskRun) run() error {
 	// with the reason `worker-shutdown`. Upon such report the queue will
 	// resolve the run as exception and create a new run, if the task has
 	// additional retries left.
-	go func() {
-		time.Sleep(time.Second * time.Duration(task.Payload.MaxRunTime))
-		taskStatusUpdate <- TaskStatusUpdate{
-			Task:   task,
-			Status: Aborted,
-			// only abort task if it is still running...
-			IfStatusIn: map[TaskStatus]bool{Claimed: true, Reclaimed: true},
-			Reason:     "malformed-payload", // "max run time (" + strconv.Itoa(task.Payload.MaxRunTime) + "s) exceeded"
-		}
-		task.reportPossibleError(<-taskStatusUpdateErr)
-	}()
+	// A command still running when maxRunTime is exceeded is killed, and the
+	// task is resolved as failed once its teardown commands have run and its
+	// logs and artifacts have been published. maxRunTime applies to the task
+	// run as a whole, not to each attempt (see runWithInfraRetries).
+	if task.maxRunTimeDeadline.IsZero() {
+		task.maxRunTimeDeadline = time.Now().Add(time.Second * time.Duration(task.Payload.MaxRunTime))
+	}
+	task.deadline = &commandDeadline{
+		at:          task.maxRunTimeDeadline,
+		description: "maxRunTime of " + strconv.Itoa(task.Payload.MaxRunTime) + " seconds",
+		status:      Failed,
+		reason:      "max-run-time-exceeded",
+		snapshot:    true,
+	}
 
-	task.Commands = make([]Command, len(task.Payload.Command))
+	// Teardown commands are appended to the task commands (see
+	// validatePayload) so that they are generated and executed in the same way
+	// as regular commands, and inherit the environment of the last command
+	// that ran.
+	taskCommandCount := len(task.Payload.Command)
+	task.Commands = make([]Command, len(task.commandSpecs))
 
 	// We only report the status at the end of the method, e.g.
 	// if a command fails, we still try to upload log files
@@ -917,108 +1751,260 @@ func (task *TaskRun) run() error {
 	var finalReason string
 	var finalError error = nil
 
-	absLogFile := filepath.Join(TaskUser.HomeDir, "public", "logs", "live_backing.log")
-	logFileHandle, err := os.Create(absLogFile)
+	absLogFile := filepath.Join(TaskDir, "public", "logs", "live_backing.log")
+	logFileHandle, err := os.Create(longPath(absLogFile))
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
+	}
+	// also closed explicitly before the task log is uploaded, but not if the
+	// task could not be started
+	defer logFileHandle.Close()
+	task.sections = &sectionWriter{w: logFileHandle}
+	task.logWriter = task.sections
+	task.logBanner()
+	if task.attempt > 0 {
+		task.Log(fmt.Sprintf("Attempt %v of task on this worker, after infrastructure exception(s) - see public/logs/attempt_<n>.log for earlier attempts", task.attempt+1))
+	}
+
+	// so that commands needn't depend on the directory they are started in
+	task.setEnvVar("TASK_WORKDIR", TaskDir)
+	// so that commands can leave themselves time to publish partial results
+	task.setEnvVar("TASK_DEADLINE", task.Definition.Deadline.String())
+	task.setEnvVar("TASK_MAX_RUN_TIME_DEADLINE", tcclient.Time(task.maxRunTimeDeadline).String())
+
+	if errReq := task.checkRequirements(); errReq != nil {
+		return errReq
 	}
-	task.logWriter = logFileHandle
 
+	enabledFeatures := []Feature{}
 	taskFeatures := []TaskFeature{}
 
-	// create task features
-	for _, feature := range Features {
-		if feature.IsEnabled(task.Payload.Features) {
+	task.setTaskPhase("starting")
+
+	// create task features, ordered so that dependencies are started first
+	orderedFeatures, err := orderFeatures(Features)
+	if err != nil {
+		return InternalError(err)
+	}
+	for _, feature := range orderedFeatures {
+		if feature.IsEnabled(task) {
+			if task.localArtifactsDir != "" {
+				task.Log(fmt.Sprintf("Feature %q is not supported when running tasks locally, so has been disabled", feature.Name()))
+				continue
+			}
+			err := feature.CheckSupported()
+			if err != nil {
+				task.Log(err.Error())
+				return MalformedPayloadError(err)
+			}
 			taskFeature := feature.NewTaskFeature(task)
 			requiredScopes := taskFeature.RequiredScopes()
-			if !scopes.Given(task.Definition.Scopes).Satisfies(requiredScopes) {
-				errorString := fmt.Sprintf("Feature requires scopes:\n\n%v\n\nbut task only has scopes:\n\n%v\n\nYou probably should add some scopes to your task definition.", requiredScopes, scopes.Given(task.Definition.Scopes))
-				task.Log(errorString)
-				return &CommandExecutionError{
-					Cause:      errors.New(errorString),
-					Reason:     "malformed-payload",
-					TaskStatus: Errored,
+			satisfied, err := task.hasScopes(requiredScopes)
+			if err != nil {
+				task.Log(fmt.Sprintf("Could not expand task scopes: %s", err))
+				return ResourceUnavailable(err)
+			}
+			if !satisfied {
+				errorString := fmt.Sprintf("Feature %q requires scopes:\n\n%v\n\nbut task only has scopes (with roles expanded):\n\n%v\n\n", feature.Name(), requiredScopes, scopes.Given(task.scopeExpansion))
+				if sources, ok := taskFeature.(ScopeSources); ok {
+					if missing := missingScopeSources(sources.RequiredScopeSources(), scopes.Given(task.scopeExpansion)); missing != "" {
+						errorString += "The missing scopes are required by these payload entries:\n\n" + missing + "\n\n"
+					}
 				}
+				errorString += "You probably should add some scopes to your task definition."
+				task.Log(errorString)
+				return MalformedPayloadError(errors.New(errorString))
 			}
+			enabledFeatures = append(enabledFeatures, feature)
 			taskFeatures = append(taskFeatures, taskFeature)
 		}
 	}
 
-	// start task features
-	for _, taskFeature := range taskFeatures {
-		err = taskFeature.Start()
-		if err != nil {
-			return WorkerShutdown(err)
+	// start task features concurrently, to reduce the time until the first
+	// task command runs; any already started are stopped if one fails
+	err = injectFault("featureStart")
+	if err != nil {
+		task.Log(err.Error())
+		return InternalError(err)
+	}
+	startupSaved, err := startTaskFeatures(enabledFeatures, taskFeatures, &task.trace)
+	if err != nil {
+		task.Log(err.Error())
+		return InternalError(err)
+	}
+	// task features are stopped explicitly once the task has finished, so that
+	// a failure to stop them is reflected in the task resolution, but must
+	// also be stopped if the task can't be run to completion
+	featuresStopped := false
+	defer func() {
+		if !featuresStopped {
+			err := stopTaskFeatures(taskFeatures)
+			if err != nil {
+				task.Log(fmt.Sprintf("Error(s) stopping task features: %s", err))
+			}
 		}
+	}()
+
+	if task.Payload.MaxIdleTime > 0 {
+		// only output of the commands counts as activity, not what the worker
+		// writes to the task log (see commandOutput)
+		task.activity = &activityWriter{w: task.logWriter}
 	}
 
-	jsonBytes, err := json.MarshalIndent(config.WorkerTypeMetadata, "  ", "  ")
+	metadata := workerMetadata()
+	metadata["taskStartup"] = map[string]interface{}{
+		"featureStartSavedSeconds": startupSaved.Seconds(),
+	}
+	jsonBytes, err := json.MarshalIndent(metadata, "  ", "  ")
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
 	}
 	task.Log("Worker Type (" + config.WorkerType + ") settings:")
 	task.Log("  " + string(jsonBytes))
+	if !clockSkewChecked.IsZero() {
+		task.Log(fmt.Sprintf("Worker clock skew relative to queue (measured %v): %v", tcclient.Time(clockSkewChecked), clockSkew))
+	}
+	err = task.uploadWorkerMetadata(metadata)
+	if err != nil {
+		// not worth failing the task over
+		log.Printf("WARN: could not upload worker metadata: %s", err)
+	}
 	task.Log("=== Task Starting ===")
+	task.setTaskPhase("running")
 	started := time.Now()
-	for i, _ := range task.Payload.Command {
+	// commands are only run if the payload preconditions are met
+	if errPre := task.checkPreconditions(); errPre != nil {
+		log.Printf("TASK EXCEPTION: %v", errPre.Error())
+		finalError = errPre.Cause
+		finalReason = errPre.Reason
+		finalTaskStatus = errPre.TaskStatus
+	}
+	stopWatching := task.watchArtifacts()
+	if len(task.Payload.Variants) > 0 {
+		// payload artifacts are published after each variant
+		if finalError == nil {
+			err := task.runVariants(taskCommandCount)
+			if err != nil {
+				log.Printf("TASK EXCEPTION OR FAILURE: Error running variants: %#v", err.Error())
+				finalError = err.Cause
+				finalReason = err.Reason
+				finalTaskStatus = err.TaskStatus
+			}
+		}
+	} else {
+		for i := 0; i < taskCommandCount && finalError == nil; i++ {
+			err := task.ExecuteCommand(i)
+			if err != nil {
+				log.Printf("TASK EXCEPTION OR FAILURE: Error executing command %v: %#v", i, err.Error())
+				finalError = err.Cause
+				finalReason = err.Reason
+				finalTaskStatus = err.TaskStatus
+				break
+			}
+		}
+	}
+	// teardown commands always run, even if a task command failed, was killed
+	// for exceeding maxRunTime, or the task was aborted (see ExecuteCommand),
+	// but have their own time limit
+	teardownMaxRunTime := task.teardownMaxRunTime()
+	task.deadline = &commandDeadline{
+		at:          time.Now().Add(time.Second * time.Duration(teardownMaxRunTime)),
+		description: "teardownMaxRunTime of " + strconv.Itoa(teardownMaxRunTime) + " seconds",
+		status:      Failed,
+		reason:      "teardown-timeout",
+	}
+	for i := taskCommandCount; i < len(task.commandSpecs); i++ {
+		task.Log("Running teardown command " + strconv.Itoa(i-taskCommandCount))
 		err := task.ExecuteCommand(i)
 		if err != nil {
-			log.Printf("TASK EXCEPTION OR FAILURE: Error executing command %v: %#v", i, err.Error())
+			log.Printf("TASK EXCEPTION OR FAILURE: Error executing teardown command %v: %#v", i-taskCommandCount, err.Error())
+			if finalError == nil {
+				finalError = err.Cause
+				finalReason = err.Reason
+				finalTaskStatus = err.TaskStatus
+			}
+		}
+	}
+	if task.Payload.ResultFile != "" {
+		err := task.processResultFile()
+		if err != nil {
+			log.Printf("TASK FAILURE: %v", err.Error())
+			if finalError == nil {
+				finalError = err.Cause
+				finalReason = err.Reason
+				finalTaskStatus = err.TaskStatus
+			}
+		}
+	}
+	if len(task.Payload.Variants) == 0 {
+		task.collectHostArtifacts()
+	}
+	if finalError == nil && len(task.Payload.Variants) == 0 {
+		err := task.checkRequiredArtifacts()
+		if err != nil {
+			log.Printf("TASK FAILURE: %v", err.Error())
 			finalError = err.Cause
 			finalReason = err.Reason
 			finalTaskStatus = err.TaskStatus
-			break
 		}
 	}
+	stopWatching()
+	if len(task.Payload.Variants) == 0 {
+		task.reportMissingArtifacts()
+	}
+	task.logSectionSummary()
 	finished := time.Now()
 	task.Log("=== Task Finished ===")
 	task.Log("Task Duration: " + finished.Sub(started).String())
+	err = task.uploadNetworkUsage()
+	if err != nil {
+		// not worth failing the task over
+		log.Printf("WARN: could not upload network usage: %s", err)
+	}
 
 	// don't fret if we can't close this
 	_ = logFileHandle.Close()
 
-	for _, artifact := range task.PayloadArtifacts() {
+	task.setTaskPhase("uploading-artifacts")
+	uploadsStarted := time.Now()
+	var artifacts []Artifact
+	if len(task.Payload.Variants) == 0 {
+		artifacts = task.PayloadArtifacts()
+	}
+	for _, artifact := range artifacts {
+		if artifact.Base().BestEffort || task.publishedWhileWatching(artifact) {
+			continue
+		}
 		err := task.uploadArtifact(artifact)
 		if err != nil {
 			log.Printf("%#v", err)
 			if finalError == nil {
-				switch t := err.(type) {
-				case *os.PathError:
-					// artifact does not exist or is not readable...
-					finalTaskStatus = Failed
-					finalError = err
-				case httpbackoff.BadHttpResponseCode:
-					// if not a 5xx error, then not worth retrying...
-					if t.HttpResponseCode/100 != 5 {
-						task.Log(fmt.Sprintf("TASK FAIL due to response code %v from Queue when uploading artifact %v", t.HttpResponseCode, artifact))
-						finalTaskStatus = Failed
-					} else {
-						task.Log(fmt.Sprintf("TASK EXCEPTION due to response code %v from Queue when uploading artifact %v", t.HttpResponseCode, artifact))
-						finalTaskStatus = Errored
-						finalReason = "worker-shutdown" // internal error (upload-failure)
-					}
-					finalError = err
-				default:
-					task.Log(fmt.Sprintf("TASK EXCEPTION due to error %#v", t))
-					// could not upload for another reason
-					finalTaskStatus = Errored
-					finalReason = "worker-shutdown" // internal error (upload-failure)
-					finalError = err
-				}
+				errUpload := task.uploadFailure(artifact, err)
+				finalError = errUpload.Cause
+				finalReason = errUpload.Reason
+				finalTaskStatus = errUpload.TaskStatus
 			}
 		}
 	}
 
+	task.uploadBestEffortArtifacts(artifacts, uploadsStarted)
+
+	task.setTaskPhase("finishing")
+	err = task.uploadTrace()
+	if err != nil {
+		// not worth failing the task over
+		log.Printf("WARN: could not upload execution trace: %s", err)
+	}
+
 	// stop task features, but in reverse order to how they were started
-	for i := len(taskFeatures) - 1; i >= 0; i-- {
-		err = taskFeatures[i].Stop()
-		if err != nil {
-			task.Log(fmt.Sprintf("TASK EXCEPTION due to error %#v", err))
-			if finalError == nil {
-				finalTaskStatus = Errored
-				finalReason = "worker-shutdown" // internal error (upload-failure)
-				finalError = err
-			}
+	featuresStopped = true
+	err = stopTaskFeatures(taskFeatures)
+	if err != nil {
+		task.Log(fmt.Sprintf("TASK EXCEPTION due to error(s) stopping task features: %s", err))
+		if finalError == nil {
+			finalTaskStatus = Errored
+			finalReason = "internal-error" // failure stopping task features
+			finalError = err
 		}
 	}
 
@@ -1029,26 +2015,98 @@ func (task *TaskRun) run() error {
 		if finalError == nil {
 			log.Println("TASK EXCEPTION when running post-task actions")
 			finalTaskStatus = Errored
-			finalReason = "worker-shutdown" // internal error (log-concatenation-failure)
+			finalReason = "internal-error" // log upload failure
 			finalError = err
 		}
 	}
 
+	// after task features have stopped and the task log has been uploaded,
+	// so that the artifacts they publish are included
+	err = task.uploadArtifactChecksums()
+	if err != nil {
+		// not worth failing the task over
+		log.Printf("WARN: could not upload artifact checksums: %s", err)
+	}
+
 	// When the worker has completed the task successfully it should call
 	// `queue.reportCompleted`.
-	taskStatusUpdate <- TaskStatusUpdate{
+	err = updateTaskStatus(TaskStatusUpdate{
 		Task:   task,
 		Status: finalTaskStatus,
 		Reason: finalReason,
+	})
+	if err != nil {
+		log.Printf("Could not resolve task %v as %v: %s", task.TaskID, finalTaskStatus, err)
+		if finalError == nil {
+			finalError = err
+		} else {
+			finalError = fmt.Errorf("%s (and could not resolve task as %v: %s)", finalError, finalTaskStatus, err)
+		}
 	}
-	err = <-taskStatusUpdateErr
-	if err != nil && finalError == nil {
-		log.Printf("%#v", err)
-		finalError = err
+	if finalTaskStatus == Errored {
+		// let caller know how the task exception was classified
+		return &CommandExecutionError{
+			Cause:      finalError,
+			Reason:     finalReason,
+			TaskStatus: finalTaskStatus,
+		}
 	}
 	return finalError
 }
 
+// workerMetadata returns the worker type metadata from the config, together
+// with details identifying this particular worker, so that task failures can
+// be correlated with changes to the worker fleet.
+func workerMetadata() map[string]interface{} {
+	metadata := map[string]interface{}{}
+	for key, value := range config.WorkerTypeMetadata {
+		metadata[key] = value
+	}
+	if failures := atomic.LoadInt64(&permanentAuthFailures); failures > 0 {
+		metadata["permanentAuthFailures"] = failures
+	}
+	metadata["queueCalls"] = queueCallCounts()
+	if imageManifest != nil {
+		metadata["imageManifest"] = imageManifest
+	}
+	if hostCapabilities != nil {
+		metadata["host"] = hostCapabilities
+	}
+	if config.Capabilities != nil {
+		metadata["capabilities"] = config.Capabilities
+	}
+	if estimate, exists := currentDurationEstimates()[config.ProvisionerID+"/"+config.WorkerType]; exists {
+		metadata["durationEstimate"] = estimate
+	}
+	metadata["worker"] = map[string]string{
+		"provisionerId": config.ProvisionerID,
+		"workerType":    config.WorkerType,
+		"workerGroup":   config.WorkerGroup,
+		"workerId":      config.WorkerID,
+		"deploymentId":  config.DeploymentID,
+	}
+	return metadata
+}
+
+// uploadWorkerMetadata publishes the given worker metadata as task artifact
+// public/logs/worker_type_metadata.json
+func (task *TaskRun) uploadWorkerMetadata(metadata map[string]interface{}) error {
+	const metadataFile = "public/logs/worker_type_metadata.json"
+	err := writeToFileAsJSON(metadata, filepath.Join(TaskDir, metadataFile))
+	if err != nil {
+		return err
+	}
+	return task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: metadataFile,
+				Expires:       task.Definition.Expires,
+			},
+			MimeType: "application/json",
+		},
+	)
+}
+
 func writeToFileAsJSON(obj interface{}, filename string) error {
 	jsonBytes, err := json.MarshalIndent(obj, "", "  ")
 	if err != nil {
@@ -1061,7 +2119,7 @@ func (task *TaskRun) postTaskActions() error {
 	log.Println("Uploading full log file")
 	err := task.uploadLog("public/logs/live_backing.log")
 	if err != nil {
-		return WorkerShutdown(err)
+		return InternalError(err)
 	}
 
 	return nil
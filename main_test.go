@@ -2,8 +2,16 @@ package main
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	tcclient "github.com/taskcluster/taskcluster-client-go"
 	"github.com/taskcluster/taskcluster-client-go/queue"
 )
 
@@ -28,6 +36,8 @@ func TestBadPayloadValidate(t *testing.T) {
 		return r, e, d
 	}()
 
+	defer func(c *Config) { config = c }(config)
+	config = &Config{}
 	badPayload := json.RawMessage(`bad payload, not even json`)
 	task := TaskRun{Definition: queue.TaskDefinitionResponse{Payload: badPayload}}
 	err := task.validatePayload()
@@ -43,3 +53,286 @@ func TestBadPayloadValidate(t *testing.T) {
 		t.Errorf("Bad task payload should have retured a *json.SyntaxError error, but actually returned a %T error. The unexpected %T error was:\n%s", err, err, err)
 	}
 }
+
+// The reasons a payload is malformed should be published in the task log, so
+// that task authors can see them, before the task is resolved.
+func TestReportMalformedPayload(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-malformed-payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(dir string, c *Config) {
+		TaskDir = dir
+		config = c
+	}(TaskDir, config)
+	TaskDir = tempDir
+	config = &Config{}
+	taskStatusUpdate, taskStatusUpdateErr, taskStatusDoneChan = localTaskStatusHandler()
+	defer func() {
+		taskStatusDoneChan <- true
+	}()
+	now := time.Now()
+	task := &TaskRun{
+		TaskID: "malformed",
+		Definition: queue.TaskDefinitionResponse{
+			Expires: tcclient.Time(now.Add(time.Hour)),
+			Payload: json.RawMessage(`{"maxRunTime": 30}`),
+		},
+		Status:            Claimed,
+		localArtifactsDir: filepath.Join(tempDir, "artifacts"),
+	}
+	err = task.validatePayload()
+	if err == nil {
+		t.Fatal("Payload without commands should not pass validation")
+	}
+	task.reportMalformedPayload(err)
+	if task.Status != Errored {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Errored, task.Status)
+	}
+	taskLog, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not published: %v", err)
+	}
+	if !strings.Contains(string(taskLog), "does not conform to the payload schema") || !strings.Contains(string(taskLog), "command") {
+		t.Fatalf("Expected task log to explain why the payload is malformed, but it is:\n%s", taskLog)
+	}
+}
+
+// Payloads exceeding the limits in the worker config should not pass
+// validation.
+func TestPayloadLimits(t *testing.T) {
+	command := `[["echo", "hello"], ["echo", "world"]]`
+	if runtime.GOOS == "windows" {
+		command = `["echo hello", "echo world"]`
+	}
+	payload := json.RawMessage(`{"command": ` + command + `, "maxRunTime": 30}`)
+	defer func(c *Config) { config = c }(config)
+	for _, c := range []*Config{
+		{MaxTaskCommands: 1},
+		{MaxPayloadBytes: len(payload) - 1},
+	} {
+		config = c
+		task := TaskRun{Definition: queue.TaskDefinitionResponse{Payload: payload}}
+		err := task.validatePayload()
+		if err == nil {
+			t.Errorf("Payload should have exceeded limits in config %#v, but passed validation", c)
+		}
+	}
+	config = &Config{MaxTaskCommands: 2, MaxPayloadBytes: len(payload)}
+	task := TaskRun{Definition: queue.TaskDefinitionResponse{Payload: payload}}
+	err := task.validatePayload()
+	if err != nil {
+		t.Errorf("Payload within config limits should have passed validation, but got: %v", err)
+	}
+}
+
+// newCommandTask returns a task with the given payload, whose commands can be
+// run with ExecuteCommand until the given deadline, without running the task.
+func newCommandTask(t *testing.T, payload string, deadline *commandDeadline) *TaskRun {
+	task := &TaskRun{
+		logWriter: ioutil.Discard,
+		deadline:  deadline,
+	}
+	err := json.Unmarshal([]byte(payload), &task.Payload)
+	if err != nil {
+		t.Fatalf("Could not unmarshal payload: %v", err)
+	}
+	task.commandSpecs, err = parseCommandSpecs(task.Payload.Command)
+	if err != nil {
+		t.Fatalf("Could not parse payload commands: %v", err)
+	}
+	task.Commands = make([]Command, len(task.commandSpecs))
+	return task
+}
+
+// Teardown commands should run after a task command fails or exceeds
+// maxRunTime, and be killed if they exceed teardownMaxRunTime.
+func TestTeardown(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands use bash")
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	artifacts := `[{"type": "file", "path": "teardown.txt", "expires": "` + expires + `"}]`
+	for _, test := range []struct {
+		name     string
+		payload  string
+		status   TaskStatus
+		tornDown bool
+		log      string
+	}{
+		{
+			name: "after failure",
+			payload: `{
+				"command": [["/bin/bash", "-c", "exit 1"]],
+				"teardown": [["/bin/bash", "-c", "echo done > teardown.txt"]],
+				"maxRunTime": 30,
+				"artifacts": ` + artifacts + `
+			}`,
+			status:   Failed,
+			tornDown: true,
+			log:      "Running teardown command 0",
+		},
+		{
+			name: "after timeout",
+			payload: `{
+				"command": [["/bin/bash", "-c", "sleep 30"]],
+				"teardown": [["/bin/bash", "-c", "echo done > teardown.txt"]],
+				"maxRunTime": 1,
+				"artifacts": ` + artifacts + `
+			}`,
+			status:   Failed,
+			tornDown: true,
+			log:      "Command killed: Task exceeded maxRunTime of 1 seconds",
+		},
+		{
+			name: "exceeding teardownMaxRunTime",
+			payload: `{
+				"command": [["/bin/bash", "-c", "true"]],
+				"teardown": [["/bin/bash", "-c", "sleep 30"], ["/bin/bash", "-c", "echo done > teardown.txt"]],
+				"maxRunTime": 30,
+				"teardownMaxRunTime": 1,
+				"artifacts": ` + artifacts + `
+			}`,
+			status:   Failed,
+			tornDown: false,
+			log:      "Command killed: Task exceeded teardownMaxRunTime of 1 seconds",
+		},
+	} {
+		started := time.Now()
+		tempDir, status, _ := runLocalTask(t, test.payload, nil)
+		defer os.RemoveAll(tempDir)
+		if status != test.status {
+			t.Fatalf("%v: expected task to resolve as %v but it resolved as %v", test.name, test.status, status)
+		}
+		if elapsed := time.Since(started); elapsed > 8*time.Second {
+			t.Fatalf("%v: expected task to be done well before its commands would complete, but it took %v", test.name, elapsed)
+		}
+		_, err := os.Stat(filepath.Join(tempDir, "artifacts", "teardown.txt"))
+		if tornDown := err == nil; tornDown != test.tornDown {
+			t.Fatalf("%v: expected teardown command writing teardown.txt to run: %v, but it ran: %v", test.name, test.tornDown, tornDown)
+		}
+		logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+		if err != nil {
+			t.Fatalf("%v: task log was not saved locally: %v", test.name, err)
+		}
+		if !strings.Contains(string(logFile), test.log) {
+			t.Fatalf("%v: expected task log to contain %q:\n%s", test.name, test.log, logFile)
+		}
+	}
+}
+
+func TestWorkerMetadata(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{
+		ProvisionerID:      "test-provisioner",
+		WorkerType:         "test-worker-type",
+		WorkerGroup:        "test-worker-group",
+		WorkerID:           "test-worker-id",
+		DeploymentID:       "test-deployment",
+		WorkerTypeMetadata: map[string]interface{}{"machine-setup": "manifest"},
+	}
+	metadata := workerMetadata()
+	if metadata["machine-setup"] != "manifest" {
+		t.Fatalf("Worker type metadata from config missing: %#v", metadata)
+	}
+	expected := map[string]string{
+		"provisionerId": "test-provisioner",
+		"workerType":    "test-worker-type",
+		"workerGroup":   "test-worker-group",
+		"workerId":      "test-worker-id",
+		"deploymentId":  "test-deployment",
+	}
+	if !reflect.DeepEqual(metadata["worker"], expected) {
+		t.Fatalf("Expected worker details %#v but got %#v", expected, metadata["worker"])
+	}
+	if _, exists := config.WorkerTypeMetadata["worker"]; exists {
+		t.Fatal("Worker details should not be added to config.WorkerTypeMetadata")
+	}
+}
+
+// The worker metadata should be published as an artifact of every task.
+func TestWorkerMetadataArtifact(t *testing.T) {
+	command := `[["true"]]`
+	if runtime.GOOS == "windows" {
+		command = `["echo hello"]`
+	}
+	tempDir, status, err := runLocalTask(t, `{"command": `+command+`, "maxRunTime": 30}`, nil)
+	defer os.RemoveAll(tempDir)
+	if err != nil || status != Succeeded {
+		t.Fatalf("Task should have succeeded, but resolved as %v: %v", status, err)
+	}
+	data, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "worker_type_metadata.json"))
+	if err != nil {
+		t.Fatalf("Worker metadata was not published: %v", err)
+	}
+	var metadata struct {
+		Worker map[string]string `json:"worker"`
+	}
+	err = json.Unmarshal(data, &metadata)
+	if err != nil {
+		t.Fatalf("Published worker metadata is not valid json: %v\n%s", err, data)
+	}
+	if metadata.Worker["workerType"] != "run-task" {
+		t.Fatalf("Published worker metadata does not identify the worker:\n%s", data)
+	}
+}
+
+// maxRunTime applies to the task run as a whole, so a retried attempt should
+// not get a fresh maxRunTime.
+func TestMaxRunTimeAcrossAttempts(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-attempts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(c *Config, dir string) {
+		config = c
+		TaskDir = dir
+	}(config, TaskDir)
+	config = &Config{
+		WorkerTypeMetadata:     map[string]interface{}{},
+		RunTasksAsCurrentUser:  true,
+		TeardownMaxRunTimeSecs: 300,
+		KillGracePeriodSecs:    1,
+	}
+	TaskDir = tempDir
+	err = startup()
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskStatusUpdate, taskStatusUpdateErr, taskStatusDoneChan = localTaskStatusHandler()
+	defer func() {
+		taskStatusDoneChan <- true
+	}()
+
+	now := time.Now()
+	task := &TaskRun{
+		TaskID: "attempts",
+		Definition: queue.TaskDefinitionResponse{
+			Created:  tcclient.Time(now),
+			Deadline: tcclient.Time(now.Add(time.Hour)),
+			Expires:  tcclient.Time(now.Add(time.Hour * 24)),
+			Payload:  json.RawMessage(`{"command": [["go", "version"]], "maxRunTime": 30}`),
+		},
+		Status:            Claimed,
+		localArtifactsDir: filepath.Join(tempDir, "artifacts"),
+	}
+	err = task.validatePayload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// as if an earlier attempt had used up maxRunTime
+	task.attempt = 1
+	task.maxRunTimeDeadline = now.Add(-time.Second)
+	err = task.run()
+	if task.Status != Failed {
+		t.Fatalf("Expected retried task to fail for exceeding maxRunTime, but it resolved as %v (error: %v)", task.Status, err)
+	}
+	if err == nil || !strings.Contains(err.Error(), "maxRunTime") {
+		t.Fatalf("Expected error due to maxRunTime being exceeded, but got %v", err)
+	}
+	if !task.maxRunTimeDeadline.Equal(now.Add(-time.Second)) {
+		t.Fatalf("maxRunTime deadline of earlier attempt should be kept, but was changed to %v", task.maxRunTimeDeadline)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// whether the worker was in a maintenance window when last checked - only
+// accessed from the worker loop in runWorker
+var inMaintenance bool
+
+// maintenanceWindow is a daily period of time (UTC) during which the worker
+// does not claim tasks. If end is before start, the window spans midnight.
+type maintenanceWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+// parseMaintenanceWindow parses a window of the form "HH:MM-HH:MM" (UTC), as
+// used in config setting maintenanceWindows.
+func parseMaintenanceWindow(window string) (maintenanceWindow, error) {
+	var startH, startM, endH, endM int
+	_, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startH, &startM, &endH, &endM)
+	if err != nil || startH > 23 || endH > 23 || startM > 59 || endM > 59 || startH < 0 || endH < 0 || startM < 0 || endM < 0 {
+		return maintenanceWindow{}, fmt.Errorf("Invalid maintenance window %q in config setting maintenanceWindows - should be of the form HH:MM-HH:MM", window)
+	}
+	return maintenanceWindow{
+		start: time.Duration(startH)*time.Hour + time.Duration(startM)*time.Minute,
+		end:   time.Duration(endH)*time.Hour + time.Duration(endM)*time.Minute,
+	}, nil
+}
+
+func (w maintenanceWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if w.start <= w.end {
+		return sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	return sinceMidnight >= w.start || sinceMidnight < w.end
+}
+
+// maintenanceMode returns true if t falls within one of the maintenance
+// windows in config, logging whenever the worker enters or leaves
+// maintenance mode. Windows are validated when the config is loaded.
+func maintenanceMode(t time.Time) bool {
+	inWindow := false
+	for _, window := range config.MaintenanceWindows {
+		w, err := parseMaintenanceWindow(window)
+		if err == nil && w.contains(t) {
+			inWindow = true
+			break
+		}
+	}
+	if inWindow != inMaintenance {
+		if inWindow {
+			log.Println("Entering maintenance mode - no further tasks will be claimed until the maintenance window ends")
+		} else {
+			log.Println("Leaving maintenance mode - resuming claiming tasks")
+		}
+		inMaintenance = inWindow
+	}
+	return inWindow
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/taskcluster/taskcluster-client-go/queue"
@@ -27,16 +28,19 @@ type (
 		Certificate                string                 `json:"certificate"`
 		ProvisionerID              string                 `json:"provisionerId"`
 		RefreshUrlsPrematurelySecs int                    `json:"refreshURLsPrematurelySecs"`
+		TeardownMaxRunTimeSecs     int                    `json:"teardownMaxRunTimeSecs"`
 		PublicIP                   net.IP                 `json:"publicIP"`
 		PrivateIP                  net.IP                 `json:"privateIP"`
 		Subdomain                  string                 `json:"subdomain"`
 		WorkerGroup                string                 `json:"workerGroup"`
 		WorkerID                   string                 `json:"workerId"`
+		WorkerIDStrategy           string                 `json:"workerIdStrategy"`
 		InstanceID                 string                 `json:"instanceId"`
 		InstanceType               string                 `json:"instanceType"`
 		Region                     string                 `json:"region"`
 		WorkerType                 string                 `json:"workerType"`
 		UsersDir                   string                 `json:"usersDir"`
+		TasksDir                   string                 `json:"tasksDir"`
 		CachesDir                  string                 `json:"cachesDir"`
 		DownloadsDir               string                 `json:"downloadsDir"`
 		CleanUpTaskDirs            bool                   `json:"cleanUpTaskDirs"`
@@ -44,6 +48,62 @@ type (
 		WorkerTypeMetadata         map[string]interface{} `json:"workerTypeMetadata"`
 		SigningKeyLocation         string                 `json:"signingKeyLocation"`
 		RunTasksAsCurrentUser      bool                   `json:"runTasksAsCurrentUser"`
+		CACertificatesFile         string                 `json:"caCertificatesFile"`
+		ClientCertificateFile      string                 `json:"clientCertificateFile"`
+		ClientKeyFile              string                 `json:"clientKeyFile"`
+		QuarantineAfterErrors      int                    `json:"quarantineAfterErrors"`
+		DeploymentID               string                 `json:"deploymentId"`
+		ArtifactUploadBytesPerSec  int                    `json:"artifactUploadBytesPerSec"`
+		KVM                        bool                   `json:"kvm"`
+		KillGracePeriodSecs        int                    `json:"killGracePeriodSecs"`
+		MaintenanceWindows         []string               `json:"maintenanceWindows"`
+		MaxPayloadBytes            int                    `json:"maxPayloadBytes"`
+		MaxTaskCommands            int                    `json:"maxTaskCommands"`
+		AppArmorProfile            string                 `json:"appArmorProfile"`
+		ArtifactArchiveDir         string                 `json:"artifactArchiveDir"`
+		ArtifactArchivePatterns    []string               `json:"artifactArchivePatterns"`
+		ArtifactPrefix             string                 `json:"artifactPrefix"`
+		MaxClockSkewSecs           int                    `json:"maxClockSkewSecs"`
+		LogChunkIntervalSecs       int                    `json:"logChunkIntervalSecs"`
+		InfraRetries               int                    `json:"infraRetries"`
+		KeepTaskDirs               int                    `json:"keepTaskDirs"`
+		NotificationWebhooks       []string               `json:"notificationWebhooks"`
+		Perf                       bool                   `json:"perf"`
+		PostTaskCheckCommands      [][]string             `json:"postTaskCheckCommands"`
+		PostTaskCheckFailure       string                 `json:"postTaskCheckFailure"`
+		StartupHooks               [][]string             `json:"startupHooks"`
+		PreTaskHooks               [][]string             `json:"preTaskHooks"`
+		PostTaskHooks              [][]string             `json:"postTaskHooks"`
+		ShutdownHooks              [][]string             `json:"shutdownHooks"`
+		HookTimeoutSecs            int                    `json:"hookTimeoutSecs"`
+		StatusPort                 int                    `json:"statusPort"`
+		Sandbox                    bool                   `json:"sandbox"`
+		Umask                      string                 `json:"umask"`
+		SSHCertificateAuthorityKey string                 `json:"sshCertificateAuthorityKey"`
+		ArtifactStorage            string                 `json:"artifactStorage"`
+		ArtifactStorageDir         string                 `json:"artifactStorageDir"`
+		ArtifactStorageURL         string                 `json:"artifactStorageURL"`
+		ImageManifestFile          string                 `json:"imageManifestFile"`
+		FaultInjection             FaultInjection         `json:"faultInjection"`
+		AntivirusExclusions        bool                   `json:"antivirusExclusions"`
+		EnvironmentProbes          [][]string             `json:"environmentProbes"`
+		ReservedDiskSpaceMB        int                    `json:"reservedDiskSpaceMB"`
+		ReservedMemoryMB           int                    `json:"reservedMemoryMB"`
+		EventHandlers              [][]string             `json:"eventHandlers"`
+		RetryPolicies              map[string]RetryPolicy `json:"retryPolicies"`
+		CrashLoopRestarts          int                    `json:"crashLoopRestarts"`
+		CrashLoopWindowSecs        int                    `json:"crashLoopWindowSecs"`
+		QueueCallsPerMinute        int                    `json:"queueCallsPerMinute"`
+		QueueCallBurst             int                    `json:"queueCallBurst"`
+		QueueStartupJitterSecs     int                    `json:"queueStartupJitterSecs"`
+		ChecksumAlgorithms         []string               `json:"checksumAlgorithms"`
+		FIPSMode                   bool                   `json:"fipsMode"`
+		TaskLogBanner              string                 `json:"taskLogBanner"`
+		TaskLogNoticesURL          string                 `json:"taskLogNoticesURL"`
+		HostArtifactDirs           []string               `json:"hostArtifactDirs"`
+		IdleJobs                   []IdleJob              `json:"idleJobs"`
+		IdleJobsAfterSecs          int                    `json:"idleJobsAfterSecs"`
+		Capabilities               []string               `json:"capabilities"`
 	}
 
 	// Used for modelling the xml we get back from Azure
@@ -80,9 +140,64 @@ type (
 		Status              TaskStatus                   `json:"-"`
 		Commands            []Command                    `json:"-"`
 		// not exported
+		// limits how long the running command may run, see deadlineCheck
+		deadline     *commandDeadline
 		reclaimTimer *time.Timer
-		logWriter    io.Writer
-		Queue        *queue.Queue `json:"-"`
+		// guards logWriter, featureEnv, Artifacts, artifactHashes,
+		// TaskReclaimResponse, abortErr and taskAuthFailed, since task features are started
+		// concurrently (see startTaskFeatures), and the task is reclaimed and
+		// aborted concurrently
+		lock      sync.Mutex
+		logWriter io.Writer
+		// set once the task is aborted, see abort
+		abortErr *CommandExecutionError
+		// set once a call with the task credentials has failed permanently
+		// with an authentication or authorization error, see
+		// refreshTaskCredentials
+		taskAuthFailed bool
+		// number of earlier attempts at running the task on this worker, see
+		// runWithInfraRetries
+		attempt int
+		// when the task is aborted for exceeding payload maxRunTime, set on the
+		// first attempt at running the task
+		maxRunTimeDeadline time.Time
+		// files of watched artifact directories already published, see
+		// watchArtifacts
+		watchedUploads map[string]watchedFile
+		// task scopes with roles expanded, see expandedScopes
+		scopeExpansion []string
+		// prefix for the names of payload artifacts, see artifactPrefix
+		artifactNamePrefix string
+		// artifacts copied to the artifact archive, see archiveArtifact
+		archivedArtifacts []ArchivedArtifact
+		// hash and size of each S3 artifact uploaded, keyed by artifact name
+		artifactHashes map[string]ArtifactHash
+		// env vars set by task features, in addition to those in the payload
+		featureEnv map[string]string
+		// task commands followed by teardown commands, see parseCommandSpecs
+		commandSpecs []commandSpec
+		// if set, task commands are profiled with perf, which writes its data
+		// files to this directory (see PerfFeature)
+		perfDataDir string
+		// if non-zero, task commands run in this Windows session, as the user
+		// logged on to it, rather than in the session of the worker (see
+		// InteractiveUIFeature)
+		interactiveSession uint32
+		// if set, the hosts file with the host overrides of the task, which
+		// is bound over the hosts file in the sandbox (see HostOverridesTask)
+		hostsFile string
+		// tracks output of commands when payload specifies maxIdleTime
+		activity *activityWriter
+		// network usage of the commands that have run so far
+		networkUsage NetworkUsage
+		// records the log sections opened and closed by task commands
+		sections *sectionWriter
+		// records how long each part of running the task took, see uploadTrace
+		trace taskTrace
+		// if set, task is running locally (see run-task), and artifacts are
+		// copied to this directory rather than uploaded to the queue
+		localArtifactsDir string
+		Queue             *queue.Queue `json:"-"`
 	}
 
 	// Regardless of platform, we will have to call out to system commands to run tasks,
@@ -185,7 +300,7 @@ func (task *TaskRun) String() string {
 	response += fmt.Sprintf("Worker Type:             %v\n", task.Definition.WorkerType)
 	response += fmt.Sprintf("==========================================\n")
 	response += fmt.Sprintf("Artifacts:               %v\n", task.Payload.Artifacts)
-	response += fmt.Sprintf("Command:                 %#v\n", task.Payload.Command)
+	response += fmt.Sprintf("Command:                 %s\n", task.Payload.Command)
 	response += fmt.Sprintf("Env:                     %#v\n", task.Payload.Env)
 	response += fmt.Sprintf("Max Run Time:            %v\n", task.Payload.MaxRunTime)
 	response += fmt.Sprintf("==========================================\n")
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// NetworkUsage is the number of bytes received and sent over the network
+// interfaces of the worker (excluding loopback). While task commands run,
+// it is attributed to the task, so it includes other traffic of the worker
+// during that time, such as live log streaming and artifact uploads.
+type NetworkUsage struct {
+	BytesReceived uint64 `json:"bytesReceived"`
+	BytesSent     uint64 `json:"bytesSent"`
+}
+
+func (u NetworkUsage) total() uint64 {
+	return u.BytesReceived + u.BytesSent
+}
+
+func (u NetworkUsage) add(other NetworkUsage) NetworkUsage {
+	return NetworkUsage{
+		BytesReceived: u.BytesReceived + other.BytesReceived,
+		BytesSent:     u.BytesSent + other.BytesSent,
+	}
+}
+
+// networkUsageSince returns the network usage since the interface counters
+// were at start
+func networkUsageSince(start NetworkUsage) (NetworkUsage, error) {
+	now, err := networkCounters() // platform specific
+	if err != nil {
+		return NetworkUsage{}, err
+	}
+	// counters may have wrapped, or been reset by an interface going down
+	usage := NetworkUsage{}
+	if now.BytesReceived > start.BytesReceived {
+		usage.BytesReceived = now.BytesReceived - start.BytesReceived
+	}
+	if now.BytesSent > start.BytesSent {
+		usage.BytesSent = now.BytesSent - start.BytesSent
+	}
+	return usage, nil
+}
+
+// uploadNetworkUsage writes the network usage of the task to the task log,
+// and publishes it as artifact public/logs/network_usage.json. Since the
+// usage includes traffic of the worker itself (live log, log chunks and
+// artifact uploads), exceeding payload maxNetworkUsage only causes a warning,
+// rather than failing the task for bytes it may not have sent.
+func (task *TaskRun) uploadNetworkUsage() error {
+	const usageFile = "public/logs/network_usage.json"
+	task.Log(fmt.Sprintf("Network Usage: %v bytes received, %v bytes sent", task.networkUsage.BytesReceived, task.networkUsage.BytesSent))
+	if max := task.Payload.MaxNetworkUsage; max > 0 && task.networkUsage.total() > uint64(max)*1024*1024 {
+		task.Log(fmt.Sprintf("WARNING: network usage of the worker while task commands ran exceeded %v megabytes (maxNetworkUsage)", max))
+	}
+	err := writeToFileAsJSON(task.networkUsage, filepath.Join(TaskDir, usageFile))
+	if err != nil {
+		return err
+	}
+	return task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: usageFile,
+				Expires:       task.Definition.Expires,
+			},
+			MimeType: "application/json",
+		},
+	)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Network usage should be published as an artifact, and exceeding
+// maxNetworkUsage should only cause a warning in the task log, since the
+// counters include traffic of the worker itself.
+func TestNetworkUsageWarning(t *testing.T) {
+	defer func(dir string) { TaskDir = dir }(TaskDir)
+	for usage, warning := range map[uint64]bool{
+		512 * 1024:      false,
+		2 * 1024 * 1024: true,
+	} {
+		tempDir, err := ioutil.TempDir("", "generic-worker-network-usage")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(tempDir)
+		TaskDir = tempDir
+		err = os.MkdirAll(filepath.Join(tempDir, "public", "logs"), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		taskLog := new(bytes.Buffer)
+		task := &TaskRun{
+			logWriter:         taskLog,
+			localArtifactsDir: filepath.Join(tempDir, "artifacts"),
+			networkUsage:      NetworkUsage{BytesReceived: usage / 2, BytesSent: usage / 2},
+		}
+		task.Payload.MaxNetworkUsage = 1
+		err = task.uploadNetworkUsage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if warned := strings.Contains(taskLog.String(), "(maxNetworkUsage)"); warned != warning {
+			t.Errorf("With network usage of %v bytes expected warning %v but task log was:\n%v", usage, warning, taskLog)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(task.localArtifactsDir, "public", "logs", "network_usage.json"))
+		if err != nil {
+			t.Fatalf("Network usage was not published: %v", err)
+		}
+		published := NetworkUsage{}
+		err = json.Unmarshal(data, &published)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if published != task.networkUsage {
+			t.Errorf("Expected published network usage %#v but got %#v", task.networkUsage, published)
+		}
+	}
+}
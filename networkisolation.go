@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+type NetworkIsolationFeature struct {
+}
+
+type NetworkIsolationTask struct {
+	task *TaskRun
+}
+
+func (feature *NetworkIsolationFeature) Name() string {
+	return "Network Isolation"
+}
+
+func (feature *NetworkIsolationFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *NetworkIsolationFeature) Initialise() error {
+	return nil
+}
+
+func (feature *NetworkIsolationFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.NetworkIsolation
+}
+
+// network namespaces can only be created by root on Linux
+func (feature *NetworkIsolationFeature) CheckSupported() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("Feature %q is only supported on Linux", feature.Name())
+	}
+	if os.Geteuid() != 0 {
+		return fmt.Errorf("Feature %q is not supported by worker type %v, since the worker does not run as root", feature.Name(), config.WorkerType)
+	}
+	for _, tool := range []string{"unshare", "ip"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			return fmt.Errorf("Feature %q is not supported by worker type %v, since %v is not installed", feature.Name(), config.WorkerType, tool)
+		}
+	}
+	return nil
+}
+
+func (feature *NetworkIsolationFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &NetworkIsolationTask{
+		task: task,
+	}
+}
+
+func (n *NetworkIsolationTask) RequiredScopes() scopes.Required {
+	// isolation only restricts what the task can do, so no scopes required
+	return scopes.Required{}
+}
+
+func (n *NetworkIsolationTask) Start() error {
+	n.task.Log("Task commands will run without network access (other than loopback)")
+	return nil
+}
+
+func (n *NetworkIsolationTask) Stop() error {
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Task commands requiring network isolation should only see a loopback
+// interface.
+func TestNetworkIsolation(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{}
+	if err := (&NetworkIsolationFeature{}).CheckSupported(); err != nil {
+		t.Skipf("Network isolation not supported: %v", err)
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [["/bin/bash", "-c", "ip -o link show > links.txt"]],
+		"maxRunTime": 30,
+		"features": {"networkIsolation": true},
+		"artifacts": [{"type": "file", "path": "links.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Task should have run successfully, but got error: %v", err)
+	}
+	if status != Succeeded {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Succeeded, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "links.txt"))
+	if err != nil {
+		t.Fatalf("Artifact links.txt was not saved locally: %v", err)
+	}
+	links := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(links) != 1 || !strings.Contains(links[0], ": lo:") {
+		t.Fatalf("Expected only a loopback interface, but found:\n%s", out)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"github.com/taskcluster/taskcluster-client-go/auth"
+)
+
+// configPrompt is a config setting that new-config asks for
+type configPrompt struct {
+	name        string
+	description string
+	// value used if nothing is entered; settings without a default are
+	// optional if not required
+	defaultValue string
+	required     bool
+}
+
+var configPrompts = []configPrompt{
+	{name: "provisionerId", description: "Provisioner id of the worker type", defaultValue: "aws-provisioner-v1", required: true},
+	{name: "workerType", description: "Worker type to claim tasks for", required: true},
+	{name: "workerGroup", description: "Worker group of this worker", required: true},
+	{name: "workerId", description: "Worker id of this worker", required: true},
+	{name: "clientId", description: "Taskcluster client id of the worker", required: true},
+	{name: "accessToken", description: "Taskcluster access token of the worker", required: true},
+	{name: "certificate", description: "Taskcluster certificate, if the credentials are temporary"},
+	{name: "publicIP", description: "IP address that clients are directed to for live logs", required: true},
+	{name: "livelogSecret", description: "Secret shared with the livelog proxy", required: true},
+	{name: "tasksDir", description: "Directory that task directories are created in"},
+}
+
+// currentScopes returns the scopes of the credentials in c, as reported by
+// auth.currentScopes
+var currentScopes = func(c *Config) ([]string, error) {
+	a := auth.New(
+		&tcclient.Credentials{
+			ClientID:    c.ClientID,
+			AccessToken: c.AccessToken,
+			Certificate: c.Certificate,
+		},
+	)
+	s, err := a.CurrentScopes()
+	if err != nil {
+		return nil, err
+	}
+	return s.Scopes, nil
+}
+
+// workerScopes returns the scopes the worker credentials need, in order to
+// claim tasks for the configured worker type
+func (c *Config) workerScopes() scopes.Required {
+	return scopes.Required{
+		{
+			"queue:poll-task-urls:" + c.ProvisionerID + "/" + c.WorkerType,
+			"queue:claim-task:" + c.ProvisionerID + "/" + c.WorkerType,
+			"queue:worker-id:" + c.WorkerGroup + "/" + c.WorkerID,
+		},
+	}
+}
+
+// newConfig asks for the required (and a few optional) config settings on in,
+// writes them to file, and checks that the resulting config is valid, that
+// the credentials have the scopes the worker needs, and that the tasks
+// directory is writable.
+func newConfig(file string, in io.Reader, out io.Writer) error {
+	settings := map[string]string{}
+	scanner := bufio.NewScanner(in)
+	for _, p := range configPrompts {
+		for {
+			fmt.Fprintf(out, "%v (%v)", p.description, p.name)
+			if p.defaultValue != "" {
+				fmt.Fprintf(out, " [%v]", p.defaultValue)
+			}
+			fmt.Fprint(out, ": ")
+			value := ""
+			more := scanner.Scan()
+			if more {
+				value = strings.TrimSpace(scanner.Text())
+			} else if err := scanner.Err(); err != nil {
+				return err
+			}
+			if value == "" {
+				value = p.defaultValue
+			}
+			if value != "" {
+				settings[p.name] = value
+				break
+			}
+			if !p.required {
+				break
+			}
+			if !more {
+				return MissingConfigError{Setting: p.name, File: file}
+			}
+			fmt.Fprintf(out, "Config setting %v is required\n", p.name)
+		}
+	}
+
+	err := writeToFileAsJSON(settings, file)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Created file "+file)
+
+	c, err := loadConfig(file, false)
+	if err != nil {
+		return fmt.Errorf("Config file %v is not valid: %s", file, err)
+	}
+
+	given, err := currentScopes(c)
+	if err != nil {
+		return fmt.Errorf("Could not check the scopes of client %v: %s", c.ClientID, err)
+	}
+	required := c.workerScopes()
+	if !scopes.Given(given).Satisfies(required) {
+		return fmt.Errorf("Client %v does not have the scopes the worker needs: %v", c.ClientID, strings.Join(required[0], ", "))
+	}
+	fmt.Fprintln(out, "Client "+c.ClientID+" has the scopes the worker needs")
+
+	if c.TasksDir != "" {
+		free, err := probeDir(c.TasksDir)
+		if err != nil {
+			return fmt.Errorf("Tasks directory %v is not usable: %s", c.TasksDir, err)
+		}
+		fmt.Fprintf(out, "Tasks directory %v is writable, with %v MB free\n", c.TasksDir, free/1024/1024)
+	}
+	return nil
+}
+
+// probeDir creates dir if it does not exist, checks a file can be written to
+// it, and returns the free disk space of the volume it is on
+func probeDir(dir string) (uint64, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return 0, err
+	}
+	probe := filepath.Join(dir, ".generic-worker-probe")
+	err = ioutil.WriteFile(probe, []byte("probe"), 0644)
+	if err != nil {
+		return 0, err
+	}
+	err = os.Remove(probe)
+	if err != nil {
+		return 0, err
+	}
+	return freeDiskSpace(dir)
+}
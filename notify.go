@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// taskEvent is posted as json to each of config.NotificationWebhooks when a
+// task starts and when it is resolved. The text property is a human readable
+// summary, so that chat services (such as Slack incoming webhooks) can
+// display the event without further processing.
+type taskEvent struct {
+	Event         string `json:"event"`
+	TaskID        string `json:"taskId"`
+	RunID         uint   `json:"runId"`
+	ProvisionerID string `json:"provisionerId"`
+	WorkerType    string `json:"workerType"`
+	WorkerGroup   string `json:"workerGroup"`
+	WorkerID      string `json:"workerId"`
+	Status        string `json:"status,omitempty"`
+	Reason        string `json:"reason,omitempty"`
+	Text          string `json:"text"`
+}
+
+// notify posts a taskEvent for the given event ("task-started" or
+// "task-resolved") to the configured webhooks, in the background. Delivery is
+// best effort; failures are only logged.
+func (task *TaskRun) notify(event string, err error) {
+	if len(config.NotificationWebhooks) == 0 {
+		return
+	}
+	e := taskEvent{
+		Event:         event,
+		TaskID:        task.TaskID,
+		RunID:         task.RunID,
+		ProvisionerID: config.ProvisionerID,
+		WorkerType:    config.WorkerType,
+		WorkerGroup:   config.WorkerGroup,
+		WorkerID:      config.WorkerID,
+	}
+	e.Text = fmt.Sprintf("Task %v/%v started on worker %v/%v", task.TaskID, task.RunID, config.WorkerType, config.WorkerID)
+	if event == "task-resolved" {
+		e.Status = string(task.Status)
+		if cee, ok := err.(*CommandExecutionError); ok {
+			e.Reason = cee.Reason
+		}
+		e.Text = fmt.Sprintf("Task %v/%v resolved as %v on worker %v/%v", task.TaskID, task.RunID, task.Status, config.WorkerType, config.WorkerID)
+		if e.Reason != "" {
+			e.Text += " (" + e.Reason + ")"
+		}
+	}
+	body, errJSON := json.Marshal(e)
+	if errJSON != nil {
+		log.Printf("WARN: could not create %v notification: %s", event, errJSON)
+		return
+	}
+	for _, url := range config.NotificationWebhooks {
+		go postNotification(url, body)
+	}
+}
+
+// notifyWorkerEvent posts a taskEvent for an event of the worker itself,
+// rather than of a task, to the configured webhooks, waiting for delivery
+func notifyWorkerEvent(event, text string) {
+	e := taskEvent{
+		Event:         event,
+		ProvisionerID: config.ProvisionerID,
+		WorkerType:    config.WorkerType,
+		WorkerGroup:   config.WorkerGroup,
+		WorkerID:      config.WorkerID,
+		Text:          text,
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("WARN: could not create %v notification: %s", event, err)
+		return
+	}
+	for _, url := range config.NotificationWebhooks {
+		postNotification(url, body)
+	}
+}
+
+func postNotification(url string, body []byte) {
+	httpCall := func(client *http.Client) (*http.Response, error) {
+		return client.Post(url, "application/json", bytes.NewReader(body))
+	}
+	resp, attempts, err := retryPolicy("notification").retryHTTP("Posting notification to "+url, httpCall)
+	if err != nil {
+		log.Printf("WARN: could not post notification to %v after %v attempts: %s", url, strconv.Itoa(attempts), err)
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Configured webhooks should receive a json description of task events.
+func TestNotify(t *testing.T) {
+	events := make(chan taskEvent, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e taskEvent
+		err := json.NewDecoder(r.Body).Decode(&e)
+		if err != nil {
+			t.Errorf("Could not decode notification: %v", err)
+		}
+		events <- e
+	}))
+	defer server.Close()
+	defer func(c *Config) { config = c }(config)
+	config = &Config{
+		WorkerType:           "test-worker-type",
+		NotificationWebhooks: []string{server.URL},
+	}
+	task := &TaskRun{TaskID: "abc", RunID: 1, Status: Errored}
+	task.notify("task-resolved", ResourceUnavailable(errors.New("disk full")))
+	select {
+	case e := <-events:
+		if e.Event != "task-resolved" || e.TaskID != "abc" || e.Status != string(Errored) || e.Reason != "resource-unavailable" {
+			t.Fatalf("Unexpected notification: %#v", e)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("No notification received")
+	}
+}
@@ -9,8 +9,8 @@ import "os"
 // higher-level interfaces.
 //
 // If there is an error, it will be of type *PathError.
-func StartProcess(name string, argv []string, attr *os.ProcAttr, username, password string) (*Process, error) {
-	return startProcess(name, argv, attr, username, password)
+func StartProcess(name string, argv []string, attr *os.ProcAttr, username, password string, sessionID uint32) (*Process, error) {
+	return startProcess(name, argv, attr, username, password, sessionID)
 }
 
 // Wait waits for the Process to exit, and then returns a
@@ -22,6 +22,11 @@ func (p *Process) Wait() (*ProcessState, error) {
 	return p.wait()
 }
 
+// Kill causes the Process to exit immediately.
+func (p *Process) Kill() error {
+	return p.kill()
+}
+
 // Release releases any resources associated with the Process p,
 // rendering it unusable in the future.
 // Release only needs to be called if Wait is not.
@@ -98,6 +98,11 @@ type Cmd struct {
 	// if not set (i.e. empty strings), then they are not used
 	Username string
 	Password string
+
+	// If non-zero, the process is started in this Windows session, as the
+	// user logged on to it, on its interactive desktop, rather than as
+	// Username. Requires the calling process to run as LocalSystem.
+	SessionID uint32
 }
 
 // Start starts the specified command but does not wait for it to complete.
@@ -147,6 +152,7 @@ func (c *Cmd) Start() error {
 		},
 		c.Username,
 		c.Password,
+		c.SessionID,
 	)
 	if err != nil {
 		c.closeDescriptors(c.closeAfterStart)
@@ -11,7 +11,7 @@ import (
 	mysyscall "github.com/taskcluster/generic-worker/syscall"
 )
 
-func startProcess(name string, argv []string, attr *os.ProcAttr, username, password string) (p *Process, err error) {
+func startProcess(name string, argv []string, attr *os.ProcAttr, username, password string, sessionID uint32) (p *Process, err error) {
 	// If there is no SysProcAttr (ie. no Chroot or changed
 	// UID/GID), double-check existence of the directory we want
 	// to chdir into.  We can make the error clearer this way.
@@ -35,7 +35,7 @@ func startProcess(name string, argv []string, attr *os.ProcAttr, username, passw
 		sysattr.Files = append(sysattr.Files, f.Fd())
 	}
 
-	pid, h, e := mysyscall.StartProcess(name, argv, sysattr, username, password)
+	pid, h, e := mysyscall.StartProcess(name, argv, sysattr, username, password, sessionID)
 	if e != nil {
 		return nil, &os.PathError{Op: "fork/exec", Path: name, Err: e}
 	}
@@ -93,6 +93,15 @@ func (p *Process) release() error {
 	return nil
 }
 
+func (p *Process) kill() error {
+	if p.done() {
+		return errors.New("os: process already finished")
+	}
+	handle := atomic.LoadUintptr(&p.handle)
+	e := syscall.TerminateProcess(syscall.Handle(handle), 1)
+	return os.NewSyscallError("TerminateProcess", e)
+}
+
 func (p *Process) wait() (ps *ProcessState, err error) {
 	handle := atomic.LoadUintptr(&p.handle)
 	s, e := syscall.WaitForSingleObject(syscall.Handle(handle), syscall.INFINITE)
@@ -140,3 +149,7 @@ func newProcess(pid int, handle uintptr) *Process {
 func (p *Process) setDone() {
 	atomic.StoreUint32(&p.isdone, 1)
 }
+
+func (p *Process) done() bool {
+	return atomic.LoadUint32(&p.isdone) > 0
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	// env var references in payload paths, e.g. ${BUILD_DIR}
+	envVarReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+	// e.g. C:/ or C:
+	windowsDrive = regexp.MustCompile(`^[A-Za-z]:`)
+)
+
+// normalisePayloadPath interprets a path given in the task payload, relative
+// to the task directory: env var references of the form ${NAME} are replaced
+// with the value from the payload env, backslashes are treated as path
+// separators on Windows (elsewhere they are legal in file names), and the
+// path is cleaned. The result uses forward slashes, and is "." for the task
+// directory itself. Absolute paths, and paths outside the task directory, are
+// rejected, with an error naming the payload entry.
+func normalisePayloadPath(entry, p string, env map[string]string) (string, error) {
+	var undefined []string
+	expanded := envVarReference.ReplaceAllStringFunc(p, func(ref string) string {
+		name := envVarReference.FindStringSubmatch(ref)[1]
+		value, ok := env[name]
+		if !ok {
+			undefined = append(undefined, name)
+		}
+		return value
+	})
+	if len(undefined) > 0 {
+		return "", fmt.Errorf("%v: path %q references env var(s) %v not defined in the payload env", entry, p, strings.Join(undefined, ", "))
+	}
+	slashed := expanded
+	if runtime.GOOS == "windows" {
+		slashed = strings.Replace(expanded, `\`, "/", -1)
+	}
+	if strings.HasPrefix(slashed, "/") || runtime.GOOS == "windows" && windowsDrive.MatchString(slashed) {
+		return "", fmt.Errorf("%v: path %q must be relative to the task directory, not absolute", entry, p)
+	}
+	cleaned := path.Clean(slashed)
+	if cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("%v: path %q must be inside the task directory", entry, p)
+	}
+	return cleaned, nil
+}
+
+// normalisePayloadPaths normalises the artifact paths and result file path of
+// the task payload, see normalisePayloadPath
+func (task *TaskRun) normalisePayloadPaths() error {
+	env := map[string]string{}
+	if task.Payload.Env != nil {
+		err := json.Unmarshal(task.Payload.Env, &env)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range task.Payload.Artifacts {
+		p, err := normalisePayloadPath(fmt.Sprintf("artifacts[%v]", i), task.Payload.Artifacts[i].Path, env)
+		if err != nil {
+			return err
+		}
+		task.Payload.Artifacts[i].Path = p
+	}
+	if task.Payload.ResultFile != "" {
+		p, err := normalisePayloadPath("resultFile", task.Payload.ResultFile, env)
+		if err != nil {
+			return err
+		}
+		task.Payload.ResultFile = p
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// perf data files are written to this directory, relative to the task
+// directory, which is also the artifact name prefix they are published under
+const perfDataDir = "public/perf"
+
+type PerfFeature struct {
+}
+
+type PerfTask struct {
+	task *TaskRun
+}
+
+func (feature *PerfFeature) Name() string {
+	return "Perf Profiling"
+}
+
+func (feature *PerfFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *PerfFeature) Initialise() error {
+	return nil
+}
+
+func (feature *PerfFeature) IsEnabled(task *TaskRun) bool {
+	return len(task.Payload.Perf.Events) > 0
+}
+
+func (feature *PerfFeature) CheckSupported() error {
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("Feature %q is only supported on Linux", feature.Name())
+	}
+	if !config.Perf {
+		return fmt.Errorf("Feature %q is not enabled on this worker (see config setting perf)", feature.Name())
+	}
+	_, err := exec.LookPath("perf")
+	if err != nil {
+		return fmt.Errorf("Feature %q requires perf to be installed on the worker: %s", feature.Name(), err)
+	}
+	return nil
+}
+
+func (feature *PerfFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &PerfTask{
+		task: task,
+	}
+}
+
+func (p *PerfTask) RequiredScopes() scopes.Required {
+	// perf exposes kernel and hardware events, so access is restricted
+	return scopes.Required{
+		{
+			"generic-worker:perf:" + config.ProvisionerID + "/" + config.WorkerType,
+		},
+	}
+}
+
+// Start creates the directory for perf data files, and flags that task
+// commands should be profiled (see profile in plat_linux.go).
+func (p *PerfTask) Start() error {
+	dir := filepath.Join(TaskDir, filepath.FromSlash(perfDataDir))
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		return err
+	}
+	p.task.perfDataDir = dir
+	return nil
+}
+
+// Stop publishes the perf data files of the task commands that were run.
+func (p *PerfTask) Stop() error {
+	for i := range p.task.Payload.Command {
+		artifact := fmt.Sprintf("%v/command_%06d.data", perfDataDir, i)
+		_, err := os.Stat(filepath.Join(TaskDir, filepath.FromSlash(artifact)))
+		if err != nil {
+			// command was not run, or perf failed to record it
+			continue
+		}
+		err = p.task.uploadArtifact(
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: artifact,
+					Expires:       p.task.Definition.Expires,
+				},
+				MimeType: "application/octet-stream",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
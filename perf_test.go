@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Perf data files should be published for the task commands that were
+// profiled.
+func TestPerfArtifacts(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-perf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	TaskDir = tempDir
+	task := &TaskRun{localArtifactsDir: filepath.Join(tempDir, "artifacts")}
+	task.Payload.Command = make([]json.RawMessage, 2)
+	task.Payload.Perf.Events = []string{"cycles"}
+	if !(&PerfFeature{}).IsEnabled(task) {
+		t.Fatal("Perf feature should be enabled when payload specifies perf events")
+	}
+	p := (&PerfFeature{}).NewTaskFeature(task)
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Could not start perf feature: %v", err)
+	}
+	if task.perfDataDir != filepath.Join(tempDir, "public", "perf") {
+		t.Fatalf("Perf data directory not set for task commands: %q", task.perfDataDir)
+	}
+	// only the first command ran
+	err = ioutil.WriteFile(filepath.Join(task.perfDataDir, "command_000000.data"), []byte("perf data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Could not publish perf data: %v", err)
+	}
+	if len(task.Artifacts) != 1 || task.Artifacts[0].Base().CanonicalPath != "public/perf/command_000000.data" {
+		t.Fatalf("Expected only perf data of command 0 to be published, but got %v", task.Artifacts)
+	}
+	if _, err := os.Stat(filepath.Join(task.localArtifactsDir, "public", "perf", "command_000000.data")); err != nil {
+		t.Fatalf("Perf data was not published: %v", err)
+	}
+}
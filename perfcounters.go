@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// performance counter samples are written to this file, relative to the task
+// directory, which is also the name of the artifact they are published as
+const performanceCountersFile = "public/perf/counters.csv"
+
+// a var so that tests can replace it
+var typeperfExecutable = "typeperf"
+
+type PerformanceCountersFeature struct {
+}
+
+type PerformanceCountersTask struct {
+	task     *TaskRun
+	typeperf *exec.Cmd
+}
+
+func (feature *PerformanceCountersFeature) Name() string {
+	return "Performance Counters"
+}
+
+func (feature *PerformanceCountersFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *PerformanceCountersFeature) Initialise() error {
+	return nil
+}
+
+func (feature *PerformanceCountersFeature) IsEnabled(task *TaskRun) bool {
+	return len(task.Payload.PerformanceCounters) > 0
+}
+
+func (feature *PerformanceCountersFeature) CheckSupported() error {
+	if runtime.GOOS != "windows" {
+		return fmt.Errorf("Feature %q is only supported on Windows", feature.Name())
+	}
+	return nil
+}
+
+func (feature *PerformanceCountersFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &PerformanceCountersTask{
+		task: task,
+	}
+}
+
+func (p *PerformanceCountersTask) RequiredScopes() scopes.Required {
+	// counters are read only, and sampling once a second is cheap, so no need
+	// to control access to this feature
+	return scopes.Required{}
+}
+
+// Start runs typeperf in the background, sampling the requested counters
+// every second until the task completes.
+func (p *PerformanceCountersTask) Start() error {
+	file := filepath.Join(TaskDir, filepath.FromSlash(performanceCountersFile))
+	err := os.MkdirAll(filepath.Dir(file), 0777)
+	if err != nil {
+		return err
+	}
+	args := append([]string{}, p.task.Payload.PerformanceCounters...)
+	args = append(args, "-si", "1", "-f", "CSV", "-o", file, "-y")
+	p.typeperf = exec.Command(typeperfExecutable, args...)
+	p.task.Log("Recording performance counters to " + performanceCountersFile)
+	return p.typeperf.Start()
+}
+
+// Stop stops typeperf, and publishes the samples it recorded.
+func (p *PerformanceCountersTask) Stop() error {
+	err := p.typeperf.Process.Kill()
+	if err != nil {
+		log.Printf("WARN: could not stop typeperf: %s", err)
+	}
+	// typeperf exits with an error when killed
+	_ = p.typeperf.Wait()
+	return p.task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: performanceCountersFile,
+				Expires:       p.task.Definition.Expires,
+			},
+			MimeType: "text/csv",
+		},
+	)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/taskcluster/taskcluster-client-go/queue"
+)
+
+// The requested counters should be sampled with typeperf while the task runs,
+// and the samples published when the task completes.
+func TestPerformanceCounters(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-perf-counters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(dir string) {
+		TaskDir = dir
+	}(TaskDir)
+	TaskDir = tempDir
+	feature := &PerformanceCountersFeature{}
+	if runtime.GOOS == "windows" {
+		if err := feature.CheckSupported(); err != nil {
+			t.Fatalf("Performance counters should be supported on Windows, but got: %v", err)
+		}
+	} else {
+		if err := feature.CheckSupported(); err == nil {
+			t.Fatal("Performance counters should only be supported on Windows")
+		}
+		// fake typeperf, which writes its arguments to the output file
+		defer func(executable string) {
+			typeperfExecutable = executable
+		}(typeperfExecutable)
+		typeperfExecutable = filepath.Join(tempDir, "typeperf")
+		script := "#!/bin/sh\nfor a in \"$@\"; do [ \"$prev\" = -o ] && out=\"$a\"; prev=\"$a\"; done\necho \"$@\" > \"$out\"\nexec sleep 30\n"
+		err = ioutil.WriteFile(typeperfExecutable, []byte(script), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	task := &TaskRun{
+		logWriter:         ioutil.Discard,
+		localArtifactsDir: filepath.Join(tempDir, "artifacts"),
+	}
+	err = json.Unmarshal([]byte(`{"performanceCounters": ["\\Processor(_Total)\\% Processor Time"]}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !feature.IsEnabled(task) {
+		t.Fatal("Performance counters should be enabled when payload specifies performanceCounters")
+	}
+	p := feature.NewTaskFeature(task)
+	err = p.Start()
+	if err != nil {
+		t.Fatalf("Could not start recording performance counters: %v", err)
+	}
+	// wait for the first sample
+	samples := filepath.Join(tempDir, filepath.FromSlash(performanceCountersFile))
+	for i := 0; i < 50; i++ {
+		if info, err := os.Stat(samples); err == nil && info.Size() > 0 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	err = p.Stop()
+	if err != nil {
+		t.Fatalf("Could not publish performance counters: %v", err)
+	}
+	published, err := ioutil.ReadFile(filepath.Join(task.localArtifactsDir, "public", "perf", "counters.csv"))
+	if err != nil {
+		t.Fatalf("Performance counters were not published: %v", err)
+	}
+	if !strings.Contains(string(published), "Processor") {
+		t.Fatalf("Expected published performance counters to include the requested counter, but got:\n%s", published)
+	}
+}
+
+// Counters are passed to typeperf as arguments, so anything that is not a
+// counter path (e.g. a typeperf option) should not pass payload validation.
+func TestPerformanceCountersValidation(t *testing.T) {
+	command := `[["echo", "hello"]]`
+	if runtime.GOOS == "windows" {
+		command = `["echo hello"]`
+	}
+	config = &Config{}
+	for counter, valid := range map[string]bool{
+		`\\Processor(_Total)\\% Processor Time`: true,
+		`-o`:                                    false,
+		`C:\\counters.csv`:                      false,
+	} {
+		payload := json.RawMessage(`{"command": ` + command + `, "maxRunTime": 30, "performanceCounters": ["` + counter + `"]}`)
+		task := TaskRun{Definition: queue.TaskDefinitionResponse{Payload: payload}}
+		err := task.validatePayload()
+		if valid && err != nil {
+			t.Errorf("Performance counter %q should have passed validation, but got: %v", counter, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("Performance counter %q should not have passed validation", counter)
+		}
+	}
+}
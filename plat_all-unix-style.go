@@ -10,7 +10,10 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 )
 
 func exceptionOrFailure(errCommand error) *CommandExecutionError {
@@ -21,7 +24,18 @@ func exceptionOrFailure(errCommand error) *CommandExecutionError {
 			TaskStatus: Failed,
 		}
 	}
-	return WorkerShutdown(errCommand)
+	return InternalError(errCommand)
+}
+
+// hostsFile is modified by payload hostOverrides, and is a variable so that
+// tests can use their own
+var hostsFile = "/etc/hosts"
+
+const defaultImageManifestFile = "/etc/generic-worker/image-manifest.json"
+
+// longPath is only needed on Windows, to work around MAX_PATH limitations
+func longPath(path string) string {
+	return path
 }
 
 func immediateShutdown() {
@@ -32,6 +46,14 @@ func immediateShutdown() {
 	}
 }
 
+func immediateReboot() {
+	cmd := exec.Command("shutdown", "-r", "now")
+	err := cmd.Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 // we put this in init() instead of startup() as we want tests to be able to change
 // it - note we shouldn't have these nasty global vars, I can only apologise, and
 // say taskcluster-worker will be much nicer
@@ -45,31 +67,189 @@ func init() {
 		Name:     "",
 		Password: "",
 	}
+	TaskDir = pwd
 }
 
 func startup() error {
 	log.Printf("Detected %s platform", runtime.GOOS)
-	return os.MkdirAll(filepath.Join(TaskUser.HomeDir, "public", "logs"), 0700)
+	if config.TasksDir != "" {
+		TaskDir = config.TasksDir
+	}
+	if config.Umask != "" {
+		// already validated in loadConfig
+		mask, _ := parseUmask(config.Umask)
+		// inherited by task commands, so also applies to files they create
+		previous := syscall.Umask(mask)
+		log.Printf("Changed umask from %04o to %04o", previous, mask)
+	}
+	return os.MkdirAll(filepath.Join(TaskDir, "public", "logs"), 0700)
 }
 
 func (task *TaskRun) generateCommand(index int) error {
-	cmd := exec.Command(task.Payload.Command[index][0], task.Payload.Command[index][1:]...)
-	cmd.Stdout = task.logWriter
-	cmd.Stderr = task.logWriter
+	spec := task.commandSpecs[index]
+	args := task.isolateNetwork(task.sandbox(task.profile(index, task.confine(spec.Command))))
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = spec.dir()
+	// run in a new process group, so that kill() can signal all processes the
+	// command starts
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Stdout = task.commandOutput()
+	cmd.Stderr = task.commandOutput()
 	// cmd.Stdout = log
 	// cmd.Stderr = log
 	err := task.prepEnvVars(cmd)
 	if err != nil {
 		return err
 	}
+	for i, j := range spec.Env {
+		log.Printf("Setting env var: %v", i)
+		cmd.Env = append(cmd.Env, i+"="+j)
+	}
 	task.Commands[index] = Command{osCommand: cmd}
 	return nil
 }
 
+// snapshotCommands returns the commands (keyed by the file their output
+// should be written to) that describe the processes of the command, see
+// snapshotProcesses
+func (c *Command) snapshotCommands() map[string][]string {
+	pgid := c.osCommand.(*exec.Cmd).Process.Pid
+	return map[string][]string{
+		"open_files.txt": {"lsof", "-n", "-P", "-g", strconv.Itoa(pgid)},
+	}
+}
+
+// processes lists the processes in the process group of the command. Other
+// processes on the host are left out, since their command lines (e.g. those
+// of other tasks, or the worker) may contain secrets, and the listing is
+// published as a public artifact. ps has no portable option to select a
+// process group, so its output is filtered.
+func (c *Command) processes() ([]byte, error) {
+	pgid := strconv.Itoa(c.osCommand.(*exec.Cmd).Process.Pid)
+	output, err := exec.Command("ps", "-A", "-o", "pgid,pid,ppid,etime,time,stat,command").Output()
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.SplitAfter(string(output), "\n")
+	// keep the header
+	processes := lines[0]
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); len(fields) > 0 && fields[0] == pgid {
+			processes += line
+		}
+	}
+	return []byte(processes), nil
+}
+
+// kill sends SIGTERM to the process group of the command, so that its
+// processes have a chance to flush partially written results, and then
+// SIGKILL if they have not all exited after config.KillGracePeriodSecs.
+func (c *Command) kill() error {
+	pgid := c.osCommand.(*exec.Cmd).Process.Pid
+	err := syscall.Kill(-pgid, syscall.SIGTERM)
+	if err != nil {
+		return err
+	}
+	deadline := time.Now().Add(time.Duration(config.KillGracePeriodSecs) * time.Second)
+	for time.Now().Before(deadline) {
+		// signal 0 only checks whether any process of the group still exists
+		if syscall.Kill(-pgid, 0) == syscall.ESRCH {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	err = syscall.Kill(-pgid, syscall.SIGKILL)
+	if err == syscall.ESRCH {
+		return nil
+	}
+	return err
+}
+
+// freeDiskSpace returns the number of bytes available to unprivileged users
+// on the volume containing path
+func freeDiskSpace(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	err := syscall.Statfs(path, &stat)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(stat.Bavail) * uint64(stat.Bsize), nil
+}
+
 func taskCleanup() error {
 	return nil
 }
 
+// applyLocale sets the time zone and language of the task commands via env
+// vars, so there is nothing to restore afterwards (see LocaleFeature)
+func (task *TaskRun) applyLocale() (restore func() error, err error) {
+	if tz := task.Payload.Locale.TimeZone; tz != "" {
+		task.setEnvVar("TZ", tz)
+	}
+	if lang := task.Payload.Locale.Language; lang != "" {
+		task.setEnvVar("LANG", lang)
+		task.setEnvVar("LC_ALL", lang)
+	}
+	return func() error { return nil }, nil
+}
+
+// there are no Windows sessions on this platform, so tasks requiring one are
+// rejected (see InteractiveUIFeature)
+func workerSessionID() (uint32, error) {
+	return 0, fmt.Errorf("Windows sessions are not available on %v", runtime.GOOS)
+}
+
+func consoleSessionID() (uint32, string, error) {
+	return 0, "", fmt.Errorf("Windows sessions are not available on %v", runtime.GOOS)
+}
+
+// there are no scheduled tasks or registry on this platform, so tasks
+// requiring their cleanup are rejected (see CleanupFeature)
+func listScheduledTasks() ([]scheduledTask, error) {
+	return nil, fmt.Errorf("Scheduled tasks are not available on %v", runtime.GOOS)
+}
+
+func removeScheduledTask(name string) error {
+	return fmt.Errorf("Scheduled tasks are not available on %v", runtime.GOOS)
+}
+
+func deleteRegistryKey(key string) error {
+	return fmt.Errorf("The registry is not available on %v", runtime.GOOS)
+}
+
+// the task directory is shared by all tasks, so cannot be kept
+func perTaskDirs() bool {
+	return false
+}
+
+// enableCrashDumps lifts the soft limit on core file size of the worker
+// process, which task commands inherit, and configures core files to be
+// written to dir (platform specific).
+func enableCrashDumps(dir string) (restore func() error, err error) {
+	var oldLimit syscall.Rlimit
+	err = syscall.Getrlimit(syscall.RLIMIT_CORE, &oldLimit)
+	if err != nil {
+		return nil, err
+	}
+	err = syscall.Setrlimit(syscall.RLIMIT_CORE, &syscall.Rlimit{Cur: oldLimit.Max, Max: oldLimit.Max})
+	if err != nil {
+		return nil, err
+	}
+	restoreDir, err := setCoreDumpDir(dir)
+	if err != nil {
+		syscall.Setrlimit(syscall.RLIMIT_CORE, &oldLimit)
+		return nil, err
+	}
+	return func() error {
+		err := restoreDir()
+		errLimit := syscall.Setrlimit(syscall.RLIMIT_CORE, &oldLimit)
+		if err != nil {
+			return err
+		}
+		return errLimit
+	}, nil
+}
+
 func install(arguments map[string]interface{}) (err error) {
 	return nil
 }
@@ -79,7 +259,7 @@ func (task *TaskRun) prepEnvVars(cmd *exec.Cmd) error {
 	taskEnv := []string{}
 	for _, j := range workerEnv {
 		if !strings.HasPrefix(j, "TASKCLUSTER_ACCESS_TOKEN=") {
-			log.Printf("Setting env var: %v", j)
+			log.Printf("Setting env var: %v", strings.SplitN(j, "=", 2)[0])
 			taskEnv = append(taskEnv, j)
 		}
 	}
@@ -90,15 +270,23 @@ func (task *TaskRun) prepEnvVars(cmd *exec.Cmd) error {
 			return err
 		}
 		for i, j := range envVars {
-			log.Printf("Setting env var: %v=%v", i, j)
+			log.Printf("Setting env var: %v", i)
 			taskEnv = append(taskEnv, i+"="+j)
 		}
-		cmd.Env = taskEnv
 	}
-	log.Printf("Environment: %v", taskEnv)
+	for i, j := range task.featureEnv {
+		log.Printf("Setting env var: %v", i)
+		taskEnv = append(taskEnv, i+"="+j)
+	}
+	// always set, so that env vars given for a single command are added to
+	// the task env, rather than replacing it (see generateCommand)
+	cmd.Env = taskEnv
 	return nil
 }
 
+// commandLine is the program to run followed by its arguments
+type commandLine []string
+
 func (task *TaskRun) describeCommand(index int) string {
-	return fmt.Sprintf("%q", task.Payload.Command[index])
+	return fmt.Sprintf("%q", task.commandSpecs[index].Command)
 }
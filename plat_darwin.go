@@ -1,17 +1,46 @@
 package main
 
 import (
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dchest/uniuri"
 )
 
+// AppArmor is not available on macOS, so commands are never confined
+func (task *TaskRun) confine(command []string) []string {
+	return command
+}
+
+// perf is not available on macOS, so tasks requiring it are rejected (see
+// PerfFeature)
+func (task *TaskRun) profile(index int, command []string) []string {
+	return command
+}
+
+// thread stacks of other processes cannot be captured without a debugger
+func (c *Command) threadStacks() (string, error) {
+	return "", errors.New("Capturing thread stacks is not supported on macOS")
+}
+
+// bubblewrap is not available on macOS, so commands are never sandboxed
+func (task *TaskRun) sandbox(command []string) []string {
+	return command
+}
+
+// network namespaces are not available on macOS, so tasks requiring network
+// isolation are rejected (see NetworkIsolationFeature)
+func (task *TaskRun) isolateNetwork(command []string) []string {
+	return command
+}
+
 func deleteHomeDir(path string, user string) error {
 	log.Println("Removing home directory '" + path + "'...")
 	err := os.RemoveAll(path)
@@ -74,6 +103,29 @@ func (user *OSUser) createNewOSUser() error {
 	return err
 }
 
+// checkCrashDumpPrivileges returns an error if the worker cannot run sysctl
+// with sudo, without a password, to set kern.corefile
+func checkCrashDumpPrivileges() error {
+	return exec.Command("sudo", "-n", "sysctl", "-n", "kern.corefile").Run()
+}
+
+// setCoreDumpDir configures core files to be written to dir, via sysctl
+// kern.corefile
+func setCoreDumpDir(dir string) (restore func() error, err error) {
+	out, err := exec.Command("sysctl", "-n", "kern.corefile").Output()
+	if err != nil {
+		return nil, err
+	}
+	oldCoreFile := strings.TrimSpace(string(out))
+	err = exec.Command("sudo", "sysctl", "-w", "kern.corefile="+filepath.Join(dir, "core.%N.%P")).Run()
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return exec.Command("sudo", "sysctl", "-w", "kern.corefile="+oldCoreFile).Run()
+	}, nil
+}
+
 // Uses [A-Za-z0-9] characters (default set) to avoid strange escaping problems
 // that could potentially affect security. Prefixed with `pWd0_` to ensure
 // password contains a special character (_), lowercase and uppercase letters,
@@ -86,3 +138,127 @@ func (user *OSUser) createNewOSUser() error {
 func generatePassword() string {
 	return "pWd0_" + uniuri.NewLen(24)
 }
+
+func packageListCommand() []string {
+	return []string{"brew", "list", "--versions"}
+}
+
+func osVersionCommands() [][]string {
+	return [][]string{
+		{"uname", "-a"},
+		{"sw_vers"},
+	}
+}
+
+// networkCounters returns the bytes received and sent by all network
+// interfaces other than loopback, according to netstat
+func networkCounters() (NetworkUsage, error) {
+	output, err := exec.Command("netstat", "-ibn").Output()
+	if err != nil {
+		return NetworkUsage{}, err
+	}
+	usage := NetworkUsage{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		// each interface has one <Link#n> row with its totals, which lacks
+		// an address column for interfaces without a MAC address
+		if len(fields) < 10 || !strings.HasPrefix(fields[2], "<Link#") || strings.HasPrefix(fields[0], "lo") {
+			continue
+		}
+		received, sent := fields[len(fields)-5], fields[len(fields)-2]
+		r, err := strconv.ParseUint(received, 10, 64)
+		if err != nil {
+			return usage, err
+		}
+		s, err := strconv.ParseUint(sent, 10, 64)
+		if err != nil {
+			return usage, err
+		}
+		usage.BytesReceived += r
+		usage.BytesSent += s
+	}
+	return usage, nil
+}
+
+// availableMemory returns the number of bytes of free and inactive memory,
+// according to vm_stat
+func availableMemory() (uint64, error) {
+	output, err := exec.Command("vm_stat").Output()
+	if err != nil {
+		return 0, err
+	}
+	pageSize := uint64(4096)
+	pages := uint64(0)
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, "page size of") {
+			fields := strings.Fields(line)
+			for i, field := range fields {
+				if field == "of" && i+1 < len(fields) {
+					size, err := strconv.ParseUint(fields[i+1], 10, 64)
+					if err == nil {
+						pageSize = size
+					}
+				}
+			}
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || (parts[0] != "Pages free" && parts[0] != "Pages inactive") {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(parts[1]), "."), 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		pages += n
+	}
+	return pages * pageSize, nil
+}
+
+// totalMemory returns the number of bytes of physical memory, according to
+// sysctl hw.memsize
+func totalMemory() (uint64, error) {
+	output, err := exec.Command("sysctl", "-n", "hw.memsize").Output()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(output)), 10, 64)
+}
+
+// cpuFlags returns the CPU features reported by sysctl. These are not
+// reported on Apple silicon, which has no CPU features of interest.
+func cpuFlags() (map[string]bool, error) {
+	flags := map[string]bool{}
+	for _, name := range []string{"machdep.cpu.features", "machdep.cpu.leaf7_features"} {
+		output, err := exec.Command("sysctl", "-n", name).Output()
+		if err != nil {
+			continue
+		}
+		for _, flag := range strings.Fields(string(output)) {
+			flags[strings.ToLower(flag)] = true
+		}
+	}
+	return flags, nil
+}
+
+// hostFIPSMode returns false, since macOS has no system wide FIPS mode
+func hostFIPSMode() (bool, error) {
+	return false, nil
+}
+
+// gpuModel returns the chipset models of the displays listed by
+// system_profiler
+func gpuModel() (string, error) {
+	output, err := exec.Command("system_profiler", "SPDisplaysDataType").Output()
+	if err != nil {
+		return "", err
+	}
+	gpus := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) == 2 && parts[0] == "Chipset Model" {
+			gpus = append(gpus, strings.TrimSpace(parts[1]))
+		}
+	}
+	return strings.Join(gpus, "; "), nil
+}
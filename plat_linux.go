@@ -0,0 +1,294 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// confine returns the command line to run the given task command with, so
+// that it is confined by the AppArmor profile in the worker config, unless
+// the task has opted out (see UnconfinedFeature).
+func (task *TaskRun) confine(command []string) []string {
+	if config.AppArmorProfile == "" || task.Payload.Features.Unconfined {
+		return command
+	}
+	return append([]string{"aa-exec", "--profile", config.AppArmorProfile, "--"}, command...)
+}
+
+// profile returns the command line to run the given task command with, so
+// that it is profiled with perf, if the task requested it (see PerfFeature).
+// Teardown commands are not profiled.
+func (task *TaskRun) profile(index int, command []string) []string {
+	if task.perfDataDir == "" || index >= len(task.Payload.Command) {
+		return command
+	}
+	args := []string{"perf", "record", "-o", filepath.Join(task.perfDataDir, fmt.Sprintf("command_%06d.data", index))}
+	for _, event := range task.Payload.Perf.Events {
+		args = append(args, "-e", event)
+	}
+	if task.Payload.Perf.Frequency > 0 {
+		args = append(args, "-F", strconv.Itoa(task.Payload.Perf.Frequency))
+	}
+	return append(append(args, "--"), command...)
+}
+
+// sandbox returns the command line to run the given task command with, so
+// that it runs in a bubblewrap sandbox, if config setting sandbox is true. In
+// the sandbox the file system is read only, except for the task directory,
+// and a private /tmp and home directory. /dev only has the standard devices,
+// plus /dev/kvm if the task requires it. The hosts file is replaced by one
+// with the host overrides of the task, if it has any. The worker config file
+// and any key files it refers to are hidden.
+func (task *TaskRun) sandbox(command []string) []string {
+	if !config.Sandbox {
+		return command
+	}
+	args := []string{
+		"bwrap",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+	}
+	if task.Payload.Devices.Kvm {
+		args = append(args, "--dev-bind", kvmDevice, kvmDevice)
+	}
+	if task.hostsFile != "" {
+		args = append(args, "--ro-bind", task.hostsFile, hostsFile)
+	}
+	if home := os.Getenv("HOME"); home != "" {
+		args = append(args, "--tmpfs", home)
+	}
+	args = append(args, "--bind", TaskDir, TaskDir)
+	// the task directory may contain the worker config, so hide secrets last
+	for _, secret := range []string{configFile, config.SigningKeyLocation, config.LiveLogKey, config.ClientKeyFile, config.SSHCertificateAuthorityKey} {
+		if secret == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(secret); err == nil {
+			if _, err := os.Stat(abs); err == nil {
+				args = append(args, "--ro-bind", "/dev/null", abs)
+			}
+		}
+	}
+	args = append(args, "--unshare-pid", "--die-with-parent", "--")
+	return append(args, command...)
+}
+
+// isolateNetwork returns the command line to run the given task command with,
+// so that it runs in a new network namespace with only a loopback interface,
+// if the task requires network isolation (see NetworkIsolationFeature).
+func (task *TaskRun) isolateNetwork(command []string) []string {
+	if !task.Payload.Features.NetworkIsolation {
+		return command
+	}
+	return append([]string{"unshare", "--net", "--", "/bin/sh", "-c", `ip link set lo up && exec "$0" "$@"`}, command...)
+}
+
+// a var so that tests can replace it
+var corePatternFile = "/proc/sys/kernel/core_pattern"
+
+// checkCrashDumpPrivileges returns an error if the worker cannot write to
+// /proc/sys/kernel/core_pattern, which requires root
+func checkCrashDumpPrivileges() error {
+	return syscall.Access(corePatternFile, 2) // W_OK
+}
+
+// setCoreDumpDir configures core files to be written to dir, via
+// /proc/sys/kernel/core_pattern
+func setCoreDumpDir(dir string) (restore func() error, err error) {
+	oldCorePattern, err := ioutil.ReadFile(corePatternFile)
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(corePatternFile, []byte(filepath.Join(dir, "core.%e.%p")), 0644)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioutil.WriteFile(corePatternFile, oldCorePattern, 0644)
+	}, nil
+}
+
+// threadStacks returns the kernel stacks of the threads of all processes in
+// the process group of the command, read from /proc (which requires root)
+func (c *Command) threadStacks() (string, error) {
+	pgid := strconv.Itoa(c.osCommand.(*exec.Cmd).Process.Pid)
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return "", err
+	}
+	var stacks bytes.Buffer
+	for _, proc := range procs {
+		pid := proc.Name()
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if err != nil {
+			continue
+		}
+		// the command name (field 2) is in parentheses, and may contain
+		// spaces, so fields are counted from the closing parenthesis
+		fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+		if len(fields) < 3 || fields[2] != pgid {
+			continue
+		}
+		threads, err := ioutil.ReadDir(filepath.Join("/proc", pid, "task"))
+		if err != nil {
+			continue
+		}
+		for _, thread := range threads {
+			stack, err := ioutil.ReadFile(filepath.Join("/proc", pid, "task", thread.Name(), "stack"))
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(&stacks, "=== process %v thread %v ===\n%s\n", pid, thread.Name(), stack)
+		}
+	}
+	return stacks.String(), nil
+}
+
+func packageListCommand() []string {
+	return []string{"dpkg-query", "-W", "-f", "${Package} ${Version}\n"}
+}
+
+func osVersionCommands() [][]string {
+	return [][]string{
+		{"uname", "-a"},
+		{"cat", "/etc/os-release"},
+	}
+}
+
+// networkCounters returns the bytes received and sent by all network
+// interfaces other than loopback, since they came up
+func networkCounters() (NetworkUsage, error) {
+	data, err := ioutil.ReadFile("/proc/net/dev")
+	if err != nil {
+		return NetworkUsage{}, err
+	}
+	return parseProcNetDev(string(data))
+}
+
+// parseProcNetDev sums the byte counters of the interfaces listed in the
+// content of /proc/net/dev, excluding loopback
+func parseProcNetDev(content string) (NetworkUsage, error) {
+	usage := NetworkUsage{}
+	for _, line := range strings.Split(content, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(parts[1])
+		if len(fields) < 9 {
+			continue
+		}
+		received, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return usage, fmt.Errorf("Could not parse bytes received by interface %v: %s", strings.TrimSpace(parts[0]), err)
+		}
+		sent, err := strconv.ParseUint(fields[8], 10, 64)
+		if err != nil {
+			return usage, fmt.Errorf("Could not parse bytes sent by interface %v: %s", strings.TrimSpace(parts[0]), err)
+		}
+		usage.BytesReceived += received
+		usage.BytesSent += sent
+	}
+	return usage, nil
+}
+
+// availableMemory returns the number of bytes of memory available for
+// starting new applications without swapping, according to /proc/meminfo
+func availableMemory() (uint64, error) {
+	return meminfo("MemAvailable")
+}
+
+// totalMemory returns the number of bytes of physical memory, according to
+// /proc/meminfo
+func totalMemory() (uint64, error) {
+	return meminfo("MemTotal")
+}
+
+// meminfo returns the number of bytes of the given /proc/meminfo entry
+func meminfo(key string) (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == key+":" && fields[2] == "kB" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			return kb * 1024, err
+		}
+	}
+	return 0, fmt.Errorf("Could not find %v in /proc/meminfo", key)
+}
+
+// hostFIPSMode returns whether the kernel runs in FIPS mode, according to
+// /proc/sys/crypto/fips_enabled
+func hostFIPSMode() (bool, error) {
+	data, err := ioutil.ReadFile("/proc/sys/crypto/fips_enabled")
+	if os.IsNotExist(err) {
+		// kernel built without FIPS support
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(string(data)) == "1", nil
+}
+
+// cpuFlags returns the flags of the first CPU in /proc/cpuinfo
+func cpuFlags() (map[string]bool, error) {
+	data, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+	return parseCPUInfoFlags(string(data)), nil
+}
+
+// parseCPUInfoFlags returns the flags (x86) or features (ARM) of the first
+// CPU in the given /proc/cpuinfo content
+func parseCPUInfoFlags(cpuinfo string) map[string]bool {
+	flags := map[string]bool{}
+	for _, line := range strings.Split(cpuinfo, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(parts[0])
+		if key != "flags" && key != "Features" {
+			continue
+		}
+		for _, flag := range strings.Fields(parts[1]) {
+			flags[strings.ToLower(flag)] = true
+		}
+		break
+	}
+	return flags
+}
+
+// gpuModel returns the VGA and 3D controllers listed by lspci, if installed
+func gpuModel() (string, error) {
+	if _, err := exec.LookPath("lspci"); err != nil {
+		return "", nil
+	}
+	output, err := exec.Command("lspci").Output()
+	if err != nil {
+		return "", err
+	}
+	gpus := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, class := range []string{"VGA compatible controller: ", "3D controller: "} {
+			if i := strings.Index(line, class); i >= 0 {
+				gpus = append(gpus, strings.TrimSpace(line[i+len(class):]))
+			}
+		}
+	}
+	return strings.Join(gpus, "; "), nil
+}
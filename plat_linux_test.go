@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Task commands should be run via aa-exec when the worker config specifies an
+// AppArmor profile, unless the task has opted out with feature unconfined.
+func TestAppArmorProfile(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	command := []string{"/bin/bash", "-c", "echo hello"}
+	confined := []string{"aa-exec", "--profile", "task-profile", "--", "/bin/bash", "-c", "echo hello"}
+	for _, test := range []struct {
+		profile    string
+		unconfined bool
+		expected   []string
+	}{
+		{profile: "", unconfined: false, expected: command},
+		{profile: "task-profile", unconfined: false, expected: confined},
+		{profile: "task-profile", unconfined: true, expected: command},
+	} {
+		config = &Config{AppArmorProfile: test.profile}
+		task := &TaskRun{
+			commandSpecs: []commandSpec{{Command: command}},
+			Commands:     make([]Command, 1),
+		}
+		task.Payload.Features.Unconfined = test.unconfined
+		err := task.generateCommand(0)
+		if err != nil {
+			t.Fatalf("Could not generate command: %v", err)
+		}
+		args := task.Commands[0].osCommand.(*exec.Cmd).Args
+		if !reflect.DeepEqual(args, test.expected) {
+			t.Errorf("With appArmorProfile %q and unconfined %v, expected command %q but got %q", test.profile, test.unconfined, test.expected, args)
+		}
+	}
+}
+
+// Task commands, but not teardown commands, should be run via perf record
+// when the perf feature has been started for the task.
+func TestPerfProfile(t *testing.T) {
+	task := &TaskRun{}
+	err := json.Unmarshal([]byte(`{"command": [["true"], ["false"]], "teardown": [["true"]], "perf": {"events": ["cycles", "instructions"], "frequency": 99}}`), &task.Payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command := []string{"/bin/bash", "-c", "echo hello"}
+	if args := task.profile(1, command); !reflect.DeepEqual(args, command) {
+		t.Fatalf("Command should not be profiled before perf feature has started, but got %q", args)
+	}
+	task.perfDataDir = "/tasks/task_1/public/perf"
+	expected := []string{"perf", "record", "-o", "/tasks/task_1/public/perf/command_000001.data", "-e", "cycles", "-e", "instructions", "-F", "99", "--", "/bin/bash", "-c", "echo hello"}
+	if args := task.profile(1, command); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected profiled command %q but got %q", expected, args)
+	}
+	if args := task.profile(2, command); !reflect.DeepEqual(args, command) {
+		t.Fatalf("Teardown command should not be profiled, but got %q", args)
+	}
+}
+
+// With config setting sandbox, task commands should run under bubblewrap,
+// with only the task directory writable, and the worker config and key files
+// hidden.
+func TestSandbox(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-sandbox")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(c *Config, file string, dir string, home string) {
+		config = c
+		configFile = file
+		TaskDir = dir
+		os.Setenv("HOME", home)
+	}(config, configFile, TaskDir, os.Getenv("HOME"))
+	configFile = filepath.Join(tempDir, "generic-worker.config")
+	signingKey := filepath.Join(tempDir, "signing.key")
+	caKey := filepath.Join(tempDir, "ssh_ca")
+	for _, file := range []string{configFile, signingKey, caKey} {
+		err = ioutil.WriteFile(file, []byte("secret"), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	TaskDir = filepath.Join(tempDir, "task_1")
+	os.Setenv("HOME", "/home/worker")
+	command := []string{"/bin/bash", "-c", "echo hello"}
+
+	config = &Config{
+		SigningKeyLocation:         signingKey,
+		SSHCertificateAuthorityKey: caKey,
+		// does not exist, so there is nothing to hide
+		LiveLogKey: filepath.Join(tempDir, "livelog.key"),
+	}
+	task := &TaskRun{}
+	if args := task.sandbox(command); !reflect.DeepEqual(args, command) {
+		t.Fatalf("Command should not be sandboxed unless config setting sandbox is true, but got %q", args)
+	}
+
+	config.Sandbox = true
+	expected := []string{
+		"bwrap",
+		"--ro-bind", "/", "/",
+		"--dev", "/dev",
+		"--proc", "/proc",
+		"--tmpfs", "/tmp",
+		"--tmpfs", "/home/worker",
+		"--bind", TaskDir, TaskDir,
+		"--ro-bind", "/dev/null", configFile,
+		"--ro-bind", "/dev/null", signingKey,
+		"--ro-bind", "/dev/null", caKey,
+		"--unshare-pid", "--die-with-parent", "--",
+		"/bin/bash", "-c", "echo hello",
+	}
+	if args := task.sandbox(command); !reflect.DeepEqual(args, expected) {
+		t.Fatalf("Expected sandboxed command %q but got %q", expected, args)
+	}
+
+	// --dev only provides the standard devices
+	task.Payload.Devices.Kvm = true
+	if args := task.sandbox(command); !reflect.DeepEqual(args[10:13], []string{"--dev-bind", "/dev/kvm", "/dev/kvm"}) {
+		t.Fatalf("Expected /dev/kvm to be bound into the sandbox, but got %q", args)
+	}
+	task.Payload.Devices.Kvm = false
+
+	// host overrides only apply in the sandbox
+	task.hostsFile = filepath.Join(tempDir, "task-hosts")
+	if args := task.sandbox(command); !reflect.DeepEqual(args[10:13], []string{"--ro-bind", task.hostsFile, "/etc/hosts"}) {
+		t.Fatalf("Expected hosts file of the task to be bound into the sandbox, but got %q", args)
+	}
+	task.hostsFile = ""
+
+	// the SSH certificate authority key can sign certificates that grant
+	// access to any task, so tasks must not be able to read it
+	if _, err := exec.LookPath("bwrap"); err != nil {
+		t.Skip("Skipping running sandboxed command, since bwrap is not installed")
+	}
+	// /tmp is replaced in the sandbox, so the key must be elsewhere to be
+	// visible at all
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDir, err := ioutil.TempDir(cwd, "sandbox-secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(keyDir)
+	config.SSHCertificateAuthorityKey = filepath.Join(keyDir, "ssh_ca")
+	err = ioutil.WriteFile(config.SSHCertificateAuthorityKey, []byte("secret"), 0600)
+	if err == nil {
+		err = os.MkdirAll(TaskDir, 0700)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("HOME", "")
+	args := task.sandbox([]string{"cat", config.SSHCertificateAuthorityKey})
+	out, err := exec.Command(args[0], args[1:]...).Output()
+	if err != nil {
+		t.Skipf("Skipping running sandboxed command, since bwrap could not run it: %v", err)
+	}
+	if len(out) > 0 {
+		t.Fatalf("SSH certificate authority key should not be readable in sandbox, but read %q", out)
+	}
+}
+
+// Network usage should be summed over the interfaces in /proc/net/dev,
+// excluding loopback.
+func TestParseProcNetDev(t *testing.T) {
+	content := `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+    lo: 9876543   12345    0    0    0     0          0         0  9876543   12345    0    0    0     0       0          0
+  eth0: 1000000    2000    0    0    0     0          0         0   300000    1500    0    0    0     0       0          0
+  eth1:    5000      20    0    0    0     0          0         0      700      10    0    0    0     0       0          0
+`
+	usage, err := parseProcNetDev(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (NetworkUsage{BytesReceived: 1005000, BytesSent: 300700}); usage != expected {
+		t.Fatalf("Expected network usage %#v, excluding loopback, but got %#v", expected, usage)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,7 +14,9 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
+	"unsafe"
 
 	"github.com/contester/runlib/subprocess"
 	"github.com/dchest/uniuri"
@@ -21,6 +24,12 @@ import (
 	"golang.org/x/sys/windows/registry"
 )
 
+// hostsFile is modified by payload hostOverrides, and is a variable so that
+// tests can use their own
+var hostsFile = filepath.Join(os.Getenv("SystemRoot"), "System32", "drivers", "etc", "hosts")
+
+const defaultImageManifestFile = `C:\generic-worker\image-manifest.json`
+
 func immediateShutdown() {
 	cmd := exec.Command("C:\\Windows\\System32\\shutdown.exe", "/s")
 	err := cmd.Run()
@@ -29,6 +38,14 @@ func immediateShutdown() {
 	}
 }
 
+func immediateReboot() {
+	cmd := exec.Command("C:\\Windows\\System32\\shutdown.exe", "/r", "/t", "0")
+	err := cmd.Run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
 func exceptionOrFailure(errCommand error) *CommandExecutionError {
 	switch errCommand.(type) {
 	case *exec.ExitError:
@@ -37,7 +54,20 @@ func exceptionOrFailure(errCommand error) *CommandExecutionError {
 			TaskStatus: Failed,
 		}
 	}
-	return WorkerShutdown(errCommand)
+	return InternalError(errCommand)
+}
+
+// longPath returns the extended-length form (with \\?\ prefix) of an absolute
+// path, so that files and directories can be accessed even if their path
+// exceeds MAX_PATH (260 characters). Relative paths are returned unaltered.
+func longPath(path string) string {
+	if !filepath.IsAbs(path) || strings.HasPrefix(path, `\\?\`) {
+		return path
+	}
+	if strings.HasPrefix(path, `\\`) {
+		return `\\?\UNC\` + filepath.Clean(path)[2:]
+	}
+	return `\\?\` + filepath.Clean(path)
 }
 
 func processCommandOutput(callback func(line string), prog string, options ...string) error {
@@ -55,9 +85,49 @@ func processCommandOutput(callback func(line string), prog string, options ...st
 
 func startup() error {
 	log.Println("Detected Windows platform...")
+	if config.AntivirusExclusions {
+		err := excludeFromAntivirus()
+		if err != nil {
+			return err
+		}
+	}
 	return taskCleanup()
 }
 
+// excludeFromAntivirus adds the directories that tasks write to as Windows
+// Defender exclusions, since scanning every file as it is written roughly
+// doubles the time tasks take to write files
+func excludeFromAntivirus() error {
+	dirs := antivirusExclusions()
+	err := runCommands(false, "", "", antivirusExclusionCommand(dirs))
+	if err != nil {
+		return fmt.Errorf("Could not add Windows Defender exclusions: %s", err)
+	}
+	log.Printf("Added Windows Defender exclusions for directories: %v", strings.Join(dirs, ", "))
+	return nil
+}
+
+// antivirusExclusions returns the directories excluded from Windows Defender
+// when config setting antivirusExclusions is true. Only the directory that
+// task directories are created in is excluded; caches and downloads may hold
+// content fetched from anywhere, so are still scanned.
+func antivirusExclusions() []string {
+	if config.TasksDir != "" {
+		return []string{config.TasksDir}
+	}
+	return []string{config.UsersDir}
+}
+
+// antivirusExclusionCommand returns the command that adds dirs as Windows
+// Defender exclusions
+func antivirusExclusionCommand(dirs []string) []string {
+	paths := make([]string, len(dirs))
+	for i, dir := range dirs {
+		paths[i] = "'" + strings.Replace(dir, "'", "''", -1) + "'"
+	}
+	return []string{"powershell", "-NoProfile", "-Command", "Add-MpPreference -ExclusionPath " + strings.Join(paths, ",")}
+}
+
 func deleteHomeDir(path string, user string) error {
 	if !config.CleanUpTaskDirs {
 		log.Println("*NOT* Removing home directory '" + path + "' as 'cleanUpTaskDirs' is set to 'false' in generic worker config...")
@@ -65,7 +135,7 @@ func deleteHomeDir(path string, user string) error {
 	}
 
 	// first try using task user
-	passwordFile := filepath.Join(filepath.Dir(path), user, "_Passw0rd")
+	passwordFile := filepath.Join(config.UsersDir, user, "_Passw0rd")
 	password, err := ioutil.ReadFile(passwordFile)
 
 	if err == nil && string(password) != "" {
@@ -112,9 +182,25 @@ func createNewTaskUser() error {
 	if err != nil {
 		return err
 	}
+	TaskDir = TaskUser.HomeDir
+	if config.TasksDir != "" {
+		TaskDir = filepath.Join(config.TasksDir, userName)
+		// the task user is not able to create directories under tasksDir, so
+		// create its task directory for it, and grant it full control
+		err = os.MkdirAll(TaskDir, 0777)
+		if err != nil {
+			return err
+		}
+		err = runCommands(false, "", "", []string{
+			"icacls", TaskDir, "/grant", userName + ":(OI)(CI)F",
+		})
+		if err != nil {
+			return err
+		}
+	}
 	// run md command as new user, to trigger profile creation
 	err = runCommands(false, userName, password, []string{
-		"cmd", "/c", "md", filepath.Join(TaskUser.HomeDir, "public", "logs"),
+		"cmd", "/c", "md", filepath.Join(TaskDir, "public", "logs"),
 	})
 	if err != nil {
 		return err
@@ -171,7 +257,10 @@ func generatePassword() string {
 }
 
 func deleteExistingOSUsers() {
-	deleteHomeDirs()
+	deleteHomeDirs(config.UsersDir)
+	if config.TasksDir != "" {
+		deleteHomeDirs(config.TasksDir)
+	}
 	log.Println("Looking for existing task users to delete...")
 	err := processCommandOutput(deleteOSUserAccount, "wmic", "useraccount", "get", "name")
 	if err != nil {
@@ -180,10 +269,12 @@ func deleteExistingOSUsers() {
 	}
 }
 
-func deleteHomeDirs() {
-	homeDirsParent, err := os.Open(config.UsersDir)
+// deleteHomeDirs deletes the directories of previous task users under
+// parentDir, which is either usersDir or tasksDir
+func deleteHomeDirs(parentDir string) {
+	homeDirsParent, err := os.Open(parentDir)
 	if err != nil {
-		log.Println("WARNING: Could not open " + config.UsersDir + " directory to find old home directories to delete")
+		log.Println("WARNING: Could not open " + parentDir + " directory to find old home directories to delete")
 		log.Printf("%v", err)
 		return
 	}
@@ -194,15 +285,20 @@ func deleteHomeDirs() {
 		log.Printf("%v", err)
 		// don't return, since we may have partial listings
 	}
+	keptUsers := keptTaskUsers()
 	for _, file := range fi {
 		if file.IsDir() {
 			if fileName := file.Name(); strings.HasPrefix(fileName, "task_") {
-				path := filepath.Join(config.UsersDir, fileName)
+				path := filepath.Join(parentDir, fileName)
 				// fileName could be <user> or <user>.<hostname>...
 				user := fileName
 				if i := strings.IndexRune(user, '.'); i >= 0 {
 					user = user[:i]
 				}
+				if keptUsers[user] {
+					log.Println("Keeping directory '" + path + "' of failed task (see config setting keepTaskDirs)")
+					continue
+				}
 				// ignore any error occuring here, not a lot we can do about it...
 				deleteHomeDir(path, user)
 			}
@@ -226,11 +322,11 @@ func deleteOSUserAccount(line string) {
 func (task *TaskRun) generateCommand(index int) error {
 	// In order that capturing of log files works, create a custom .bat file
 	// for the task which redirects output to a log file...
-	env := filepath.Join(TaskUser.HomeDir, "env.txt")
-	dir := filepath.Join(TaskUser.HomeDir, "dir.txt")
+	env := filepath.Join(TaskDir, "env.txt")
+	dir := filepath.Join(TaskDir, "dir.txt")
 	commandName := fmt.Sprintf("command_%06d", index)
-	wrapper := filepath.Join(TaskUser.HomeDir, commandName+"_wrapper.bat")
-	script := filepath.Join(TaskUser.HomeDir, commandName+".bat")
+	wrapper := filepath.Join(TaskDir, commandName+"_wrapper.bat")
+	script := filepath.Join(TaskDir, commandName+".bat")
 	contents := ":: This script runs command " + strconv.Itoa(index) + " defined in TaskId " + task.TaskID + "..." + "\r\n"
 	contents += "@echo off\r\n"
 
@@ -252,11 +348,15 @@ func (task *TaskRun) generateCommand(index int) error {
 				return err
 			}
 			for envVar, envValue := range envVars {
-				log.Printf("Setting env var: %v=%v", envVar, envValue)
+				log.Printf("Setting env var: %v", envVar)
 				contents += "set " + envVar + "=" + envValue + "\r\n"
 			}
 		}
-		contents += "cd \"" + TaskUser.HomeDir + "\"" + "\r\n"
+		for envVar, envValue := range task.featureEnv {
+			log.Printf("Setting env var: %v", envVar)
+			contents += "set " + envVar + "=" + envValue + "\r\n"
+		}
+		contents += "cd \"" + TaskDir + "\"" + "\r\n"
 
 		// Otherwise get the env from the previous command
 	} else {
@@ -278,6 +378,22 @@ func (task *TaskRun) generateCommand(index int) error {
 		}
 	}
 
+	// apply any env vars and working directory given for this command. Unlike
+	// changes made by the command itself, these only apply to this command (as
+	// on other platforms), so the previous values are saved, and put back
+	// before the env and working directory are stored for the next command.
+	// An empty env var is the same as an unset one in cmd.exe, so unset env
+	// vars are put back too.
+	spec := task.commandSpecs[index]
+	for envVar, envValue := range spec.Env {
+		log.Printf("Setting env var: %v", envVar)
+		contents += "set \"tcsaved_" + envVar + "=%" + envVar + "%\"\r\n"
+		contents += "set " + envVar + "=" + envValue + "\r\n"
+	}
+	if spec.Cwd != "" {
+		contents += "pushd \"" + spec.dir() + "\"" + "\r\n"
+	}
+
 	// see http://blogs.msdn.com/b/oldnewthing/archive/2008/09/26/8965755.aspx
 	// need to explicitly unset as we rely on it later
 	contents += "set errorlevel=\r\n"
@@ -295,8 +411,17 @@ func (task *TaskRun) generateCommand(index int) error {
 	// store exit code
 	contents += "set tcexitcode=%errorlevel%\r\n"
 
+	// put back env vars and working directory given for this command
+	for envVar := range spec.Env {
+		contents += "set \"" + envVar + "=%tcsaved_" + envVar + "%\"\r\n"
+		contents += "set tcsaved_" + envVar + "=\r\n"
+	}
+	if spec.Cwd != "" {
+		contents += "popd\r\n"
+	}
+
 	// now store env for next command, unless this is the last command
-	if index != len(task.Payload.Command)-1 {
+	if index != len(task.commandSpecs)-1 {
 		contents += "set > " + env + "\r\n"
 		contents += "cd > " + dir + "\r\n"
 	}
@@ -318,7 +443,7 @@ func (task *TaskRun) generateCommand(index int) error {
 	// Now make the actual task a .bat script
 	fileContents := []byte(strings.Join([]string{
 		"@echo on",
-		task.Payload.Command[index],
+		string(spec.Command),
 		"@echo off",
 	}, "\r\n"))
 
@@ -344,15 +469,358 @@ func (task *TaskRun) generateCommand(index int) error {
 	cmd := exec.Command(command[0], command[1:]...)
 	cmd.Username = TaskUser.Name
 	cmd.Password = TaskUser.Password
-	cmd.Dir = TaskUser.HomeDir
+	cmd.SessionID = task.interactiveSession
+	cmd.Dir = TaskDir
+	// run in a new process group, so that kill() can send CTRL_BREAK to the
+	// processes the command starts, without also sending it to the worker
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
 	log.Println("Running command: '" + strings.Join(command, "' '") + "'")
-	cmd.Stdout = task.logWriter
-	cmd.Stderr = task.logWriter
+	cmd.Stdout = task.commandOutput()
+	cmd.Stderr = task.commandOutput()
 	// cmd.Stdin = strings.NewReader("blah blah")
 	task.Commands[index] = Command{osCommand: cmd}
 	return nil
 }
 
+// snapshotCommands returns the commands (keyed by the file their output
+// should be written to) that describe the processes of the command, see
+// snapshotProcesses
+func (c *Command) snapshotCommands() map[string][]string {
+	return map[string][]string{
+		"sockets.txt": {"netstat", "-ano"},
+	}
+}
+
+// processes lists the processes running on the host. tasklist cannot select
+// the processes started by the command, but does not show command lines
+// either, so the listing does not reveal secrets of other processes.
+func (c *Command) processes() ([]byte, error) {
+	return exec.Command("tasklist", "/v").Output()
+}
+
+// listScheduledTasks returns all scheduled tasks, with the user they run as,
+// see CleanupFeature
+func listScheduledTasks() ([]scheduledTask, error) {
+	out, err := exec.Command("schtasks", "/query", "/fo", "csv", "/v", "/nh").Output()
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(bytes.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	tasks := []scheduledTask{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		// columns are HostName, TaskName, ..., Run As User (15th), ...; each
+		// task is listed once per trigger
+		if len(record) > 14 && !seen[record[1]] {
+			seen[record[1]] = true
+			tasks = append(tasks, scheduledTask{Name: record[1], RunAs: record[14]})
+		}
+	}
+	return tasks, nil
+}
+
+func removeScheduledTask(name string) error {
+	return exec.Command("schtasks", "/delete", "/tn", name, "/f").Run()
+}
+
+// deleteRegistryKey deletes the given key, including all of its subkeys
+func deleteRegistryKey(key string) error {
+	return exec.Command("reg", "delete", key, "/f").Run()
+}
+
+// thread stacks of other processes cannot be captured without a debugger
+func (c *Command) threadStacks() (string, error) {
+	return "", errors.New("Capturing thread stacks is not supported on Windows")
+}
+
+// kill sends CTRL_BREAK to the process group of the command, so that its
+// processes have a chance to flush partially written results, and then kills
+// it if it has not exited after config.KillGracePeriodSecs. CTRL_BREAK only
+// reaches processes attached to the same console as the worker, so if it
+// cannot be sent, the command is killed straight away.
+func (c *Command) kill() error {
+	process := c.osCommand.(*exec.Cmd).Process
+	r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(process.Pid))
+	if r == 0 {
+		log.Printf("Could not send CTRL_BREAK to process %v, so killing it straight away: %v", process.Pid, err)
+		return process.Kill()
+	}
+	handle, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, uint32(process.Pid))
+	if err != nil {
+		// most likely the process has already exited
+		return process.Kill()
+	}
+	defer syscall.CloseHandle(handle)
+	event, err := syscall.WaitForSingleObject(handle, uint32(config.KillGracePeriodSecs*1000))
+	if err == nil && event == syscall.WAIT_OBJECT_0 {
+		return nil
+	}
+	return process.Kill()
+}
+
+// checkCrashDumpPrivileges returns an error if the worker cannot create the
+// Windows Error Reporting LocalDumps registry key, which requires
+// administrative privileges
+func checkCrashDumpPrivileges() error {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SOFTWARE\Microsoft\Windows\Windows Error Reporting`, registry.CREATE_SUB_KEY|registry.SET_VALUE)
+	if err != nil {
+		return err
+	}
+	return k.Close()
+}
+
+// enableCrashDumps configures Windows Error Reporting to write minidumps of
+// crashing processes to dir, see
+// https://msdn.microsoft.com/en-us/library/windows/desktop/bb787181(v=vs.85).aspx
+func enableCrashDumps(dir string) (restore func() error, err error) {
+	const localDumps = `SOFTWARE\Microsoft\Windows\Windows Error Reporting\LocalDumps`
+	k, _, err := registry.CreateKey(registry.LOCAL_MACHINE, localDumps, registry.ALL_ACCESS)
+	if err != nil {
+		return nil, fmt.Errorf(`Was not able to create registry key '%v' due to %s`, localDumps, err)
+	}
+	defer k.Close()
+	oldDumpFolder, _, errOldFolder := k.GetStringValue("DumpFolder")
+	oldDumpType, _, errOldType := k.GetIntegerValue("DumpType")
+	err = k.SetStringValue("DumpFolder", dir)
+	if err != nil {
+		return nil, fmt.Errorf(`Was not able to set registry entry '%v\DumpFolder' to %q due to %s`, localDumps, dir, err)
+	}
+	// 1 = minidump
+	err = k.SetDWordValue("DumpType", 1)
+	if err != nil {
+		return nil, fmt.Errorf(`Was not able to set registry entry '%v\DumpType' to 1 due to %s`, localDumps, err)
+	}
+	return func() error {
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, localDumps, registry.SET_VALUE)
+		if err != nil {
+			return err
+		}
+		defer k.Close()
+		var errFolder, errType error
+		if errOldFolder != nil {
+			// there was no previous value
+			errFolder = k.DeleteValue("DumpFolder")
+		} else {
+			errFolder = k.SetStringValue("DumpFolder", oldDumpFolder)
+		}
+		if errOldType != nil {
+			errType = k.DeleteValue("DumpType")
+		} else {
+			errType = k.SetDWordValue("DumpType", uint32(oldDumpType))
+		}
+		if errFolder != nil {
+			return errFolder
+		}
+		return errType
+	}, nil
+}
+
+var (
+	getDiskFreeSpaceEx           = syscall.NewLazyDLL("kernel32.dll").NewProc("GetDiskFreeSpaceExW")
+	processIdToSessionId         = syscall.NewLazyDLL("kernel32.dll").NewProc("ProcessIdToSessionId")
+	globalMemoryStatusEx         = syscall.NewLazyDLL("kernel32.dll").NewProc("GlobalMemoryStatusEx")
+	isProcessorFeature           = syscall.NewLazyDLL("kernel32.dll").NewProc("IsProcessorFeaturePresent")
+	generateConsoleCtrlEvent     = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+	wtsGetActiveConsoleSessionId = syscall.NewLazyDLL("kernel32.dll").NewProc("WTSGetActiveConsoleSessionId")
+	wtsQuerySessionInformation   = syscall.NewLazyDLL("wtsapi32.dll").NewProc("WTSQuerySessionInformationW")
+	wtsFreeMemory                = syscall.NewLazyDLL("wtsapi32.dll").NewProc("WTSFreeMemory")
+)
+
+// memoryStatusEx is the MEMORYSTATUSEX structure filled in by
+// GlobalMemoryStatusEx
+type memoryStatusEx struct {
+	Length               uint32
+	MemoryLoad           uint32
+	TotalPhys            uint64
+	AvailPhys            uint64
+	TotalPageFile        uint64
+	AvailPageFile        uint64
+	TotalVirtual         uint64
+	AvailVirtual         uint64
+	AvailExtendedVirtual uint64
+}
+
+// availableMemory returns the number of bytes of physical memory available
+func availableMemory() (uint64, error) {
+	status := memoryStatusEx{}
+	status.Length = uint32(unsafe.Sizeof(status))
+	r, _, err := globalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status)))
+	if r == 0 {
+		return 0, err
+	}
+	return status.AvailPhys, nil
+}
+
+// totalMemory returns the number of bytes of physical memory, according to
+// GlobalMemoryStatusEx
+func totalMemory() (uint64, error) {
+	status := memoryStatusEx{}
+	status.Length = uint32(unsafe.Sizeof(status))
+	r, _, err := globalMemoryStatusEx.Call(uintptr(unsafe.Pointer(&status)))
+	if r == 0 {
+		return 0, err
+	}
+	return status.TotalPhys, nil
+}
+
+// processorFeatures are the IsProcessorFeaturePresent features (PF_*
+// constants) that are reported as CPU flags, named as on Linux
+var processorFeatures = map[string]uintptr{
+	"vmx":     21, // PF_VIRT_FIRMWARE_ENABLED
+	"aes":     30, // PF_ARM_V8_CRYPTO_INSTRUCTIONS_AVAILABLE
+	"sse4_2":  38, // PF_SSE4_2_INSTRUCTIONS_AVAILABLE
+	"avx":     39, // PF_AVX_INSTRUCTIONS_AVAILABLE
+	"avx2":    40, // PF_AVX2_INSTRUCTIONS_AVAILABLE
+	"avx512f": 41, // PF_AVX512F_INSTRUCTIONS_AVAILABLE
+}
+
+// cpuFlags returns the CPU flags reported by IsProcessorFeaturePresent. Older
+// versions of Windows do not know of the newer features, so do not report
+// them.
+func cpuFlags() (map[string]bool, error) {
+	flags := map[string]bool{}
+	for flag, feature := range processorFeatures {
+		r, _, _ := isProcessorFeature.Call(feature)
+		flags[flag] = r != 0
+	}
+	return flags, nil
+}
+
+// hostFIPSMode returns whether the "System cryptography: Use FIPS compliant
+// algorithms" security policy is enabled
+func hostFIPSMode() (bool, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Control\Lsa\FipsAlgorithmPolicy`, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer k.Close()
+	enabled, _, err := k.GetIntegerValue("Enabled")
+	if err == registry.ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return enabled == 1, nil
+}
+
+// gpuModel returns the names of the video controllers reported by WMI
+func gpuModel() (string, error) {
+	output, err := exec.Command("wmic", "path", "win32_VideoController", "get", "name").Output()
+	if err != nil {
+		return "", err
+	}
+	gpus := []string{}
+	for _, line := range strings.Split(string(output), "\n")[1:] {
+		if name := strings.TrimSpace(line); name != "" {
+			gpus = append(gpus, name)
+		}
+	}
+	return strings.Join(gpus, "; "), nil
+}
+
+// freeDiskSpace returns the number of bytes available to the worker on the
+// volume containing path
+func freeDiskSpace(path string) (uint64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	r, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&free)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return free, nil
+}
+
+// each task user has its own task directory, unless tasks run as the current
+// user
+func perTaskDirs() bool {
+	return !config.RunTasksAsCurrentUser
+}
+
+// applyLocale sets the system time zone, and the regional format of the task
+// user, as given in the task payload. The returned function restores the
+// original system time zone (see LocaleFeature). The task user is deleted
+// after the task, so its regional format needn't be restored.
+func (task *TaskRun) applyLocale() (restore func() error, err error) {
+	restore = func() error { return nil }
+	if tz := task.Payload.Locale.TimeZone; tz != "" {
+		out, err := exec.Command("tzutil", "/g").Output()
+		if err != nil {
+			return nil, err
+		}
+		oldTZ := strings.TrimSpace(string(out))
+		err = runCommands(false, "", "", []string{"tzutil", "/s", tz})
+		if err != nil {
+			return nil, err
+		}
+		task.Log("Time zone set to " + tz)
+		restore = func() error {
+			return runCommands(false, "", "", []string{"tzutil", "/s", oldTZ})
+		}
+	}
+	if lang := task.Payload.Locale.Language; lang != "" {
+		err = runCommands(false, TaskUser.Name, TaskUser.Password, []string{"powershell", "-NoProfile", "-Command", "Set-Culture", lang})
+		if err != nil {
+			errRestore := restore()
+			if errRestore != nil {
+				log.Printf("WARN: could not restore time zone: %s", errRestore)
+			}
+			return nil, err
+		}
+		task.Log("Language set to " + lang)
+	}
+	return restore, nil
+}
+
+// workerSessionID returns the id of the Windows session that the worker runs
+// in, which is 0 when running as a Windows service
+func workerSessionID() (uint32, error) {
+	var session uint32
+	r, _, err := processIdToSessionId.Call(uintptr(os.Getpid()), uintptr(unsafe.Pointer(&session)))
+	if r == 0 {
+		return 0, err
+	}
+	return session, nil
+}
+
+// consoleSessionID returns the id of the session attached to the console, and
+// the name of the user logged on to it
+func consoleSessionID() (uint32, string, error) {
+	r, _, _ := wtsGetActiveConsoleSessionId.Call()
+	session := uint32(r)
+	if session == 0xFFFFFFFF {
+		return 0, "", errors.New("No session is attached to the console")
+	}
+	var buffer *uint16
+	var size uint32
+	r, _, err := wtsQuerySessionInformation.Call(
+		0, // WTS_CURRENT_SERVER_HANDLE
+		uintptr(session),
+		5, // WTSUserName
+		uintptr(unsafe.Pointer(&buffer)),
+		uintptr(unsafe.Pointer(&size)),
+	)
+	if r == 0 {
+		return 0, "", err
+	}
+	defer wtsFreeMemory.Call(uintptr(unsafe.Pointer(buffer)))
+	// size is in bytes, including the terminating null character
+	user := syscall.UTF16ToString((*[1 << 16]uint16)(unsafe.Pointer(buffer))[:size/2])
+	if user == "" {
+		return 0, "", fmt.Errorf("No user is logged on to console session %v", session)
+	}
+	return session, user, nil
+}
+
 func taskCleanup() error {
 	if config.RunTasksAsCurrentUser {
 		// dir, err := ioutil.TempDir("", "generic-worker")
@@ -362,7 +830,11 @@ func taskCleanup() error {
 		// TaskUser = OSUser{
 		// 	HomeDir: dir,
 		// }
-		err := os.MkdirAll(filepath.Join(TaskUser.HomeDir, "public", "logs"), 0700)
+		TaskDir = TaskUser.HomeDir
+		if config.TasksDir != "" {
+			TaskDir = config.TasksDir
+		}
+		err := os.MkdirAll(filepath.Join(TaskDir, "public", "logs"), 0700)
 		if err != nil {
 			return err
 		}
@@ -583,6 +1055,45 @@ func Error(c *exec.Cmd) ([]byte, error) {
 	return b.Bytes(), err
 }
 
+// commandLine is a line of a Windows .bat file
+type commandLine string
+
 func (task *TaskRun) describeCommand(index int) string {
-	return task.Payload.Command[index]
+	return string(task.commandSpecs[index].Command)
+}
+
+func packageListCommand() []string {
+	return []string{"wmic", "product", "get", "name,version"}
+}
+
+func osVersionCommands() [][]string {
+	return [][]string{
+		{"cmd", "/c", "ver"},
+		{"wmic", "qfe", "list", "brief"},
+	}
+}
+
+// networkCounters returns the bytes received and sent by all network
+// interfaces, according to the "Bytes" row of netstat -e
+func networkCounters() (NetworkUsage, error) {
+	output, err := exec.Command("netstat", "-e").Output()
+	if err != nil {
+		return NetworkUsage{}, err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[0] != "Bytes" {
+			continue
+		}
+		received, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return NetworkUsage{}, err
+		}
+		sent, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return NetworkUsage{}, err
+		}
+		return NetworkUsage{BytesReceived: received, BytesSent: sent}, nil
+	}
+	return NetworkUsage{}, errors.New("Could not find network byte counters in output of netstat -e")
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Only the directory that task directories are created in should be excluded
+// from Windows Defender, with single quotes escaped for PowerShell.
+func TestAntivirusExclusions(t *testing.T) {
+	config = &Config{UsersDir: `C:\Users`, CachesDir: `C:\generic-worker\caches`, DownloadsDir: `C:\generic-worker\downloads`}
+	if dirs, expected := antivirusExclusions(), []string{`C:\Users`}; !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("Expected antivirus exclusions %v but got %v", expected, dirs)
+	}
+	config.TasksDir = `C:\tasks`
+	if dirs, expected := antivirusExclusions(), []string{`C:\tasks`}; !reflect.DeepEqual(dirs, expected) {
+		t.Fatalf("Expected antivirus exclusions %v but got %v", expected, dirs)
+	}
+	command := antivirusExclusionCommand([]string{`C:\tasks`, `D:\Bob's tasks`})
+	expected := []string{"powershell", "-NoProfile", "-Command", `Add-MpPreference -ExclusionPath 'C:\tasks','D:\Bob''s tasks'`}
+	if !reflect.DeepEqual(command, expected) {
+		t.Fatalf("Expected command %q but got %q", expected, command)
+	}
+}
+
+// The cwd and env given for a command should only apply to that command, even
+// though on Windows changes made by a command carry over to later commands.
+func TestCommandCwdAndEnvNotCarriedOver(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [
+			{"command": "echo [%GREETING%]> out.txt", "cwd": "subdir", "env": {"GREETING": "hello"}},
+			"echo [%GREETING%]> out.txt"
+		],
+		"maxRunTime": 30,
+		"artifacts": [
+			{"type": "file", "path": "subdir/out.txt", "expires": "` + expires + `"},
+			{"type": "file", "path": "out.txt", "expires": "` + expires + `"}
+		]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, func() {
+		err := os.Mkdir("subdir", 0755)
+		if err != nil {
+			t.Fatalf("Could not create subdirectory: %v", err)
+		}
+	})
+	defer os.RemoveAll(tempDir)
+	if err != nil || status != Succeeded {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v (%v)", Succeeded, status, err)
+	}
+	for artifact, expected := range map[string]string{"subdir/out.txt": "[hello]", "out.txt": "[]"} {
+		out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", filepath.FromSlash(artifact)))
+		if err != nil {
+			t.Fatalf("Artifact %v was not saved locally: %v", artifact, err)
+		}
+		if strings.TrimSpace(string(out)) != expected {
+			t.Fatalf("Expected artifact %v to contain %q but it contains %q", artifact, expected, string(out))
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// checkPreconditions checks the preconditions in the task payload, logging
+// each one that is not met.
+func (task *TaskRun) checkPreconditions() *CommandExecutionError {
+	for _, precondition := range task.Payload.Preconditions {
+		path := precondition.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(TaskDir, path)
+		}
+		if precondition.State != "" {
+			var problem string
+			info, err := os.Stat(longPath(path))
+			switch {
+			case precondition.State == "absent" && err == nil:
+				problem = "exists, but should not"
+			case precondition.State != "absent" && err != nil:
+				problem = fmt.Sprintf("should be a %v, but could not be read: %s", precondition.State, err)
+			case precondition.State == "file" && !info.Mode().IsRegular():
+				problem = "should be a file, but is not"
+			case precondition.State == "directory" && !info.IsDir():
+				problem = "should be a directory, but is not"
+			}
+			if problem != "" {
+				err := fmt.Errorf("Precondition not met: %v %v", path, problem)
+				task.Log(err.Error())
+				return MalformedPayloadError(err)
+			}
+		}
+		if precondition.MinFreeSpace > 0 {
+			free, err := freeDiskSpace(path)
+			if err != nil {
+				task.Log(fmt.Sprintf("Precondition not met: could not determine free disk space for %v: %s", path, err))
+				return InternalError(err)
+			}
+			if free < uint64(precondition.MinFreeSpace)*1024*1024 {
+				err := fmt.Errorf("Precondition not met: %v megabytes free for %v, but %v megabytes required", free/1024/1024, path, precondition.MinFreeSpace)
+				task.Log(err.Error())
+				return ResourceUnavailable(err)
+			}
+		}
+	}
+	return nil
+}
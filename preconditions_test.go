@@ -0,0 +1,41 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Task commands should not run if a payload precondition is not met.
+func TestPreconditionNotMet(t *testing.T) {
+	command := `[["/bin/bash", "-c", "touch ran.txt"]]`
+	if runtime.GOOS == "windows" {
+		command = `["type nul > ran.txt"]`
+	}
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"preconditions": [
+			{"path": "public", "state": "directory", "minFreeSpace": 1},
+			{"path": "toolchain.tar.gz", "state": "file"}
+		]
+	}`
+	tempDir, status, _ := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Errored {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Errored, status)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "ran.txt")); err == nil {
+		t.Fatal("Task command ran even though a precondition was not met")
+	}
+	logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not saved locally: %v", err)
+	}
+	if !strings.Contains(string(logFile), "toolchain.tar.gz should be a file") {
+		t.Fatalf("Expected task log to explain which precondition was not met:\n%s", logFile)
+	}
+}
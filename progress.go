@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+// maxProgressUpdateBytes limits the size of the progress updates that tasks
+// can post, since each one is written to the task log
+const maxProgressUpdateBytes = 4096
+
+type ProgressReportingFeature struct {
+}
+
+// ProgressUpdate is the json body that a task should POST to the progress
+// reporting url to report how far it has got.
+type ProgressUpdate struct {
+	Percent int    `json:"percent"`
+	Step    string `json:"step"`
+}
+
+type ProgressReportingTask struct {
+	task     *TaskRun
+	listener net.Listener
+}
+
+func (feature *ProgressReportingFeature) Name() string {
+	return "Progress Reporting"
+}
+
+func (feature *ProgressReportingFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *ProgressReportingFeature) Initialise() error {
+	return nil
+}
+
+func (feature *ProgressReportingFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.ProgressReporting
+}
+
+func (feature *ProgressReportingFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *ProgressReportingFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &ProgressReportingTask{
+		task: task,
+	}
+}
+
+func (p *ProgressReportingTask) RequiredScopes() scopes.Required {
+	// the endpoint only listens on the loopback interface, and only writes to
+	// the task log, so no need to control access to this feature
+	return scopes.Required{}
+}
+
+// Start listens on a random port of the loopback interface, and exports the
+// url that progress should be posted to in env var TASKCLUSTER_PROGRESS_URL.
+func (p *ProgressReportingTask) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	p.listener = listener
+	mux := http.NewServeMux()
+	mux.HandleFunc("/progress", p.handleProgress)
+	go http.Serve(listener, mux)
+	p.task.setEnvVar("TASKCLUSTER_PROGRESS_URL", "http://"+listener.Addr().String()+"/progress")
+	return nil
+}
+
+func (p *ProgressReportingTask) Stop() error {
+	if p.listener == nil {
+		return nil
+	}
+	err := p.listener.Close()
+	if err != nil {
+		// no need to raise an exception
+		log.Printf("WARN: could not close progress reporting listener: %s", err)
+	}
+	return nil
+}
+
+// handleProgress writes progress updates posted by the task to the task log.
+// They are not relayed to the queue, since it has no api for attaching
+// metadata to a task run.
+func (p *ProgressReportingTask) handleProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		http.Error(w, "Progress must be reported with an HTTP POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxProgressUpdateBytes+1))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not read progress update: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(body) > maxProgressUpdateBytes {
+		http.Error(w, fmt.Sprintf("Progress update may not exceed %v bytes", maxProgressUpdateBytes), http.StatusRequestEntityTooLarge)
+		return
+	}
+	var update ProgressUpdate
+	err = json.Unmarshal(body, &update)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Could not interpret progress update: %s", err), http.StatusBadRequest)
+		return
+	}
+	p.task.Log(fmt.Sprintf("Progress: %v%% %s", update.Percent, update.Step))
+	w.WriteHeader(http.StatusNoContent)
+}
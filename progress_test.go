@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// Progress posted to TASKCLUSTER_PROGRESS_URL should be written to the task
+// log, and requests that are not progress updates should be refused.
+func TestProgressReporting(t *testing.T) {
+	log := &bytes.Buffer{}
+	task := &TaskRun{logWriter: log}
+	p := &ProgressReportingTask{task: task}
+	err := p.Start()
+	if err != nil {
+		t.Fatalf("Could not start progress reporting: %v", err)
+	}
+	defer p.Stop()
+	url := task.featureEnv["TASKCLUSTER_PROGRESS_URL"]
+	if url == "" {
+		t.Fatal("Env var TASKCLUSTER_PROGRESS_URL should be set for task commands")
+	}
+
+	resp, err := http.Post(url, "application/json", strings.NewReader(`{"percent": 42, "step": "running tests"}`))
+	if err != nil {
+		t.Fatalf("Could not post progress update: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("Expected response code %v for progress update, but got %v", http.StatusNoContent, resp.StatusCode)
+	}
+	if !strings.Contains(log.String(), "Progress: 42% running tests") {
+		t.Fatalf("Progress update was not written to task log:\n%s", log)
+	}
+
+	log.Reset()
+	resp, err = http.Get(url)
+	if err != nil {
+		t.Fatalf("Could not get progress url: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Expected response code %v for GET request, but got %v", http.StatusMethodNotAllowed, resp.StatusCode)
+	}
+	oversized := `{"percent": 42, "step": "` + strings.Repeat("x", maxProgressUpdateBytes) + `"}`
+	resp, err = http.Post(url, "application/json", strings.NewReader(oversized))
+	if err != nil {
+		t.Fatalf("Could not post oversized progress update: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected response code %v for oversized progress update, but got %v", http.StatusRequestEntityTooLarge, resp.StatusCode)
+	}
+	resp, err = http.Post(url, "application/json", strings.NewReader(`42%`))
+	if err != nil {
+		t.Fatalf("Could not post invalid progress update: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("Expected response code %v for invalid progress update, but got %v", http.StatusBadRequest, resp.StatusCode)
+	}
+	if log.Len() > 0 {
+		t.Fatalf("Refused requests should not be written to task log:\n%s", log)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+var (
+	// number of internal worker errors since the last task that ran without
+	// one - only accessed from the worker loop in runWorker
+	consecutiveInternalErrors int
+	// if non-empty, the worker is quarantined for this reason, and does not
+	// claim tasks. It is served by the status endpoint (see setQuarantined).
+	// Only accessed from the worker loop in runWorker.
+	quarantineReason string
+	// the quarantine file that quarantineReason was read from, if any, see
+	// loadQuarantine
+	quarantineFileInEffect string
+)
+
+// recordTaskOutcome should be called with the error returned by
+// TaskRun.run(), to track whether the worker is repeatedly failing to run
+// tasks due to internal errors, rather than problems with the tasks
+// themselves.
+func recordTaskOutcome(err error) {
+	// the worker cannot be relied on to claim and resolve tasks
+	if failures := atomic.LoadInt64(&permanentAuthFailures); failures > 0 {
+		quarantine(fmt.Sprintf("%v calls to taskcluster failed permanently due to authentication or authorization errors - check the credentials (clientId %v) and scopes in the worker config", failures, config.ClientID))
+	}
+	if e, ok := err.(*CommandExecutionError); ok && e.Reason == "internal-error" {
+		recordInternalError(e.Cause)
+		return
+	}
+	consecutiveInternalErrors = 0
+}
+
+// recordInternalError quarantines the worker once config.QuarantineAfterErrors
+// consecutive internal errors have occurred.
+func recordInternalError(err error) {
+	consecutiveInternalErrors++
+	if config.QuarantineAfterErrors > 0 && consecutiveInternalErrors >= config.QuarantineAfterErrors && quarantineReason == "" {
+		quarantineReason = fmt.Sprintf("%v", err)
+		log.Printf("Quarantining worker after %v consecutive internal errors, last error: %v", consecutiveInternalErrors, quarantineReason)
+	}
+}
+
+func quarantined() bool {
+	return quarantineReason != ""
+}
+
+// quarantine stops the worker from claiming any further tasks, for the given
+// reason
+func quarantine(reason string) {
+	if quarantineReason == "" {
+		quarantineReason = reason
+		log.Printf("Quarantining worker due to: %v", reason)
+	}
+}
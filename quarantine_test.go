@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Worker should be quarantined after config.QuarantineAfterErrors consecutive
+// internal errors, but task failures should not count towards this.
+func TestQuarantineAfterErrors(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{QuarantineAfterErrors: 2}
+	defer func() {
+		consecutiveInternalErrors = 0
+		quarantineReason = ""
+	}()
+	internalError := InternalError(errors.New("task user could not be created"))
+	taskFailure := &CommandExecutionError{Cause: errors.New("exit code 1"), TaskStatus: Failed}
+
+	recordTaskOutcome(internalError)
+	recordTaskOutcome(taskFailure)
+	recordTaskOutcome(internalError)
+	if quarantined() {
+		t.Fatal("Worker should not be quarantined, since internal errors were not consecutive")
+	}
+	recordTaskOutcome(internalError)
+	if !quarantined() {
+		t.Fatal("Worker should be quarantined after 2 consecutive internal errors")
+	}
+	if quarantineReason != "task user could not be created" {
+		t.Fatalf("Unexpected quarantine reason: %q", quarantineReason)
+	}
+}
+
+// Calls failing permanently due to bad credentials or missing scopes mean
+// that the worker cannot be relied on to resolve tasks, so should quarantine
+// it.
+func TestQuarantineAfterAuthFailure(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{ClientID: "test-client"}
+	defer func() {
+		quarantineReason = ""
+		atomic.StoreInt64(&permanentAuthFailures, 0)
+	}()
+	recordTaskOutcome(nil)
+	if quarantined() {
+		t.Fatal("Worker should not be quarantined without auth failures")
+	}
+	atomic.AddInt64(&permanentAuthFailures, 1)
+	recordTaskOutcome(nil)
+	if !quarantined() || !strings.Contains(quarantineReason, "test-client") {
+		t.Fatalf("Worker should be quarantined after a permanent auth failure, but quarantine reason is %q", quarantineReason)
+	}
+}
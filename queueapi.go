@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var (
+	// limits the rate of calls the worker makes to the queue, created from
+	// config on first use
+	queueLimiter *rateLimiter
+	// number of calls made to each queue API method, published in worker
+	// metadata
+	queueCalls     = map[string]int{}
+	queueCallsLock sync.Mutex
+)
+
+// rateLimiter is a token bucket allowing on average perMinute calls per
+// minute, and up to burst calls in quick succession. This stops a large
+// number of workers (e.g. all restarted after an image roll) from hammering
+// the queue at the same time.
+type rateLimiter struct {
+	sync.Mutex
+	perMinute int
+	burst     int
+	// the first call waits a random duration of up to startupJitter, so that
+	// workers started at the same time spread out their first calls
+	startupJitter time.Duration
+	tokens        float64
+	last          time.Time
+	random        *rand.Rand
+}
+
+func newRateLimiter(perMinute, burst int, startupJitter time.Duration) *rateLimiter {
+	return &rateLimiter{
+		perMinute:     perMinute,
+		burst:         burst,
+		startupJitter: startupJitter,
+		random:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// reserve takes a token from the bucket for a call made at time now, and
+// returns how long the caller must wait before making the call. Callers
+// that have to wait for the bucket to refill wait a random extra duration
+// of up to one interval, so that throttled workers do not call in lockstep.
+func (r *rateLimiter) reserve(now time.Time) time.Duration {
+	r.Lock()
+	defer r.Unlock()
+	var jitter time.Duration
+	if r.last.IsZero() {
+		r.tokens = float64(r.burst)
+		if r.startupJitter > 0 {
+			jitter = time.Duration(r.random.Int63n(int64(r.startupJitter)))
+		}
+	}
+	if r.perMinute <= 0 {
+		r.last = now
+		return jitter
+	}
+	interval := time.Minute / time.Duration(r.perMinute)
+	if !r.last.IsZero() {
+		r.tokens += float64(now.Sub(r.last)) / float64(interval)
+		if r.tokens > float64(r.burst) {
+			r.tokens = float64(r.burst)
+		}
+	}
+	r.last = now
+	// tokens going negative reserves tokens that are yet to be refilled
+	r.tokens--
+	if r.tokens >= 0 {
+		return jitter
+	}
+	return jitter + time.Duration(-r.tokens*float64(interval)) + time.Duration(r.random.Int63n(int64(interval)))
+}
+
+// validateQueueRateLimit checks the config settings of the queue rate limit
+func (c *Config) validateQueueRateLimit() error {
+	if c.QueueCallsPerMinute < 0 || c.QueueStartupJitterSecs < 0 {
+		return fmt.Errorf("Config settings queueCallsPerMinute and queueStartupJitterSecs must not be negative")
+	}
+	if c.QueueCallsPerMinute > 0 && c.QueueCallBurst < 1 {
+		return fmt.Errorf("Config setting queueCallBurst must be at least 1, but is %v", c.QueueCallBurst)
+	}
+	return nil
+}
+
+// limitQueueCall must be called before each call to the queue API method
+// name. It counts the call, and waits until the rate limit allows it.
+func limitQueueCall(name string) {
+	queueCallsLock.Lock()
+	if queueLimiter == nil {
+		queueLimiter = newRateLimiter(config.QueueCallsPerMinute, config.QueueCallBurst, time.Duration(config.QueueStartupJitterSecs)*time.Second)
+	}
+	limiter := queueLimiter
+	queueCalls[name]++
+	queueCallsLock.Unlock()
+	if wait := limiter.reserve(time.Now()); wait > 0 {
+		log.Printf("Waiting %v before calling queue.%v, due to the queue rate limit", wait, name)
+		time.Sleep(wait)
+	}
+}
+
+// queueCallCounts returns the number of calls made to each queue API method
+func queueCallCounts() map[string]int {
+	queueCallsLock.Lock()
+	defer queueCallsLock.Unlock()
+	counts := make(map[string]int, len(queueCalls))
+	for name, n := range queueCalls {
+		counts[name] = n
+	}
+	return counts
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	r := newRateLimiter(60, 2, 0)
+	now := time.Now()
+	for i := 0; i < 2; i++ {
+		if wait := r.reserve(now); wait != 0 {
+			t.Fatalf("Call %v within burst should not wait, but waits %v", i+1, wait)
+		}
+	}
+	wait := r.reserve(now)
+	if wait < time.Second || wait >= 2*time.Second {
+		t.Fatalf("Call over burst should wait between 1s and 2s, but waits %v", wait)
+	}
+	wait = r.reserve(now)
+	if wait < 2*time.Second || wait >= 3*time.Second {
+		t.Fatalf("Second call over burst should wait between 2s and 3s, but waits %v", wait)
+	}
+	// bucket refills to burst, and no further
+	now = now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		if wait := r.reserve(now); wait != 0 {
+			t.Fatalf("Call %v after bucket refilled should not wait, but waits %v", i+1, wait)
+		}
+	}
+	if wait := r.reserve(now); wait == 0 {
+		t.Fatal("Call over burst after bucket refilled should wait")
+	}
+
+	unlimited := newRateLimiter(0, 0, time.Minute)
+	if wait := unlimited.reserve(now); wait >= time.Minute {
+		t.Fatalf("First call should wait less than startup jitter of 1m, but waits %v", wait)
+	}
+	for i := 0; i < 100; i++ {
+		if wait := unlimited.reserve(now); wait != 0 {
+			t.Fatalf("Unlimited calls should not wait, but call %v waits %v", i+2, wait)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"runtime/debug"
+)
+
+// runRecoveringPanics runs the task, and if the worker panics while doing so,
+// resolves the task as exception (with the stack trace in the task log)
+// rather than the claim silently expiring. The panic is returned as an
+// internal error, so that a worker that repeatedly panics is quarantined (see
+// config setting quarantineAfterErrors).
+func (task *TaskRun) runRecoveringPanics() (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		stack := debug.Stack()
+		log.Printf("PANIC while running task %v: %v\n%s", task.TaskID, r, stack)
+		task.Log(fmt.Sprintf("TASK EXCEPTION due to internal worker error: %v\n%s", r, stack))
+		// best effort, since the task log may already have been closed
+		errLog := task.uploadLog("public/logs/live_backing.log")
+		if errLog != nil {
+			log.Printf("WARN: could not upload task log after panic: %s", errLog)
+		}
+		errStatus := updateTaskStatus(TaskStatusUpdate{
+			Task:   task,
+			Status: Errored,
+			Reason: "internal-error", // panic
+		})
+		if errStatus != nil {
+			log.Printf("WARN: could not resolve task %v after panic: %s", task.TaskID, errStatus)
+		}
+		err = InternalError(fmt.Errorf("panic while running task: %v", r))
+	}()
+	return task.run()
+}
+
+// startRecoveringPanics starts the task feature of the named feature. Task
+// features are started in their own goroutines (see startTaskFeatures), where
+// runRecoveringPanics cannot recover a panic, so a panic is recovered here
+// and returned as an internal error, rather than crashing the worker.
+func startRecoveringPanics(name string, taskFeature TaskFeature) (err error) {
+	defer func() {
+		r := recover()
+		if r == nil {
+			return
+		}
+		log.Printf("PANIC while starting feature %q: %v\n%s", name, r, debug.Stack())
+		err = InternalError(fmt.Errorf("panic while starting feature %q: %v", name, r))
+	}()
+	return taskFeature.Start()
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+// panickingFeature is a feature that panics when the worker checks whether
+// it is enabled for a task
+type panickingFeature struct {
+	dummyFeature
+}
+
+func (f *panickingFeature) IsEnabled(task *TaskRun) bool {
+	panic("feature exploded")
+}
+
+// A panic while running a task should resolve the task as exception, with an
+// internal error, rather than crashing the worker, which should go on to run
+// further tasks.
+func TestPanicRecovery(t *testing.T) {
+	defer func(features []Feature) {
+		Features = features
+		consecutiveInternalErrors = 0
+		quarantineReason = ""
+	}(Features)
+	Features = append([]Feature{&panickingFeature{dummyFeature{name: "panicking"}}}, Features...)
+	payload := `{
+		"command": [["go", "version"]],
+		"maxRunTime": 30
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Errored {
+		t.Fatalf("Expected task to resolve as %v after panic, but it resolved as %v", Errored, status)
+	}
+	if e, ok := err.(*CommandExecutionError); !ok || e.Reason != "internal-error" || !strings.Contains(e.Cause.Error(), "feature exploded") {
+		t.Fatalf("Expected internal error caused by panic, but got %#v", err)
+	}
+	defer func(c *Config) { config = c }(config)
+	config = &Config{QuarantineAfterErrors: 2}
+	recordTaskOutcome(err)
+	if quarantined() {
+		t.Fatal("Worker should not be quarantined after a single panic")
+	}
+
+	// the next task should run as normal
+	Features = Features[1:]
+	tempDir, status, err = runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Succeeded || err != nil {
+		t.Fatalf("Expected task after panic to resolve as %v, but it resolved as %v (error: %v)", Succeeded, status, err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// taskResult is the content of the result file that task commands may write,
+// see payload property resultFile
+type taskResult struct {
+	Status         string   `json:"status"`
+	FailureReasons []string `json:"failureReasons"`
+	Suites         []struct {
+		Name    string `json:"name"`
+		Status  string `json:"status"`
+		Passed  int    `json:"passed"`
+		Failed  int    `json:"failed"`
+		Skipped int    `json:"skipped"`
+	} `json:"suites"`
+	Annotations []struct {
+		Path    string `json:"path"`
+		Line    int    `json:"line"`
+		EndLine int    `json:"endLine"`
+		Level   string `json:"level"`
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	} `json:"annotations"`
+}
+
+// checkRunAnnotation is an annotation in the format of the GitHub checks API,
+// published in public/github/annotations.json
+type checkRunAnnotation struct {
+	Path            string `json:"path"`
+	StartLine       int    `json:"start_line"`
+	EndLine         int    `json:"end_line"`
+	AnnotationLevel string `json:"annotation_level"`
+	Title           string `json:"title,omitempty"`
+	Message         string `json:"message"`
+}
+
+const taskResultSchema = `{
+  "type": "object",
+  "required": ["status"],
+  "properties": {
+    "status": {"enum": ["passed", "failed"]},
+    "failureReasons": {"type": "array", "items": {"type": "string"}},
+    "suites": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["name", "status"],
+        "properties": {
+          "name": {"type": "string"},
+          "status": {"enum": ["passed", "failed", "skipped"]},
+          "passed": {"type": "integer", "minimum": 0},
+          "failed": {"type": "integer", "minimum": 0},
+          "skipped": {"type": "integer", "minimum": 0}
+        }
+      }
+    },
+    "annotations": {
+      "type": "array",
+      "items": {
+        "type": "object",
+        "required": ["path", "line", "level", "message"],
+        "properties": {
+          "path": {"type": "string"},
+          "line": {"type": "integer", "minimum": 1},
+          "endLine": {"type": "integer", "minimum": 1},
+          "level": {"enum": ["notice", "warning", "failure"]},
+          "title": {"type": "string"},
+          "message": {"type": "string"}
+        }
+      }
+    }
+  }
+}`
+
+// processResultFile validates the result file written by the task commands,
+// and logs a summary of it. A missing or invalid result file, or one
+// reporting that the task failed, results in a task failure.
+func (task *TaskRun) processResultFile() *CommandExecutionError {
+	fail := func(err error) *CommandExecutionError {
+		task.Log(err.Error())
+		return &CommandExecutionError{
+			Cause:      err,
+			TaskStatus: Failed,
+		}
+	}
+	path := filepath.Join(TaskDir, filepath.FromSlash(task.Payload.ResultFile))
+	data, err := ioutil.ReadFile(longPath(path))
+	if err != nil {
+		return fail(fmt.Errorf("Could not read result file %v: %s", task.Payload.ResultFile, err))
+	}
+	result, err := gojsonschema.Validate(gojsonschema.NewStringLoader(taskResultSchema), gojsonschema.NewStringLoader(string(data)))
+	if err != nil {
+		return fail(fmt.Errorf("Result file %v is not valid json: %s", task.Payload.ResultFile, err))
+	}
+	if !result.Valid() {
+		problems := []string{}
+		for _, desc := range result.Errors() {
+			problems = append(problems, desc.String())
+		}
+		return fail(fmt.Errorf("Result file %v is invalid: %v", task.Payload.ResultFile, strings.Join(problems, "; ")))
+	}
+	var r taskResult
+	err = json.Unmarshal(data, &r)
+	if err != nil {
+		return fail(err)
+	}
+
+	task.Log("=== Task Result ===")
+	task.Log("Status: " + r.Status)
+	for _, reason := range r.FailureReasons {
+		task.Log("Failure reason: " + reason)
+	}
+	for _, suite := range r.Suites {
+		task.Log(fmt.Sprintf("Suite %v: %v (passed: %v, failed: %v, skipped: %v)", suite.Name, suite.Status, suite.Passed, suite.Failed, suite.Skipped))
+	}
+	err = task.publishGitHubSummary(&r)
+	if err != nil {
+		// not worth failing the task over
+		log.Printf("WARN: could not publish GitHub summary: %s", err)
+	}
+	if r.Status == "failed" {
+		reasons := "no reason given"
+		if len(r.FailureReasons) > 0 {
+			reasons = strings.Join(r.FailureReasons, "; ")
+		}
+		return &CommandExecutionError{
+			Cause:      fmt.Errorf("Result file %v reports task failed: %v", task.Payload.ResultFile, reasons),
+			TaskStatus: Failed,
+		}
+	}
+	return nil
+}
+
+// publishGitHubSummary publishes the result as artifacts
+// public/github/summary.md (markdown) and public/github/annotations.json
+// (GitHub check run annotations), so that a GitHub integration can render it
+// as check run output without each task having to produce it
+func (task *TaskRun) publishGitHubSummary(r *taskResult) error {
+	annotations := []checkRunAnnotation{}
+	for _, a := range r.Annotations {
+		endLine := a.EndLine
+		if endLine < a.Line {
+			endLine = a.Line
+		}
+		annotations = append(annotations, checkRunAnnotation{
+			Path:            a.Path,
+			StartLine:       a.Line,
+			EndLine:         endLine,
+			AnnotationLevel: a.Level,
+			Title:           a.Title,
+			Message:         a.Message,
+		})
+	}
+	annotationsJSON, err := json.MarshalIndent(annotations, "", "  ")
+	if err != nil {
+		return err
+	}
+	for _, summary := range []struct {
+		artifact string
+		mimeType string
+		content  []byte
+	}{
+		{"public/github/summary.md", "text/markdown; charset=utf-8", []byte(task.gitHubSummary(r))},
+		{"public/github/annotations.json", "application/json", annotationsJSON},
+	} {
+		file := filepath.Join(TaskDir, filepath.FromSlash(summary.artifact))
+		err := os.MkdirAll(filepath.Dir(file), 0755)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(file, summary.content, 0644)
+		if err != nil {
+			return err
+		}
+		err = task.uploadArtifact(
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: summary.artifact,
+					Expires:       task.Definition.Expires,
+				},
+				MimeType:        summary.mimeType,
+				ContentEncoding: "gzip",
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gitHubSummary returns the result as markdown
+func (task *TaskRun) gitHubSummary(r *taskResult) string {
+	cell := strings.NewReplacer("|", "\\|", "\n", " ")
+	var md bytes.Buffer
+	fmt.Fprintf(&md, "## Task %v %v\n\n", task.TaskID, r.Status)
+	if len(r.FailureReasons) > 0 {
+		md.WriteString("### Failure reasons\n\n")
+		for _, reason := range r.FailureReasons {
+			fmt.Fprintf(&md, "- %v\n", reason)
+		}
+		md.WriteString("\n")
+	}
+	if len(r.Suites) > 0 {
+		md.WriteString("### Suites\n\n")
+		md.WriteString("| Suite | Status | Passed | Failed | Skipped |\n")
+		md.WriteString("| --- | --- | ---: | ---: | ---: |\n")
+		for _, suite := range r.Suites {
+			fmt.Fprintf(&md, "| %v | %v | %v | %v | %v |\n", cell.Replace(suite.Name), suite.Status, suite.Passed, suite.Failed, suite.Skipped)
+		}
+		md.WriteString("\n")
+	}
+	if len(r.Annotations) > 0 {
+		fmt.Fprintf(&md, "%v annotation(s), see `public/github/annotations.json`.\n", len(r.Annotations))
+	}
+	return md.String()
+}
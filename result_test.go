@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// A result file reporting failure should cause the task to fail, even though
+// its commands succeeded, and should be summarised in the task log and in the
+// GitHub summary artifacts.
+func TestResultFile(t *testing.T) {
+	command := `[["/bin/bash", "-c", "cp expected-result.json result.json"]]`
+	if runtime.GOOS == "windows" {
+		command = `["copy expected-result.json result.json"]`
+	}
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"resultFile": "result.json"
+	}`
+	tempDir, status, _ := runLocalTask(t, payload, func() {
+		result := `{"status": "failed", "failureReasons": ["3 tests failed"], "suites": [{"name": "unit", "status": "failed", "passed": 7, "failed": 3}], "annotations": [{"path": "src/lib.go", "line": 12, "level": "failure", "message": "TestLib failed"}]}`
+		err := ioutil.WriteFile("expected-result.json", []byte(result), 0644)
+		if err != nil {
+			t.Fatalf("Could not write result file: %v", err)
+		}
+	})
+	defer os.RemoveAll(tempDir)
+	if status != Failed {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Failed, status)
+	}
+	logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not saved locally: %v", err)
+	}
+	for _, expected := range []string{
+		"Failure reason: 3 tests failed",
+		"Suite unit: failed (passed: 7, failed: 3, skipped: 0)",
+	} {
+		if !strings.Contains(string(logFile), expected) {
+			t.Fatalf("Expected task log to contain %q, but it does not:\n%s", expected, logFile)
+		}
+	}
+	summary, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "github", "summary.md"))
+	if err != nil || !strings.Contains(string(summary), "| unit | failed | 7 | 3 | 0 |") {
+		t.Fatalf("Expected GitHub summary to list suites, got %q (%v)", summary, err)
+	}
+	var annotations []checkRunAnnotation
+	data, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "github", "annotations.json"))
+	if err == nil {
+		err = json.Unmarshal(data, &annotations)
+	}
+	if err != nil || len(annotations) != 1 || annotations[0].StartLine != 12 || annotations[0].EndLine != 12 || annotations[0].AnnotationLevel != "failure" {
+		t.Fatalf("Unexpected GitHub annotations %#v (%v)", annotations, err)
+	}
+}
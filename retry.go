@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// runWithInfraRetries runs the task, and if it hits an infrastructure
+// exception before it was resolved (e.g. a task feature could not be
+// started), re-runs it on this worker up to config.InfraRetries times, rather
+// than resolving it as exception straight away. The task log of each failed
+// attempt is published as public/logs/attempt_<n>.log. If the task is still
+// unresolved after the final attempt, it is resolved as exception.
+func (task *TaskRun) runWithInfraRetries() error {
+	err := task.runRecoveringPanics()
+	for task.attempt < config.InfraRetries && task.retryable(err) {
+		task.attempt++
+		log.Printf("Re-running task %v after infrastructure exception (attempt %v of %v): %v", task.TaskID, task.attempt+1, config.InfraRetries+1, err)
+		errArchive := task.archiveAttemptLog()
+		if errArchive != nil {
+			log.Printf("WARN: could not publish log of attempt %v: %s", task.attempt, errArchive)
+		}
+		err = task.runRecoveringPanics()
+	}
+	task.resolveIfUnresolved(err)
+	return err
+}
+
+// retryable returns true if err is an infrastructure exception, and the task
+// has not been resolved, so may safely be run again
+func (task *TaskRun) retryable(err error) bool {
+	e, ok := err.(*CommandExecutionError)
+	if !ok || (task.Status != Claimed && task.Status != Reclaimed) {
+		return false
+	}
+	return e.Reason == "internal-error" || e.Reason == "resource-unavailable"
+}
+
+// archiveAttemptLog moves the task log of the attempt that just failed out of
+// the way of the next attempt, and publishes it
+func (task *TaskRun) archiveAttemptLog() error {
+	attemptLog := fmt.Sprintf("public/logs/attempt_%v.log", task.attempt)
+	err := os.Rename(
+		longPath(filepath.Join(TaskDir, "public", "logs", "live_backing.log")),
+		longPath(filepath.Join(TaskDir, filepath.FromSlash(attemptLog))),
+	)
+	if err != nil {
+		return err
+	}
+	return task.uploadLog(attemptLog)
+}
+
+// resolveIfUnresolved resolves the task as exception if run() returned an
+// exception without resolving the task, which happens when the task could not
+// be started
+func (task *TaskRun) resolveIfUnresolved(err error) {
+	e, ok := err.(*CommandExecutionError)
+	if !ok || e.TaskStatus != Errored || (task.Status != Claimed && task.Status != Reclaimed) {
+		return
+	}
+	errLog := task.uploadLog("public/logs/live_backing.log")
+	if errLog != nil {
+		log.Printf("WARN: could not upload task log: %s", errLog)
+	}
+	task.reportPossibleError(updateTaskStatus(TaskStatusUpdate{
+		Task:   task,
+		Status: Errored,
+		Reason: e.Reason,
+	}))
+}
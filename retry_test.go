@@ -0,0 +1,26 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Only infrastructure exceptions of tasks that have not yet been resolved
+// should be retried.
+func TestRetryable(t *testing.T) {
+	task := &TaskRun{Status: Claimed}
+	cause := errors.New("could not start task feature")
+	if !task.retryable(InternalError(cause)) || !task.retryable(ResourceUnavailable(cause)) {
+		t.Fatal("Infrastructure exceptions of unresolved task should be retryable")
+	}
+	if task.retryable(MalformedPayloadError(cause)) {
+		t.Fatal("Malformed payload should not be retryable")
+	}
+	if task.retryable(&CommandExecutionError{Cause: errors.New("exit code 1"), TaskStatus: Failed}) {
+		t.Fatal("Task failure should not be retryable")
+	}
+	task.Status = Errored
+	if task.retryable(InternalError(cause)) {
+		t.Fatal("Resolved task should not be retryable")
+	}
+}
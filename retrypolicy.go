@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/taskcluster/httpbackoff"
+)
+
+// RetryPolicy defines how an http request that the worker makes itself, and
+// that fails intermittently, is retried. Zero values are replaced by the
+// defaults of the operation. Calls through the taskcluster client are not
+// retried with a policy, since the client already retries them with its own
+// backoff.
+type RetryPolicy struct {
+	// maximum number of attempts, including the first one
+	MaxAttempts int `json:"maxAttempts"`
+	// wait before the second attempt
+	InitialIntervalSecs int `json:"initialIntervalSecs"`
+	// maximum wait between attempts
+	MaxIntervalSecs int `json:"maxIntervalSecs"`
+	// factor the wait grows by after each attempt
+	Multiplier float64 `json:"multiplier"`
+	// maximum duration of each attempt of an http request, including reading
+	// the response body; no limit if zero
+	TimeoutSecs int `json:"timeoutSecs"`
+}
+
+// retryOperations are the operations that retry policies can be configured
+// for, with their default policies
+var retryOperations = map[string]RetryPolicy{
+	"artifactUpload": {MaxAttempts: 10, InitialIntervalSecs: 1, MaxIntervalSecs: 60, Multiplier: 2},
+	"notification":   {MaxAttempts: 5, InitialIntervalSecs: 1, MaxIntervalSecs: 30, Multiplier: 2, TimeoutSecs: 30},
+	"queue":          {MaxAttempts: 3, InitialIntervalSecs: 1, MaxIntervalSecs: 30, Multiplier: 2},
+}
+
+// validateRetryPolicies checks that retry policies are only configured for
+// known operations, and are sensible
+func validateRetryPolicies(policies map[string]RetryPolicy) error {
+	for operation, p := range policies {
+		if _, exists := retryOperations[operation]; !exists {
+			return fmt.Errorf("Config setting retryPolicies contains unknown operation %q", operation)
+		}
+		if p.MaxAttempts < 0 || p.InitialIntervalSecs < 0 || p.MaxIntervalSecs < 0 || p.TimeoutSecs < 0 {
+			return fmt.Errorf("Config setting retryPolicies has negative values for operation %q", operation)
+		}
+		if p.Multiplier != 0 && p.Multiplier < 1 {
+			return fmt.Errorf("Config setting retryPolicies has multiplier %v for operation %q, which is less than 1", p.Multiplier, operation)
+		}
+	}
+	return nil
+}
+
+// retryPolicy returns the retry policy configured for operation, with
+// defaults for anything not configured
+func retryPolicy(operation string) RetryPolicy {
+	p := retryOperations[operation]
+	c := config.RetryPolicies[operation]
+	if c.MaxAttempts != 0 {
+		p.MaxAttempts = c.MaxAttempts
+	}
+	if c.InitialIntervalSecs != 0 {
+		p.InitialIntervalSecs = c.InitialIntervalSecs
+	}
+	if c.MaxIntervalSecs != 0 {
+		p.MaxIntervalSecs = c.MaxIntervalSecs
+	}
+	if c.Multiplier != 0 {
+		p.Multiplier = c.Multiplier
+	}
+	if c.TimeoutSecs != 0 {
+		p.TimeoutSecs = c.TimeoutSecs
+	}
+	return p
+}
+
+// interval returns how long to wait after the given (failed) attempt
+func (p RetryPolicy) interval(attempt int) time.Duration {
+	interval := float64(p.InitialIntervalSecs)
+	for i := 1; i < attempt; i++ {
+		interval *= p.Multiplier
+	}
+	if max := float64(p.MaxIntervalSecs); max > 0 && interval > max {
+		interval = max
+	}
+	return time.Duration(interval * float64(time.Second))
+}
+
+// isPermanentError returns whether err is a failure that retrying will not
+// fix, i.e. an http 4xx response
+func isPermanentError(err error) bool {
+	e, ok := err.(httpbackoff.BadHttpResponseCode)
+	return ok && e.HttpResponseCode/100 == 4
+}
+
+// isConflictError returns true if err is an http 409 response, which the
+// queue returns e.g. when reclaiming a task run that was cancelled
+func isConflictError(err error) bool {
+	e, ok := err.(httpbackoff.BadHttpResponseCode)
+	return ok && e.HttpResponseCode == 409
+}
+
+// retry calls f until it succeeds, fails permanently, or the attempts of the
+// policy are used up, backing off between attempts. It returns the number of
+// attempts made, and the error of the last one.
+func (p RetryPolicy) retry(description string, f func() error) (int, error) {
+	attempt := 1
+	for {
+		err := f()
+		if err == nil {
+			if attempt > 1 {
+				log.Printf("%v succeeded on attempt %v of %v", description, attempt, p.MaxAttempts)
+			}
+			return attempt, nil
+		}
+		if isPermanentError(err) || attempt >= p.MaxAttempts {
+			log.Printf("%v failed on attempt %v of %v, giving up: %s", description, attempt, p.MaxAttempts, err)
+			return attempt, err
+		}
+		wait := p.interval(attempt)
+		log.Printf("WARN: %v failed on attempt %v of %v, retrying in %v: %s", description, attempt, p.MaxAttempts, wait, err)
+		time.Sleep(wait)
+		attempt++
+	}
+}
+
+// retryHTTP retries the http request made by f with the policy. f must use
+// the client it is given, which enforces the timeout of the policy. Responses
+// with 4xx and 5xx status codes are returned as httpbackoff.BadHttpResponseCode
+// errors (including the response body), and only 5xx responses are retried.
+// The response is only returned if the request succeeded.
+func (p RetryPolicy) retryHTTP(description string, f func(client *http.Client) (*http.Response, error)) (*http.Response, int, error) {
+	client := *httpClient
+	client.Timeout = time.Duration(p.TimeoutSecs) * time.Second
+	var resp *http.Response
+	attempts, err := p.retry(description, func() (err error) {
+		resp, err = f(&client)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode/100 != 4 && resp.StatusCode/100 != 5 {
+			return nil
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return httpbackoff.BadHttpResponseCode{
+			HttpResponseCode: resp.StatusCode,
+			Message:          fmt.Sprintf("HTTP response code %v\n%s", resp.StatusCode, body),
+		}
+	})
+	if err != nil {
+		return nil, attempts, err
+	}
+	return resp, attempts, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Retry policies should fill in defaults, retry intermittent failures up to
+// the configured number of attempts, and not retry http 4xx responses.
+func TestRetryPolicy(t *testing.T) {
+	defer func(c *Config, client *http.Client) {
+		config = c
+		httpClient = client
+	}(config, httpClient)
+	config = &Config{
+		RetryPolicies: map[string]RetryPolicy{
+			"queue": {MaxAttempts: 4},
+		},
+	}
+	p := retryPolicy("queue")
+	if p.MaxAttempts != 4 || p.Multiplier != 2 || p.MaxIntervalSecs != 30 {
+		t.Fatalf("Unexpected policy: %#v", p)
+	}
+	if d := p.interval(3); d != 4*time.Second {
+		t.Fatalf("Expected third wait of 4s, got %v", d)
+	}
+	if d := p.interval(10); d != 30*time.Second {
+		t.Fatalf("Expected wait to be capped at 30s, got %v", d)
+	}
+	p.InitialIntervalSecs = 0
+	calls := 0
+	attempts, err := p.retry("test", func() error {
+		calls++
+		return errors.New("intermittent")
+	})
+	if err == nil || attempts != 4 || calls != 4 {
+		t.Fatalf("Expected 4 failed attempts, got %v attempts and %v calls: %v", attempts, calls, err)
+	}
+	httpClient = &http.Client{}
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch requests {
+		case 1:
+			w.WriteHeader(503)
+		case 2:
+			w.WriteHeader(200)
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	defer server.Close()
+	resp, attempts, err := p.retryHTTP("test", func(client *http.Client) (*http.Response, error) {
+		return client.Get(server.URL)
+	})
+	if err != nil || attempts != 2 {
+		t.Fatalf("Expected success on second attempt, got %v attempts: %v", attempts, err)
+	}
+	resp.Body.Close()
+	_, attempts, err = p.retryHTTP("test", func(client *http.Client) (*http.Response, error) {
+		return client.Get(server.URL)
+	})
+	if !isPermanentError(err) || attempts != 1 {
+		t.Fatalf("Expected 404 not to be retried, got %v attempts: %v", attempts, err)
+	}
+	if validateRetryPolicies(map[string]RetryPolicy{"download": {}}) == nil {
+		t.Fatal("Expected policy for unknown operation to be rejected")
+	}
+}
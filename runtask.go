@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/taskcluster/slugid-go/slugid"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+	"github.com/taskcluster/taskcluster-client-go/queue"
+)
+
+// runTaskLocally runs the task payload in payloadFile in the current
+// directory, without talking to the queue. This allows task authors to iterate
+// on their payloads without submitting real tasks. Artifacts (including the
+// task log) are copied into artifactsDir rather than uploaded. Task features
+// are not supported, since they require the queue. Returns the resolved status
+// of the task, and an error if the task was not successful.
+func runTaskLocally(payloadFile string, artifactsDir string) (TaskStatus, error) {
+	payload, err := ioutil.ReadFile(payloadFile)
+	if err != nil {
+		return Errored, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return Errored, err
+	}
+	absArtifactsDir, err := filepath.Abs(artifactsDir)
+	if err != nil {
+		return Errored, err
+	}
+	TaskUser = OSUser{
+		HomeDir: cwd,
+	}
+	TaskDir = cwd
+	config = &Config{
+		WorkerType:             "run-task",
+		WorkerTypeMetadata:     map[string]interface{}{},
+		RunTasksAsCurrentUser:  true,
+		TeardownMaxRunTimeSecs: 300,
+		KillGracePeriodSecs:    10,
+	}
+	err = startup()
+	if err != nil {
+		return Errored, err
+	}
+	taskStatusUpdate, taskStatusUpdateErr, taskStatusDoneChan = localTaskStatusHandler()
+
+	now := time.Now()
+	task := &TaskRun{
+		TaskID: slugid.Nice(),
+		Definition: queue.TaskDefinitionResponse{
+			Created: tcclient.Time(now),
+			Expires: tcclient.Time(now.Add(time.Hour * 24)),
+			Payload: json.RawMessage(payload),
+		},
+		Status:            Claimed,
+		localArtifactsDir: absArtifactsDir,
+	}
+	err = task.validatePayload()
+	if err != nil {
+		return Errored, err
+	}
+	// leave time for the task commands to run for maxRunTime, followed by the
+	// teardown commands
+	task.Definition.Deadline = tcclient.Time(now.Add(time.Second * time.Duration(task.Payload.MaxRunTime+task.teardownMaxRunTime())))
+	err = task.runRecoveringPanics()
+	return task.Status, err
+}
+
+// localTaskStatusHandler is a replacement for TaskStatusHandler when running
+// tasks locally. It tracks TaskRun.Status, but does not inform the queue.
+func localTaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, done chan<- bool) {
+	r := make(chan TaskStatusUpdate)
+	e := make(chan error)
+	d := make(chan bool)
+	go func() {
+		for {
+			select {
+			case update := <-r:
+				// IfStatusIn is interpreted as by TaskStatusHandler
+				if update.IfStatusIn == nil || update.IfStatusIn[update.Status] {
+					log.Printf("Task %v resolved with status %v %v", update.Task.TaskID, update.Status, update.Reason)
+					update.Task.Status = update.Status
+				}
+				e <- nil
+			case <-d:
+				return
+			}
+		}
+	}()
+	return r, e, d
+}
+
+// saveArtifactLocally copies the given artifact into task.localArtifactsDir,
+// under its canonical path. Only S3 artifacts have content to be copied; other
+// artifact types are just logged.
+func (task *TaskRun) saveArtifactLocally(artifact Artifact) error {
+	s3Artifact, ok := artifact.(S3Artifact)
+	if !ok {
+		log.Printf("Not saving %T %v locally: %#v", artifact, artifact.Base().CanonicalPath, artifact)
+		return nil
+	}
+	dest := filepath.Join(task.localArtifactsDir, filepath.FromSlash(s3Artifact.ArtifactName()))
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return err
+	}
+	log.Printf("Saving artifact %v to %v", s3Artifact.ArtifactName(), dest)
+	return copyFileContents(longPath(filepath.Join(TaskDir, s3Artifact.CanonicalPath)), longPath(dest))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runLocalTask runs payload with runTaskLocally in a new temporary directory,
+// after running setup (if not nil) in it, and returns the directory (which
+// the caller should remove) and the resolved status and error of the task.
+// The current directory, and the globals that runTaskLocally sets, are
+// restored before returning.
+func runLocalTask(t *testing.T, payload string, setup func()) (string, TaskStatus, error) {
+	defer func(c *Config, dir string, user OSUser) {
+		config = c
+		TaskDir = dir
+		TaskUser = user
+	}(config, TaskDir, TaskUser)
+	tempDir, err := ioutil.TempDir("", "generic-worker-run-task")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Could not determine current directory: %v", err)
+	}
+	err = os.Chdir(tempDir)
+	if err != nil {
+		t.Fatalf("Could not change directory to %v: %v", tempDir, err)
+	}
+	defer os.Chdir(cwd)
+	if setup != nil {
+		setup()
+	}
+	err = ioutil.WriteFile("payload.json", []byte(payload), 0644)
+	if err != nil {
+		t.Fatalf("Could not write payload file: %v", err)
+	}
+	status, err := runTaskLocally("payload.json", "artifacts")
+	return tempDir, status, err
+}
+
+// run-task should run commands locally, and copy artifacts and the task log to
+// the artifacts directory, without talking to the queue.
+func TestRunTaskLocally(t *testing.T) {
+	// write via TASK_WORKDIR, to check it points at the task directory
+	command := `[["/bin/bash", "-c", "echo hello > \"${TASK_WORKDIR}/out.txt\""]]`
+	if runtime.GOOS == "windows" {
+		command = `["echo hello> \"%TASK_WORKDIR%\\out.txt\""]`
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"artifacts": [{"type": "file", "path": "out.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Task should have run successfully, but got error: %v", err)
+	}
+	if status != Succeeded {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Succeeded, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "out.txt"))
+	if err != nil {
+		t.Fatalf("Artifact out.txt was not saved locally: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "hello" {
+		t.Fatalf("Expected artifact out.txt to contain %q but it contains %q", "hello", string(out))
+	}
+	checksums, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "artifact_checksums.json"))
+	if err != nil {
+		t.Fatalf("Artifact checksums were not saved locally: %v", err)
+	}
+	hashes := map[string]ArtifactHash{}
+	err = json.Unmarshal(checksums, &hashes)
+	if err != nil {
+		t.Fatalf("Could not interpret artifact checksums: %v", err)
+	}
+	expectedHash := ArtifactHash{
+		SHA256: fmt.Sprintf("%x", sha256.Sum256(out)),
+		Size:   int64(len(out)),
+	}
+	if hashes["out.txt"] != expectedHash {
+		t.Fatalf("Expected checksum %v for artifact out.txt but got %v", expectedHash, hashes["out.txt"])
+	}
+	logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not saved locally: %v", err)
+	}
+	if !strings.Contains(string(logFile), "=== Task Finished ===") {
+		t.Fatalf("Task log saved locally is incomplete:\n%s", logFile)
+	}
+}
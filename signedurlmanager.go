@@ -29,7 +29,11 @@ func SignedURLsManager() (chan chan *queue.PollTaskUrlsResponse, chan *queue.Pol
 		// When a worker wants to poll for pending tasks it must call
 		// `queue.pollTaskUrls(provisionerId, workerType)` which then returns
 		// an array of objects on the form `{signedPollUrl, signedDeleteUrl}`.
-		signedURLs, err = Queue.PollTaskUrls(config.ProvisionerID, config.WorkerType)
+		err = retryOnAuthError("pollTaskUrls", reloadWorkerCredentials, func() (err error) {
+			limitQueueCall("pollTaskUrls")
+			signedURLs, err = Queue.PollTaskUrls(config.ProvisionerID, config.WorkerType)
+			return
+		})
 		// TODO: not sure if this is the right thing to do. If Queue has an outage, maybe better to
 		// do expoenential backoff indefinitely?
 		if err != nil {
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// directory (relative to the task directory) that process snapshots are
+// written to, and name prefix of the artifacts they are published as
+const timeoutSnapshotDir = "public/timeout"
+
+// snapshotProcesses captures the state of the processes of the given command,
+// which is about to be killed due to reason, and publishes it as
+// artifacts under public/timeout/, so that task authors can see what was
+// stuck: a listing of processes, their open files and sockets, and (Linux
+// only, if the worker runs as root) the kernel stacks of their threads.
+func (task *TaskRun) snapshotProcesses(command *Command, reason string) {
+	dir := filepath.Join(TaskDir, filepath.FromSlash(timeoutSnapshotDir))
+	err := os.MkdirAll(dir, 0777)
+	if err != nil {
+		log.Printf("WARN: could not create directory for process snapshot: %s", err)
+		return
+	}
+	snapshot := map[string][]byte{}
+	processes, err := command.processes()
+	if err != nil {
+		processes = append(processes, []byte(fmt.Sprintf("\nCould not list processes: %s\n", err))...)
+	}
+	snapshot["processes.txt"] = processes
+	for file, args := range command.snapshotCommands() {
+		output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+		if err != nil {
+			output = append(output, []byte(fmt.Sprintf("\n%q failed: %s\n", args, err))...)
+		}
+		snapshot[file] = output
+	}
+	if stacks, err := command.threadStacks(); err == nil {
+		snapshot["stacks.txt"] = []byte(stacks)
+	}
+	for file, content := range snapshot {
+		err := ioutil.WriteFile(filepath.Join(dir, file), content, 0644)
+		if err != nil {
+			log.Printf("WARN: could not write process snapshot file %v: %s", file, err)
+			continue
+		}
+		err = task.uploadArtifact(
+			S3Artifact{
+				BaseArtifact: BaseArtifact{
+					CanonicalPath: timeoutSnapshotDir + "/" + file,
+					Expires:       task.Definition.Expires,
+				},
+				MimeType: "text/plain; charset=utf-8",
+			},
+		)
+		if err != nil {
+			log.Printf("WARN: could not upload process snapshot file %v: %s", file, err)
+		}
+	}
+	task.Log(fmt.Sprintf("Captured snapshot of running processes under %v/ due to %v", timeoutSnapshotDir, reason))
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshCredentialsDir is the directory, relative to the task directory, that
+// the task's SSH key pair and certificate are written to
+const sshCredentialsDir = ".taskcluster-ssh"
+
+type SSHCertificateFeature struct {
+}
+
+type SSHCertificateTask struct {
+	task *TaskRun
+	dir  string
+}
+
+func (feature *SSHCertificateFeature) Name() string {
+	return "SSH Certificate"
+}
+
+func (feature *SSHCertificateFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *SSHCertificateFeature) Initialise() error {
+	return nil
+}
+
+func (feature *SSHCertificateFeature) IsEnabled(task *TaskRun) bool {
+	return len(task.Payload.SSHCertificate.Principals) > 0
+}
+
+func (feature *SSHCertificateFeature) CheckSupported() error {
+	if config.SSHCertificateAuthorityKey == "" {
+		return fmt.Errorf("Task requires SSH credentials, but worker type %v does not have an SSH certificate authority configured", config.WorkerType)
+	}
+	// on Linux and macOS, task commands run as the worker user, so could read
+	// the certificate authority key and sign certificates for any principal,
+	// unless the key is hidden from them in the sandbox
+	if runtime.GOOS != "windows" && !config.Sandbox {
+		return fmt.Errorf("Task requires SSH credentials, but worker type %v does not run task commands in a sandbox (config setting sandbox), so tasks could read its SSH certificate authority key", config.WorkerType)
+	}
+	return nil
+}
+
+func (feature *SSHCertificateFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &SSHCertificateTask{
+		task: task,
+	}
+}
+
+// The certificate grants access to whatever systems trust the certificate
+// authority of the worker type, so each principal is restricted separately
+func (s *SSHCertificateTask) RequiredScopes() scopes.Required {
+	requiredScopes := []string{}
+	for _, principal := range s.task.Payload.SSHCertificate.Principals {
+		requiredScopes = append(requiredScopes, "generic-worker:ssh-certificate:"+config.ProvisionerID+"/"+config.WorkerType+"/"+principal)
+	}
+	return scopes.Required{requiredScopes}
+}
+
+// RequiredScopeSources names the principal that requires each scope
+func (s *SSHCertificateTask) RequiredScopeSources() map[string][]string {
+	sources := map[string][]string{}
+	for i, principal := range s.task.Payload.SSHCertificate.Principals {
+		scope := "generic-worker:ssh-certificate:" + config.ProvisionerID + "/" + config.WorkerType + "/" + principal
+		sources[scope] = append(sources[scope], fmt.Sprintf("sshCertificate.principals[%v]", i))
+	}
+	return sources
+}
+
+// Start generates a new key pair, signs its public key with the certificate
+// authority of the worker type, and writes both to the task directory.
+func (s *SSHCertificateTask) Start() error {
+	caKey, err := ioutil.ReadFile(config.SSHCertificateAuthorityKey)
+	if err != nil {
+		return fmt.Errorf("Could not read SSH certificate authority key: %s", err)
+	}
+	ca, err := ssh.ParsePrivateKey(caKey)
+	if err != nil {
+		return fmt.Errorf("Could not parse SSH certificate authority key %v: %s", config.SSHCertificateAuthorityKey, err)
+	}
+	cert, privateKey, err := s.task.newSSHCertificate(ca, time.Now())
+	if err != nil {
+		return err
+	}
+	s.dir = filepath.Join(TaskDir, sshCredentialsDir)
+	err = os.MkdirAll(s.dir, 0700)
+	if err != nil {
+		return err
+	}
+	keyFile := filepath.Join(s.dir, "id_ecdsa")
+	certFile := keyFile + "-cert.pub"
+	err = ioutil.WriteFile(keyFile, privateKey, 0600)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0644)
+	if err != nil {
+		return err
+	}
+	s.task.Log(fmt.Sprintf("Provisioned SSH certificate %v (serial %v) for principals %v, valid until %v", cert.KeyId, cert.Serial, cert.ValidPrincipals, time.Unix(int64(cert.ValidBefore), 0).UTC()))
+	s.task.setEnvVar("TASKCLUSTER_SSH_KEY", keyFile)
+	s.task.setEnvVar("TASKCLUSTER_SSH_CERTIFICATE", certFile)
+	return nil
+}
+
+// Stop deletes the key pair and certificate. The certificate cannot be
+// revoked, but expires when the task would have been aborted anyway.
+func (s *SSHCertificateTask) Stop() error {
+	if s.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(s.dir)
+}
+
+// newSSHCertificate generates a new ECDSA key pair, and returns a user
+// certificate for its public key signed by ca, together with the private key
+// in PEM format. The certificate is valid for the principals requested in the
+// task payload, from a few minutes before now (to allow for clock skew) until
+// the run deadline of the task.
+func (task *TaskRun) newSSHCertificate(ca ssh.Signer, now time.Time) (*ssh.Certificate, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial := make([]byte, 8)
+	_, err = rand.Read(serial)
+	if err != nil {
+		return nil, nil, err
+	}
+	cert := &ssh.Certificate{
+		Key:             publicKey,
+		Serial:          binary.BigEndian.Uint64(serial),
+		CertType:        ssh.UserCert,
+		KeyId:           fmt.Sprintf("%v/%v", task.TaskID, task.RunID),
+		ValidPrincipals: task.Payload.SSHCertificate.Principals,
+		ValidAfter:      uint64(now.Add(-5 * time.Minute).Unix()),
+		ValidBefore:     uint64(task.runDeadline().Unix()),
+		Permissions: ssh.Permissions{
+			Extensions: map[string]string{
+				"permit-pty": "",
+			},
+		},
+	}
+	err = cert.SignCert(rand.Reader, ca)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Could not sign SSH certificate: %s", err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// WorkerStatus is the json body served by the status endpoint (see config
+// setting statusPort), for tray tools and fleet dashboards.
+type WorkerStatus struct {
+	ProvisionerID string `json:"provisionerId"`
+	WorkerType    string `json:"workerType"`
+	WorkerGroup   string `json:"workerGroup"`
+	WorkerID      string `json:"workerId"`
+	Version       string `json:"version"`
+	// "idle", "maintenance", "quarantined" or "running-task"
+	State string `json:"state"`
+	// why the worker is quarantined, if it is
+	QuarantineReason string        `json:"quarantineReason,omitempty"`
+	TasksRun         int           `json:"tasksRun"`
+	Task             *TaskProgress `json:"task,omitempty"`
+	// estimated task run durations, by task queue
+	// (<provisionerId>/<workerType>)
+	DurationEstimates map[string]DurationEstimate `json:"durationEstimates"`
+}
+
+// TaskProgress describes the task the worker is currently running
+type TaskProgress struct {
+	TaskID string `json:"taskId"`
+	RunID  uint   `json:"runId"`
+	// "claimed", "starting", "running", "uploading-artifacts" or
+	// "finishing"
+	Phase             string        `json:"phase"`
+	PhaseStarted      tcclient.Time `json:"phaseStarted"`
+	ArtifactsUploaded int           `json:"artifactsUploaded"`
+}
+
+var currentStatus struct {
+	sync.Mutex
+	state      string
+	task       *TaskRun
+	phase      string
+	phaseStart time.Time
+	tasksRun   int
+	// copy of quarantineReason, see setQuarantined
+	quarantineReason string
+	// set once the worker is shutting down, see abortTasks
+	shutdownErr *CommandExecutionError
+}
+
+// setWorkerState records the state of the worker while it is not running a
+// task
+func setWorkerState(state string) {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	currentStatus.state = state
+	currentStatus.quarantineReason = ""
+}
+
+// setQuarantined records that the worker is quarantined for the given reason.
+// quarantineReason is only accessed from the worker loop, so is copied here
+// for the status endpoint.
+func setQuarantined(reason string) {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	currentStatus.state = "quarantined"
+	currentStatus.quarantineReason = reason
+}
+
+// setTaskPhase records which phase of running the given task the worker is in
+func (task *TaskRun) setTaskPhase(phase string) {
+	task.trace.setPhase(phase)
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	currentStatus.state = "running-task"
+	currentStatus.task = task
+	currentStatus.phase = phase
+	currentStatus.phaseStart = time.Now()
+	if currentStatus.shutdownErr != nil {
+		task.abort(currentStatus.shutdownErr)
+	}
+}
+
+// abortTasks aborts the task the worker is running, if any, and any task it
+// starts afterwards, since the worker is shutting down (see TaskRun.abort)
+func abortTasks(err *CommandExecutionError) {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	currentStatus.shutdownErr = err
+	if currentStatus.task != nil {
+		currentStatus.task.abort(err)
+	}
+}
+
+// shuttingDown returns true once abortTasks has been called, after which the
+// worker should not claim any more tasks
+func shuttingDown() bool {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	return currentStatus.shutdownErr != nil
+}
+
+// taskFinished records that the worker is no longer running a task
+func taskFinished() {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	if currentStatus.task != nil {
+		currentStatus.tasksRun++
+	}
+	currentStatus.task = nil
+	currentStatus.state = "idle"
+}
+
+func workerStatus() WorkerStatus {
+	currentStatus.Lock()
+	defer currentStatus.Unlock()
+	status := WorkerStatus{
+		ProvisionerID:    config.ProvisionerID,
+		WorkerType:       config.WorkerType,
+		WorkerGroup:      config.WorkerGroup,
+		WorkerID:         config.WorkerID,
+		Version:          version,
+		State:            currentStatus.state,
+		QuarantineReason: currentStatus.quarantineReason,
+		TasksRun:         currentStatus.tasksRun,
+		// durationEstimates has its own lock, which is never held while
+		// acquiring currentStatus
+		DurationEstimates: currentDurationEstimates(),
+	}
+	if task := currentStatus.task; task != nil {
+		task.lock.Lock()
+		uploaded := len(task.Artifacts)
+		task.lock.Unlock()
+		status.Task = &TaskProgress{
+			TaskID:            task.TaskID,
+			RunID:             task.RunID,
+			Phase:             currentStatus.phase,
+			PhaseStarted:      tcclient.Time(currentStatus.phaseStart),
+			ArtifactsUploaded: uploaded,
+		}
+	}
+	return status
+}
+
+// serveStatus serves the worker status as json at /status on the given port
+// of the loopback interface, for the lifetime of the worker
+func serveStatus(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%v", port))
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", handleStatus)
+	go func() {
+		err := http.Serve(listener, mux)
+		log.Printf("WARN: status endpoint stopped: %s", err)
+	}()
+	log.Printf("Serving worker status at http://%v/status", listener.Addr())
+	return nil
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "The worker status must be fetched with an HTTP GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(workerStatus())
+}
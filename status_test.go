@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+// The status endpoint should report the task being run, and its phase, and
+// count it once it has finished, and report why the worker is quarantined.
+func TestWorkerStatus(t *testing.T) {
+	defer func(c *Config) { config = c }(config)
+	config = &Config{WorkerType: "test-worker-type"}
+	defer taskFinished()
+	task := &TaskRun{TaskID: "KTBKfEgxR5GdfIIREQIvFQ", RunID: 2}
+	task.setTaskPhase("uploading-artifacts")
+	rec := httptest.NewRecorder()
+	handleStatus(rec, httptest.NewRequest("GET", "/status", nil))
+	var status WorkerStatus
+	err := json.Unmarshal(rec.Body.Bytes(), &status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.State != "running-task" || status.Task == nil || status.Task.TaskID != task.TaskID || status.Task.Phase != "uploading-artifacts" {
+		t.Fatalf("Unexpected worker status: %s", rec.Body.Bytes())
+	}
+	tasksRun := status.TasksRun
+	taskFinished()
+	status = workerStatus()
+	if status.State != "idle" || status.Task != nil || status.TasksRun != tasksRun+1 {
+		t.Fatalf("Expected idle worker status after task finished, but got %#v", status)
+	}
+	setQuarantined("task user could not be created")
+	status = workerStatus()
+	if status.State != "quarantined" || status.QuarantineReason != "task user could not be created" {
+		t.Fatalf("Expected quarantined worker status with reason, but got %#v", status)
+	}
+	setWorkerState("idle")
+	if status = workerStatus(); status.QuarantineReason != "" {
+		t.Fatalf("Expected no quarantine reason once worker is no longer quarantined, but got %#v", status)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ArtifactStorage stores the content of artifacts somewhere other than the
+// S3 buckets of the queue, for deployments that do not use AWS-backed queues.
+// Artifacts stored this way are published to the queue as redirect artifacts
+// to the url returned by Store.
+type ArtifactStorage interface {
+	// Store stores the content of the artifact, and returns the url that it
+	// can be downloaded from
+	Store(task *TaskRun, artifact S3Artifact) (url string, err error)
+}
+
+// DirectoryStorage copies artifact content to a local (or network mounted)
+// directory, which is served by a web server at BaseURL
+type DirectoryStorage struct {
+	Dir     string
+	BaseURL string
+}
+
+// HTTPStorage uploads artifact content with HTTP PUT requests, e.g. to a
+// WebDAV server, under BaseURL
+type HTTPStorage struct {
+	BaseURL string
+}
+
+// artifactStorage returns the artifact storage configured by config setting
+// artifactStorage, or nil if artifacts are stored by the queue
+func (c *Config) artifactStorage() ArtifactStorage {
+	switch c.ArtifactStorage {
+	case "directory":
+		return &DirectoryStorage{
+			Dir:     c.ArtifactStorageDir,
+			BaseURL: c.ArtifactStorageURL,
+		}
+	case "http":
+		return &HTTPStorage{
+			BaseURL: c.ArtifactStorageURL,
+		}
+	}
+	return nil
+}
+
+// artifactStoragePath returns where the content of the artifact is stored,
+// relative to the root of the artifact storage
+func (task *TaskRun) artifactStoragePath(artifact S3Artifact) string {
+	return fmt.Sprintf("%v/%v/%v", task.TaskID, task.RunID, artifact.ArtifactName())
+}
+
+func (s *DirectoryStorage) Store(task *TaskRun, artifact S3Artifact) (string, error) {
+	p := task.artifactStoragePath(artifact)
+	dest := filepath.Join(s.Dir, filepath.FromSlash(p))
+	// artifact names come from the task, so must not be trusted to stay
+	// inside the storage directory of the task run
+	runDir := filepath.Join(s.Dir, task.TaskID, strconv.Itoa(int(task.RunID)))
+	if !withinDirectory(runDir, dest) {
+		return "", fmt.Errorf("Artifact name %q would be stored outside of artifact storage directory %v", artifact.ArtifactName(), runDir)
+	}
+	err := os.MkdirAll(filepath.Dir(dest), 0755)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("Storing artifact %v in %v", artifact.ArtifactName(), dest)
+	err = copyFileContents(longPath(filepath.Join(TaskDir, artifact.CanonicalPath)), longPath(dest))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(s.BaseURL, "/") + "/" + p, nil
+}
+
+func (s *HTTPStorage) Store(task *TaskRun, artifact S3Artifact) (string, error) {
+	url := strings.TrimSuffix(s.BaseURL, "/") + "/" + task.artifactStoragePath(artifact)
+	// the server would not know to serve gzip compressed content with a
+	// Content-Encoding header, so the content is uploaded as is
+	artifact.ContentEncoding = ""
+	return url, artifact.put(url, url)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	// how long to wait before restarting the worker after it crashed
+	supervisorRestartDelay = 5 * time.Second
+	// how many bytes of the output of the worker are kept for crash logs
+	crashLogBytes = 64 * 1024
+)
+
+// crashLoopDetector tracks when the worker crashed, to detect it crashing
+// repeatedly
+type crashLoopDetector struct {
+	// number of crashes within window that make a crash loop
+	crashes int
+	window  time.Duration
+	times   []time.Time
+}
+
+// crashed records a crash at time t, and returns whether the worker is now
+// in a crash loop
+func (d *crashLoopDetector) crashed(t time.Time) bool {
+	recent := []time.Time{}
+	for _, crash := range append(d.times, t) {
+		if t.Sub(crash) < d.window {
+			recent = append(recent, crash)
+		}
+	}
+	d.times = recent
+	return d.crashes > 0 && len(d.times) >= d.crashes
+}
+
+// tailBuffer is a writer that keeps the last max bytes written to it
+type tailBuffer struct {
+	sync.Mutex
+	max  int
+	data []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.Lock()
+	defer t.Unlock()
+	t.data = append(t.data, p...)
+	if len(t.data) > t.max {
+		t.data = t.data[len(t.data)-t.max:]
+	}
+	return len(p), nil
+}
+
+// Bytes returns the bytes kept, and empties the buffer
+func (t *tailBuffer) Bytes() []byte {
+	t.Lock()
+	defer t.Unlock()
+	data := t.data
+	t.data = nil
+	return data
+}
+
+// quarantineFile is stored alongside the worker config. While it exists, the
+// worker is quarantined, and does not claim tasks.
+func quarantineFile(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), "quarantine.json")
+}
+
+// crashLogDir is stored alongside the worker config
+func crashLogDir(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), "crash-logs")
+}
+
+// loadQuarantine quarantines the worker if the given quarantine file exists,
+// and ends the quarantine once it has been deleted. It is called before each
+// attempt to claim a task (see runWorker), so deleting the file takes effect
+// without restarting the worker. A quarantine file that cannot be read still
+// quarantines the worker, since it was presumably written to do so.
+func loadQuarantine(file string) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		if quarantineFileInEffect == file {
+			log.Printf("Quarantine file %v has been deleted, ending quarantine", file)
+			quarantineReason = ""
+			quarantineFileInEffect = ""
+		}
+		return
+	}
+	if quarantined() {
+		return
+	}
+	var q struct {
+		Reason string `json:"reason"`
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &q)
+	}
+	if err != nil {
+		q.Reason = fmt.Sprintf("quarantine file could not be read: %s", err)
+	}
+	quarantine(fmt.Sprintf("%v (delete %v to end the quarantine)", q.Reason, file))
+	quarantineFileInEffect = file
+}
+
+// supervise runs the worker (generic-worker run) as a child process, and
+// restarts it whenever it crashes. The output of each crashed run is saved
+// in the crash log directory, and reported to the configured notification
+// webhooks. If the worker crashes config.CrashLoopRestarts times within
+// config.CrashLoopWindowSecs, the host is quarantined with a quarantine file,
+// and further restarts are delayed by the window. A worker that fails due to
+// invalid config (exit code 64) is not restarted.
+func supervise(configFile string, configureForAws bool) error {
+	c, err := loadConfig(configFile, false)
+	if err != nil {
+		// the worker will report the problem when it starts, and exit
+		log.Printf("WARN: could not load config for supervising the worker: %s", err)
+	}
+	if c == nil {
+		c = &Config{}
+	}
+	config = c
+	executable, err := workerExecutable()
+	if err != nil {
+		return err
+	}
+	args := []string{"run", "--config", configFile}
+	if configureForAws {
+		args = append(args, "--configure-for-aws")
+	}
+	detector := &crashLoopDetector{
+		crashes: config.CrashLoopRestarts,
+		window:  time.Duration(config.CrashLoopWindowSecs) * time.Second,
+	}
+	output := &tailBuffer{max: crashLogBytes}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	for {
+		cmd := exec.Command(executable, args...)
+		cmd.Stdout = io.MultiWriter(os.Stdout, output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, output)
+		log.Printf("Supervisor starting worker: %v %v", executable, args)
+		err := cmd.Start()
+		if err != nil {
+			return err
+		}
+		exited := make(chan error, 1)
+		go func() {
+			exited <- cmd.Wait()
+		}()
+		select {
+		case sig := <-signals:
+			log.Printf("Supervisor received %v, stopping worker", sig)
+			if errSignal := cmd.Process.Signal(sig); errSignal != nil {
+				cmd.Process.Kill()
+			}
+			<-exited
+			return nil
+		case err = <-exited:
+		}
+		if err == nil {
+			log.Print("Worker exited cleanly, so not restarting it")
+			return nil
+		}
+		crashTime := time.Now()
+		reportCrash(configFile, crashTime, err, output.Bytes())
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 64 {
+				return fmt.Errorf("Worker config %v is invalid, so not restarting worker", configFile)
+			}
+		}
+		delay := supervisorRestartDelay
+		if detector.crashed(crashTime) {
+			delay = detector.window
+			// unless still quarantined after an earlier crash loop
+			if _, errStat := os.Stat(quarantineFile(configFile)); os.IsNotExist(errStat) {
+				quarantineHost(configFile, fmt.Sprintf("worker crashed %v times within %v", len(detector.times), detector.window))
+			}
+		}
+		log.Printf("Supervisor restarting worker in %v", delay)
+		select {
+		case <-signals:
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
+
+// workerExecutable returns the absolute path of the running worker binary,
+// looking up os.Args[0] in PATH if it is not a path
+func workerExecutable() (string, error) {
+	executable, err := exec.LookPath(os.Args[0])
+	if err != nil {
+		return "", err
+	}
+	return filepath.Abs(executable)
+}
+
+// reportCrash saves the output of the crashed worker to the crash log
+// directory, and reports the crash to the configured notification webhooks
+func reportCrash(configFile string, crashTime time.Time, err error, output []byte) {
+	log.Printf("WORKER CRASHED: %v", err)
+	dir := crashLogDir(configFile)
+	crashLog := filepath.Join(dir, fmt.Sprintf("crash-%v.log", crashTime.UTC().Format("20060102T150405Z")))
+	errWrite := os.MkdirAll(dir, 0755)
+	if errWrite == nil {
+		errWrite = ioutil.WriteFile(crashLog, output, 0644)
+	}
+	if errWrite != nil {
+		log.Printf("WARN: could not save crash log: %s", errWrite)
+		crashLog = "(not saved)"
+	}
+	tail := output
+	if len(tail) > 2048 {
+		tail = tail[len(tail)-2048:]
+	}
+	notifyWorkerEvent("worker-crashed", fmt.Sprintf("Worker %v/%v crashed (%v), crash log %v:\n%s", config.WorkerType, config.WorkerID, err, crashLog, tail))
+}
+
+// quarantineHost writes the quarantine file, so that the worker starts
+// quarantined until an operator deletes it
+func quarantineHost(configFile, reason string) {
+	file := quarantineFile(configFile)
+	log.Printf("Quarantining host since %v", reason)
+	err := writeToFileAsJSON(map[string]string{"reason": reason}, file)
+	if err != nil {
+		log.Printf("WARN: could not write quarantine file %v: %s", file, err)
+	}
+	notifyWorkerEvent("worker-quarantined", fmt.Sprintf("Worker %v/%v quarantined since %v - delete %v to end the quarantine", config.WorkerType, config.WorkerID, reason, file))
+}
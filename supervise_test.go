@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The worker should be quarantined while the quarantine file exists, and the
+// quarantine should end once it has been deleted, without a restart. Other
+// quarantines should not be ended by the quarantine file being missing.
+func TestQuarantineFile(t *testing.T) {
+	defer func() {
+		quarantineReason = ""
+		quarantineFileInEffect = ""
+	}()
+	dir, err := ioutil.TempDir("", "generic-worker-quarantine")
+	if err != nil {
+		t.Fatalf("Could not create temp directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := quarantineFile(filepath.Join(dir, "generic-worker.config"))
+	loadQuarantine(file)
+	if quarantined() {
+		t.Fatalf("Expected worker not to be quarantined without a quarantine file, but quarantine reason is %q", quarantineReason)
+	}
+	err = writeToFileAsJSON(map[string]string{"reason": "worker crashed 3 times within 10m0s"}, file)
+	if err != nil {
+		t.Fatalf("Could not write quarantine file: %v", err)
+	}
+	loadQuarantine(file)
+	if !quarantined() || !strings.Contains(quarantineReason, "worker crashed 3 times") {
+		t.Fatalf("Expected worker to be quarantined due to quarantine file, but quarantine reason is %q", quarantineReason)
+	}
+	err = os.Remove(file)
+	if err != nil {
+		t.Fatalf("Could not delete quarantine file: %v", err)
+	}
+	loadQuarantine(file)
+	if quarantined() {
+		t.Fatalf("Expected quarantine to end once quarantine file was deleted, but quarantine reason is %q", quarantineReason)
+	}
+	quarantine("post task check failed")
+	loadQuarantine(file)
+	if !quarantined() {
+		t.Fatal("Expected quarantine not due to the quarantine file to continue")
+	}
+}
+
+// The supervisor should only detect a crash loop once enough crashes have
+// happened within the window, and keep only the tail of the worker output.
+func TestCrashLoopDetector(t *testing.T) {
+	d := &crashLoopDetector{crashes: 3, window: 10 * time.Minute}
+	start := time.Now()
+	// the first crash has dropped out of the window by the third
+	for i, minutes := range []int{0, 6, 12, 14} {
+		expected := i == 3
+		if d.crashed(start.Add(time.Duration(minutes)*time.Minute)) != expected {
+			t.Fatalf("Expected crash loop detected to be %v after crash %v", expected, i+1)
+		}
+	}
+	output := &tailBuffer{max: 5}
+	fmt.Fprint(output, "abc")
+	fmt.Fprint(output, "defg")
+	if tail := string(output.Bytes()); tail != "cdefg" {
+		t.Fatalf("Expected last 5 bytes of output, got %q", tail)
+	}
+	if len(output.Bytes()) != 0 {
+		t.Fatal("Expected output to be emptied after reading it")
+	}
+}
@@ -16,7 +16,11 @@ import (
 var zeroProcAttr syscall.ProcAttr
 var zeroSysProcAttr syscall.SysProcAttr
 
-func StartProcess(argv0 string, argv []string, attr *syscall.ProcAttr, username, password string) (pid int, handle uintptr, err error) {
+// StartProcess starts the given process, as the given user if username and
+// password are set. If sessionID is non-zero, the process is instead started
+// in that Windows session, as the user logged on to it, on its interactive
+// desktop, which requires the calling process to run as LocalSystem.
+func StartProcess(argv0 string, argv []string, attr *syscall.ProcAttr, username, password string, sessionID uint32) (pid int, handle uintptr, err error) {
 	if len(argv0) == 0 {
 		return 0, 0, syscall.EWINDOWS
 	}
@@ -110,7 +114,36 @@ func StartProcess(argv0 string, argv []string, attr *syscall.ProcAttr, username,
 	pi := new(syscall.ProcessInformation)
 
 	flags := (sys.CreationFlags | syscall.CREATE_UNICODE_ENVIRONMENT) &^ CREATE_NEW_CONSOLE
-	if username+password != "" {
+	if sessionID != 0 {
+		var token syscall.Token
+		err = WTSQueryUserToken(sessionID, &token)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer token.Close()
+		// the environment of the logged on user, as with
+		// CreateProcessWithLogon, rather than that of the calling process
+		var env *uint16
+		err = CreateEnvironmentBlock(&env, token, false)
+		if err != nil {
+			return 0, 0, err
+		}
+		defer DestroyEnvironmentBlock(env)
+		si.Desktop = syscall.StringToUTF16Ptr(`winsta0\default`)
+		err = CreateProcessAsUser(
+			token,
+			argv0p,
+			argvp,
+			nil,
+			nil,
+			true,
+			flags,
+			env,
+			dirp,
+			si,
+			pi,
+		)
+	} else if username+password != "" {
 		err = CreateProcessWithLogon(
 			syscall.StringToUTF16Ptr(username),
 			syscall.StringToUTF16Ptr("."),
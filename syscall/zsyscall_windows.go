@@ -15,10 +15,15 @@ var (
 	modadvapi32 = syscall.NewLazyDLL("advapi32.dll")
 	modkernel32 = syscall.NewLazyDLL("kernel32.dll")
 	moduserenv  = syscall.NewLazyDLL("userenv.dll")
+	modwtsapi32 = syscall.NewLazyDLL("wtsapi32.dll")
 
 	procCreateProcessWithLogonW = modadvapi32.NewProc("CreateProcessWithLogonW")
+	procCreateProcessAsUserW    = modadvapi32.NewProc("CreateProcessAsUserW")
 	procCreateProcessW          = modkernel32.NewProc("CreateProcessW")
 	procCreateProfile           = moduserenv.NewProc("CreateProfile")
+	procCreateEnvironmentBlock  = moduserenv.NewProc("CreateEnvironmentBlock")
+	procDestroyEnvironmentBlock = moduserenv.NewProc("DestroyEnvironmentBlock")
+	procWTSQueryUserToken       = modwtsapi32.NewProc("WTSQueryUserToken")
 )
 
 const LOGON_WITH_PROFILE = 0x00000001
@@ -106,3 +111,108 @@ func CreateProcess(
 	}
 	return
 }
+
+func CreateProcessAsUser(
+	token syscall.Token,
+	appName *uint16,
+	commandLine *uint16,
+	procSecurity *syscall.SecurityAttributes,
+	threadSecurity *syscall.SecurityAttributes,
+	inheritHandles bool,
+	creationFlags uint32,
+	env *uint16,
+	currentDir *uint16,
+	startupInfo *syscall.StartupInfo,
+	outProcInfo *syscall.ProcessInformation,
+) (err error) {
+	var _p0 uint32
+	if inheritHandles {
+		_p0 = 1
+	} else {
+		_p0 = 0
+	}
+	r1, _, e1 := syscall.Syscall12(
+		procCreateProcessAsUserW.Addr(),
+		11,
+		uintptr(token),
+		uintptr(unsafe.Pointer(appName)),
+		uintptr(unsafe.Pointer(commandLine)),
+		uintptr(unsafe.Pointer(procSecurity)),
+		uintptr(unsafe.Pointer(threadSecurity)),
+		uintptr(_p0),
+		uintptr(creationFlags),
+		uintptr(unsafe.Pointer(env)),
+		uintptr(unsafe.Pointer(currentDir)),
+		uintptr(unsafe.Pointer(startupInfo)),
+		uintptr(unsafe.Pointer(outProcInfo)),
+		0,
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
+func CreateEnvironmentBlock(env **uint16, token syscall.Token, inherit bool) (err error) {
+	var _p0 uint32
+	if inherit {
+		_p0 = 1
+	} else {
+		_p0 = 0
+	}
+	r1, _, e1 := syscall.Syscall(
+		procCreateEnvironmentBlock.Addr(),
+		3,
+		uintptr(unsafe.Pointer(env)),
+		uintptr(token),
+		uintptr(_p0),
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
+func DestroyEnvironmentBlock(env *uint16) (err error) {
+	r1, _, e1 := syscall.Syscall(
+		procDestroyEnvironmentBlock.Addr(),
+		1,
+		uintptr(unsafe.Pointer(env)),
+		0,
+		0,
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
+
+func WTSQueryUserToken(sessionID uint32, token *syscall.Token) (err error) {
+	r1, _, e1 := syscall.Syscall(
+		procWTSQueryUserToken.Addr(),
+		2,
+		uintptr(sessionID),
+		uintptr(unsafe.Pointer(token)),
+		0,
+	)
+	if r1 == 0 {
+		if e1 != 0 {
+			err = error(e1)
+		} else {
+			err = syscall.EINVAL
+		}
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// keptTaskDir is the task directory of a failed task, which is not deleted
+// when the next task is prepared, so that the failure can be investigated on
+// the worker (see config setting keepTaskDirs)
+type keptTaskDir struct {
+	TaskID   string        `json:"taskId"`
+	RunID    uint          `json:"runId"`
+	User     string        `json:"user"`
+	Dir      string        `json:"dir"`
+	Resolved tcclient.Time `json:"resolved"`
+}
+
+// keptTaskDirsFile returns the file that kept task directories are recorded
+// in, which lives alongside the worker config file
+func keptTaskDirsFile() string {
+	return filepath.Join(filepath.Dir(configFile), "kept-task-dirs.json")
+}
+
+// loadKeptTaskDirs returns the kept task directories, oldest first
+func loadKeptTaskDirs() ([]keptTaskDir, error) {
+	dirs := []keptTaskDir{}
+	data, err := ioutil.ReadFile(keptTaskDirsFile())
+	if os.IsNotExist(err) {
+		return dirs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &dirs)
+	if err != nil {
+		return nil, err
+	}
+	return dirs, nil
+}
+
+// keepTaskDir records that the directory of the given failed task should be
+// kept, if task directories are not shared between tasks (see perTaskDirs).
+func (task *TaskRun) keepTaskDir() {
+	if config.KeepTaskDirs <= 0 || !perTaskDirs() {
+		return
+	}
+	log.Printf("Keeping task directory %v of failed task %v/%v", TaskDir, task.TaskID, task.RunID)
+	err := recordKeptTaskDir(keptTaskDir{
+		TaskID:   task.TaskID,
+		RunID:    task.RunID,
+		User:     TaskUser.Name,
+		Dir:      TaskDir,
+		Resolved: tcclient.Time(time.Now()),
+	})
+	if err != nil {
+		log.Printf("WARN: could not record kept task directory %v: %s", TaskDir, err)
+	}
+}
+
+// recordKeptTaskDir adds dir to the kept task directories. Only the
+// directories of the most recent config.KeepTaskDirs failed tasks are kept;
+// older ones are deleted along with the directories of successful tasks.
+func recordKeptTaskDir(dir keptTaskDir) error {
+	dirs, err := loadKeptTaskDirs()
+	if err != nil {
+		log.Printf("WARN: could not read kept task directories, so forgetting them: %s", err)
+		dirs = []keptTaskDir{}
+	}
+	dirs = append(dirs, dir)
+	if len(dirs) > config.KeepTaskDirs {
+		dirs = dirs[len(dirs)-config.KeepTaskDirs:]
+	}
+	return writeToFileAsJSON(dirs, keptTaskDirsFile())
+}
+
+// keptTaskUsers returns the names of the task users whose directories are
+// kept, and therefore should not be deleted
+func keptTaskUsers() map[string]bool {
+	users := map[string]bool{}
+	dirs, err := loadKeptTaskDirs()
+	if err != nil {
+		log.Printf("WARN: could not read kept task directories: %s", err)
+		return users
+	}
+	for _, dir := range dirs {
+		users[dir.User] = true
+	}
+	return users
+}
+
+// listTaskDirs writes the kept task directories to out, most recent first
+func listTaskDirs(out io.Writer) error {
+	dirs, err := loadKeptTaskDirs()
+	if err != nil {
+		return err
+	}
+	if len(dirs) == 0 {
+		fmt.Fprintln(out, "No task directories kept")
+		return nil
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fmt.Fprintf(out, "%v  %v/%v  %v\n", dirs[i].Resolved, dirs[i].TaskID, dirs[i].RunID, dirs[i].Dir)
+	}
+	return nil
+}
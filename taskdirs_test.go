@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// Only the directories of the most recent config.KeepTaskDirs failed tasks
+// should be kept, and they should be listed most recent first.
+func TestKeptTaskDirs(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-kept-task-dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(c *Config, file string) {
+		config = c
+		configFile = file
+	}(config, configFile)
+	config = &Config{KeepTaskDirs: 2}
+	configFile = filepath.Join(tempDir, "generic-worker.config")
+
+	out := &bytes.Buffer{}
+	err = listTaskDirs(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "No task directories kept\n" {
+		t.Fatalf("Expected no task directories to be listed, but got:\n%v", out)
+	}
+
+	resolved := time.Now()
+	for i := 1; i <= 3; i++ {
+		err = recordKeptTaskDir(keptTaskDir{
+			TaskID:   fmt.Sprintf("task%v", i),
+			RunID:    0,
+			User:     fmt.Sprintf("task_%v", i),
+			Dir:      filepath.Join(tempDir, fmt.Sprintf("task_%v", i)),
+			Resolved: tcclient.Time(resolved.Add(time.Duration(i) * time.Minute)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dirs, err := loadKeptTaskDirs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 2 || dirs[0].TaskID != "task2" || dirs[1].TaskID != "task3" {
+		t.Fatalf("Expected task directories of task2 and task3 to be kept, but got %#v", dirs)
+	}
+	// the directory of task1 has expired, so it is no longer protected from
+	// deletion
+	if users := keptTaskUsers(); !reflect.DeepEqual(users, map[string]bool{"task_2": true, "task_3": true}) {
+		t.Fatalf("Expected task users task_2 and task_3 to be kept, but got %v", users)
+	}
+
+	out.Reset()
+	err = listTaskDirs(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "task3/0") || !strings.Contains(lines[1], "task2/0") {
+		t.Fatalf("Expected task3 to be listed before task2, but got:\n%v", out)
+	}
+}
+
+// Task directories should not be kept when config setting keepTaskDirs is 0.
+func TestKeepTaskDirDisabled(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "generic-worker-kept-task-dirs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDir)
+	defer func(c *Config, file string) {
+		config = c
+		configFile = file
+	}(config, configFile)
+	config = &Config{KeepTaskDirs: 0}
+	configFile = filepath.Join(tempDir, "generic-worker.config")
+
+	task := &TaskRun{TaskID: "task1"}
+	task.keepTaskDir()
+	if _, err := os.Stat(keptTaskDirsFile()); !os.IsNotExist(err) {
+		t.Fatalf("Expected no kept task directories to be recorded, but %v exists (%v)", keptTaskDirsFile(), err)
+	}
+}
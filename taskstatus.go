@@ -5,6 +5,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"sync"
 
 	"github.com/taskcluster/httpbackoff"
 	"github.com/taskcluster/taskcluster-client-go"
@@ -18,6 +19,22 @@ type TaskStatusUpdate struct {
 	Reason     string
 }
 
+// taskStatusLock is held while a task status update is requested and its
+// reply read, so that each reply reaches the goroutine that requested the
+// update, since updates are requested concurrently (e.g. by the reclaim timer,
+// and by artifact uploads refreshing task credentials)
+var taskStatusLock sync.Mutex
+
+// updateTaskStatus requests the given update from the task status handler
+// (see TaskStatusHandler), and returns the error it replies with. It is safe
+// to call from multiple goroutines.
+func updateTaskStatus(update TaskStatusUpdate) error {
+	taskStatusLock.Lock()
+	defer taskStatusLock.Unlock()
+	taskStatusUpdate <- update
+	return <-taskStatusUpdateErr
+}
+
 // Enumerate task status to aid life-cycle decision making
 // Use strings for benefit of simple logging/reporting
 const (
@@ -50,7 +67,12 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 
 	reportException := func(task *TaskRun, reason string) error {
 		ter := queue.TaskExceptionRequest{Reason: reason}
-		tsr, err := Queue.ReportException(task.TaskID, strconv.FormatInt(int64(task.RunID), 10), &ter)
+		var tsr *queue.TaskStatusResponse
+		err := retryOnAuthError("reportException", reloadWorkerCredentials, func() (err error) {
+			limitQueueCall("reportException")
+			tsr, err = Queue.ReportException(task.TaskID, strconv.FormatInt(int64(task.RunID), 10), &ter)
+			return
+		})
 		if err != nil {
 			log.Printf("Not able to report exception for task %v:", task.TaskID)
 			log.Printf("%v", err)
@@ -62,7 +84,12 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 	}
 
 	reportFailed := func(task *TaskRun) error {
-		tsr, err := Queue.ReportFailed(task.TaskID, strconv.FormatInt(int64(task.RunID), 10))
+		var tsr *queue.TaskStatusResponse
+		err := retryOnAuthError("reportFailed", reloadWorkerCredentials, func() (err error) {
+			limitQueueCall("reportFailed")
+			tsr, err = Queue.ReportFailed(task.TaskID, strconv.FormatInt(int64(task.RunID), 10))
+			return
+		})
 		if err != nil {
 			log.Printf("Not able to report failed completion for task %v:", task.TaskID)
 			log.Printf("%v", err)
@@ -75,7 +102,12 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 
 	reportCompleted := func(task *TaskRun) error {
 		log.Println("Command finished successfully!")
-		tsr, err := Queue.ReportCompleted(task.TaskID, strconv.FormatInt(int64(task.RunID), 10))
+		var tsr *queue.TaskStatusResponse
+		err := retryOnAuthError("reportCompleted", reloadWorkerCredentials, func() (err error) {
+			limitQueueCall("reportCompleted")
+			tsr, err = Queue.ReportCompleted(task.TaskID, strconv.FormatInt(int64(task.RunID), 10))
+			return
+		})
 		if err != nil {
 			log.Printf("Not able to report successful completion for task %v:", task.TaskID)
 			log.Printf("%v", err)
@@ -94,6 +126,7 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 		}
 		// Using the taskId and runId from the <MessageText> tag, the worker
 		// must call queue.claimTask().
+		limitQueueCall("claimTask")
 		tcrsp, err := Queue.ClaimTask(task.TaskID, fmt.Sprintf("%d", task.RunID), &task.TaskClaimRequest)
 		// check if an error occurred...
 		if err != nil {
@@ -135,7 +168,17 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 
 	reclaim := func(task *TaskRun) error {
 		log.Printf("Reclaiming task %v...", task.TaskID)
-		tcrsp, err := Queue.ReclaimTask(task.TaskID, fmt.Sprintf("%d", task.RunID))
+		err := injectFault("reclaim")
+		if err != nil {
+			log.Printf("%v", err)
+			return err
+		}
+		var tcrsp *queue.TaskReclaimResponse
+		err = retryOnAuthError("reclaimTask", reloadWorkerCredentials, func() (err error) {
+			limitQueueCall("reclaimTask")
+			tcrsp, err = Queue.ReclaimTask(task.TaskID, fmt.Sprintf("%d", task.RunID))
+			return
+		})
 
 		// check if an error occurred...
 		if err != nil {
@@ -143,7 +186,9 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 			return err
 		}
 
+		task.lock.Lock()
 		task.TaskReclaimResponse = *tcrsp
+		task.lock.Unlock()
 		// note we don't need to worry about a mutex here since either old
 		// value or new value can be used for some crossover time, and the
 		// update should be atomic
@@ -156,12 +201,11 @@ func TaskStatusHandler() (request chan<- TaskStatusUpdate, err <-chan error, don
 		return nil
 	}
 
+	// reason must be one of the exception reasons the queue accepts
 	abort := func(task *TaskRun, reason string) error {
 		log.Printf("Aborting task %v due to: %v...", task.TaskID, reason)
 		task.Status = Aborted
-		// TODO: need to kill running jobs! Need a go routine to track running
-		// jobs, and kill them on aborts
-		return reportException(task, "task-cancelled")
+		return reportException(task, reason)
 	}
 
 	cancel := func(task *TaskRun, reason string) error {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// Concurrent uploads may refresh task credentials at the same time, and each
+// should get the reply to its own reclaim.
+func TestConcurrentTaskStatusUpdates(t *testing.T) {
+	defer func(r chan<- TaskStatusUpdate, e <-chan error, d chan<- bool) {
+		taskStatusUpdate, taskStatusUpdateErr, taskStatusDoneChan = r, e, d
+	}(taskStatusUpdate, taskStatusUpdateErr, taskStatusDoneChan)
+	r := make(chan TaskStatusUpdate)
+	// buffered, so that the handler does not wait for each reply to be read
+	// before handling the next request
+	e := make(chan error, 100)
+	taskStatusUpdate, taskStatusUpdateErr = r, e
+	go func() {
+		for update := range r {
+			e <- errors.New(update.Task.TaskID)
+		}
+	}()
+	defer close(r)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(taskID string) {
+			defer wg.Done()
+			err := (&TaskRun{TaskID: taskID}).refreshTaskCredentials()
+			if err == nil || err.Error() != taskID {
+				t.Errorf("Expected reply to reclaim of task %v, but got %v", taskID, err)
+			}
+		}(fmt.Sprintf("task%v", i))
+	}
+	wg.Wait()
+}
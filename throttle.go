@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"time"
+)
+
+// throttledReader wraps an io.Reader, sleeping as necessary so that on
+// average no more than bytesPerSec bytes are read per second. This is used to
+// stop artifact uploads from saturating the network link of the worker.
+type throttledReader struct {
+	r           io.Reader
+	bytesPerSec int64
+	start       time.Time
+	read        int64
+}
+
+func newThrottledReader(r io.Reader, bytesPerSec int64) *throttledReader {
+	return &throttledReader{
+		r:           r,
+		bytesPerSec: bytesPerSec,
+	}
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	// read no more than one second's worth at a time, to keep the rate smooth
+	if int64(len(p)) > t.bytesPerSec {
+		p = p[:t.bytesPerSec]
+	}
+	n, err := t.r.Read(p)
+	t.read += int64(n)
+	due := t.start.Add(time.Duration(float64(t.read) / float64(t.bytesPerSec) * float64(time.Second)))
+	if wait := due.Sub(time.Now()); wait > 0 {
+		time.Sleep(wait)
+	}
+	return n, err
+}
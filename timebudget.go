@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+type TimeBudgetFeature struct {
+}
+
+// TimeBudget is the json body served by the time budget url, so that tasks
+// can find out how much longer they may run for.
+type TimeBudget struct {
+	// deadline of the task definition
+	TaskDeadline tcclient.Time `json:"taskDeadline"`
+	// when the task is aborted for exceeding payload property maxRunTime
+	MaxRunTimeDeadline tcclient.Time `json:"maxRunTimeDeadline"`
+	// when the current claim of the task expires (the worker reclaims the
+	// task before then, while it is running)
+	TakenUntil tcclient.Time `json:"takenUntil"`
+	// seconds until the earlier of taskDeadline and maxRunTimeDeadline
+	RemainingSeconds int64 `json:"remainingSeconds"`
+}
+
+type TimeBudgetTask struct {
+	task     *TaskRun
+	listener net.Listener
+}
+
+func (feature *TimeBudgetFeature) Name() string {
+	return "Time Budget"
+}
+
+func (feature *TimeBudgetFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *TimeBudgetFeature) Initialise() error {
+	return nil
+}
+
+func (feature *TimeBudgetFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.TimeBudget
+}
+
+func (feature *TimeBudgetFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *TimeBudgetFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &TimeBudgetTask{
+		task: task,
+	}
+}
+
+func (b *TimeBudgetTask) RequiredScopes() scopes.Required {
+	// the endpoint only listens on the loopback interface, and only reveals
+	// information about the task itself
+	return scopes.Required{}
+}
+
+// Start listens on a random port of the loopback interface, and exports the
+// url that the time budget can be fetched from in env var
+// TASKCLUSTER_TIME_BUDGET_URL.
+func (b *TimeBudgetTask) Start() error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	b.listener = listener
+	mux := http.NewServeMux()
+	mux.HandleFunc("/budget", b.handleBudget)
+	go http.Serve(listener, mux)
+	b.task.setEnvVar("TASKCLUSTER_TIME_BUDGET_URL", "http://"+listener.Addr().String()+"/budget")
+	return nil
+}
+
+func (b *TimeBudgetTask) Stop() error {
+	if b.listener == nil {
+		return nil
+	}
+	err := b.listener.Close()
+	if err != nil {
+		// no need to raise an exception
+		log.Printf("WARN: could not close time budget listener: %s", err)
+	}
+	return nil
+}
+
+func (b *TimeBudgetTask) handleBudget(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "The time budget must be fetched with an HTTP GET request", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(b.task.timeBudget())
+}
+
+// timeBudget returns the deadlines of the task, and how long it has left
+// until the earlier of them
+func (task *TaskRun) timeBudget() TimeBudget {
+	remaining := task.runDeadline().Sub(time.Now())
+	if remaining < 0 {
+		remaining = 0
+	}
+	return TimeBudget{
+		TaskDeadline:       task.Definition.Deadline,
+		MaxRunTimeDeadline: tcclient.Time(task.maxRunTimeDeadline),
+		TakenUntil:         tcclient.Time(task.takenUntil()),
+		RemainingSeconds:   int64(remaining / time.Second),
+	}
+}
+
+// runDeadline returns the earlier of the task deadline and the time the task
+// is aborted for exceeding payload property maxRunTime
+func (task *TaskRun) runDeadline() time.Time {
+	deadline := time.Time(task.Definition.Deadline)
+	if task.maxRunTimeDeadline.Before(deadline) {
+		deadline = task.maxRunTimeDeadline
+	}
+	return deadline
+}
+
+// takenUntil returns when the current claim of the task expires
+func (task *TaskRun) takenUntil() time.Time {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	// First time we need to check claim response, after that, need to check reclaim response
+	if len(task.TaskReclaimResponse.Status.Runs) > 0 {
+		return time.Time(task.TaskReclaimResponse.Status.Runs[task.RunID].TakenUntil)
+	}
+	return time.Time(task.TaskClaimResponse.Status.Runs[task.RunID].TakenUntil)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	tcclient "github.com/taskcluster/taskcluster-client-go"
+)
+
+// The time budget endpoint should report the task deadlines, the claim
+// expiry, and the time remaining until the earlier deadline.
+func TestTimeBudget(t *testing.T) {
+	task := &TaskRun{maxRunTimeDeadline: time.Now().Add(time.Hour)}
+	task.Definition.Deadline = tcclient.Time(time.Now().Add(2 * time.Hour))
+	claim := `{"status": {"runs": [{"runId": 0, "takenUntil": "2030-01-01T00:00:00.000Z"}]}}`
+	err := json.Unmarshal([]byte(claim), &task.TaskClaimResponse)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskFeature := (&TimeBudgetFeature{}).NewTaskFeature(task)
+	err = taskFeature.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskFeature.Stop()
+	resp, err := http.Get(task.featureEnv["TASKCLUSTER_TIME_BUDGET_URL"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var budget TimeBudget
+	err = json.NewDecoder(resp.Body).Decode(&budget)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budget.RemainingSeconds <= 3500 || budget.RemainingSeconds > 3600 {
+		t.Fatalf("Expected about an hour remaining until max run time deadline, but got %v seconds", budget.RemainingSeconds)
+	}
+	if budget.TakenUntil.String() != "2030-01-01T00:00:00.000Z" {
+		t.Fatalf("Expected takenUntil from claim response, but got %v", budget.TakenUntil)
+	}
+}
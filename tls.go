@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime"
+)
+
+// newHTTPClient returns an http client for transferring artifacts, which
+// additionally trusts the CA certificates in c.CACertificatesFile, and
+// presents the client certificate in c.ClientCertificateFile, if configured.
+// This is needed for mirrors and artifact stores that use a private PKI.
+func (c *Config) newHTTPClient() (*http.Client, error) {
+	if c.CACertificatesFile == "" && c.ClientCertificateFile == "" {
+		return &http.Client{}, nil
+	}
+	tlsConfig := &tls.Config{}
+	if c.CACertificatesFile != "" {
+		pemCerts, err := ioutil.ReadFile(c.CACertificatesFile)
+		if err != nil {
+			return nil, err
+		}
+		// the certificates are trusted in addition to the system roots, so
+		// don't silently fall back to trusting only them (SystemCertPool is
+		// not supported on Windows)
+		rootCAs, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("Config setting caCertificatesFile is not supported on %v, since the system root certificates cannot be loaded: %s", runtime.GOOS, err)
+		}
+		if !rootCAs.AppendCertsFromPEM(pemCerts) {
+			return nil, fmt.Errorf("No PEM encoded certificates found in caCertificatesFile %v", c.CACertificatesFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+	if c.ClientCertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertificateFile, c.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
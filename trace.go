@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// traceEvent is an event of the Chrome trace event format, as understood by
+// about:tracing and Perfetto
+type traceEvent struct {
+	Name     string            `json:"name"`
+	Category string            `json:"cat,omitempty"`
+	Phase    string            `json:"ph"`
+	Time     int64             `json:"ts"`
+	Duration int64             `json:"dur,omitempty"`
+	PID      int               `json:"pid"`
+	TID      int               `json:"tid"`
+	Args     map[string]string `json:"args,omitempty"`
+}
+
+// taskTrace records how long the worker spent on each part of running a task
+// (claiming it, its phases, starting task features, running commands and
+// uploading artifacts), published as artifact public/logs/trace.json. Each
+// track (e.g. "commands") is shown as a separate thread.
+type taskTrace struct {
+	sync.Mutex
+	events []traceEvent
+	tracks map[string]int
+	// current phase of the task, see setTaskPhase
+	phase      string
+	phaseStart time.Time
+}
+
+// span records that name (in the given track) started at start, and has just
+// finished
+func (t *taskTrace) span(track, name string, start time.Time, args map[string]string) {
+	if t == nil {
+		return
+	}
+	t.Lock()
+	defer t.Unlock()
+	t.record(track, name, start, time.Now(), args)
+}
+
+// record must be called with t locked
+func (t *taskTrace) record(track, name string, start, end time.Time, args map[string]string) {
+	if t.tracks == nil {
+		t.tracks = map[string]int{}
+	}
+	tid, exists := t.tracks[track]
+	if !exists {
+		tid = len(t.tracks) + 1
+		t.tracks[track] = tid
+		t.events = append(t.events, traceEvent{
+			Name:  "thread_name",
+			Phase: "M",
+			PID:   1,
+			TID:   tid,
+			Args:  map[string]string{"name": track},
+		})
+	}
+	t.events = append(t.events, traceEvent{
+		Name:     name,
+		Category: track,
+		Phase:    "X",
+		Time:     start.UnixNano() / 1000,
+		Duration: end.Sub(start).Nanoseconds() / 1000,
+		PID:      1,
+		TID:      tid,
+		Args:     args,
+	})
+}
+
+// setPhase ends the current phase of the task (if any), and starts the given
+// one; an empty phase just ends the current one
+func (t *taskTrace) setPhase(phase string) {
+	t.Lock()
+	defer t.Unlock()
+	now := time.Now()
+	if t.phase != "" {
+		t.record("phases", t.phase, t.phaseStart, now, nil)
+	}
+	t.phase = phase
+	t.phaseStart = now
+}
+
+// uploadTrace publishes the trace of the task so far as artifact
+// public/logs/trace.json
+func (task *TaskRun) uploadTrace() error {
+	task.trace.setPhase("")
+	task.trace.Lock()
+	data, err := json.MarshalIndent(map[string]interface{}{"traceEvents": task.trace.events}, "", "  ")
+	task.trace.Unlock()
+	if err != nil {
+		return err
+	}
+	traceFile := filepath.Join(TaskDir, "public", "logs", "trace.json")
+	err = os.MkdirAll(filepath.Dir(traceFile), 0755)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(traceFile, data, 0644)
+	if err != nil {
+		return err
+	}
+	return task.uploadArtifact(
+		S3Artifact{
+			BaseArtifact: BaseArtifact{
+				CanonicalPath: "public/logs/trace.json",
+				Expires:       task.Definition.Expires,
+			},
+			MimeType:        "application/json",
+			ContentEncoding: "gzip",
+		},
+	)
+}
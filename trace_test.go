@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskTrace(t *testing.T) {
+	trace := &taskTrace{}
+	start := time.Now().Add(-time.Second)
+	trace.setPhase("running")
+	trace.span("commands", "command 0", start, map[string]string{"command": "echo hello"})
+	trace.span("commands", "command 1", start, nil)
+	trace.setPhase("finishing")
+	trace.setPhase("")
+
+	names := []string{}
+	tids := map[string]int{}
+	for _, e := range trace.events {
+		if e.Phase == "M" {
+			tids[e.Args["name"]] = e.TID
+			continue
+		}
+		names = append(names, e.Name)
+		if e.TID != tids[e.Category] {
+			t.Fatalf("Event %v of track %v has tid %v but track has tid %v", e.Name, e.Category, e.TID, tids[e.Category])
+		}
+	}
+	expected := []string{"command 0", "command 1", "running", "finishing"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected trace events %v but got %v", expected, names)
+	}
+	if len(tids) != 2 || tids["commands"] == tids["phases"] {
+		t.Fatalf("Expected separate tracks for commands and phases but got %v", tids)
+	}
+	if d := trace.events[1].Duration; d < 1000000 {
+		t.Fatalf("Expected span of command 0 to last at least 1s but it lasted %vus", d)
+	}
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// parseUmask interprets config setting umask, an octal file mode creation
+// mask such as "0022"
+func parseUmask(umask string) (int, error) {
+	mask, err := strconv.ParseUint(umask, 8, 32)
+	if err != nil || mask > 0777 {
+		return 0, fmt.Errorf("Config setting umask %q is not a valid octal file mode creation mask between 0000 and 0777", umask)
+	}
+	return int(mask), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/taskcluster/taskcluster-base-go/scopes"
+)
+
+type UnconfinedFeature struct {
+}
+
+type UnconfinedTask struct {
+	task *TaskRun
+}
+
+func (feature *UnconfinedFeature) Name() string {
+	return "Unconfined"
+}
+
+func (feature *UnconfinedFeature) Dependencies() []string {
+	return nil
+}
+
+func (feature *UnconfinedFeature) Initialise() error {
+	return nil
+}
+
+func (feature *UnconfinedFeature) IsEnabled(task *TaskRun) bool {
+	return task.Payload.Features.Unconfined
+}
+
+func (feature *UnconfinedFeature) CheckSupported() error {
+	return nil
+}
+
+func (feature *UnconfinedFeature) NewTaskFeature(task *TaskRun) TaskFeature {
+	return &UnconfinedTask{
+		task: task,
+	}
+}
+
+// Opting out of the AppArmor profile of the worker type weakens the isolation
+// of the worker from the task, so is restricted per worker type
+func (u *UnconfinedTask) RequiredScopes() scopes.Required {
+	return scopes.Required{
+		{"generic-worker:unconfined:" + config.ProvisionerID + "/" + config.WorkerType},
+	}
+}
+
+func (u *UnconfinedTask) Start() error {
+	if runtime.GOOS == "linux" && config.AppArmorProfile != "" {
+		u.task.Log(fmt.Sprintf("Task commands will not be confined by AppArmor profile %q", config.AppArmorProfile))
+	}
+	return nil
+}
+
+func (u *UnconfinedTask) Stop() error {
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// variantResult is the outcome of running the task commands for a variant
+// (see payload property variants)
+type variantResult struct {
+	name     string
+	err      *CommandExecutionError
+	duration time.Duration
+}
+
+// status describes the outcome, as reported in the task log
+func (r variantResult) status() string {
+	switch {
+	case r.err == nil:
+		return "succeeded"
+	case r.err.TaskStatus == Failed:
+		return "failed"
+	case r.err.TaskStatus == Cancelled:
+		return "cancelled"
+	}
+	return "exception (" + r.err.Reason + ")"
+}
+
+// validateVariants checks payload property variants beyond what the payload
+// schema can check
+func (task *TaskRun) validateVariants() error {
+	if len(task.Payload.Variants) == 0 {
+		return nil
+	}
+	names := map[string]bool{}
+	for _, variant := range task.Payload.Variants {
+		if names[variant.Name] {
+			return fmt.Errorf("variant name %q is not unique", variant.Name)
+		}
+		names[variant.Name] = true
+		// the name is a directory in the artifact names of the variant, which
+		// are also used as paths on the worker, so must not be . or ..
+		if variant.Name == "." || variant.Name == ".." {
+			return fmt.Errorf("variant name %q is not allowed", variant.Name)
+		}
+		if _, err := variantEnv(variant.Env); err != nil {
+			return fmt.Errorf("could not interpret env of variant %q: %s", variant.Name, err)
+		}
+	}
+	for _, artifact := range task.Payload.Artifacts {
+		if artifact.Watch {
+			return fmt.Errorf("artifact %v cannot be watched, since the task has variants", artifact.Path)
+		}
+	}
+	return nil
+}
+
+func variantEnv(env json.RawMessage) (map[string]string, error) {
+	vars := map[string]string{}
+	if env == nil {
+		return vars, nil
+	}
+	err := json.Unmarshal(env, &vars)
+	return vars, err
+}
+
+// writeLogMarker writes a log section marker line to the task log, see
+// sectionWriter
+func (task *TaskRun) writeLogMarker(marker string) {
+	task.lock.Lock()
+	defer task.lock.Unlock()
+	if task.logWriter != nil {
+		task.logWriter.Write([]byte(marker + "\n"))
+	}
+}
+
+// runVariants runs the first commandCount commands of the task once for each
+// variant, publishing the payload artifacts after each one, and returns the
+// aggregated outcome: the first outcome other than a failure (an exception,
+// or the task being cancelled) if there is one, otherwise the first failure.
+// Failed variants do not stop later variants from running, but any other
+// outcome, or maxRunTime being exceeded, does.
+func (task *TaskRun) runVariants(commandCount int) *CommandExecutionError {
+	task.lock.Lock()
+	baseEnv := task.featureEnv
+	task.lock.Unlock()
+	basePrefix := task.artifactNamePrefix
+	defer func() {
+		task.lock.Lock()
+		task.featureEnv = baseEnv
+		task.lock.Unlock()
+		task.artifactNamePrefix = basePrefix
+	}()
+
+	results := []variantResult{}
+	for _, variant := range task.Payload.Variants {
+		if len(results) > 0 {
+			if last := results[len(results)-1]; last.err != nil && (last.err.TaskStatus != Failed || task.deadline.hasExpired()) {
+				task.Log(fmt.Sprintf("Not running variant %v, since variant %v %v", variant.Name, last.name, last.status()))
+				continue
+			}
+		}
+		// schema and validateVariants already checked the env
+		env, _ := variantEnv(variant.Env)
+		env["TASK_VARIANT"] = variant.Name
+		task.lock.Lock()
+		task.featureEnv = map[string]string{}
+		for name, value := range baseEnv {
+			task.featureEnv[name] = value
+		}
+		for name, value := range env {
+			task.featureEnv[name] = value
+		}
+		task.lock.Unlock()
+		task.artifactNamePrefix = basePrefix + variant.Name + "/"
+
+		task.writeLogMarker(sectionStartMarker + "Variant " + variant.Name)
+		started := time.Now()
+		var err *CommandExecutionError
+		for i := 0; i < commandCount && err == nil; i++ {
+			err = task.ExecuteCommand(i)
+		}
+		task.collectHostArtifacts()
+		if err == nil {
+			err = task.checkRequiredArtifacts()
+		}
+		task.reportMissingArtifacts()
+		if errUpload := task.uploadVariantArtifacts(); err == nil {
+			err = errUpload
+		}
+		result := variantResult{
+			name:     variant.Name,
+			err:      err,
+			duration: time.Since(started),
+		}
+		task.Log(fmt.Sprintf("Variant %v %v", variant.Name, result.status()))
+		task.writeLogMarker(sectionEndMarker)
+		if err != nil {
+			log.Printf("Variant %v of task %v not successful: %v", variant.Name, task.TaskID, err)
+		}
+		results = append(results, result)
+	}
+
+	summary := make([]string, len(results))
+	var failure, exception *CommandExecutionError
+	for i, result := range results {
+		summary[i] = fmt.Sprintf("%v: %v (%v)", result.name, result.status(), result.duration-result.duration%time.Millisecond)
+		switch {
+		case result.err == nil:
+		case result.err.TaskStatus == Failed:
+			if failure == nil {
+				failure = result.err
+			}
+		case exception == nil:
+			exception = result.err
+		}
+	}
+	task.Log("=== Variants ===")
+	task.Log(strings.Join(summary, "\n"))
+	if exception != nil {
+		return exception
+	}
+	return failure
+}
+
+// uploadVariantArtifacts publishes the payload artifacts produced by the
+// current variant, and returns the first failure to upload one that is not
+// best effort
+func (task *TaskRun) uploadVariantArtifacts() *CommandExecutionError {
+	var failure *CommandExecutionError
+	for _, artifact := range task.PayloadArtifacts() {
+		err := task.uploadArtifact(artifact)
+		if err == nil {
+			continue
+		}
+		if artifact.Base().BestEffort {
+			task.Log(fmt.Sprintf("Could not upload best effort artifact %v: %s", artifact.Base().ArtifactName(), err))
+			continue
+		}
+		log.Printf("%#v", err)
+		if failure == nil {
+			failure = task.uploadFailure(artifact, err)
+		}
+	}
+	return failure
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVariants(t *testing.T) {
+	command := `[["/bin/bash", "-c", "echo $TASK_VARIANT $TARGET > out.txt && test $TARGET != broken"]]`
+	if runtime.GOOS == "windows" {
+		command = `["echo %TASK_VARIANT% %TARGET%> out.txt", "if %TARGET% == broken exit 1"]`
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"variants": [
+			{"name": "x86", "env": {"TARGET": "i686"}},
+			{"name": "broken", "env": {"TARGET": "broken"}},
+			{"name": "arm64", "env": {"TARGET": "aarch64"}}
+		],
+		"artifacts": [{"type": "file", "path": "out.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Failed {
+		t.Fatalf("Expected task to resolve as %v since a variant failed, but it resolved as %v (%v)", Failed, status, err)
+	}
+	// later variants still run after one fails
+	for variant, target := range map[string]string{"x86": "i686", "broken": "broken", "arm64": "aarch64"} {
+		out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", variant, "out.txt"))
+		if err != nil {
+			t.Fatalf("Artifact of variant %v was not published: %v", variant, err)
+		}
+		if expected := variant + " " + target; strings.TrimSpace(string(out)) != expected {
+			t.Fatalf("Expected artifact of variant %v to contain %q but it contains %q", variant, expected, string(out))
+		}
+	}
+	logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not saved locally: %v", err)
+	}
+	for _, expected := range []string{"x86: succeeded", "broken: failed", "arm64: succeeded", "Variant arm64 ("} {
+		if !strings.Contains(string(logFile), expected) {
+			t.Fatalf("Expected task log to contain %q but it is:\n%s", expected, logFile)
+		}
+	}
+}
+
+// A variant that is aborted, e.g. because the task was cancelled, should stop
+// later variants from running, and determine how the task resolves.
+func TestAbortedVariant(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands use bash")
+	}
+	go func() {
+		for {
+			if status := workerStatus(); status.Task != nil && status.Task.Phase == "running" {
+				currentStatus.Lock()
+				currentStatus.task.abort(&CommandExecutionError{
+					Cause:      errors.New("Task was cancelled"),
+					Reason:     "canceled",
+					TaskStatus: Cancelled,
+				})
+				currentStatus.Unlock()
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+	}()
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": [["/bin/bash", "-c", "echo $TASK_VARIANT > out.txt && test $TASK_VARIANT != slow || sleep 30"]],
+		"maxRunTime": 60,
+		"variants": [
+			{"name": "slow"},
+			{"name": "fast"}
+		],
+		"artifacts": [{"type": "file", "path": "out.txt", "expires": "` + expires + `"}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if status != Cancelled {
+		t.Fatalf("Expected task to resolve as %v since a variant was cancelled, but it resolved as %v (%v)", Cancelled, status, err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "artifacts", "fast", "out.txt")); err == nil {
+		t.Fatal("Expected no further variants to run after a variant was cancelled")
+	}
+	logFile, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "public", "logs", "live_backing.log"))
+	if err != nil {
+		t.Fatalf("Task log was not saved locally: %v", err)
+	}
+	for _, expected := range []string{"slow: cancelled", "Not running variant fast, since variant slow cancelled"} {
+		if !strings.Contains(string(logFile), expected) {
+			t.Fatalf("Expected task log to contain %q but it is:\n%s", expected, logFile)
+		}
+	}
+}
+
+// Variant names are used in artifact names, so must not be . or ..
+func TestValidateVariants(t *testing.T) {
+	for _, name := range []string{".", ".."} {
+		task := &TaskRun{}
+		err := json.Unmarshal([]byte(`{"variants": [{"name": "`+name+`"}]}`), &task.Payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = task.validateVariants(); err == nil {
+			t.Fatalf("Expected variant name %q to be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// verifyMachineState runs the commands of config setting
+// postTaskCheckCommands, to check that the previous task has not left
+// the machine in a state that could affect the next task (e.g. stray
+// processes, or services listening on ports). Returns an error describing the
+// first command that fails.
+func verifyMachineState() error {
+	for _, command := range config.PostTaskCheckCommands {
+		log.Printf("Verifying machine state: %q", command)
+		output, err := exec.Command(command[0], command[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Post task verification command %q failed: %s\n%s", command, err, strings.TrimSpace(string(output)))
+		}
+	}
+	return nil
+}
+
+// handleDirtyMachine quarantines or reboots the worker after a failed post
+// task verification, according to config setting
+// postTaskCheckFailure, so that no further tasks run on a dirty
+// machine
+func handleDirtyMachine(err error) {
+	log.Printf("%v", err)
+	if config.PostTaskCheckFailure == "reboot" {
+		log.Print("Rebooting worker due to failed post task verification")
+		immediateReboot()
+		return
+	}
+	quarantine(err.Error())
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// A failing post task check command should quarantine the worker.
+func TestPostTaskCheckFailure(t *testing.T) {
+	defer func() {
+		quarantineReason = ""
+	}()
+	defer func(c *Config) { config = c }(config)
+	config = &Config{PostTaskCheckCommands: [][]string{{"go", "version"}}}
+	err := verifyMachineState()
+	if err != nil {
+		t.Fatalf("Expected post task check to pass, but got %v", err)
+	}
+	config.PostTaskCheckCommands = append(config.PostTaskCheckCommands, []string{"go", "no-such-command"})
+	err = verifyMachineState()
+	if err == nil {
+		t.Fatal("Expected post task check to fail")
+	}
+	handleDirtyMachine(err)
+	if !quarantined() || !strings.Contains(quarantineReason, "no-such-command") {
+		t.Fatalf("Expected worker to be quarantined due to failed check, but quarantine reason is %q", quarantineReason)
+	}
+}
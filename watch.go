@@ -0,0 +1,113 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// how often the directories of artifacts with watch set are scanned for new
+// or changed files
+var artifactWatchInterval = 5 * time.Second
+
+// watchedFile is the state of a file in a watched artifact directory
+type watchedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+func (f watchedFile) same(g watchedFile) bool {
+	return f.size == g.size && f.modTime.Equal(g.modTime)
+}
+
+// watchArtifacts publishes the files of directory artifacts with watch set
+// while the task commands run. A file is published once it is unchanged
+// between two consecutive scans, and published again if it later changes.
+// The returned function stops watching.
+func (task *TaskRun) watchArtifacts() (stop func()) {
+	task.watchedUploads = map[string]watchedFile{}
+	watched := false
+	for _, artifact := range task.Payload.Artifacts {
+		watched = watched || (artifact.Watch && artifact.Type == "directory")
+	}
+	if !watched {
+		return func() {}
+	}
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		seen := map[string]watchedFile{}
+		ticker := time.NewTicker(artifactWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				task.scanWatchedArtifacts(seen)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-finished
+	}
+}
+
+// scanWatchedArtifacts publishes the files of watched artifact directories
+// that have not changed since the previous scan, as recorded in seen
+func (task *TaskRun) scanWatchedArtifacts(seen map[string]watchedFile) {
+	for _, artifact := range task.Payload.Artifacts {
+		if !artifact.Watch || artifact.Type != "directory" {
+			continue
+		}
+		walkFn := func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return nil
+			}
+			relativePath, err := filepath.Rel(longPath(TaskDir), path)
+			if err != nil {
+				return nil
+			}
+			name := canonicalPath(relativePath)
+			current := watchedFile{size: info.Size(), modTime: info.ModTime()}
+			previous, known := seen[name]
+			seen[name] = current
+			if !known || !previous.same(current) {
+				return nil
+			}
+			if published, ok := task.watchedUploads[name]; ok && published.same(current) {
+				return nil
+			}
+			b := BaseArtifact{
+				CanonicalPath: name,
+				Expires:       artifact.Expires,
+			}
+			err = task.uploadArtifact(prefixArtifactName(resolve(b, "file", artifact.ContentType), task.artifactNamePrefix))
+			if err != nil {
+				log.Printf("WARN: could not publish watched artifact %v: %s", name, err)
+				return nil
+			}
+			task.watchedUploads[name] = current
+			return nil
+		}
+		filepath.Walk(longPath(filepath.Join(TaskDir, canonicalPath(artifact.Path))), walkFn)
+	}
+}
+
+// publishedWhileWatching returns whether the given artifact was already
+// published by watchArtifacts, and has not changed since
+func (task *TaskRun) publishedWhileWatching(artifact Artifact) bool {
+	if _, ok := artifact.(S3Artifact); !ok {
+		return false
+	}
+	name := artifact.Base().CanonicalPath
+	published, ok := task.watchedUploads[name]
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(longPath(filepath.Join(TaskDir, name)))
+	return err == nil && published.same(watchedFile{size: info.Size(), modTime: info.ModTime()})
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Files in watched artifact directories should be published while the task
+// runs, so should survive being deleted before the task completes.
+func TestWatchedArtifacts(t *testing.T) {
+	oldInterval := artifactWatchInterval
+	artifactWatchInterval = 100 * time.Millisecond
+	defer func() {
+		artifactWatchInterval = oldInterval
+	}()
+
+	command := `[["/bin/bash", "-c", "mkdir out && echo hello > out/partial.txt && sleep 2 && rm out/partial.txt"]]`
+	if runtime.GOOS == "windows" {
+		command = `["mkdir out && echo hello> out\\partial.txt && ping -n 3 127.0.0.1 > nul && del out\\partial.txt"]`
+	}
+	expires := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	payload := `{
+		"command": ` + command + `,
+		"maxRunTime": 30,
+		"artifacts": [{"type": "directory", "path": "out", "expires": "` + expires + `", "watch": true}]
+	}`
+	tempDir, status, err := runLocalTask(t, payload, nil)
+	defer os.RemoveAll(tempDir)
+	if err != nil {
+		t.Fatalf("Task should have run successfully, but got error: %v", err)
+	}
+	if status != Succeeded {
+		t.Fatalf("Expected task to resolve as %v but it resolved as %v", Succeeded, status)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(tempDir, "artifacts", "out", "partial.txt"))
+	if err != nil {
+		t.Fatalf("Watched artifact out/partial.txt was not published while the task ran: %v", err)
+	}
+	if strings.TrimSpace(string(out)) != "hello" {
+		t.Fatalf("Expected artifact out/partial.txt to contain %q but it contains %q", "hello", string(out))
+	}
+}
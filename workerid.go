@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/taskcluster/slugid-go/slugid"
+)
+
+// worker ids are taskcluster identifiers, which are limited to these
+// characters, and at most 22 of them
+var (
+	invalidWorkerIDChars = regexp.MustCompile("[^a-zA-Z0-9_-]")
+	maxWorkerIDLength    = 22
+)
+
+// persistedWorkerID is stored alongside the worker config when config setting
+// workerIdStrategy is "random". The machine the id was generated on is also
+// recorded, so that a copy of the file on a cloned disk image is detected,
+// rather than two workers sharing the same id.
+type persistedWorkerID struct {
+	WorkerID string `json:"workerId"`
+	Machine  string `json:"machine"`
+}
+
+// machineFingerprint identifies the machine the worker runs on
+var machineFingerprint = func() (string, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	mac, err := firstMACAddress()
+	if err != nil {
+		return "", err
+	}
+	return hostname + "/" + mac, nil
+}
+
+// applyWorkerIDStrategy sets c.WorkerID according to config setting
+// workerIdStrategy, if set. The worker id file is only used by strategy
+// "random".
+func (c *Config) applyWorkerIDStrategy(workerIDFile string) error {
+	var id string
+	var err error
+	switch c.WorkerIDStrategy {
+	case "":
+		return nil
+	case "instance-id":
+		if c.InstanceID == "" {
+			return fmt.Errorf("Config setting workerIdStrategy %q requires the instance id, which is only known when running with --configure-for-aws", c.WorkerIDStrategy)
+		}
+		id = c.InstanceID
+	case "hostname":
+		id, err = os.Hostname()
+	case "mac":
+		id, err = firstMACAddress()
+		id = strings.Replace(id, ":", "", -1)
+	case "random":
+		id, err = randomWorkerID(workerIDFile)
+	default:
+		return fmt.Errorf("Config setting workerIdStrategy must be one of \"instance-id\", \"hostname\", \"mac\" or \"random\", not %q", c.WorkerIDStrategy)
+	}
+	if err != nil {
+		return fmt.Errorf("Could not determine worker id using workerIdStrategy %q: %s", c.WorkerIDStrategy, err)
+	}
+	c.WorkerID = sanitiseWorkerID(id)
+	if c.WorkerID != id {
+		log.Printf("WARN: worker id %q is not a valid identifier, so using %q instead, which is less likely to be unique", id, c.WorkerID)
+	}
+	return nil
+}
+
+// sanitiseWorkerID replaces characters that are not valid in a worker id with
+// a hyphen, and truncates it to the maximum length
+func sanitiseWorkerID(id string) string {
+	id = invalidWorkerIDChars.ReplaceAllString(id, "-")
+	if len(id) > maxWorkerIDLength {
+		id = id[:maxWorkerIDLength]
+	}
+	return id
+}
+
+// firstMACAddress returns the hardware address of the first network
+// interface that has one, other than loopback interfaces
+func firstMACAddress() (string, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return "", err
+	}
+	for _, i := range interfaces {
+		if i.Flags&net.FlagLoopback == 0 && len(i.HardwareAddr) > 0 {
+			return i.HardwareAddr.String(), nil
+		}
+	}
+	return "", fmt.Errorf("No network interface with a hardware address found")
+}
+
+// randomWorkerID returns the worker id persisted in the given file, or
+// generates (and persists) a new one if there is no such file, or if the file
+// was created on a different machine
+func randomWorkerID(file string) (string, error) {
+	machine, err := machineFingerprint()
+	if err != nil {
+		return "", err
+	}
+	var persisted persistedWorkerID
+	data, err := ioutil.ReadFile(file)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return "", err
+	default:
+		err = json.Unmarshal(data, &persisted)
+		if err != nil {
+			return "", fmt.Errorf("Could not read worker id file %v: %s", file, err)
+		}
+		if persisted.Machine == machine {
+			return persisted.WorkerID, nil
+		}
+		log.Printf("WARN: worker id file %v was created on machine %v, not %v (cloned disk image?), so generating a new worker id to avoid a collision", file, persisted.Machine, machine)
+	}
+	persisted = persistedWorkerID{
+		WorkerID: slugid.Nice(),
+		Machine:  machine,
+	}
+	return persisted.WorkerID, writeToFileAsJSON(persisted, file)
+}
+
+// workerIDFile returns the file that a random worker id is persisted in,
+// which lives alongside the given worker config file
+func workerIDFile(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), "worker-id.json")
+}